@@ -0,0 +1,168 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var dataCmd = &cobra.Command{
+	Use:   "data",
+	Short: "Data utilities",
+	Long:  `Utilities to import and export records of the project's database.`,
+}
+
+var dataExportCmd = &cobra.Command{
+	Use:   "export MODEL",
+	Short: "Export records of a model",
+	Long: `Export the records of MODEL in CSV or XLSX format.
+Records are exported with their external ID and relation fields are exported as the
+external IDs of the related records, so that CSV files can be loaded back as data files.
+The project in the current directory is built and run to export the data.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "data", append([]string{"export", args[0]}, dataExportArgs()...))
+	},
+}
+
+var dataImportCmd = &cobra.Command{
+	Use:   "import MODEL FILE",
+	Short: "Import records of a model from a CSV file",
+	Long: `Import the records of MODEL from the given CSV FILE.
+The import is transactional: if any line is in error, nothing is imported and
+all the errors are reported. Use --dry-run to only validate the file.
+The project in the current directory is built and run to import the data.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		fileName, err := filepath.Abs(args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		runProject(".", "data", []string{"import", args[0], fileName, fmt.Sprintf("--dry-run=%t", viper.GetBool("Data.DryRun"))})
+	},
+}
+
+// dataExportArgs returns the command line arguments to pass the
+// data export flags to the project's executable.
+func dataExportArgs() []string {
+	return []string{
+		"--fields", strings.Join(viper.GetStringSlice("Data.Fields"), ","),
+		"--domain", viper.GetString("Data.Domain"),
+		"--format", viper.GetString("Data.Format"),
+		"--output", viper.GetString("Data.Output"),
+	}
+}
+
+// ExportData exports the records of the given model into the output file
+// according to the data export flags. It is meant to be called
+// from a project start file which imports all the project's module.
+func ExportData(modelName string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	var domain []interface{}
+	if domStr := viper.GetString("Data.Domain"); domStr != "" {
+		if err := json.Unmarshal([]byte(domStr), &domain); err != nil {
+			fmt.Println("Unable to parse domain:", err)
+			os.Exit(1)
+		}
+	}
+	var data []byte
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		rc := env.Pool(modelName)
+		var fields []models.FieldName
+		for _, f := range viper.GetStringSlice("Data.Fields") {
+			fields = append(fields, rc.Model().FieldName(f))
+		}
+		rc = rc.SearchAll()
+		if len(domain) > 0 {
+			rc = rc.Search(models.ParseDomain(domain, rc.Model()))
+		}
+		data = rc.Export(fields, models.ExportFormat(viper.GetString("Data.Format")))
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	output := viper.GetString("Data.Output")
+	if output == "" {
+		output = fmt.Sprintf("%s.%s", modelName, viper.GetString("Data.Format"))
+	}
+	if err = ioutil.WriteFile(output, data, 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// ImportData imports the records of the given model from the given CSV file
+// and prints the import report. It is meant to be called from a project
+// start file which imports all the project's module.
+func ImportData(modelName, fileName string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	csvFile, err := os.Open(fileName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer csvFile.Close()
+	report, err := models.ImportCSVData(security.SuperUserID, modelName, csvFile, viper.GetBool("Data.DryRun"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, ie := range report.Errors {
+		fmt.Println(ie.Error())
+	}
+	switch {
+	case report.HasErrors():
+		fmt.Printf("%d error(s) found. No record has been imported.\n", len(report.Errors))
+		os.Exit(1)
+	case report.DryRun:
+		fmt.Printf("No error found. %d record(s) would be created and %d updated.\n", report.Created, report.Updated)
+	default:
+		fmt.Printf("%d record(s) created and %d updated.\n", report.Created, report.Updated)
+	}
+}
+
+// SetDataImportFlags adds the data import flags to the given command.
+func SetDataImportFlags(c *cobra.Command) {
+	c.Flags().Bool("dry-run", false, "Only validate the file and report errors without importing records")
+	viper.BindPFlag("Data.DryRun", c.Flags().Lookup("dry-run"))
+}
+
+// SetDataExportFlags adds the data export flags to the given command.
+func SetDataExportFlags(c *cobra.Command) {
+	c.Flags().StringSliceP("fields", "f", []string{}, "Comma separated list of fields to export, possibly as paths (ex: Name,Partner.Country.Name). Defaults to all importable fields.")
+	viper.BindPFlag("Data.Fields", c.Flags().Lookup("fields"))
+	c.Flags().StringP("domain", "d", "", `Domain to filter the records to export, as a JSON list (ex: [["name", "ilike", "john"]])`)
+	viper.BindPFlag("Data.Domain", c.Flags().Lookup("domain"))
+	c.Flags().String("format", "csv", "Export format. Should be one of 'csv' or 'xlsx'")
+	viper.BindPFlag("Data.Format", c.Flags().Lookup("format"))
+	c.Flags().StringP("output", "O", "", "File to which the records are exported. Defaults to MODEL.csv or MODEL.xlsx in the current directory.")
+	viper.BindPFlag("Data.Output", c.Flags().Lookup("output"))
+}
+
+func init() {
+	SetDataExportFlags(dataExportCmd)
+	dataCmd.AddCommand(dataExportCmd)
+	SetDataImportFlags(dataImportCmd)
+	dataCmd.AddCommand(dataImportCmd)
+	HexyaCmd.AddCommand(dataCmd)
+}
@@ -47,6 +47,7 @@ var generateCmd = &cobra.Command{
 	Long: `Generate the source code of the pool package which includes the definition of all the models.
 This command also :
 - creates the resource directory by symlinking all modules resources into the project directory.
+- creates an XSD file in the resource directory to validate resource XML files.
 - creates or updates the main.go of the project.
 This command must be rerun after each source code modification, including module import.`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -90,7 +91,7 @@ func runGenerate(projectDir string) {
 	fmt.Println("Modules paths:")
 	fmt.Println(" -", strings.Join(targetPaths, "\n - "))
 
-	fmt.Print(`1/5 - Loading program...`)
+	fmt.Print(`1/6 - Loading program...`)
 	packs, err := loadProgram(targetPaths, testEnabled)
 	if err != nil {
 		panic(err)
@@ -98,15 +99,15 @@ func runGenerate(projectDir string) {
 	mods := generate.GetModulePackages(packs)
 	fmt.Println("Ok")
 
-	fmt.Print("2/5 - Generating symlinks...")
+	fmt.Print("2/6 - Generating symlinks...")
 	createSymlinks(mods, projectDir)
 	fmt.Println("Ok")
 
-	fmt.Print("3/5 - Generating pool...")
+	fmt.Print("3/6 - Generating pool...")
 	generate.CreatePool(mods, poolDir)
 	fmt.Println("Ok")
 
-	fmt.Print("4/5 - Checking the generated code...")
+	fmt.Print("4/6 - Checking the generated code...")
 	_, err = loadProgram(targetPaths, testEnabled)
 	if err != nil {
 		fmt.Println("FAIL")
@@ -115,7 +116,15 @@ func runGenerate(projectDir string) {
 	}
 	fmt.Println("Ok")
 
-	fmt.Print("5/5 - Creating main.go in project...")
+	fmt.Print("5/6 - Generating XSD for resource files...")
+	if testEnabled {
+		fmt.Println("SKIPPED")
+	} else {
+		generate.CreateXSD(mods, filepath.Join(projectDir, ResDirRel, generate.XSDFileName))
+		fmt.Println("Ok")
+	}
+
+	fmt.Print("6/6 - Creating main.go in project...")
 	if testEnabled {
 		fmt.Println("SKIPPED")
 	} else {
@@ -323,6 +332,150 @@ func main() {
 	}
 	hexyaCmd.AddCommand(updateDBCmd)
 
+	var dataCmd = &cobra.Command{
+		Use:   "data",
+		Short: "Data utilities",
+		Long: "Utilities to import and export records of the database.",
+	}
+	hexyaCmd.AddCommand(dataCmd)
+
+	var dataExportCmd = &cobra.Command{
+		Use:   "export MODEL",
+		Short: "Export records of a model",
+		Long: "Export the records of MODEL in CSV or XLSX format.",
+		Args: cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.ExportData(args[0])
+		},
+	}
+	dataCmd.AddCommand(dataExportCmd)
+	cmd.SetDataExportFlags(dataExportCmd)
+
+	var dataImportCmd = &cobra.Command{
+		Use:   "import MODEL FILE",
+		Short: "Import records of a model from a CSV file",
+		Long: "Import the records of MODEL from the given CSV FILE.",
+		Args: cobra.ExactArgs(2),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.ImportData(args[0], args[1])
+		},
+	}
+	dataCmd.AddCommand(dataImportCmd)
+	cmd.SetDataImportFlags(dataImportCmd)
+
+	var securityCmd = &cobra.Command{
+		Use:   "security",
+		Short: "Security utilities",
+		Long: "Utilities to audit the security configuration.",
+	}
+	hexyaCmd.AddCommand(securityCmd)
+
+	var securityMethodsCmd = &cobra.Command{
+		Use:   "methods",
+		Short: "List the methods that can be called through RPC",
+		Long: "List every public method of the models with the groups that are allowed to call it.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.SecurityMethods()
+		},
+	}
+	securityCmd.AddCommand(securityMethodsCmd)
+
+	var securityGroupsCmd = &cobra.Command{
+		Use:   "groups [UID]",
+		Short: "List the group memberships of users",
+		Long: "List the effective group memberships of all users or of the user with the given UID.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			var uid string
+			if len(args) > 0 {
+				uid = args[0]
+			}
+			cmd.SecurityGroups(uid)
+		},
+	}
+	securityCmd.AddCommand(securityGroupsCmd)
+
+	var securityAPIKeyCmd = &cobra.Command{
+		Use:   "apikey",
+		Short: "Manage API keys",
+		Long: "Issue, list and revoke API keys.",
+	}
+	securityCmd.AddCommand(securityAPIKeyCmd)
+
+	var securityAPIKeyIssueCmd = &cobra.Command{
+		Use:   "issue UID NAME",
+		Short: "Issue a new API key",
+		Long: "Issue a new API key that authenticates the user with the given UID.",
+		Args: cobra.ExactArgs(2),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.IssueAPIKey(args[0], args[1])
+		},
+	}
+	securityAPIKeyCmd.AddCommand(securityAPIKeyIssueCmd)
+	cmd.SetAPIKeyIssueFlags(securityAPIKeyIssueCmd)
+
+	var securityAPIKeyRevokeCmd = &cobra.Command{
+		Use:   "revoke KEY_ID",
+		Short: "Revoke an API key",
+		Long: "Revoke the API key with the given KEY_ID.",
+		Args: cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.RevokeAPIKey(args[0])
+		},
+	}
+	securityAPIKeyCmd.AddCommand(securityAPIKeyRevokeCmd)
+
+	var securityAPIKeyListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List API keys",
+		Long: "List the API keys of the database with their scope and expiration date.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.ListAPIKeys()
+		},
+	}
+	securityAPIKeyCmd.AddCommand(securityAPIKeyListCmd)
+
+	var securityUnlockCmd = &cobra.Command{
+		Use:   "unlock LOGIN",
+		Short: "Unlock an account locked after failed login attempts",
+		Long: "Forget the failed login attempts of LOGIN, or of an IP address with --address.",
+		Args: cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.Unlock(args[0])
+		},
+	}
+	securityCmd.AddCommand(securityUnlockCmd)
+	cmd.SetUnlockFlags(securityUnlockCmd)
+
+	var securityOIDCCmd = &cobra.Command{
+		Use:   "oidc",
+		Short: "Manage OpenID Connect identities",
+		Long: "Link and unlink the identities of OpenID Connect providers to existing users.",
+	}
+	securityCmd.AddCommand(securityOIDCCmd)
+
+	var securityOIDCLinkCmd = &cobra.Command{
+		Use:   "link ISSUER SUBJECT UID",
+		Short: "Link an OpenID Connect identity to a user",
+		Long: "Link the identity with the given SUBJECT at the given ISSUER to the user with the given UID.",
+		Args: cobra.ExactArgs(3),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.LinkOIDCIdentity(args[0], args[1], args[2])
+		},
+	}
+	securityOIDCCmd.AddCommand(securityOIDCLinkCmd)
+
+	var securityOIDCUnlinkCmd = &cobra.Command{
+		Use:   "unlink ISSUER SUBJECT",
+		Short: "Unlink an OpenID Connect identity",
+		Long: "Remove the link of the identity with the given SUBJECT at the given ISSUER.",
+		Args: cobra.ExactArgs(2),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.UnlinkOIDCIdentity(args[0], args[1])
+		},
+	}
+	securityOIDCCmd.AddCommand(securityOIDCUnlinkCmd)
+
 	cobra.OnInitialize(cmd.InitConfig)
 
 	if err := hexyaCmd.Execute(); err != nil {
@@ -77,6 +77,22 @@ func SetHexyaFlags(c *cobra.Command) {
 	viper.BindPFlag("DB.SSLKey", c.PersistentFlags().Lookup("db-ssl-key"))
 	c.PersistentFlags().String("db-ssl-ca", "", "Path to certificate authority certificate(s) file")
 	viper.BindPFlag("DB.SSLCA", c.PersistentFlags().Lookup("db-ssl-ca"))
+	c.PersistentFlags().Duration("db-statement-timeout", 0, "Maximum duration of SQL statements. Longer statements are cancelled. No limit if 0")
+	viper.BindPFlag("DB.StatementTimeout", c.PersistentFlags().Lookup("db-statement-timeout"))
+	c.PersistentFlags().StringSlice("db-replicas", []string{}, "Comma separated list of the hosts of the read replicas of the database, with an optional port (ex: replica1,replica2:5433)")
+	viper.BindPFlag("DB.Replicas", c.PersistentFlags().Lookup("db-replicas"))
+	c.PersistentFlags().Duration("db-max-replication-lag", 0, "Replication lag above which a read replica is not used. No limit if 0")
+	viper.BindPFlag("DB.MaxReplicationLag", c.PersistentFlags().Lookup("db-max-replication-lag"))
+	c.PersistentFlags().Int("db-max-open-conns", 0, "Maximum number of open connections to the database and to each read replica. No limit if 0")
+	viper.BindPFlag("DB.MaxOpenConns", c.PersistentFlags().Lookup("db-max-open-conns"))
+	c.PersistentFlags().Int("db-max-idle-conns", 0, "Maximum number of idle connections kept to the database and to each read replica. Defaults to 2 if 0")
+	viper.BindPFlag("DB.MaxIdleConns", c.PersistentFlags().Lookup("db-max-idle-conns"))
+	c.PersistentFlags().Duration("db-conn-max-lifetime", 0, "Maximum duration for which a database connection is reused. No limit if 0")
+	viper.BindPFlag("DB.ConnMaxLifetime", c.PersistentFlags().Lookup("db-conn-max-lifetime"))
+	c.PersistentFlags().Int("db-max-databases", 0, "Maximum number of databases other than db-name that the server keeps connected when serving several databases. No limit if 0")
+	viper.BindPFlag("DB.MaxDatabases", c.PersistentFlags().Lookup("db-max-databases"))
+	c.PersistentFlags().Duration("db-idle-timeout", 0, "Duration after which the databases other than db-name that served no request are closed. Never closed if 0")
+	viper.BindPFlag("DB.IdleTimeout", c.PersistentFlags().Lookup("db-idle-timeout"))
 }
 
 // InitConfig initializes Hexya configuration system (viper).
@@ -0,0 +1,412 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hexya-erp/hexya/src/apikeys"
+	"github.com/hexya-erp/hexya/src/lockout"
+	"github.com/hexya-erp/hexya/src/memberships"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/oidc"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var securityCmd = &cobra.Command{
+	Use:   "security",
+	Short: "Security utilities",
+	Long:  `Utilities to audit and manage the security configuration of the project.`,
+}
+
+var securityMethodsCmd = &cobra.Command{
+	Use:   "methods",
+	Short: "List the methods that can be called through RPC",
+	Long: `List every public method of the project's models, that is every method that
+can be called through RPC, with the groups that are allowed to call it.
+Private methods are not listed. The project in the current directory is built
+and run to compute the report.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", []string{"methods"})
+	},
+}
+
+var securityGroupsCmd = &cobra.Command{
+	Use:   "groups [UID]",
+	Short: "List the group memberships of users",
+	Long: `List the effective group memberships of all users, or of the user with the
+given UID, with the way each membership is obtained: natively or by
+inheritance. The project in the current directory is built and run to
+compute the report.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", append([]string{"groups"}, args...))
+	},
+}
+
+var securityAPIKeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Manage API keys",
+	Long: `Issue, list and revoke the API keys with which integrations authenticate
+on the API endpoints of the server.`,
+}
+
+var securityAPIKeyIssueCmd = &cobra.Command{
+	Use:   "issue UID NAME",
+	Short: "Issue a new API key",
+	Long: `Issue a new API key that authenticates the user with the given UID.
+NAME describes the usage of the key. The key is printed once and cannot be
+retrieved afterwards. The project in the current directory is built and run
+to issue the key.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", append([]string{"apikey", "issue", args[0], args[1]}, securityAPIKeyIssueArgs()...))
+	},
+}
+
+var securityAPIKeyRevokeCmd = &cobra.Command{
+	Use:   "revoke KEY_ID",
+	Short: "Revoke an API key",
+	Long: `Revoke the API key with the given KEY_ID, as printed by 'hexya security apikey list'.
+The project in the current directory is built and run to revoke the key.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", []string{"apikey", "revoke", args[0]})
+	},
+}
+
+var securityAPIKeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API keys",
+	Long: `List the API keys of the database with their scope and expiration date.
+The project in the current directory is built and run to list the keys.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", []string{"apikey", "list"})
+	},
+}
+
+var securityUnlockCmd = &cobra.Command{
+	Use:   "unlock LOGIN",
+	Short: "Unlock an account locked after failed login attempts",
+	Long: `Forget the failed login attempts of LOGIN, so that its user can log in again
+immediately. With --address, LOGIN is the IP address of a blocked client.
+The project in the current directory is built and run to unlock the account.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", []string{"unlock", args[0], fmt.Sprintf("--address=%t", viper.GetBool("Lockout.Address"))})
+	},
+}
+
+var securityOIDCCmd = &cobra.Command{
+	Use:   "oidc",
+	Short: "Manage OpenID Connect identities",
+	Long:  `Link and unlink the identities of OpenID Connect providers to existing users.`,
+}
+
+var securityOIDCLinkCmd = &cobra.Command{
+	Use:   "link ISSUER SUBJECT UID",
+	Short: "Link an OpenID Connect identity to a user",
+	Long: `Link the identity with the given SUBJECT at the given ISSUER to the user with
+the given UID, so that this user is logged in when authenticated by an OpenID
+Connect provider of ISSUER with SUBJECT. The project in the current directory
+is built and run to link the identity.`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", append([]string{"oidc", "link"}, args...))
+	},
+}
+
+var securityOIDCUnlinkCmd = &cobra.Command{
+	Use:   "unlink ISSUER SUBJECT",
+	Short: "Unlink an OpenID Connect identity",
+	Long: `Remove the link of the identity with the given SUBJECT at the given ISSUER.
+The project in the current directory is built and run to unlink the identity.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(".", "security", append([]string{"oidc", "unlink"}, args...))
+	},
+}
+
+// securityAPIKeyIssueArgs returns the command line arguments to pass the
+// API key issue flags to the project's executable.
+func securityAPIKeyIssueArgs() []string {
+	return []string{
+		fmt.Sprintf("--read-only=%t", viper.GetBool("APIKey.ReadOnly")),
+		"--models", strings.Join(viper.GetStringSlice("APIKey.Models"), ","),
+		"--expiration", viper.GetString("APIKey.Expiration"),
+	}
+}
+
+// SecurityMethods prints the public methods of all models with the groups
+// allowed to call them. It is meant to be called from a project start file
+// which imports all the project's module.
+func SecurityMethods() {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	writeMethodsReport(os.Stdout)
+}
+
+// writeMethodsReport writes to w the public methods of all models
+// that are not mixins, with the groups allowed to call them.
+func writeMethodsReport(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tMETHOD\tGROUPS")
+	for _, model := range models.Registry.AllModels() {
+		if model.IsMixin() {
+			continue
+		}
+		for _, method := range model.Methods().AllMethods() {
+			if !method.IsPublic() {
+				continue
+			}
+			var groups []string
+			for _, group := range method.AllowedGroups() {
+				groups = append(groups, group.ID())
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", model.Name(), method.Name(), strings.Join(groups, ", "))
+		}
+	}
+	tw.Flush()
+}
+
+// SecurityGroups prints the effective group memberships of all users, or of
+// the user with the given uid if it is not empty. It is meant to be called
+// from a project start file which imports all the project's module.
+func SecurityGroups(uidStr string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	security.Registry.SetMembershipStore(memberships.Store{})
+	var uids []int64
+	if uidStr != "" {
+		uid, err := strconv.ParseInt(uidStr, 10, 64)
+		if err != nil {
+			fmt.Println("Invalid uid:", err)
+			os.Exit(1)
+		}
+		uids = append(uids, uid)
+	} else {
+		all, err := memberships.All()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		uids = append(uids, security.SuperUserID)
+		for uid := range all {
+			if uid != security.SuperUserID {
+				uids = append(uids, uid)
+			}
+		}
+		sort.Slice(uids, func(i, j int) bool {
+			return uids[i] < uids[j]
+		})
+	}
+	writeGroupsReport(os.Stdout, uids)
+}
+
+// writeGroupsReport writes to w the effective group memberships
+// of the users with the given uids.
+func writeGroupsReport(w io.Writer, uids []int64) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UID\tGROUP\tNAME\tMEMBERSHIP")
+	for _, uid := range uids {
+		userGroups := security.Registry.UserGroups(uid)
+		groups := make([]*security.Group, 0, len(userGroups))
+		for group := range userGroups {
+			groups = append(groups, group)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i].ID() < groups[j].ID()
+		})
+		for _, group := range groups {
+			membership := "native"
+			if userGroups[group] == security.InheritedGroup {
+				membership = "inherited"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", uid, group.ID(), group.Name(), membership)
+		}
+	}
+	tw.Flush()
+}
+
+// IssueAPIKey issues a new API key for the user with the given uid
+// according to the API key issue flags and prints it. It is meant to be
+// called from a project start file which imports all the project's module.
+func IssueAPIKey(uidStr, name string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	uid, err := strconv.ParseInt(uidStr, 10, 64)
+	if err != nil {
+		fmt.Println("Invalid uid:", err)
+		os.Exit(1)
+	}
+	var expiration dates.DateTime
+	if expStr := viper.GetString("APIKey.Expiration"); expStr != "" {
+		expiration, err = dates.ParseDateTimeWithLayout("2006-01-02", expStr)
+		if err != nil {
+			fmt.Println("Invalid expiration date:", err)
+			os.Exit(1)
+		}
+	}
+	scope := models.APIScope{
+		ReadOnly: viper.GetBool("APIKey.ReadOnly"),
+		Models:   viper.GetStringSlice("APIKey.Models"),
+	}
+	for _, modelName := range scope.Models {
+		if _, ok := models.Registry.Get(modelName); !ok {
+			fmt.Println("Unknown model:", modelName)
+			os.Exit(1)
+		}
+	}
+	token, err := apikeys.Issue(uid, name, scope, expiration)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
+
+// RevokeAPIKey revokes the API key with the given key id. It is meant to be
+// called from a project start file which imports all the project's module.
+func RevokeAPIKey(keyID string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	if err := apikeys.Revoke(keyID); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// ListAPIKeys prints the API keys of the database. It is meant to be
+// called from a project start file which imports all the project's module.
+func ListAPIKeys() {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	keys, err := apikeys.List()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	writeAPIKeysReport(os.Stdout, keys)
+}
+
+// writeAPIKeysReport writes to w the given API keys
+func writeAPIKeysReport(w io.Writer, keys []apikeys.Key) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY ID\tUID\tNAME\tREAD ONLY\tMODELS\tEXPIRATION\tLAST USED")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%t\t%s\t%s\t%s\n", key.ID, key.UID, key.Name, key.Scope.ReadOnly,
+			strings.Join(key.Scope.Models, ", "), dateOrDash(key.ExpirationDate), dateOrDash(key.LastUsed))
+	}
+	tw.Flush()
+}
+
+// dateOrDash returns the string representation of the given date or "-" if it is zero
+func dateOrDash(date dates.DateTime) string {
+	if date.IsZero() {
+		return "-"
+	}
+	return date.String()
+}
+
+// Unlock forgets the failed login attempts of the given login, or of the
+// given address if the address flag is set. It is meant to be called from
+// a project start file which imports all the project's module.
+func Unlock(login string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	security.AuthenticationRegistry.SetThrottling(lockout.Policy, lockout.Store{})
+	if viper.GetBool("Lockout.Address") {
+		security.AuthenticationRegistry.UnlockAddress(context.Background(), login)
+		return
+	}
+	security.AuthenticationRegistry.Unlock(context.Background(), login)
+}
+
+// LinkOIDCIdentity links the OpenID Connect identity with the given subject
+// at the given issuer to the user with the given uid. It is meant to be
+// called from a project start file which imports all the project's module.
+func LinkOIDCIdentity(issuer, subject, uidStr string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	uid, err := strconv.ParseInt(uidStr, 10, 64)
+	if err != nil {
+		fmt.Println("Invalid uid:", err)
+		os.Exit(1)
+	}
+	if err := oidc.LinkUser(context.Background(), issuer, subject, uid); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// UnlinkOIDCIdentity removes the link of the OpenID Connect identity with
+// the given subject at the given issuer. It is meant to be called from a
+// project start file which imports all the project's module.
+func UnlinkOIDCIdentity(issuer, subject string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	if err := oidc.UnlinkUser(context.Background(), issuer, subject); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// SetUnlockFlags adds the unlock flags to the given command.
+func SetUnlockFlags(c *cobra.Command) {
+	c.Flags().Bool("address", false, "Unlock the given IP address instead of a login")
+	viper.BindPFlag("Lockout.Address", c.Flags().Lookup("address"))
+}
+
+// SetAPIKeyIssueFlags adds the API key issue flags to the given command.
+func SetAPIKeyIssueFlags(c *cobra.Command) {
+	c.Flags().Bool("read-only", false, "Prevent the key from modifying the database")
+	viper.BindPFlag("APIKey.ReadOnly", c.Flags().Lookup("read-only"))
+	c.Flags().StringSlice("models", []string{}, "Comma separated list of the models that can be accessed with the key. Defaults to all models.")
+	viper.BindPFlag("APIKey.Models", c.Flags().Lookup("models"))
+	c.Flags().String("expiration", "", "Date after which the key cannot be used anymore, in YYYY-MM-DD format. Defaults to never.")
+	viper.BindPFlag("APIKey.Expiration", c.Flags().Lookup("expiration"))
+}
+
+func init() {
+	SetAPIKeyIssueFlags(securityAPIKeyIssueCmd)
+	securityAPIKeyCmd.AddCommand(securityAPIKeyIssueCmd)
+	securityAPIKeyCmd.AddCommand(securityAPIKeyRevokeCmd)
+	securityAPIKeyCmd.AddCommand(securityAPIKeyListCmd)
+	securityCmd.AddCommand(securityAPIKeyCmd)
+	securityCmd.AddCommand(securityGroupsCmd)
+	securityOIDCCmd.AddCommand(securityOIDCLinkCmd)
+	securityOIDCCmd.AddCommand(securityOIDCUnlinkCmd)
+	securityCmd.AddCommand(securityOIDCCmd)
+	SetUnlockFlags(securityUnlockCmd)
+	securityCmd.AddCommand(securityUnlockCmd)
+	securityCmd.AddCommand(securityMethodsCmd)
+	HexyaCmd.AddCommand(securityCmd)
+}
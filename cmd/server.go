@@ -15,22 +15,40 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/databases"
+	"github.com/hexya-erp/hexya/src/graphql"
+	"github.com/hexya-erp/hexya/src/health"
 	"github.com/hexya-erp/hexya/src/i18n"
+	"github.com/hexya-erp/hexya/src/ldap"
+	"github.com/hexya-erp/hexya/src/lockout"
+	"github.com/hexya-erp/hexya/src/memberships"
 	"github.com/hexya-erp/hexya/src/menus"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/oidc"
 	"github.com/hexya-erp/hexya/src/reports"
+	"github.com/hexya-erp/hexya/src/rest"
+	"github.com/hexya-erp/hexya/src/rpc"
 	"github.com/hexya-erp/hexya/src/server"
 	"github.com/hexya-erp/hexya/src/templates"
 	"github.com/hexya-erp/hexya/src/tools/logging"
+	"github.com/hexya-erp/hexya/src/tools/tracing"
+	"github.com/hexya-erp/hexya/src/twofactor"
 	"github.com/hexya-erp/hexya/src/views"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -72,15 +90,21 @@ func StartServer() {
 	setupLogger()
 	defer log.Sync()
 	setupDebug()
+	setupTracing()
 	resourceDir, err := filepath.Abs(viper.GetString("ResourceDir"))
 	if err != nil {
 		log.Panic("Unable to find Resource directory", "error", err)
 	}
 	server.ResourceDir = resourceDir
+	server.RequestTimeout = viper.GetDuration("Server.RequestTimeout")
+	if err := server.SetTrustedProxies(viper.GetStringSlice("Server.TrustedProxies")); err != nil {
+		log.Panic("Unable to read trusted proxies", "error", err)
+	}
 	server.PreInit()
 	connectToDB()
 	i18n.BootStrap()
 	models.BootStrap()
+	memberships.BootStrap()
 	models.RunWorkerLoop()
 	server.LoadTranslations(resourceDir, i18n.Langs)
 	server.LoadInternalResources(resourceDir)
@@ -88,6 +112,30 @@ func StartServer() {
 	templates.BootStrap()
 	actions.BootStrap()
 	reports.BootStrap()
+	if viper.GetBool("Server.REST") {
+		rest.BootStrap()
+	}
+	if viper.GetBool("Server.RPC") {
+		rpc.BootStrap()
+	}
+	if viper.GetBool("Server.GraphQL") {
+		graphql.BootStrap()
+	}
+	setupSecondFactorGroups()
+	setupLDAPBackends()
+	if err := viper.UnmarshalKey("Security.Lockout", &lockout.Policy); err != nil {
+		log.Panic("Unable to read lockout configuration", "error", err)
+	}
+	lockout.BootStrap()
+	twofactor.BootStrap()
+	if setupOIDCProviders() {
+		oidc.BootStrap()
+	}
+	health.BootStrap()
+	if filter := viper.GetString("Server.DBFilter"); filter != "" {
+		databases.Filter = filter
+		databases.BootStrap()
+	}
 	controllers.BootStrap()
 	menus.BootStrap()
 	server.PostInit()
@@ -96,14 +144,101 @@ func StartServer() {
 	cert := viper.GetString("Server.Certificate")
 	key := viper.GetString("Server.PrivateKey")
 	domain := viper.GetString("Server.Domain")
-	switch {
-	case cert != "":
-		srv.RunTLS(address, cert, key)
-	case domain != "":
-		srv.RunAutoTLS(domain)
-	default:
-		srv.Run(address)
+	srvErr := make(chan error, 1)
+	go func() {
+		switch {
+		case cert != "":
+			srvErr <- srv.RunTLS(address, cert, key)
+		case domain != "":
+			srvErr <- srv.RunAutoTLS(domain)
+		default:
+			srvErr <- srv.Run(address)
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var runErr error
+	select {
+	case sig := <-signals:
+		log.Info("Received signal, shutting down", "signal", sig.String())
+	case runErr = <-srvErr:
+		log.Error("HTTP server failed, shutting down", "address", address, "error", runErr)
+	}
+	signal.Stop(signals)
+	shutdown(srv, viper.GetDuration("Server.ShutdownTimeout"))
+	if runErr != nil {
+		os.Exit(1)
+	}
+}
+
+// shutdown gracefully stops the given server, the workers and the
+// database connection. The requests and workers being run are
+// given the given timeout to complete.
+func shutdown(srv *server.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	server.PreShutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warn("Requests did not complete before shutdown timeout", "error", err)
+	}
+	memberships.StopListening()
+	workersStopped := make(chan struct{})
+	go func() {
+		models.StopWorkerLoop()
+		close(workersStopped)
+	}()
+	select {
+	case <-workersStopped:
+	case <-ctx.Done():
+		log.Warn("Workers did not complete before shutdown timeout")
 	}
+	if n := models.RollbackOpenTransactions(ctx); n > 0 {
+		log.Warn("Rolled back open transactions", "count", n)
+	}
+	models.DBClose()
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), timeout)
+	defer flushCancel()
+	if err := tracing.Shutdown(flushCtx); err != nil {
+		log.Warn("Unable to export pending spans", "error", err)
+	}
+	log.Info("Hexya stopped")
+}
+
+// setupSecondFactorGroups requires a second factor for the
+// members of the groups given in the configuration.
+func setupSecondFactorGroups() {
+	for _, groupID := range viper.GetStringSlice("Security.SecondFactorGroups") {
+		group := security.Registry.GetGroup(groupID)
+		if group == nil {
+			log.Panic("Unknown group in second factor groups", "group", groupID)
+		}
+		group.RequireSecondFactor(true)
+	}
+}
+
+// setupLDAPBackends registers the LDAP authentication
+// backends given in the configuration.
+func setupLDAPBackends() {
+	var backends []*ldap.Backend
+	if err := viper.UnmarshalKey("Security.LDAP", &backends); err != nil {
+		log.Panic("Unable to read LDAP configuration", "error", err)
+	}
+	for _, backend := range backends {
+		security.AuthenticationRegistry.RegisterBackend(backend)
+	}
+}
+
+// setupOIDCProviders registers the OpenID Connect providers given in the
+// configuration. It returns true if at least one provider is registered.
+func setupOIDCProviders() bool {
+	var providers []*oidc.Provider
+	if err := viper.UnmarshalKey("Security.OIDC", &providers); err != nil {
+		log.Panic("Unable to read OpenID Connect providers configuration", "error", err)
+	}
+	for _, provider := range providers {
+		oidc.RegisterProvider(provider)
+	}
+	return len(providers) > 0
 }
 
 // setupLogger initializes the logger
@@ -121,22 +256,72 @@ func setupDebug() {
 	pprof.Register(server.GetServer().Engine)
 }
 
+// setupTracing sets the span exporter given in the configuration
+func setupTracing() {
+	switch exporter := viper.GetString("Tracing.Exporter"); exporter {
+	case "":
+	case "otlp":
+		tracing.SetExporter(&tracing.OTLPExporter{
+			Endpoint:    viper.GetString("Tracing.Endpoint"),
+			Headers:     viper.GetStringMapString("Tracing.Headers"),
+			ServiceName: viper.GetString("Tracing.ServiceName"),
+		})
+	case "stdout":
+		tracing.SetExporter(tracing.NewWriterExporter(os.Stdout))
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(viper.GetString("Tracing.File"))
+		if err != nil {
+			log.Panic("Unable to open tracing file", "file", viper.GetString("Tracing.File"), "error", err)
+		}
+		tracing.SetExporter(fileExporter)
+	default:
+		log.Panic("Unknown tracing exporter", "exporter", exporter)
+	}
+}
+
 // connectToDB creates the connection to the database
 func connectToDB() {
 	models.DBConnect(models.ConnectionParams{
-		Driver:   viper.GetString("DB.Driver"),
-		Host:     viper.GetString("DB.Host"),
-		Port:     viper.GetString("DB.Port"),
-		User:     viper.GetString("DB.User"),
-		Password: viper.GetString("DB.Password"),
-		DBName:   viper.GetString("DB.Name"),
-		SSLMode:  viper.GetString("DB.SSLMode"),
-		SSLCert:  viper.GetString("DB.SSLCert"),
-		SSLKey:   viper.GetString("DB.SSLKey"),
-		SSLCA:    viper.GetString("DB.SSLCA"),
+		Driver:              viper.GetString("DB.Driver"),
+		Host:                viper.GetString("DB.Host"),
+		Port:                viper.GetString("DB.Port"),
+		User:                viper.GetString("DB.User"),
+		Password:            viper.GetString("DB.Password"),
+		DBName:              viper.GetString("DB.Name"),
+		SSLMode:             viper.GetString("DB.SSLMode"),
+		SSLCert:             viper.GetString("DB.SSLCert"),
+		SSLKey:              viper.GetString("DB.SSLKey"),
+		SSLCA:               viper.GetString("DB.SSLCA"),
+		StatementTimeout:    viper.GetDuration("DB.StatementTimeout"),
+		Replicas:            replicasParams(viper.GetStringSlice("DB.Replicas")),
+		MaxReplicationLag:   viper.GetDuration("DB.MaxReplicationLag"),
+		MaxOpenConns:        viper.GetInt("DB.MaxOpenConns"),
+		MaxIdleConns:        viper.GetInt("DB.MaxIdleConns"),
+		ConnMaxLifetime:     viper.GetDuration("DB.ConnMaxLifetime"),
+		MaxDatabases:        viper.GetInt("DB.MaxDatabases"),
+		DatabaseIdleTimeout: viper.GetDuration("DB.IdleTimeout"),
 	})
 }
 
+// replicasParams returns the connection parameters of the read replicas
+// given as hosts with an optional port. Other parameters are taken from
+// the primary database.
+func replicasParams(hosts []string) []models.ConnectionParams {
+	res := make([]models.ConnectionParams, len(hosts))
+	for i, host := range hosts {
+		res[i].Host = host
+		if strings.HasPrefix(host, "/") {
+			// Unix domain socket directory
+			continue
+		}
+		if h, port, err := net.SplitHostPort(host); err == nil {
+			res[i].Host = h
+			res[i].Port = port
+		}
+	}
+	return res
+}
+
 // SetServerFlags adds the server flags to the given command.
 func SetServerFlags(c *cobra.Command) {
 	c.PersistentFlags().StringP("interface", "i", "", "Interface on which the server should listen. Empty string is all interfaces")
@@ -151,6 +336,28 @@ func SetServerFlags(c *cobra.Command) {
 	viper.BindPFlag("Server.Certificate", c.PersistentFlags().Lookup("certificate"))
 	c.PersistentFlags().StringP("private-key", "K", "", "Private key file for HTTPS.")
 	viper.BindPFlag("Server.PrivateKey", c.PersistentFlags().Lookup("private-key"))
+	c.PersistentFlags().Bool("rest", false, "Enable the REST API at /api/v1 with its OpenAPI document at /api/openapi.json")
+	viper.BindPFlag("Server.REST", c.PersistentFlags().Lookup("rest"))
+	c.PersistentFlags().Bool("rpc", false, "Enable the Odoo compatible XML-RPC API at /xmlrpc/2 and JSON-RPC API at /jsonrpc")
+	viper.BindPFlag("Server.RPC", c.PersistentFlags().Lookup("rpc"))
+	c.PersistentFlags().Bool("graphql", false, "Enable the GraphQL API at /graphql")
+	viper.BindPFlag("Server.GraphQL", c.PersistentFlags().Lookup("graphql"))
+	c.PersistentFlags().Duration("shutdown-timeout", 30*time.Second, "Time given to the requests and workers being run to complete when the server shuts down")
+	viper.BindPFlag("Server.ShutdownTimeout", c.PersistentFlags().Lookup("shutdown-timeout"))
+	c.PersistentFlags().String("db-filter", "", "Serve the databases whose name matches this regular expression, in which %h is replaced by the host of the request and %d by its first label (ex: %d). Only the database of db-name is served if empty")
+	viper.BindPFlag("Server.DBFilter", c.PersistentFlags().Lookup("db-filter"))
+	c.PersistentFlags().Duration("request-timeout", 0, "Maximum duration of requests, after which their database queries are cancelled. No limit if 0")
+	viper.BindPFlag("Server.RequestTimeout", c.PersistentFlags().Lookup("request-timeout"))
+	c.PersistentFlags().StringSlice("trusted-proxies", []string{}, "Comma separated list of the addresses or networks of the reverse proxies whose X-Forwarded-For header gives the address of the clients (ex: 10.0.0.1,192.168.0.0/16)")
+	viper.BindPFlag("Server.TrustedProxies", c.PersistentFlags().Lookup("trusted-proxies"))
+	c.PersistentFlags().StringSlice("2fa-groups", []string{}, "Comma separated list of the IDs of the groups whose members must authenticate with a second factor (ex: admin)")
+	viper.BindPFlag("Security.SecondFactorGroups", c.PersistentFlags().Lookup("2fa-groups"))
+	c.PersistentFlags().String("tracing-exporter", "", "Exporter of the tracing spans: 'otlp', 'stdout' or 'file'. Tracing is disabled if empty")
+	viper.BindPFlag("Tracing.Exporter", c.PersistentFlags().Lookup("tracing-exporter"))
+	c.PersistentFlags().String("tracing-endpoint", "http://localhost:4318", "URL of the OpenTelemetry collector to which spans are sent with the 'otlp' exporter")
+	viper.BindPFlag("Tracing.Endpoint", c.PersistentFlags().Lookup("tracing-endpoint"))
+	c.PersistentFlags().String("tracing-file", "hexya-traces.json", "File to which spans are appended with the 'file' exporter")
+	viper.BindPFlag("Tracing.File", c.PersistentFlags().Lookup("tracing-file"))
 }
 
 func runCommand(c string, args ...string) error {
@@ -4,6 +4,7 @@
 package cmd
 
 import (
+	"context"
 	"path/filepath"
 
 	"github.com/hexya-erp/hexya/src/models"
@@ -15,7 +16,8 @@ import (
 var updateDBCmd = &cobra.Command{
 	Use:   "updatedb",
 	Short: "Update the database schema",
-	Long:  `Synchronize the database schema with the models definitions.`,
+	Long: `Synchronize the database schema with the models definitions.
+By default, only the database of db-name is updated.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		projectDir := "."
 		if len(args) > 0 {
@@ -33,21 +35,40 @@ func UpdateDB() {
 	server.PreInit()
 	connectToDB()
 	models.BootStrap()
-	models.SyncDatabase()
 	resourceDir, err := filepath.Abs(viper.GetString("ResourceDir"))
 	if err != nil {
 		log.Panic("Unable to find Resource directory", "error", err)
 	}
 	server.ResourceDir = resourceDir
 	server.LoadInternalResources(resourceDir)
-	server.LoadDataRecords(resourceDir)
-	if viper.GetBool("Demo") {
-		log.Info("Demo mode detected: loading demo data")
-		server.LoadDemoRecords(resourceDir)
+	for _, database := range databasesToUpdate() {
+		database.Sync()
+		ctx := models.ContextWithDatabase(context.Background(), database)
+		server.LoadDataRecordsContext(ctx, resourceDir)
+		if viper.GetBool("Demo") {
+			log.Info("Demo mode detected: loading demo data", "database", database.Name())
+			server.LoadDemoRecordsContext(ctx, resourceDir)
+		}
+		log.Info("Database updated successfully", "database", database.Name())
+	}
+}
+
+// databasesToUpdate returns the databases given by the databases flag,
+// or the default database if the flag is not set.
+func databasesToUpdate() []*models.Database {
+	names := viper.GetStringSlice("UpdateDB.Databases")
+	if len(names) == 0 {
+		return []*models.Database{models.DefaultDatabase()}
+	}
+	res := make([]*models.Database, len(names))
+	for i, name := range names {
+		res[i] = models.ConnectDatabase(name)
 	}
-	log.Info("Database updated successfully")
+	return res
 }
 
 func init() {
 	HexyaCmd.AddCommand(updateDBCmd)
+	updateDBCmd.Flags().StringSlice("databases", []string{}, "Comma separated list of the databases to update. They must have been created beforehand. Defaults to the database of db-name")
+	viper.BindPFlag("UpdateDB.Databases", updateDBCmd.Flags().Lookup("databases"))
 }
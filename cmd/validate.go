@@ -0,0 +1,103 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/hexya-erp/hexya/src/tools/generate"
+	"github.com/hexya-erp/hexya/src/tools/xsdutils"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [DIR]",
+	Short: "Validate resource XML files",
+	Long: `Validate the XML files in DIR against the XSD generated by 'hexya generate'.
+DIR defaults to the resource directory of the project in the current directory.
+Only files with a 'hexya' root element are checked.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := ResDirRel
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		schemaFile, _ := cmd.Flags().GetString("schema")
+		if schemaFile == "" {
+			schemaFile = filepath.Join(dir, generate.XSDFileName)
+		}
+		if !runValidate(dir, schemaFile) {
+			os.Exit(1)
+		}
+	},
+}
+
+// runValidate validates the resource XML files in dir against the given
+// XSD file, prints the errors and returns true if all files are valid.
+func runValidate(dir, schemaFile string) bool {
+	schema, err := xsdutils.ReadSchemaFile(schemaFile)
+	if err != nil {
+		fmt.Printf("Unable to read XSD file %s: %s\n", schemaFile, err)
+		fmt.Println("Run 'hexya generate' first to create it.")
+		return false
+	}
+	valid := true
+	var count int
+	for _, fileName := range findXMLFiles(dir) {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromFile(fileName); err != nil {
+			fmt.Printf("%s: %s\n", fileName, err)
+			valid = false
+			continue
+		}
+		if doc.Root() == nil || doc.Root().Tag != "hexya" {
+			continue
+		}
+		count++
+		for _, err := range schema.Validate(doc) {
+			fmt.Printf("%s: %s\n", fileName, err)
+			valid = false
+		}
+	}
+	if valid {
+		fmt.Printf("%d file(s) validated successfully\n", count)
+	}
+	return valid
+}
+
+// findXMLFiles returns the XML files in the given directory and its
+// subdirectories, following symlinks.
+func findXMLFiles(dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fmt.Printf("Unable to read directory %s: %s\n", dir, err)
+		return nil
+	}
+	var res []string
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil {
+				continue
+			}
+		}
+		switch {
+		case info.IsDir():
+			res = append(res, findXMLFiles(path)...)
+		case strings.ToLower(filepath.Ext(path)) == ".xml":
+			res = append(res, path)
+		}
+	}
+	return res
+}
+
+func init() {
+	validateCmd.Flags().String("schema", "", "Path to the XSD file. Defaults to DIR/hexya.xsd")
+	HexyaCmd.AddCommand(validateCmd)
+}
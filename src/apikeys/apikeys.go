@@ -0,0 +1,215 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package apikeys implements API keys with which integrations can
+authenticate on the API endpoints of the server without storing the
+password of a real user.
+
+An API key is a token of the form 'hexya_<key id>_<secret>'. Only a hash of
+the secret is stored in the database, together with the uid of the user the
+key authenticates, its scope and its expiration date.
+
+Keys are sent in the 'Authorization: Bearer <key>' header of HTTP requests,
+or as the password of the user in HTTP basic authentication and in the
+XML-RPC and JSON-RPC APIs.
+*/
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+	"github.com/hexya-erp/hexya/src/tools/password"
+)
+
+const (
+	// ModelName is the name of the model in which API keys are stored
+	ModelName = "APIKey"
+	// tokenPrefix is the prefix of all API keys
+	tokenPrefix = "hexya_"
+	// keyIDLen is the number of random bytes of a key id
+	keyIDLen = 6
+	// secretLen is the number of random bytes of a key secret
+	secretLen = 20
+)
+
+var log logging.Logger
+
+// A Key holds the data of an API key, without its secret
+type Key struct {
+	ID             string
+	Name           string
+	UID            int64
+	Scope          models.APIScope
+	ExpirationDate dates.DateTime
+	LastUsed       dates.DateTime
+}
+
+// IsAPIKey returns true if the given secret has the form of an API key
+func IsAPIKey(secret string) bool {
+	_, _, ok := splitToken(secret)
+	return ok
+}
+
+// splitToken returns the key id and the secret of the given API key token.
+// ok is false if token is not a well-formed API key.
+func splitToken(token string) (keyID, secret string, ok bool) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(token, tokenPrefix), "_")
+	if len(parts) != 2 || len(parts[0]) != 2*keyIDLen || len(parts[1]) != 2*secretLen {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// randomHex returns a random hexadecimal string of n bytes
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Issue creates a new API key that authenticates the user with the given uid
+// within the given scope and returns its token. The token cannot be retrieved
+// afterwards since only its hash is stored.
+//
+// If expiration is not zero, the key cannot be used after this date.
+func Issue(uid int64, name string, scope models.APIScope, expiration dates.DateTime) (string, error) {
+	keyID, err := randomHex(keyIDLen)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomHex(secretLen)
+	if err != nil {
+		return "", err
+	}
+	hash, err := password.Hash(secret)
+	if err != nil {
+		return "", err
+	}
+	err = models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		rc := env.Pool(ModelName)
+		rc.Call("Create", models.NewModelData(rc.Model()).
+			Set(rc.Model().FieldName("Name"), name).
+			Set(rc.Model().FieldName("KeyID"), keyID).
+			Set(rc.Model().FieldName("Hash"), hash).
+			Set(rc.Model().FieldName("UserID"), uid).
+			Set(rc.Model().FieldName("ReadOnly"), scope.ReadOnly).
+			Set(rc.Model().FieldName("Models"), strings.Join(scope.Models, ",")).
+			Set(rc.Model().FieldName("ExpirationDate"), expiration))
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s_%s", tokenPrefix, keyID, secret), nil
+}
+
+// Revoke deletes the API key with the given key id
+func Revoke(keyID string) error {
+	return models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		key := findKey(env, keyID)
+		if key.IsEmpty() {
+			log.Panic("Unknown API key", "keyID", keyID)
+		}
+		key.Call("Unlink")
+	})
+}
+
+// List returns all the API keys of the database
+func List() ([]Key, error) {
+	var res []Key
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		for _, rec := range env.Pool(ModelName).SearchAll().OrderBy("UserID", "Name").Records() {
+			res = append(res, keyFromRecord(rec))
+		}
+	})
+	return res, err
+}
+
+// Authenticate returns the uid and the scope of the given API key token.
+//
+// It returns a security.UserNotFoundError if token is not an API key and a
+// security.InvalidCredentialsError if the key does not exist, has expired or
+// if its secret does not match. The last used date of the key is updated on
+// success. The key is searched in the database of ctx.
+func Authenticate(ctx context.Context, token string) (int64, *models.APIScope, error) {
+	keyID, secret, ok := splitToken(token)
+	if !ok {
+		return 0, nil, security.UserNotFoundError("API key")
+	}
+	var key Key
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		rec := findKey(env, keyID)
+		if rec.IsEmpty() {
+			return
+		}
+		hash, _ := rec.Get(rec.Model().FieldName("Hash")).(string)
+		if !password.Verify(secret, hash) {
+			return
+		}
+		key = keyFromRecord(rec)
+		if !key.ExpirationDate.IsZero() && key.ExpirationDate.Lower(dates.Now()) {
+			key.UID = 0
+			return
+		}
+		rec.Set(rec.Model().FieldName("LastUsed"), dates.Now())
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if key.UID == 0 {
+		return 0, nil, security.InvalidCredentialsError(fmt.Sprintf("API key %s", keyID))
+	}
+	return key.UID, &key.Scope, nil
+}
+
+// findKey returns the record of the API key with the given key id
+func findKey(env models.Environment, keyID string) *models.RecordCollection {
+	model := models.Registry.MustGet(ModelName)
+	return env.Pool(ModelName).Search(model.Field(model.FieldName("KeyID")).Equals(keyID)).Fetch()
+}
+
+// keyFromRecord returns the Key of the given API key record
+func keyFromRecord(rec *models.RecordCollection) Key {
+	model := rec.Model()
+	key := Key{
+		ID:             rec.Get(model.FieldName("KeyID")).(string),
+		Name:           rec.Get(model.FieldName("Name")).(string),
+		UID:            rec.Get(model.FieldName("UserID")).(int64),
+		ExpirationDate: rec.Get(model.FieldName("ExpirationDate")).(dates.DateTime),
+		LastUsed:       rec.Get(model.FieldName("LastUsed")).(dates.DateTime),
+	}
+	key.Scope.ReadOnly = rec.Get(model.FieldName("ReadOnly")).(bool)
+	if mods := rec.Get(model.FieldName("Models")).(string); mods != "" {
+		key.Scope.Models = strings.Split(mods, ",")
+	}
+	return key
+}
+
+func init() {
+	log = logging.GetLogger("apikeys")
+	apiKey := models.NewModel(ModelName)
+	apiKey.AddFields(map[string]models.FieldDefinition{
+		"Name":           fields.Char{Required: true, Help: "Description of the usage of the key"},
+		"KeyID":          fields.Char{String: "Key ID", Required: true, Unique: true, Index: true, NoCopy: true},
+		"Hash":           fields.Char{Required: true, NoCopy: true},
+		"UserID":         fields.Integer{String: "User ID", Required: true, Index: true, Help: "ID of the user authenticated by the key"},
+		"ReadOnly":       fields.Boolean{Help: "If set, the key cannot be used to modify the database"},
+		"Models":         fields.Char{Help: "Comma separated list of the models that can be accessed with the key. All models if empty."},
+		"ExpirationDate": fields.DateTime{Help: "Date after which the key cannot be used anymore"},
+		"LastUsed":       fields.DateTime{NoCopy: true},
+	})
+}
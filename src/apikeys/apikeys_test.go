@@ -0,0 +1,52 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package apikeys
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Testing API keys", t, func() {
+		Convey("The APIKey model should be declared", func() {
+			models.BootStrap()
+			model := models.Registry.MustGet(ModelName)
+			So(model.IsMixin(), ShouldBeFalse)
+			fInfos := model.FieldsGet()
+			So(fInfos, ShouldContainKey, "key_id")
+			So(fInfos, ShouldContainKey, "hash")
+			So(fInfos["user_id"].Type, ShouldEqual, fieldtype.Integer)
+			So(fInfos["expiration_date"].Type, ShouldEqual, fieldtype.DateTime)
+		})
+		Convey("Well-formed keys should be recognized", func() {
+			keyID, err := randomHex(keyIDLen)
+			So(err, ShouldBeNil)
+			secret, err := randomHex(secretLen)
+			So(err, ShouldBeNil)
+			token := tokenPrefix + keyID + "_" + secret
+			So(IsAPIKey(token), ShouldBeTrue)
+			kID, sec, ok := splitToken(token)
+			So(ok, ShouldBeTrue)
+			So(kID, ShouldEqual, keyID)
+			So(sec, ShouldEqual, secret)
+		})
+		Convey("Malformed keys should not be recognized", func() {
+			So(IsAPIKey("secret"), ShouldBeFalse)
+			So(IsAPIKey("hexya_0123456789ab"), ShouldBeFalse)
+			So(IsAPIKey("hexya_0123_0123456789012345678901234567890123456789"), ShouldBeFalse)
+			So(IsAPIKey("hexya_0123456789ab_0123"), ShouldBeFalse)
+			So(IsAPIKey("hexya_0123456789ab_0123456789012345678901234567890123456789_a"), ShouldBeFalse)
+		})
+		Convey("Authenticating with a password should return a UserNotFoundError", func() {
+			_, _, err := Authenticate(context.Background(), "secret")
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+		})
+	})
+}
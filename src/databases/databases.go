@@ -0,0 +1,209 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package databases
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+)
+
+// compileFilter returns the regular expression of Filter for
+// the given host, or nil if Filter is empty.
+func compileFilter(host string) (*regexp.Regexp, error) {
+	if Filter == "" {
+		return nil, nil
+	}
+	domain := strings.TrimPrefix(host, "www.")
+	if i := strings.Index(domain, "."); i >= 0 {
+		domain = domain[:i]
+	}
+	pattern := strings.NewReplacer("%h", regexp.QuoteMeta(host), "%d", regexp.QuoteMeta(domain)).Replace(Filter)
+	return regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+}
+
+// hostName returns the host of the given request without port
+func hostName(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return r.Host
+	}
+	return host
+}
+
+// A selector selects the database of a request among the databases allowed for its host.
+//
+// The databases of the database server are only listed when necessary,
+// since connected databases are checked against the filter only.
+type selector struct {
+	filter *regexp.Regexp
+	names  []string
+	listed bool
+}
+
+// newSelector returns a new selector for the given host
+func newSelector(host string) (*selector, error) {
+	filter, err := compileFilter(host)
+	if err != nil {
+		return nil, err
+	}
+	return &selector{filter: filter}, nil
+}
+
+// list returns the names of the databases of the database server
+func (s *selector) list() ([]string, error) {
+	if s.listed {
+		return s.names, nil
+	}
+	names, err := listDatabases()
+	if err != nil {
+		return nil, err
+	}
+	s.names = names
+	s.listed = true
+	return names, nil
+}
+
+// allowed returns true if the database with the given name exists and matches the filter
+func (s *selector) allowed(name string) (bool, error) {
+	if s.filter != nil && !s.filter.MatchString(name) {
+		return false, nil
+	}
+	if _, ok := models.GetDatabase(name); ok {
+		return true, nil
+	}
+	names, err := s.list()
+	if err != nil {
+		return false, err
+	}
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// candidates returns the names of the databases that match the filter
+func (s *selector) candidates() ([]string, error) {
+	names, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	if s.filter == nil {
+		return names, nil
+	}
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		if s.filter.MatchString(name) {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// selectName returns the name of the database to serve, that is the first allowed
+// of the requested names, or the only candidate if Filter is set, or the default
+// database if it is allowed. It returns an empty string if there is none.
+func (s *selector) selectName(requested ...string) (string, error) {
+	for _, name := range requested {
+		if name == "" {
+			continue
+		}
+		ok, err := s.allowed(name)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return name, nil
+		}
+	}
+	if s.filter != nil {
+		candidates, err := s.candidates()
+		if err != nil {
+			return "", err
+		}
+		if len(candidates) == 1 {
+			return candidates[0], nil
+		}
+	}
+	defaultDB := models.DefaultDatabase()
+	if defaultDB == nil {
+		return "", nil
+	}
+	ok, err := s.allowed(defaultDB.Name())
+	if err != nil || !ok {
+		return "", err
+	}
+	return defaultDB.Name(), nil
+}
+
+// selectDatabase is the middleware that selects the database of the request
+// and binds the context of the request to it.
+//
+// The database is acquired for the time of the request, so that it is not
+// closed while serving it.
+func selectDatabase(c *server.Context) {
+	if models.DefaultDatabase() == nil || c.Request.URL.Path == ListPath {
+		// The database list does not depend on the database of the request
+		return
+	}
+	sess := c.Session()
+	sessionDB, _ := sess.Get("db").(string)
+	s, err := newSelector(hostName(c.Request))
+	if err != nil {
+		c.AbortWithJSONError(http.StatusInternalServerError, err)
+		return
+	}
+	name, err := s.selectName(c.Query("db"), sessionDB)
+	if err != nil {
+		log.Warn("Unable to select database", "host", c.Request.Host, "error", err)
+		c.AbortWithJSONError(http.StatusInternalServerError, err)
+		return
+	}
+	var database *models.Database
+	if name != "" {
+		database, err = models.AcquireDatabase(name)
+		if err != nil {
+			log.Warn("Unable to connect to database", "name", name, "error", err)
+			c.AbortWithJSONError(http.StatusServiceUnavailable, err)
+			return
+		}
+		defer database.Release()
+		if err := database.CheckSchema(); err != nil {
+			c.AbortWithJSONError(http.StatusServiceUnavailable, fmt.Errorf("database %s is not up to date: %s", name, err))
+			return
+		}
+	}
+	if name != sessionDB {
+		// The user of the session belongs to the previous database
+		sess.Delete("uid")
+		sess.Delete("pending_uid")
+		sess.Delete("login")
+		sess.Set("db", name)
+		if err := sess.Save(); err != nil {
+			c.AbortWithJSONError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	c.Request = c.Request.WithContext(models.ContextWithDatabase(c.Request.Context(), database))
+	c.Next()
+}
+
+// list is the controller that returns the names of
+// the databases allowed for the host of the request.
+func list(c *server.Context) {
+	s, err := newSelector(hostName(c.Request))
+	if err != nil {
+		c.RPC(http.StatusOK, nil, err)
+		return
+	}
+	names, err := s.candidates()
+	c.RPC(http.StatusOK, names, err)
+}
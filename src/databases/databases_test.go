@@ -0,0 +1,108 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package databases
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func performRequest(method, path, host, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Host = host
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+func TestDatabases(t *testing.T) {
+	listDatabases = func() ([]string, error) {
+		return []string{"acme", "acme_test", "globex"}, nil
+	}
+	defer func() {
+		Filter = ""
+		listDatabases = models.ListDatabases
+	}()
+	Convey("Testing the database filter", t, func() {
+		Convey("An empty filter should allow all databases", func() {
+			Filter = ""
+			s, err := newSelector("acme.example.com")
+			So(err, ShouldBeNil)
+			candidates, err := s.candidates()
+			So(err, ShouldBeNil)
+			So(candidates, ShouldResemble, []string{"acme", "acme_test", "globex"})
+		})
+		Convey("%d should be replaced by the first label of the host", func() {
+			Filter = "%d"
+			s, err := newSelector("www.acme.example.com")
+			So(err, ShouldBeNil)
+			candidates, err := s.candidates()
+			So(err, ShouldBeNil)
+			So(candidates, ShouldResemble, []string{"acme"})
+			name, err := s.selectName("", "")
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "acme")
+		})
+		Convey("%h should be replaced by the whole host", func() {
+			Filter = "%h_.*"
+			s, err := newSelector("acme")
+			So(err, ShouldBeNil)
+			candidates, err := s.candidates()
+			So(err, ShouldBeNil)
+			So(candidates, ShouldResemble, []string{"acme_test"})
+		})
+		Convey("Requested databases should be selected only if they are allowed", func() {
+			Filter = "acme.*"
+			s, err := newSelector("example.com")
+			So(err, ShouldBeNil)
+			name, err := s.selectName("globex", "acme_test")
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "acme_test")
+			name, err = s.selectName("unknown", "")
+			So(err, ShouldBeNil)
+			So(name, ShouldBeEmpty)
+		})
+		Convey("Invalid filters should return an error", func() {
+			Filter = "acme("
+			_, err := newSelector("example.com")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Listing errors should be returned", func() {
+			Filter = ""
+			listDatabases = func() ([]string, error) {
+				return nil, errors.New("connection refused")
+			}
+			s, err := newSelector("example.com")
+			So(err, ShouldBeNil)
+			_, err = s.selectName("acme")
+			So(err, ShouldNotBeNil)
+			listDatabases = func() ([]string, error) {
+				return []string{"acme", "acme_test", "globex"}, nil
+			}
+		})
+	})
+	Convey("Bootstrapping", t, func() {
+		Filter = ""
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Testing the database list endpoint", t, func() {
+		Filter = "%d"
+		w := performRequest(http.MethodPost, ListPath, "globex.example.com:8080", `{"jsonrpc": "2.0", "id": 1, "params": {}}`)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		var res server.ResponseRPC
+		So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+		So(res.Result, ShouldResemble, []interface{}{"globex"})
+	})
+}
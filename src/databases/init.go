@@ -0,0 +1,84 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package databases lets a single server process serve several databases.
+
+All the databases share the model definitions, but each request is served by
+the database selected for it by the middleware added by BootStrap, in this
+order:
+
+  - the database given in the 'db' parameter of the query,
+  - the database stored in the session by a previous request,
+  - the only database allowed by Filter for the host of the request,
+  - the default database, if it is allowed by Filter.
+
+The selected database is stored in the session and in the context of the
+request, so that the Environments created with this context are bound to it.
+When the selected database changes, the user is logged out of the session.
+If no database can be selected, the Environments of the request cannot
+be created and return models.ErrNoDatabase.
+
+Only the databases of the database server that match Filter can be selected.
+The databases other than the default database are connected when first
+selected and closed when they are idle, as set by the MaxDatabases and
+DatabaseIdleTimeout connection parameters of the default database.
+
+The schema of each database must have been updated beforehand, for instance
+with 'hexya updatedb --databases'.
+
+BootStrap also adds the following endpoint:
+
+	POST /web/database/list   Returns the names of the databases allowed
+	                          for the host of the request (JSON-RPC)
+*/
+package databases
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// ListPath is the path of the database list endpoint
+const ListPath = "/web/database/list"
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+)
+
+// Filter is the regular expression that the names of the databases served for
+// a request must match. It is matched against the whole name after replacing
+// '%h' by the host of the request without port and '%d' by the first label of
+// this host, a leading 'www' label being skipped. For instance, "%d" serves the
+// database 'acme' for the requests to 'acme.example.com'.
+//
+// If Filter is empty, all the databases of the database server are allowed.
+var Filter string
+
+// listDatabases returns the names of the databases of the database server.
+// It is a variable so that it can be replaced in tests.
+var listDatabases = models.ListDatabases
+
+// BootStrap adds the database selection middleware and the database
+// list controller to the controllers registry.
+//
+// It must be called before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("Databases selection is already bootstrapped")
+	}
+	if _, err := compileFilter("localhost"); err != nil {
+		log.Panic("Invalid database filter", "filter", Filter, "error", err)
+	}
+	controllers.Registry.AddMiddleWare(selectDatabase)
+	controllers.Registry.AddController(http.MethodPost, ListPath, list)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("databases")
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// A request is a GraphQL request as sent by clients
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// authenticate is the middleware that authenticates the user of the request
+// with an API key, with HTTP basic authentication, or with the session of
+// the web client.
+//
+// The uid of the authenticated user is stored in the context.
+func authenticate(c *server.Context) {
+	uid, err := c.AuthenticatedUID()
+	status := http.StatusUnauthorized
+	switch {
+	case err != nil:
+		status = c.AuthenticationFailureStatus(err)
+	case uid == 0:
+		c.Header("WWW-Authenticate", `Basic realm="Hexya"`)
+		err = errors.New("authentication required")
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(status, &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())},
+		})
+		return
+	}
+	c.Set("uid", uid)
+}
+
+// execute is the controller that executes GraphQL requests.
+//
+// The query can be given in the JSON body of a POST request, or in the
+// query parameters of a GET request, with the variables JSON encoded.
+// GET requests can only execute queries.
+func execute(c *server.Context) {
+	var req request
+	if err := bindRequest(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())},
+		})
+		return
+	}
+	if c.Request.Method != http.MethodPost && operationType(req.Query, req.OperationName) == ast.OperationTypeMutation {
+		// Mutations could otherwise be triggered by cross-site links
+		c.Header("Allow", http.MethodPost)
+		c.JSON(http.StatusMethodNotAllowed, &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError("mutations must be sent with POST requests")},
+		})
+		return
+	}
+	var result *graphql.Result
+	err := models.ExecuteInNewEnvironmentContext(c.Request.Context(), c.GetInt64("uid"), func(env models.Environment) {
+		state := &requestState{env: env.WithAPIScope(c.APIScope())}
+		result = graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        context.WithValue(c.Request.Context(), stateKey, state),
+		})
+		if result.HasErrors() && state.mutated {
+			// Roll back the transaction so that mutations are not partially applied
+			result.Data = nil
+			panic(exceptions.UserError{Message: result.Errors[0].Message})
+		}
+	})
+	if err != nil && result == nil {
+		c.JSON(http.StatusInternalServerError, &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// bindRequest binds the GraphQL request of the given context to req
+func bindRequest(c *server.Context, req *request) error {
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(req); err != nil {
+			return err
+		}
+	} else {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return err
+			}
+		}
+	}
+	if req.Query == "" {
+		return errors.New("missing query")
+	}
+	return nil
+}
+
+// operationType returns the type of the operation of the given query
+// that is executed for the given operation name, or an empty string
+// if there is no such operation. Invalid queries are reported by
+// graphql.Do when they are executed.
+func operationType(query, operationName string) string {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return ""
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (op.Name != nil && op.Name.Value == operationName) {
+			return op.Operation
+		}
+	}
+	return ""
+}
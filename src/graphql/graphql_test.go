@@ -0,0 +1,165 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testAuthBackend struct{}
+
+func (tab testAuthBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	if login != "admin" {
+		return 0, security.UserNotFoundError(login)
+	}
+	if secret != "secret" {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	return 1, nil
+}
+
+func performRequest(method, path, body string, auth bool) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	if auth {
+		req.SetBasicAuth("admin", "secret")
+	}
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+func TestGraphQL(t *testing.T) {
+	Convey("Bootstrapping GraphQL API before models should panic", t, func() {
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Creating models and bootstrapping", t, func() {
+		partner := models.NewModel("Partner")
+		invoice := models.NewModel("Invoice")
+		models.NewMixinModel("Activable")
+		partner.AddFields(map[string]models.FieldDefinition{
+			"Name":     fields.Char{Required: true, Help: "The partner's name"},
+			"Age":      fields.Integer{},
+			"Birthday": fields.Date{},
+			"Invoices": fields.One2Many{RelationModel: models.Registry.MustGet("Invoice"), ReverseFK: "Partner"},
+		})
+		invoice.AddFields(map[string]models.FieldDefinition{
+			"Amount":  fields.Float{},
+			"Partner": fields.Many2One{RelationModel: models.Registry.MustGet("Partner")},
+		})
+		models.BootStrap()
+		security.AuthenticationRegistry.RegisterBackend(testAuthBackend{})
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Testing the generated schema", t, func() {
+		So(schema.Type("Partner"), ShouldNotBeNil)
+		So(schema.Type("Invoice"), ShouldNotBeNil)
+		So(schema.Type("PartnerInput"), ShouldNotBeNil)
+		So(schema.Type("Activable"), ShouldBeNil)
+		partnerFields := schema.Type("Partner").(*graphql.Object).Fields()
+		So(partnerFields, ShouldContainKey, "name")
+		So(partnerFields, ShouldContainKey, "invoices_ids")
+		So(partnerFields, ShouldNotContainKey, "__last_update")
+		So(partnerFields["name"].Description, ShouldEqual, "The partner's name")
+		So(partnerFields["age"].Type, ShouldEqual, graphql.Int)
+		So(partnerFields["invoices_ids"].Type.String(), ShouldEqual, "[Invoice]")
+		invoiceFields := schema.Type("Invoice").(*graphql.Object).Fields()
+		So(invoiceFields["partner_id"].Type, ShouldEqual, schema.Type("Partner"))
+		So(invoiceFields["amount"].Type, ShouldEqual, graphql.Float)
+		inputFields := schema.Type("PartnerInput").(*graphql.InputObject).Fields()
+		So(inputFields, ShouldContainKey, "name")
+		So(inputFields, ShouldNotContainKey, "id")
+		So(inputFields, ShouldNotContainKey, "display_name")
+		So(inputFields["invoices_ids"].Type.String(), ShouldEqual, "[Int]")
+		So(schema.QueryType().Fields(), ShouldContainKey, "Partner")
+		So(schema.QueryType().Fields(), ShouldContainKey, "PartnerCount")
+		So(schema.MutationType().Fields(), ShouldContainKey, "createPartner")
+		So(schema.MutationType().Fields(), ShouldContainKey, "updatePartner")
+		So(schema.MutationType().Fields(), ShouldContainKey, "deletePartner")
+	})
+	Convey("Introspecting the schema", t, func() {
+		res := graphql.Do(graphql.Params{
+			Schema:        schema,
+			RequestString: `{ __type(name: "Invoice") { fields { name } } }`,
+			Context:       context.Background(),
+		})
+		So(res.Errors, ShouldBeEmpty)
+		data, _ := json.Marshal(res.Data)
+		So(string(data), ShouldContainSubstring, `{"name":"partner_id"}`)
+	})
+	Convey("Parsing JSON literals", t, func() {
+		doc, err := parser.Parse(parser.ParseParams{Source: `{ Partner(domain: [["name", "ilike", "jo"], "|", ["age", ">", 3.5], ["id", "in", [1, 2]], {a: true}]) { id } }`})
+		So(err, ShouldBeNil)
+		arg := doc.Definitions[0].(*ast.OperationDefinition).SelectionSet.Selections[0].(*ast.Field).Arguments[0]
+		So(parseLiteral(arg.Value), ShouldResemble, []interface{}{
+			[]interface{}{"name", "ilike", "jo"},
+			"|",
+			[]interface{}{"age", ">", 3.5},
+			[]interface{}{"id", "in", []interface{}{int64(1), int64(2)}},
+			map[string]interface{}{"a": true},
+		})
+	})
+	Convey("Getting the type of the executed operation", t, func() {
+		So(operationType("{ PartnerCount }", ""), ShouldEqual, ast.OperationTypeQuery)
+		So(operationType("mutation { deletePartner(id: 1) }", ""), ShouldEqual, ast.OperationTypeMutation)
+		So(operationType("query A { PartnerCount } mutation B { deletePartner(id: 1) }", "B"), ShouldEqual, ast.OperationTypeMutation)
+		So(operationType("query A { PartnerCount }", "B"), ShouldBeEmpty)
+		So(operationType("mutation {", ""), ShouldBeEmpty)
+	})
+	Convey("Getting selected fields", t, func() {
+		doc, err := parser.Parse(parser.ParseParams{Source: `
+query { Partner { id ...PartnerFields ... on Partner { age } invoices_ids { amount } } }
+fragment PartnerFields on Partner { name invoices_ids { partner_id { name } } }`})
+		So(err, ShouldBeNil)
+		fragments := map[string]ast.Definition{"PartnerFields": doc.Definitions[1].(*ast.FragmentDefinition)}
+		selSet := doc.Definitions[0].(*ast.OperationDefinition).SelectionSet.Selections[0].(*ast.Field).SelectionSet
+		selections := selectedFields(selSet, fragments)
+		So(selections, ShouldHaveLength, 4)
+		So(selections, ShouldContainKey, "id")
+		So(selections, ShouldContainKey, "name")
+		So(selections, ShouldContainKey, "age")
+		So(selections["invoices_ids"], ShouldHaveLength, 2)
+	})
+	Convey("Testing the endpoint", t, func() {
+		Convey("Requests without authentication should fail", func() {
+			w := performRequest(http.MethodPost, Path, `{"query": "{ PartnerCount }"}`, false)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Header().Get("WWW-Authenticate"), ShouldNotBeEmpty)
+			So(w.Body.String(), ShouldContainSubstring, "authentication required")
+		})
+		Convey("Requests without query should fail", func() {
+			w := performRequest(http.MethodPost, Path, `{"variables": {}}`, true)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, "missing query")
+			w = performRequest(http.MethodGet, Path+"?variables=foo", "", true)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Mutations should be rejected in GET requests", func() {
+			w := performRequest(http.MethodGet, Path+"?query="+url.QueryEscape("mutation { deletePartner(id: 1) }"), "", true)
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			So(w.Header().Get("Allow"), ShouldEqual, http.MethodPost)
+			So(w.Body.String(), ShouldContainSubstring, "mutations must be sent with POST requests")
+			w = performRequest(http.MethodGet, Path+"?operationName=Del&query="+url.QueryEscape("query Count { PartnerCount } mutation Del { deletePartner(id: 1) }"), "", true)
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
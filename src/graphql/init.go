@@ -0,0 +1,74 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package graphql implements a GraphQL endpoint whose schema is generated
+from the models registry at bootstrap.
+
+Each model becomes an object type with a field for each of its fields.
+Relation fields are resolved as nested objects, and the records of all
+the nested levels requested by a query are loaded with one Load call
+per level and model.
+
+For each model, the following root fields are defined:
+
+	Query:
+	  {Model}(domain: JSON, limit: Int, offset: Int, order: [String]): [{Model}]
+	  {Model}Count(domain: JSON): Int
+	Mutation:
+	  create{Model}(values: {Model}Input!): {Model}
+	  update{Model}(id: Int!, values: {Model}Input!): {Model}
+	  delete{Model}(id: Int!): Boolean
+
+The endpoint is served at /graphql. All requests are executed in the
+Environment of the authenticated user, so that method execution permissions
+and record rules apply.
+*/
+package graphql
+
+import (
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// Path is the path of the GraphQL endpoint
+const Path = "/graphql"
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+	// schema is the GraphQL schema computed at bootstrap
+	schema graphql.Schema
+)
+
+// BootStrap generates the GraphQL schema from the models registry and
+// adds the GraphQL controllers to the controllers registry.
+//
+// It must be called after the models have been bootstrapped and
+// before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("GraphQL API is already bootstrapped")
+	}
+	if !models.BootStrapped() {
+		log.Panic("Models must be bootstrapped before the GraphQL API")
+	}
+	var err error
+	schema, err = Schema()
+	if err != nil {
+		log.Panic("Unable to generate GraphQL schema", "error", err)
+	}
+	grp := controllers.Registry.AddGroup(Path)
+	grp.AddMiddleWare(authenticate)
+	grp.AddController(http.MethodGet, "", execute)
+	grp.AddController(http.MethodPost, "", execute)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("graphql")
+}
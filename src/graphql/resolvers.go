@@ -0,0 +1,284 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package graphql
+
+import (
+	"context"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// contextKey is the type of the keys of the values set by this package in the request context
+type contextKey int
+
+// stateKey is the context key of the requestState
+const stateKey contextKey = iota
+
+// A requestState holds the data of a GraphQL request shared by all resolvers
+type requestState struct {
+	env models.Environment
+	// mutated is set by mutation resolvers, so that the
+	// transaction is rolled back if the request has errors.
+	mutated bool
+}
+
+// getState returns the requestState of the given context
+func getState(ctx context.Context) *requestState {
+	return ctx.Value(stateKey).(*requestState)
+}
+
+// resolveSearch returns the resolver of the search query of the given model
+func resolveSearch(model *models.Model) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		rc := getState(p.Context).env.Pool(model.Name()).SearchAll()
+		rc.CheckRPCAccess("Load")
+		if domain, ok := p.Args["domain"].([]interface{}); ok && len(domain) > 0 {
+			cond := models.ParseDomain(domain, model)
+			rc.CheckFieldsAccess(cond.FieldNames()...)
+			rc = rc.Search(cond)
+		}
+		if limit, ok := p.Args["limit"].(int); ok {
+			rc = rc.Limit(limit)
+		}
+		if offset, ok := p.Args["offset"].(int); ok {
+			rc = rc.Offset(offset)
+		}
+		if order, ok := p.Args["order"].([]interface{}); ok && len(order) > 0 {
+			exprs := make([]string, len(order))
+			for i, o := range order {
+				exprs[i], _ = o.(string)
+			}
+			rc.CheckFieldsAccess(model.OrderFieldNames(exprs...)...)
+			rc = rc.OrderBy(exprs...)
+		}
+		rc = rc.Fetch()
+		prefetch(rc, p.Info.FieldASTs[0].SelectionSet, p.Info.Fragments)
+		return rc.Records(), nil
+	}
+}
+
+// resolveCount returns the resolver of the count query of the given model
+func resolveCount(model *models.Model) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		rc := getState(p.Context).env.Pool(model.Name()).SearchAll()
+		rc.CheckRPCAccess("Load")
+		rc.CheckExecutionPermission(model.Methods().MustGet("Load"))
+		if domain, ok := p.Args["domain"].([]interface{}); ok && len(domain) > 0 {
+			cond := models.ParseDomain(domain, model)
+			rc.CheckFieldsAccess(cond.FieldNames()...)
+			rc = rc.Search(cond)
+		}
+		return rc.SearchCount(), nil
+	}
+}
+
+// resolveCreate returns the resolver of the create mutation of the given model
+func resolveCreate(model *models.Model) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		state := getState(p.Context)
+		state.mutated = true
+		rc := state.env.Pool(model.Name())
+		values, _ := p.Args["values"].(map[string]interface{})
+		rec := rc.CallRPC("Create", models.NewModelDataFromRS(rc, convertValues(model, values))).(models.RecordSet).Collection()
+		prefetch(rec, p.Info.FieldASTs[0].SelectionSet, p.Info.Fragments)
+		return rec, nil
+	}
+}
+
+// resolveUpdate returns the resolver of the update mutation of the given model
+func resolveUpdate(model *models.Model) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		state := getState(p.Context)
+		state.mutated = true
+		rec := browseRecord(state.env, model, p.Args["id"])
+		if rec.IsEmpty() {
+			return nil, nil
+		}
+		values, _ := p.Args["values"].(map[string]interface{})
+		rec.CallRPC("Write", models.NewModelDataFromRS(rec, convertValues(model, values)))
+		prefetch(rec, p.Info.FieldASTs[0].SelectionSet, p.Info.Fragments)
+		return rec, nil
+	}
+}
+
+// resolveDelete returns the resolver of the delete mutation of the given model
+func resolveDelete(model *models.Model) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		state := getState(p.Context)
+		state.mutated = true
+		rec := browseRecord(state.env, model, p.Args["id"])
+		if rec.IsEmpty() {
+			return false, nil
+		}
+		rec.CallRPC("Unlink")
+		return true, nil
+	}
+}
+
+// resolveField returns the resolver of the field with the given info.
+// The source of the resolver is the record to read the field of.
+//
+// The schema is shared by all users, so that the access to
+// restricted fields and the API scope of related models are
+// checked here for each request.
+func resolveField(fi *models.FieldInfo) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		rec, ok := p.Source.(*models.RecordCollection)
+		if !ok || rec.IsEmpty() {
+			return nil, nil
+		}
+		fieldName := rec.Model().FieldName(fi.JSON)
+		rec.CheckFieldsAccess(fieldName)
+		switch value := rec.Get(fieldName).(type) {
+		case models.RecordSet:
+			rc := value.Collection()
+			rc.CheckRPCAccess("Load")
+			if fi.Type.Is2ManyRelationType() {
+				return rc.Records(), nil
+			}
+			if rc.IsEmpty() {
+				return nil, nil
+			}
+			return rc, nil
+		case dates.Date:
+			if value.IsZero() {
+				return nil, nil
+			}
+			return value.String(), nil
+		case dates.DateTime:
+			if value.IsZero() {
+				return nil, nil
+			}
+			return value.String(), nil
+		default:
+			return value, nil
+		}
+	}
+}
+
+// prefetch loads in the cache the fields of the records of rc requested in
+// the given selection set, as well as the fields of the related records
+// requested in the nested selection sets.
+//
+// Each level of relations is loaded with a single Load call per field,
+// so that resolving the fields of each record does not query the database.
+func prefetch(rc *models.RecordCollection, selectionSet *ast.SelectionSet, fragments map[string]ast.Definition) {
+	if rc.IsEmpty() || selectionSet == nil {
+		return
+	}
+	fInfos := accessibleFields(rc)
+	selections := selectedFields(selectionSet, fragments)
+	var fields models.FieldNames
+	for jsonName := range selections {
+		if _, ok := fInfos[jsonName]; ok {
+			fields = append(fields, rc.Model().FieldName(jsonName))
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	rc.Load(fields...)
+	for jsonName, subSelections := range selections {
+		fi, ok := fInfos[jsonName]
+		if !ok || !fi.Type.IsRelationType() {
+			continue
+		}
+		related := rc.Env().Pool(fi.Relation)
+		if !related.CheckRPCAccess("Load", true) {
+			// resolveField will return the error
+			continue
+		}
+		for _, rec := range rc.Records() {
+			if rs, ok := rec.Get(rc.Model().FieldName(jsonName)).(models.RecordSet); ok {
+				related = related.Union(rs.Collection())
+			}
+		}
+		for _, subSelection := range subSelections {
+			prefetch(related, subSelection, fragments)
+		}
+	}
+}
+
+// accessibleFields returns the exposed fields of rc's model
+// that the user of rc's Environment can access.
+func accessibleFields(rc *models.RecordCollection) map[string]*models.FieldInfo {
+	res := exposedFields(rc.Model())
+	for jsonName := range res {
+		if !rc.Model().FieldAccessibleBy(rc.Model().FieldName(jsonName), rc.Env().Uid()) {
+			delete(res, jsonName)
+		}
+	}
+	return res
+}
+
+// selectedFields returns the names of the fields of the given selection set,
+// including those of fragments, with their own selection sets.
+func selectedFields(selectionSet *ast.SelectionSet, fragments map[string]ast.Definition) map[string][]*ast.SelectionSet {
+	res := make(map[string][]*ast.SelectionSet)
+	for _, selection := range selectionSet.Selections {
+		var subSet *ast.SelectionSet
+		switch sel := selection.(type) {
+		case *ast.Field:
+			res[sel.Name.Value] = append(res[sel.Name.Value], sel.SelectionSet)
+			continue
+		case *ast.InlineFragment:
+			subSet = sel.SelectionSet
+		case *ast.FragmentSpread:
+			fragment, ok := fragments[sel.Name.Value].(*ast.FragmentDefinition)
+			if !ok {
+				continue
+			}
+			subSet = fragment.SelectionSet
+		}
+		for name, sets := range selectedFields(subSet, fragments) {
+			res[name] = append(res[name], sets...)
+		}
+	}
+	return res
+}
+
+// browseRecord returns the record of the given model with the given id.
+// The returned RecordCollection is empty if the record does not exist
+// or is not visible to the current user.
+func browseRecord(env models.Environment, model *models.Model, id interface{}) *models.RecordCollection {
+	recID, _ := id.(int)
+	return env.Pool(model.Name()).Search(model.Field(models.ID).Equals(int64(recID))).Fetch()
+}
+
+// convertValues returns a FieldMap from the given input values.
+// Date and DateTime values are parsed from strings and relation ids
+// are converted to int64.
+func convertValues(model *models.Model, values map[string]interface{}) models.FieldMap {
+	res := make(models.FieldMap)
+	fInfos := model.FieldsGet()
+	for jsonName, value := range values {
+		fi := fInfos[jsonName]
+		switch val := value.(type) {
+		case string:
+			switch fi.Type {
+			case fieldtype.Date:
+				value = dates.ParseDate(val)
+			case fieldtype.DateTime:
+				value = dates.ParseDateTime(val)
+			}
+		case int:
+			if fi.Type.IsRelationType() {
+				value = int64(val)
+			}
+		case []interface{}:
+			ids := make([]int64, len(val))
+			for i, v := range val {
+				id, _ := v.(int)
+				ids[i] = int64(id)
+			}
+			value = ids
+		}
+		res[jsonName] = value
+	}
+	return res
+}
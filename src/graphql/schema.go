@@ -0,0 +1,238 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// JSON is a scalar type for arbitrary JSON values, used for domains.
+// Literals are given with the GraphQL syntax, e.g. [["name", "ilike", "john"]].
+var JSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Arbitrary JSON value",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: parseLiteral,
+})
+
+// parseLiteral returns the Go value of the given GraphQL literal
+func parseLiteral(value ast.Value) interface{} {
+	switch val := value.(type) {
+	case *ast.StringValue:
+		return val.Value
+	case *ast.EnumValue:
+		return val.Value
+	case *ast.BooleanValue:
+		return val.Value
+	case *ast.IntValue:
+		res, _ := strconv.ParseInt(val.Value, 10, 64)
+		return res
+	case *ast.FloatValue:
+		res, _ := strconv.ParseFloat(val.Value, 64)
+		return res
+	case *ast.ListValue:
+		res := make([]interface{}, len(val.Values))
+		for i, v := range val.Values {
+			res[i] = parseLiteral(v)
+		}
+		return res
+	case *ast.ObjectValue:
+		res := make(map[string]interface{})
+		for _, f := range val.Fields {
+			res[f.Name.Value] = parseLiteral(f.Value)
+		}
+		return res
+	}
+	return nil
+}
+
+// Schema returns the GraphQL schema generated from the exposed models.
+//
+// Models must have been bootstrapped before calling this function.
+func Schema() (graphql.Schema, error) {
+	objects := make(map[string]*graphql.Object)
+	queryFields := make(graphql.Fields)
+	mutationFields := make(graphql.Fields)
+	for _, model := range models.Registry.AllModels() {
+		if !exposedModel(model) {
+			continue
+		}
+		objects[model.Name()] = modelObject(model, objects)
+	}
+	for _, model := range models.Registry.AllModels() {
+		if !exposedModel(model) {
+			continue
+		}
+		object := objects[model.Name()]
+		input := modelInput(model)
+		queryFields[model.Name()] = &graphql.Field{
+			Type:        graphql.NewList(object),
+			Description: fmt.Sprintf("Search %s records", model.Name()),
+			Args: graphql.FieldConfigArgument{
+				"domain": &graphql.ArgumentConfig{Type: JSON, Description: "Domain to filter the records"},
+				"limit":  &graphql.ArgumentConfig{Type: graphql.Int, Description: "Maximum number of records to return"},
+				"offset": &graphql.ArgumentConfig{Type: graphql.Int, Description: "Number of records to skip"},
+				"order":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String), Description: "Fields to order by, with an optional 'desc' suffix"},
+			},
+			Resolve: resolveSearch(model),
+		}
+		queryFields[model.Name()+"Count"] = &graphql.Field{
+			Type:        graphql.Int,
+			Description: fmt.Sprintf("Count %s records", model.Name()),
+			Args: graphql.FieldConfigArgument{
+				"domain": &graphql.ArgumentConfig{Type: JSON, Description: "Domain to filter the records"},
+			},
+			Resolve: resolveCount(model),
+		}
+		mutationFields["create"+model.Name()] = &graphql.Field{
+			Type:        object,
+			Description: fmt.Sprintf("Create a %s record", model.Name()),
+			Args: graphql.FieldConfigArgument{
+				"values": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+			},
+			Resolve: resolveCreate(model),
+		}
+		mutationFields["update"+model.Name()] = &graphql.Field{
+			Type:        object,
+			Description: fmt.Sprintf("Update a %s record", model.Name()),
+			Args: graphql.FieldConfigArgument{
+				"id":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				"values": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+			},
+			Resolve: resolveUpdate(model),
+		}
+		mutationFields["delete"+model.Name()] = &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: fmt.Sprintf("Delete a %s record", model.Name()),
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+			},
+			Resolve: resolveDelete(model),
+		}
+	}
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: queryFields,
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Mutation",
+			Fields: mutationFields,
+		}),
+	})
+}
+
+// exposedModel returns true if the given model is exposed by the GraphQL API
+func exposedModel(model *models.Model) bool {
+	return !model.IsMixin() && !model.IsM2MLink()
+}
+
+// exposedFields returns the infos of the fields of the given model
+// that can be exposed by the GraphQL API, indexed by their JSON names.
+func exposedFields(model *models.Model) map[string]*models.FieldInfo {
+	res := make(map[string]*models.FieldInfo)
+	for jsonName, fi := range model.FieldsGet() {
+		if strings.HasPrefix(jsonName, "__") {
+			// Names starting with __ are reserved by GraphQL
+			continue
+		}
+		if fi.Relation != "" {
+			if relModel, ok := models.Registry.Get(fi.Relation); !ok || !exposedModel(relModel) {
+				continue
+			}
+		}
+		res[jsonName] = fi
+	}
+	return res
+}
+
+// modelObject returns the object type of the given model. Relation fields
+// reference the object types of the related models in objects.
+func modelObject(model *models.Model, objects map[string]*graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: model.Name(),
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			res := make(graphql.Fields)
+			for jsonName, fi := range exposedFields(model) {
+				var typ graphql.Output
+				switch {
+				case fi.Type.Is2ManyRelationType():
+					typ = graphql.NewList(objects[fi.Relation])
+				case fi.Type.IsRelationType():
+					typ = objects[fi.Relation]
+				default:
+					typ = scalarType(fi.Type)
+				}
+				res[jsonName] = &graphql.Field{
+					Type:        typ,
+					Description: fieldDescription(fi),
+					Resolve:     resolveField(fi),
+				}
+			}
+			return res
+		}),
+	})
+}
+
+// modelInput returns the input object type of the given model,
+// used to create and update records.
+func modelInput(model *models.Model) *graphql.InputObject {
+	fields := make(graphql.InputObjectConfigFieldMap)
+	for jsonName, fi := range exposedFields(model) {
+		if fi.ReadOnly || !fi.Store || fi.Name == "ID" {
+			continue
+		}
+		var typ graphql.Input
+		switch {
+		case fi.Type.Is2ManyRelationType():
+			typ = graphql.NewList(graphql.Int)
+		case fi.Type.IsRelationType():
+			typ = graphql.Int
+		default:
+			typ = scalarType(fi.Type)
+		}
+		fields[jsonName] = &graphql.InputObjectFieldConfig{
+			Type:        typ,
+			Description: fieldDescription(fi),
+		}
+	}
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   model.Name() + "Input",
+		Fields: fields,
+	})
+}
+
+// scalarType returns the GraphQL scalar type of the given non relational field type.
+// Dates are represented as strings in the server format.
+func scalarType(typ fieldtype.Type) *graphql.Scalar {
+	switch typ {
+	case fieldtype.Boolean:
+		return graphql.Boolean
+	case fieldtype.Integer:
+		return graphql.Int
+	case fieldtype.Float:
+		return graphql.Float
+	}
+	return graphql.String
+}
+
+// fieldDescription returns the description of the field with the given info
+func fieldDescription(fi *models.FieldInfo) string {
+	if fi.Help != "" {
+		return fi.Help
+	}
+	return fi.String
+}
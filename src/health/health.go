@@ -0,0 +1,110 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// A Check is a readiness check. It returns an error if
+// the application is not ready to serve requests.
+type Check func() error
+
+// A namedCheck is a Check with its name
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// checks are the readiness checks run by the readyz controller
+var checks struct {
+	sync.RWMutex
+	list []namedCheck
+}
+
+// AddCheck adds the given readiness check with the given name.
+// It panics if a check with the same name already exists.
+func AddCheck(name string, check Check) {
+	checks.Lock()
+	defer checks.Unlock()
+	for _, nc := range checks.list {
+		if nc.name == name {
+			log.Panic("Readiness check already exists", "name", name)
+		}
+	}
+	checks.list = append(checks.list, namedCheck{name: name, check: check})
+}
+
+// Ready runs all the readiness checks and returns the error
+// of each check by name, nil if the check succeeded.
+func Ready() map[string]error {
+	checks.RLock()
+	defer checks.RUnlock()
+	res := make(map[string]error, len(checks.list))
+	for _, nc := range checks.list {
+		res[nc.name] = runCheck(nc.check)
+	}
+	return res
+}
+
+// runCheck runs the given check and returns its error.
+// A panic of the check is returned as an error.
+func runCheck(check Check) (rErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rErr = fmt.Errorf("%v", r)
+		}
+	}()
+	return check()
+}
+
+// checkBootStrapped returns an error if the models are not bootstrapped
+func checkBootStrapped() error {
+	if !models.BootStrapped() {
+		return errors.New("models are not bootstrapped")
+	}
+	return nil
+}
+
+// healthz is the controller that tells that the process is alive
+func healthz(c *server.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz is the controller that tells whether the application is ready
+func readyz(c *server.Context) {
+	status := http.StatusOK
+	results := make(gin.H)
+	for name, err := range Ready() {
+		if err != nil {
+			log.Warn("Readiness check failed", "check", name, "error", err)
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+			continue
+		}
+		results[name] = "ok"
+	}
+	res := gin.H{"status": "ok", "checks": results}
+	if status != http.StatusOK {
+		res["status"] = "unavailable"
+	}
+	c.JSON(status, res)
+}
+
+// promHandler serves the metrics of the metrics registry
+var promHandler = promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+
+// metricsHandler is the controller that returns the metrics of the application
+func metricsHandler(c *server.Context) {
+	promHandler.ServeHTTP(c.Writer, c.Request)
+}
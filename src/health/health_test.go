@@ -0,0 +1,90 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func performRequest(method, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+type readyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func getReady() (int, readyResponse) {
+	w := performRequest(http.MethodGet, "/readyz")
+	var res readyResponse
+	So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+	return w.Code, res
+}
+
+func TestHealth(t *testing.T) {
+	Convey("Bootstrapping", t, func() {
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Testing the health endpoints", t, func() {
+		Convey("The process should be alive", func() {
+			w := performRequest(http.MethodGet, "/healthz")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"ok"`)
+		})
+		Convey("The application should not be ready before bootstrap", func() {
+			code, res := getReady()
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(res.Status, ShouldEqual, "unavailable")
+			So(res.Checks["bootstrap"], ShouldEqual, "models are not bootstrapped")
+			So(res.Checks["database"], ShouldEqual, "not connected to database")
+		})
+		Convey("Bootstrap check should pass after bootstrap", func() {
+			models.BootStrap()
+			code, res := getReady()
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(res.Checks["bootstrap"], ShouldEqual, "ok")
+			So(res.Checks["database"], ShouldNotEqual, "ok")
+			So(res.Checks["schema"], ShouldNotEqual, "ok")
+		})
+		Convey("Panicking and failing custom checks should be reported", func() {
+			AddCheck("custom_panic", func() error { panic("boom") })
+			AddCheck("custom_error", func() error { return errors.New("not yet") })
+			So(func() { AddCheck("custom_error", func() error { return nil }) }, ShouldPanic)
+			_, res := getReady()
+			So(res.Checks["custom_panic"], ShouldEqual, "boom")
+			So(res.Checks["custom_error"], ShouldEqual, "not yet")
+		})
+		Convey("Metrics should be exposed in Prometheus format", func() {
+			w := performRequest(http.MethodGet, "/metrics")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			body := w.Body.String()
+			So(body, ShouldContainSubstring, `hexya_http_request_duration_seconds_count{method="GET",route="/readyz",status="503"}`)
+			So(body, ShouldContainSubstring, `hexya_http_request_duration_seconds_count{method="GET",route="/healthz",status="200"}`)
+			So(body, ShouldContainSubstring, "hexya_db_pool_open_connections 0")
+			So(body, ShouldContainSubstring, "hexya_db_serialization_retries_total 0")
+			So(body, ShouldContainSubstring, "go_goroutines")
+		})
+		Convey("Unknown routes should not create a metric per path", func() {
+			performRequest(http.MethodGet, "/unknown/12")
+			w := performRequest(http.MethodGet, "/metrics")
+			So(w.Body.String(), ShouldContainSubstring, `route="other",status="404"`)
+			So(w.Body.String(), ShouldNotContainSubstring, "/unknown/12")
+		})
+	})
+}
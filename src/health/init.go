@@ -0,0 +1,53 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package health provides the endpoints used by orchestrators and monitoring
+systems to probe the application:
+
+	GET /healthz   Returns 200 as long as the process is alive
+	GET /readyz    Returns 200 if the application can serve requests, that is
+	               if the models are bootstrapped, the database is reachable
+	               and its schema is up to date, and 503 otherwise
+	GET /metrics   Returns the metrics of the application in the Prometheus
+	               text format
+
+Modules can add their own readiness checks with AddCheck.
+*/
+package health
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
+)
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+)
+
+// BootStrap adds the health controllers to the controllers registry and
+// registers the database pool statistics in the metrics.
+//
+// It must be called before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("Health endpoints are already bootstrapped")
+	}
+	metrics.Registry.MustRegister(metrics.NewDBStatsCollector(models.DBStats))
+	controllers.Registry.AddController(http.MethodGet, "/healthz", healthz)
+	controllers.Registry.AddController(http.MethodGet, "/readyz", readyz)
+	controllers.Registry.AddController(http.MethodGet, "/metrics", metricsHandler)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("health")
+	AddCheck("bootstrap", checkBootStrapped)
+	AddCheck("database", models.DBPing)
+	AddCheck("schema", models.CheckDatabaseSchema)
+}
@@ -0,0 +1,34 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package ldap implements an authentication backend that checks the credentials
+of users against an LDAP directory such as OpenLDAP or Active Directory.
+
+A Backend is registered in the authentication registry like any other backend:
+
+	security.AuthenticationRegistry.RegisterBackend(&ldap.Backend{
+		URL:        "ldaps://ldap.example.com",
+		BaseDN:     "ou=people,dc=example,dc=com",
+		UserFilter: "(uid=%s)",
+	})
+
+The user entry is first searched with the service account of the backend, and
+the user is then authenticated by binding with its own DN and password.
+
+Users not found in the directory are left to the next backends of the
+registry, while users found with a wrong password are refused. Users can be
+created on their first login and the groups of their entry can be mapped to
+security groups.
+*/
+package ldap
+
+import (
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+var log logging.Logger
+
+func init() {
+	log = logging.GetLogger("ldap")
+}
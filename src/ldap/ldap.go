@@ -0,0 +1,282 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+)
+
+// A Backend is a security.AuthBackend that authenticates
+// users against an LDAP directory.
+type Backend struct {
+	// URL of the directory server, e.g. ldap://ldap.example.com:389
+	// or ldaps://ldap.example.com:636
+	URL string
+	// StartTLS is set to upgrade ldap:// connections to TLS
+	StartTLS bool
+	// CAFile is the path to the PEM file of the certificate authorities
+	// of the server. Defaults to the certificate authorities of the system.
+	CAFile string
+	// InsecureSkipVerify disables the verification of the certificate of
+	// the server. It should only be used for tests.
+	InsecureSkipVerify bool
+	// TLSConfig overrides the TLS configuration built from CAFile
+	// and InsecureSkipVerify.
+	TLSConfig *tls.Config `mapstructure:"-"`
+	// Timeout of the connection and of the requests to the server.
+	// Defaults to 10 seconds.
+	Timeout time.Duration
+	// BindDN and BindPassword are the credentials of the service account
+	// with which users are searched. Searches are anonymous if BindDN is empty.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the DN under which users are searched
+	BaseDN string
+	// UserFilter is the filter that finds the entry of a user, in which %s is
+	// replaced by the escaped login. Defaults to (uid=%s). Active Directory
+	// users are usually found with (sAMAccountName=%s).
+	UserFilter string
+	// NameAttribute and EmailAttribute are the attributes of the user entry
+	// holding the name and the email of the user. They default to cn and mail.
+	NameAttribute  string
+	EmailAttribute string
+	// GroupAttribute is the attribute of the user entry holding the DNs of
+	// the groups of the user. Defaults to memberOf.
+	GroupAttribute string
+	// GroupBaseDN and GroupFilter are used to search the groups of the user
+	// when the directory has no GroupAttribute, as OpenLDAP without the
+	// memberof overlay. %s is replaced by the escaped DN of the user,
+	// e.g. (&(objectClass=groupOfNames)(member=%s)).
+	GroupBaseDN string
+	GroupFilter string
+	// GroupMapping maps the DNs of directory groups to the IDs of security
+	// groups. Users are added to the mapped groups of their entry and
+	// removed from the other mapped groups at each login.
+	GroupMapping map[string]string
+	// CreateUsers is set to create the users logging in for the first time
+	CreateUsers bool
+}
+
+// conn is the subset of the methods of an LDAP connection used by the backend
+type conn interface {
+	Bind(username, password string) error
+	StartTLS(config *tls.Config) error
+	Search(searchRequest *ldapv3.SearchRequest) (*ldapv3.SearchResult, error)
+	Close()
+}
+
+// dial opens a connection to the server of the given backend.
+// It is a variable so that tests can use a stand-in directory.
+var dial = func(b *Backend, tlsConfig *tls.Config) (conn, error) {
+	c, err := ldapv3.DialURL(b.URL,
+		ldapv3.DialWithDialer(&net.Dialer{Timeout: b.timeout()}),
+		ldapv3.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	c.SetTimeout(b.timeout())
+	return c, nil
+}
+
+// timeout returns the timeout of the requests to the server
+func (b *Backend) timeout() time.Duration {
+	if b.Timeout == 0 {
+		return 10 * time.Second
+	}
+	return b.Timeout
+}
+
+// userFilter returns the filter to find the entry of the user with the given login
+func (b *Backend) userFilter(login string) string {
+	filter := b.UserFilter
+	if filter == "" {
+		filter = "(uid=%s)"
+	}
+	return fmt.Sprintf(filter, ldapv3.EscapeFilter(login))
+}
+
+// attribute returns value if it is not empty and def otherwise
+func attribute(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// tlsConfig returns the TLS configuration of the connections to the server
+func (b *Backend) tlsConfig() (*tls.Config, error) {
+	if b.TLSConfig != nil {
+		return b.TLSConfig, nil
+	}
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: b.InsecureSkipVerify,
+	}
+	if b.CAFile != "" {
+		pem, err := ioutil.ReadFile(b.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", b.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// connect opens a connection to the server, upgrades it to TLS if
+// StartTLS is set and binds with the service account.
+func (b *Backend) connect() (conn, error) {
+	tlsConfig, err := b.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := dial(b, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if b.StartTLS {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if b.BindDN != "" {
+		if err = c.Bind(b.BindDN, b.BindPassword); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("unable to bind with service account: %s", err)
+		}
+	}
+	return c, nil
+}
+
+// findUser returns the entry of the user with the given login.
+// It returns a security.UserNotFoundError if there is no such user
+// and a security.InvalidCredentialsError if several entries match.
+func (b *Backend) findUser(c conn, login string) (*ldapv3.Entry, error) {
+	attrs := []string{
+		attribute(b.NameAttribute, "cn"),
+		attribute(b.EmailAttribute, "mail"),
+		attribute(b.GroupAttribute, "memberOf"),
+	}
+	res, err := c.Search(ldapv3.NewSearchRequest(b.BaseDN, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+		2, int(b.timeout().Seconds()), false, b.userFilter(login), attrs, nil))
+	if err != nil && !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	switch {
+	case res == nil || len(res.Entries) == 0:
+		return nil, security.UserNotFoundError(login)
+	case len(res.Entries) > 1:
+		log.Warn("Several LDAP entries match login", "url", b.URL, "login", login)
+		return nil, security.InvalidCredentialsError(login)
+	}
+	return res.Entries[0], nil
+}
+
+// userGroups returns the DNs of the groups of the given user entry
+func (b *Backend) userGroups(c conn, entry *ldapv3.Entry) ([]string, error) {
+	if b.GroupFilter == "" {
+		return entry.GetAttributeValues(attribute(b.GroupAttribute, "memberOf")), nil
+	}
+	res, err := c.Search(ldapv3.NewSearchRequest(attribute(b.GroupBaseDN, b.BaseDN), ldapv3.ScopeWholeSubtree,
+		ldapv3.NeverDerefAliases, 0, int(b.timeout().Seconds()), false,
+		fmt.Sprintf(b.GroupFilter, ldapv3.EscapeFilter(entry.DN)), []string{"dn"}, nil))
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, len(res.Entries))
+	for i, groupEntry := range res.Entries {
+		groups[i] = groupEntry.DN
+	}
+	return groups, nil
+}
+
+// Authenticate the user with the given login and password against the directory.
+//
+// It returns a security.UserNotFoundError if the user is not found in the
+// directory, or if any request to the directory fails (connection, bind of the
+// service account or search), so that the next backends can authenticate the
+// user. It returns a security.InvalidCredentialsError if the user is found but
+// the password is wrong, or if several entries match the login.
+//
+// Users are resolved in the database named in the given authentication context.
+func (b *Backend) Authenticate(login, secret string, authCtx *types.Context) (int64, error) {
+	if login == "" {
+		return 0, security.UserNotFoundError(login)
+	}
+	c, err := b.connect()
+	if err != nil {
+		return 0, b.unavailable(login, "Unable to connect to LDAP server", err)
+	}
+	defer c.Close()
+	entry, err := b.findUser(c, login)
+	switch err.(type) {
+	case nil:
+	case security.UserNotFoundError, security.InvalidCredentialsError:
+		return 0, err
+	default:
+		return 0, b.unavailable(login, "Unable to search LDAP user", err)
+	}
+	// An empty password would be an unauthenticated bind, which succeeds
+	// without checking anything on most servers.
+	if secret == "" {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	if err = c.Bind(entry.DN, secret); err != nil {
+		if !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultInvalidCredentials) {
+			log.Warn("Unable to bind LDAP user", "url", b.URL, "dn", entry.DN, "error", err)
+		}
+		return 0, security.InvalidCredentialsError(login)
+	}
+	user := Entry{
+		DN:    entry.DN,
+		Name:  entry.GetAttributeValue(attribute(b.NameAttribute, "cn")),
+		Email: entry.GetAttributeValue(attribute(b.EmailAttribute, "mail")),
+	}
+	if len(b.GroupMapping) > 0 {
+		// Groups are searched with the service account, since
+		// users may not be allowed to read the groups.
+		if b.BindDN != "" {
+			if err = c.Bind(b.BindDN, b.BindPassword); err != nil {
+				return 0, b.unavailable(login, "Unable to bind with LDAP service account", err)
+			}
+		}
+		if user.Groups, err = b.userGroups(c, entry); err != nil {
+			return 0, b.unavailable(login, "Unable to search LDAP groups", err)
+		}
+	}
+	ctx := models.ContextFromAuthentication(authCtx)
+	uid, err := ResolveUser(ctx, b, login, user)
+	if err != nil {
+		return 0, err
+	}
+	b.syncGroups(ctx, uid, user.Groups)
+	return uid, nil
+}
+
+// unavailable logs the given failure of the directory and returns the
+// error of Authenticate when the directory is unavailable.
+func (b *Backend) unavailable(login, msg string, err error) error {
+	log.Warn(msg, "url", b.URL, "login", login, "error", err)
+	return security.UserNotFoundError(login)
+}
+
+var _ security.AuthBackend = new(Backend)
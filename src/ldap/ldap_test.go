@@ -0,0 +1,293 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	"github.com/hexya-erp/hexya/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testEntry is an entry of the test directory
+type testEntry struct {
+	password string
+	attrs    map[string][]string
+}
+
+// testDirectory is an in-process stand-in for an LDAP server. It only
+// understands filters made of equality assertions, which must all match.
+type testDirectory struct {
+	entries  map[string]testEntry
+	down     bool
+	broken   bool
+	startTLS bool
+	binds    []string
+}
+
+// testConn is a connection to a testDirectory
+type testConn struct {
+	dir   *testDirectory
+	bound string
+}
+
+var assertionRegexp = regexp.MustCompile(`\(([a-zA-Z]+)=([^()]*)\)`)
+
+func (c *testConn) Bind(username, password string) error {
+	c.dir.binds = append(c.dir.binds, username)
+	entry, ok := c.dir.entries[username]
+	if !ok || entry.password != password {
+		return ldapv3.NewError(ldapv3.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.bound = username
+	return nil
+}
+
+func (c *testConn) StartTLS(config *tls.Config) error {
+	c.dir.startTLS = true
+	return nil
+}
+
+func (c *testConn) Search(req *ldapv3.SearchRequest) (*ldapv3.SearchResult, error) {
+	if c.bound == "" {
+		return nil, ldapv3.NewError(ldapv3.LDAPResultInsufficientAccessRights, errors.New("anonymous search"))
+	}
+	if c.dir.broken {
+		return nil, ldapv3.NewError(ldapv3.LDAPResultBusy, errors.New("server busy"))
+	}
+	res := new(ldapv3.SearchResult)
+	for dn, entry := range c.dir.entries {
+		if !strings.HasSuffix(dn, req.BaseDN) || !matches(dn, entry, req.Filter) {
+			continue
+		}
+		e := &ldapv3.Entry{DN: dn}
+		for _, attr := range req.Attributes {
+			if values, ok := entry.attrs[attr]; ok {
+				e.Attributes = append(e.Attributes, &ldapv3.EntryAttribute{Name: attr, Values: values})
+			}
+		}
+		res.Entries = append(res.Entries, e)
+	}
+	return res, nil
+}
+
+func (c *testConn) Close() {}
+
+// groupSearchFailingConn is a testConn on which group searches fail
+type groupSearchFailingConn struct {
+	*testConn
+}
+
+func (c *groupSearchFailingConn) Search(req *ldapv3.SearchRequest) (*ldapv3.SearchResult, error) {
+	if strings.Contains(req.Filter, "groupOfNames") {
+		return nil, ldapv3.NewError(ldapv3.LDAPResultBusy, errors.New("server busy"))
+	}
+	return c.testConn.Search(req)
+}
+
+// matches returns true if the entry with the given dn matches all the
+// equality assertions of the given filter
+func matches(dn string, entry testEntry, filter string) bool {
+	for _, assertion := range assertionRegexp.FindAllStringSubmatch(filter, -1) {
+		var found bool
+		for _, value := range entry.attrs[assertion[1]] {
+			if ldapv3.EscapeFilter(value) == assertion[2] {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLDAP(t *testing.T) {
+	dir := &testDirectory{
+		entries: map[string]testEntry{
+			"cn=hexya,ou=services,dc=example,dc=com": {password: "service"},
+			"uid=jdoe,ou=people,dc=example,dc=com": {
+				password: "secret",
+				attrs: map[string][]string{
+					"objectClass": {"inetOrgPerson"},
+					"uid":         {"jdoe"},
+					"cn":          {"John Doe"},
+					"mail":        {"jdoe@example.com"},
+					"memberOf":    {"CN=Accounting,ou=groups,dc=example,dc=com"},
+				},
+			},
+			"uid=dup,ou=people,dc=example,dc=com": {
+				password: "secret",
+				attrs:    map[string][]string{"objectClass": {"inetOrgPerson"}, "uid": {"twin"}},
+			},
+			"uid=dup2,ou=people,dc=example,dc=com": {
+				password: "secret",
+				attrs:    map[string][]string{"objectClass": {"inetOrgPerson"}, "uid": {"twin"}},
+			},
+			"cn=sales,ou=groups,dc=example,dc=com": {
+				attrs: map[string][]string{
+					"objectClass": {"groupOfNames"},
+					"member":      {"uid=jdoe,ou=people,dc=example,dc=com"},
+				},
+			},
+		},
+	}
+	dial = func(b *Backend, tlsConfig *tls.Config) (conn, error) {
+		if dir.down {
+			return nil, errors.New("connection refused")
+		}
+		return &testConn{dir: dir}, nil
+	}
+	accounting := security.Registry.NewGroup("ldap_accounting", "Accounting")
+	sales := security.Registry.NewGroup("ldap_sales", "Sales")
+	var resolved Entry
+	ResolveUser = func(ctx context.Context, b *Backend, login string, entry Entry) (int64, error) {
+		if login != "jdoe" && !b.CreateUsers {
+			return 0, security.UserNotFoundError(login)
+		}
+		resolved = entry
+		return 9, nil
+	}
+	defer func() { ResolveUser = resolveUser }()
+	backend := &Backend{
+		URL:          "ldap://ldap.example.com",
+		StartTLS:     true,
+		BindDN:       "cn=hexya,ou=services,dc=example,dc=com",
+		BindPassword: "service",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		UserFilter:   "(&(objectClass=inetOrgPerson)(uid=%s))",
+		GroupMapping: map[string]string{
+			"cn=accounting,ou=groups,dc=example,dc=com": "ldap_accounting",
+			"cn=sales,ou=groups,dc=example,dc=com":      "ldap_sales",
+		},
+	}
+
+	Convey("Testing LDAP authentication", t, func() {
+		Convey("A valid password should authenticate the user", func() {
+			dir.binds = nil
+			uid, err := backend.Authenticate("jdoe", "secret", nil)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 9)
+			So(dir.startTLS, ShouldBeTrue)
+			So(dir.binds, ShouldResemble, []string{
+				"cn=hexya,ou=services,dc=example,dc=com",
+				"uid=jdoe,ou=people,dc=example,dc=com",
+				"cn=hexya,ou=services,dc=example,dc=com",
+			})
+			So(resolved.DN, ShouldEqual, "uid=jdoe,ou=people,dc=example,dc=com")
+			So(resolved.Name, ShouldEqual, "John Doe")
+			So(resolved.Email, ShouldEqual, "jdoe@example.com")
+		})
+		Convey("A wrong or empty password should be refused", func() {
+			_, err := backend.Authenticate("jdoe", "wrong", nil)
+			So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+			_, err = backend.Authenticate("jdoe", "", nil)
+			So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+		})
+		Convey("Unknown users should be left to other backends", func() {
+			_, err := backend.Authenticate("unknown", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			_, err = backend.Authenticate("", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+		})
+		Convey("Logins should be escaped in filters", func() {
+			_, err := backend.Authenticate("*", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			So(backend.userFilter("j*)(uid=*"), ShouldEqual, `(&(objectClass=inetOrgPerson)(uid=j\2a\29\28uid=\2a))`)
+		})
+		Convey("Ambiguous logins should be refused", func() {
+			_, err := backend.Authenticate("twin", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+		})
+		Convey("Users found in the directory but not in Hexya should not be authenticated", func() {
+			dir.entries["uid=jsmith,ou=people,dc=example,dc=com"] = testEntry{
+				password: "secret",
+				attrs:    map[string][]string{"objectClass": {"inetOrgPerson"}, "uid": {"jsmith"}},
+			}
+			_, err := backend.Authenticate("jsmith", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			Convey("unless users are created on first login", func() {
+				backend.CreateUsers = true
+				uid, err := backend.Authenticate("jsmith", "secret", nil)
+				So(err, ShouldBeNil)
+				So(uid, ShouldEqual, 9)
+				backend.CreateUsers = false
+			})
+			delete(dir.entries, "uid=jsmith,ou=people,dc=example,dc=com")
+		})
+		Convey("An unreachable server should be left to other backends", func() {
+			dir.down = true
+			_, err := backend.Authenticate("jdoe", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			dir.down = false
+		})
+		Convey("A wrong service account password should be left to other backends", func() {
+			backend.BindPassword = "wrong"
+			_, err := backend.Authenticate("jdoe", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			backend.BindPassword = "service"
+		})
+		Convey("A failing search should be left to other backends", func() {
+			dir.broken = true
+			_, err := backend.Authenticate("jdoe", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			dir.broken = false
+		})
+	})
+	Convey("Testing group mapping", t, func() {
+		security.Registry.AddMembership(9, sales)
+		Convey("Groups of the memberOf attribute should be mapped", func() {
+			_, err := backend.Authenticate("jdoe", "secret", nil)
+			So(err, ShouldBeNil)
+			So(resolved.Groups, ShouldResemble, []string{"CN=Accounting,ou=groups,dc=example,dc=com"})
+			So(security.Registry.HasMembership(9, accounting), ShouldBeTrue)
+			So(security.Registry.HasMembership(9, sales), ShouldBeFalse)
+		})
+		Convey("Groups found with a group filter should be mapped", func() {
+			backend.GroupBaseDN = "ou=groups,dc=example,dc=com"
+			backend.GroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+			_, err := backend.Authenticate("jdoe", "secret", nil)
+			So(err, ShouldBeNil)
+			So(resolved.Groups, ShouldResemble, []string{"cn=sales,ou=groups,dc=example,dc=com"})
+			So(security.Registry.HasMembership(9, accounting), ShouldBeFalse)
+			So(security.Registry.HasMembership(9, sales), ShouldBeTrue)
+			backend.GroupBaseDN = ""
+			backend.GroupFilter = ""
+		})
+		Convey("A failing group search should be left to other backends", func() {
+			backend.GroupBaseDN = "ou=groups,dc=example,dc=com"
+			backend.GroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+			testDial := dial
+			dial = func(b *Backend, tlsConfig *tls.Config) (conn, error) {
+				c, err := testDial(b, tlsConfig)
+				if err != nil {
+					return nil, err
+				}
+				return &groupSearchFailingConn{testConn: c.(*testConn)}, nil
+			}
+			_, err := backend.Authenticate("jdoe", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			dial = testDial
+			backend.GroupBaseDN = ""
+			backend.GroupFilter = ""
+		})
+		security.Registry.RemoveAllMembershipsForUser(9)
+	})
+	Convey("Registering the backend in the authentication registry", t, func() {
+		registry := new(security.AuthBackendRegistry)
+		registry.RegisterBackend(backend)
+		uid, err := registry.Authenticate("jdoe", "secret", nil)
+		So(err, ShouldBeNil)
+		So(uid, ShouldEqual, 9)
+		_, err = registry.Authenticate("jdoe", "wrong", nil)
+		So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+		security.Registry.RemoveAllMembershipsForUser(9)
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package ldap
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/users"
+)
+
+// An Entry holds the data of the directory entry of an authenticated user
+type Entry struct {
+	DN    string
+	Name  string
+	Email string
+	// Groups are the DNs of the groups of the user. They are
+	// only searched if the backend has a GroupMapping.
+	Groups []string
+}
+
+// ResolveUser returns the uid of the user with the given login authenticated
+// by the given backend with the given directory entry, in the database of ctx.
+//
+// It should return a security.UserNotFoundError if the user does not exist
+// and cannot be created. The default implementation searches the login in
+// the Login field of the User model and creates the user if CreateUsers is set
+// on the backend. Users are linked by login since the directory is the
+// authority for the logins. It can be replaced by applications with other user models.
+var ResolveUser = resolveUser
+
+// resolveUser is the default implementation of ResolveUser
+func resolveUser(ctx context.Context, b *Backend, login string, entry Entry) (int64, error) {
+	if !users.Available() {
+		return 0, security.UserNotFoundError(login)
+	}
+	var uid int64
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		if uid = users.FindByLogin(env, login); uid != 0 || !b.CreateUsers {
+			return
+		}
+		uid = users.Create(env, users.Profile{Login: login, Name: entry.Name, Email: entry.Email})
+		log.Info("Created user on first LDAP login", "url", b.URL, "login", login, "uid", uid)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if uid == 0 {
+		return 0, security.UserNotFoundError(login)
+	}
+	return uid, nil
+}
+
+// syncGroups adds the user with the given uid to the security groups mapped
+// from the given directory groups and removes it from the other mapped groups,
+// in the database of ctx. Group DNs are compared case insensitively.
+func (b *Backend) syncGroups(ctx context.Context, uid int64, groupDNs []string) {
+	if len(b.GroupMapping) == 0 {
+		return
+	}
+	granted := make(map[string]bool)
+	for _, dn := range groupDNs {
+		granted[strings.ToLower(dn)] = true
+	}
+	mapped := make(map[string]bool)
+	for dn, groupID := range b.GroupMapping {
+		mapped[groupID] = mapped[groupID] || granted[strings.ToLower(dn)]
+	}
+	for _, groupID := range users.SyncGroups(ctx, uid, mapped) {
+		log.Warn("Unknown group in LDAP group mapping", "url", b.URL, "group", groupID)
+	}
+}
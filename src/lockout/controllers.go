@@ -0,0 +1,95 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package lockout
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+)
+
+// An unlockRequest is the body of unlock requests
+type unlockRequest struct {
+	Login   string `json:"login"`
+	Address string `json:"address"`
+}
+
+// checkAdmin aborts the request and returns false if the
+// user of the request is not a member of the admin group.
+//
+// Lockouts do not belong to any model, so that API keys restricted to some
+// models are rejected. API keys must not be read only to manage lockouts,
+// which is the case if write is true.
+func checkAdmin(c *server.Context, write bool) bool {
+	uid, err := c.AuthenticatedUID()
+	scope := c.APIScope()
+	switch {
+	case err != nil:
+		c.AbortWithJSONError(c.AuthenticationFailureStatus(err), err)
+		return false
+	case uid == 0:
+		c.AbortWithJSONError(http.StatusUnauthorized, errors.New("authentication required"))
+		return false
+	case !security.Registry.HasMembershipContext(c.Request.Context(), uid, security.GroupAdmin):
+		c.AbortWithJSONError(http.StatusForbidden, errors.New("only administrators can manage lockouts"))
+		return false
+	case scope != nil && len(scope.Models) > 0:
+		c.AbortWithJSONError(http.StatusForbidden, errors.New("this API key cannot manage lockouts"))
+		return false
+	case scope != nil && scope.ReadOnly && write:
+		c.AbortWithJSONError(http.StatusForbidden, errors.New("this API key is read only"))
+		return false
+	}
+	return true
+}
+
+// list is the controller that returns the logins and
+// addresses with failed attempts.
+func list(c *server.Context) {
+	if !checkAdmin(c, false) {
+		return
+	}
+	attempts, err := Attempts(c.Request.Context())
+	if err != nil {
+		c.AbortWithJSONError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+}
+
+// unlock is the controller that forgets the failed attempts
+// of the given login or address.
+//
+// It only accepts API keys given in an 'Authorization: Bearer' header, since
+// browsers send session cookies and cached basic credentials with cross-site
+// requests.
+func unlock(c *server.Context) {
+	if !strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+		c.AbortWithJSONError(http.StatusUnauthorized, errors.New("an API key is required to unlock"))
+		return
+	}
+	if !checkAdmin(c, true) {
+		return
+	}
+	var req unlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	if req.Login == "" && req.Address == "" {
+		c.AbortWithJSONError(http.StatusBadRequest, errors.New("login or address is required"))
+		return
+	}
+	if req.Login != "" {
+		security.AuthenticationRegistry.Unlock(c.Request.Context(), req.Login)
+	}
+	if req.Address != "" {
+		security.AuthenticationRegistry.UnlockAddress(c.Request.Context(), req.Address)
+	}
+	c.JSON(http.StatusOK, gin.H{"unlocked": true})
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package lockout stores the failed login attempts in the database, so that the
+throttling of the authentication registry works across all the processes of
+the application.
+
+Failed attempts are counted by login and by client address. After a few
+failures, further attempts are delayed progressively and the account is locked
+after Policy.MaxFailures failures. See security.ThrottlingPolicy.
+
+BootStrap adds the following endpoints for administrators, under /auth/lockout:
+
+	GET  /         List the logins and addresses with failed attempts
+	POST /unlock   Unlock the login or the address given as
+	               {"login": "jdoe"} or {"address": "192.0.2.1"}
+
+Unlock requests must be authenticated by an API key given in an
+'Authorization: Bearer' header, so that they cannot be forged by other sites.
+*/
+package lockout
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// Path is the path of the lockout administration endpoints
+const Path = "/auth/lockout"
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+)
+
+// Policy is the throttling policy set on the authentication registry by BootStrap
+var Policy = security.DefaultThrottlingPolicy
+
+// BootStrap sets the database store and Policy as the throttling of the
+// authentication registry and adds the lockout controllers to the
+// controllers registry.
+//
+// It must be called after the models have been bootstrapped and
+// before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("Lockout is already bootstrapped")
+	}
+	if !models.BootStrapped() {
+		log.Panic("Models must be bootstrapped before lockout")
+	}
+	security.AuthenticationRegistry.SetThrottling(Policy, Store{})
+	grp := controllers.Registry.AddGroup(Path)
+	grp.AddController(http.MethodGet, "/", list)
+	grp.AddController(http.MethodPost, "/unlock", unlock)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("lockout")
+	loginAttempt := models.NewModel(ModelName)
+	loginAttempt.AddFields(map[string]models.FieldDefinition{
+		"Key":         fields.Char{Required: true, Unique: true, Index: true, Help: "Login or address with failed attempts"},
+		"Failures":    fields.Integer{Help: "Number of consecutive failed attempts"},
+		"LastFailure": fields.DateTime{},
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// ModelName is the name of the model in which failed login attempts are stored
+const ModelName = "LoginAttempt"
+
+// An Attempt holds the failed attempts of a login or of an address
+type Attempt struct {
+	Login       string    `json:"login,omitempty"`
+	Address     string    `json:"address,omitempty"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// A Store is a security.LoginAttemptStore that keeps the failed
+// attempts in the database.
+//
+// Attempts are stored in the database of the context given by the
+// registry, that is the database of the request.
+//
+// Counters are updated with a single statement so that concurrent attempts
+// on several processes are all counted. If the database cannot be reached,
+// attempts are not throttled.
+type Store struct{}
+
+// tableName returns the name of the table of the LoginAttempt model
+func tableName() string {
+	return models.Registry.MustGet(ModelName).TableName()
+}
+
+// Failures returns the number of failures recorded for
+// the given key and the time of the last one.
+func (s Store) Failures(ctx context.Context, key string) (int, time.Time) {
+	var res []struct {
+		Failures    int
+		LastFailure time.Time `db:"last_failure"`
+	}
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		env.Cr().Select(&res, fmt.Sprintf(`SELECT failures, last_failure FROM %s WHERE key = ?`, tableName()), key)
+	})
+	if err != nil {
+		log.Warn("Unable to read failed login attempts", "key", key, "error", err)
+		return 0, time.Time{}
+	}
+	if len(res) == 0 {
+		return 0, time.Time{}
+	}
+	return res[0].Failures, res[0].LastFailure
+}
+
+// RecordFailure records a failure at the given time for the
+// given key and returns the number of failures.
+func (s Store) RecordFailure(ctx context.Context, key string, t time.Time, window time.Duration) int {
+	var failures int
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		env.Cr().Get(&failures, fmt.Sprintf(`
+			INSERT INTO %[1]s (key, failures, last_failure) VALUES (?, 1, ?)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN %[1]s.last_failure < ? THEN 1 ELSE %[1]s.failures + 1 END,
+				last_failure = EXCLUDED.last_failure
+			RETURNING failures`, tableName()), key, t.UTC(), t.Add(-window).UTC())
+	})
+	if err != nil {
+		log.Warn("Unable to record failed login attempt", "key", key, "error", err)
+	}
+	return failures
+}
+
+// Reset forgets the failures of the given key
+func (s Store) Reset(ctx context.Context, key string) {
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		env.Cr().Execute(fmt.Sprintf(`DELETE FROM %s WHERE key = ?`, tableName()), key)
+	})
+	if err != nil {
+		log.Warn("Unable to reset failed login attempts", "key", key, "error", err)
+	}
+}
+
+var _ security.LoginAttemptStore = Store{}
+
+// Attempts returns the logins and addresses with failed attempts in the last
+// Policy.Window in the database of ctx. Older failures are removed from the
+// database.
+func Attempts(ctx context.Context) ([]Attempt, error) {
+	var rows []struct {
+		Key         string
+		Failures    int
+		LastFailure time.Time `db:"last_failure"`
+	}
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		limit := time.Now().Add(-Policy.Window).UTC()
+		env.Cr().Execute(fmt.Sprintf(`DELETE FROM %s WHERE last_failure < ?`, tableName()), limit)
+		env.Cr().Select(&rows, fmt.Sprintf(`SELECT key, failures, last_failure FROM %s ORDER BY last_failure DESC`, tableName()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Attempt, len(rows))
+	for i, row := range rows {
+		res[i] = Attempt{Failures: row.Failures, LastFailure: row.LastFailure}
+		switch {
+		case strings.HasPrefix(row.Key, security.LoginKeyPrefix):
+			res[i].Login = strings.TrimPrefix(row.Key, security.LoginKeyPrefix)
+		case strings.HasPrefix(row.Key, security.AddressKeyPrefix):
+			res[i].Address = strings.TrimPrefix(row.Key, security.AddressKeyPrefix)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package lockout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func performRequest(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+func TestLockout(t *testing.T) {
+	Convey("Bootstrapping lockout before models should panic", t, func() {
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Bootstrapping", t, func() {
+		models.BootStrap()
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+		fInfos := models.Registry.MustGet(ModelName).FieldsGet()
+		So(fInfos, ShouldContainKey, "key")
+		So(fInfos, ShouldContainKey, "failures")
+		So(fInfos, ShouldContainKey, "last_failure")
+	})
+	Convey("Testing the endpoints", t, func() {
+		Convey("Listing attempts without credentials should fail", func() {
+			w := performRequest(http.MethodGet, Path+"/", "")
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Unlocking without credentials should fail", func() {
+			w := performRequest(http.MethodPost, Path+"/unlock", `{"login": "admin"}`)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Unlocking without an API key in a bearer header should fail", func() {
+			w := performRequest(http.MethodPost, Path+"/unlock", `{"login": "admin"}`,
+				"Authorization", "Basic YWRtaW46YWRtaW4=", "Origin", "http://evil.example.com")
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Body.String(), ShouldContainSubstring, "an API key is required to unlock")
+		})
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package memberships persists the group memberships of security.Registry in the
+database and keeps them in sync between all the processes of the application.
+
+The native memberships of users are stored in the GroupMembership model. Each
+process loads the memberships of a user the first time they are needed. When
+memberships are modified, a notification is sent on the PostgreSQL channel
+given by Channel, and every process listening to it discards its copy of the
+memberships of the user, which are loaded again when next needed. Processes
+listen to the notifications of each database whose memberships they load.
+
+Memberships are read and written in the transaction of the Environment held
+by the context.Context given to the Context methods of security.Registry, if
+any, and the notification is sent when this transaction is committed.
+*/
+package memberships
+
+import (
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+)
+
+// BootStrap sets the database store as the membership store of
+// security.Registry and starts listening to membership changes.
+//
+// It must be called after the models have been bootstrapped
+// and the database connection is established.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("Memberships are already bootstrapped")
+	}
+	if !models.BootStrapped() {
+		log.Panic("Models must be bootstrapped before memberships")
+	}
+	security.Registry.SetMembershipStore(Store{})
+	Listen()
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("memberships")
+	groupMembership := models.NewModel(ModelName)
+	groupMembership.AddFields(map[string]models.FieldDefinition{
+		"UserID":  fields.Integer{String: "User ID", Required: true, Index: true},
+		"GroupID": fields.Char{String: "Group ID", Required: true},
+	})
+	groupMembership.AddSQLConstraint("unique_membership", "unique(user_id, group_id)",
+		"A user can only be a member of a group once")
+	models.OnDatabaseClose(forget)
+}
@@ -0,0 +1,263 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package memberships
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/lib/pq"
+)
+
+const (
+	// ModelName is the name of the model in which memberships are stored
+	ModelName = "GroupMembership"
+	// Channel is the PostgreSQL channel on which membership changes are notified
+	Channel = "hexya_group_memberships"
+	// allUsers is the payload of notifications concerning all users
+	allUsers = "*"
+)
+
+// A listener listens to the membership changes of a database
+type listener struct {
+	pqListener *pq.Listener
+	done       chan struct{}
+}
+
+// listeners are the listeners of membership changes of this process,
+// indexed by database name. New listeners are only started when active.
+var listeners = struct {
+	sync.Mutex
+	active bool
+	byName map[string]*listener
+}{
+	byName: make(map[string]*listener),
+}
+
+// A Store is a security.MembershipStore that keeps the
+// memberships in the database and notifies their changes.
+type Store struct{}
+
+// tableName returns the name of the table of the GroupMembership model
+func tableName() string {
+	return models.Registry.MustGet(ModelName).TableName()
+}
+
+// execute executes fnct in a savepoint of the Environment held by ctx, so
+// that memberships are read and written in the transaction of the caller, or
+// in a new Environment bound to ctx if ctx does not hold any.
+func execute(ctx context.Context, fnct func(models.Environment)) error {
+	if env, ok := models.EnvironmentFromContext(ctx); ok {
+		return env.Savepoint(fnct)
+	}
+	return models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, fnct)
+}
+
+// changed registers the hooks of a membership change of the user with the
+// given uid in the transaction of env. When the transaction is committed, the
+// change is notified to all the processes. If it is rolled back, the
+// memberships of the user are discarded from security.Registry, since they
+// have been modified in memory.
+func changed(env models.Environment, uid int64) {
+	database := env.Database()
+	env.OnCommit(func() {
+		if err := notify(models.ContextWithDatabase(context.Background(), database), strconv.FormatInt(uid, 10)); err != nil {
+			log.Warn("Unable to notify membership change", "uid", uid, "error", err)
+		}
+	})
+	env.OnRollback(func() {
+		security.Registry.InvalidateContext(models.ContextWithDatabase(context.Background(), database), uid)
+	})
+}
+
+// notify sends a notification with the given payload on Channel
+// in a new transaction on the database of ctx.
+func notify(ctx context.Context, payload string) error {
+	return models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		env.Cr().Execute(`SELECT pg_notify(?, ?)`, Channel, payload)
+	})
+}
+
+// Load returns the IDs of the groups of which the user
+// with the given uid is a native member.
+//
+// If this process listens to membership changes, it starts listening
+// to the changes of the database of ctx if it does not already.
+func (s Store) Load(ctx context.Context, uid int64) ([]string, error) {
+	if database := models.DatabaseFromContext(ctx); database != nil {
+		listen(database)
+	}
+	var res []string
+	err := execute(ctx, func(env models.Environment) {
+		env.Cr().Select(&res, fmt.Sprintf(`SELECT group_id FROM %s WHERE user_id = ?`, tableName()), uid)
+	})
+	return res, err
+}
+
+// Add makes the user with the given uid a native member of the group with the given ID
+func (s Store) Add(ctx context.Context, uid int64, groupID string) error {
+	return execute(ctx, func(env models.Environment) {
+		res := env.Cr().Execute(fmt.Sprintf(`
+			INSERT INTO %s (user_id, group_id) VALUES (?, ?)
+			ON CONFLICT (user_id, group_id) DO NOTHING`, tableName()), uid, groupID)
+		if n, _ := res.RowsAffected(); n > 0 {
+			changed(env, uid)
+		}
+	})
+}
+
+// Remove removes the user with the given uid from the group with the given ID
+func (s Store) Remove(ctx context.Context, uid int64, groupID string) error {
+	return execute(ctx, func(env models.Environment) {
+		res := env.Cr().Execute(fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND group_id = ?`, tableName()), uid, groupID)
+		if n, _ := res.RowsAffected(); n > 0 {
+			changed(env, uid)
+		}
+	})
+}
+
+// RemoveAll removes the user with the given uid from all groups
+func (s Store) RemoveAll(ctx context.Context, uid int64) error {
+	return execute(ctx, func(env models.Environment) {
+		res := env.Cr().Execute(fmt.Sprintf(`DELETE FROM %s WHERE user_id = ?`, tableName()), uid)
+		if n, _ := res.RowsAffected(); n > 0 {
+			changed(env, uid)
+		}
+	})
+}
+
+var _ security.MembershipStore = Store{}
+
+// All returns the native memberships of all users, as
+// lists of group IDs indexed by uid.
+func All() (map[int64][]string, error) {
+	var rows []struct {
+		UserID  int64  `db:"user_id"`
+		GroupID string `db:"group_id"`
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		env.Cr().Select(&rows, fmt.Sprintf(`SELECT user_id, group_id FROM %s ORDER BY user_id, group_id`, tableName()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64][]string)
+	for _, row := range rows {
+		res[row.UserID] = append(res[row.UserID], row.GroupID)
+	}
+	return res, nil
+}
+
+// handleNotification invalidates the memberships of security.Registry in the
+// database of ctx concerned by the given notification. A nil notification
+// means that the connection has been lost, in which case all memberships of
+// the database are invalidated since notifications may have been missed.
+func handleNotification(ctx context.Context, n *pq.Notification) {
+	if n == nil || n.Extra == allUsers {
+		security.Registry.InvalidateAllContext(ctx)
+		return
+	}
+	uid, err := strconv.ParseInt(n.Extra, 10, 64)
+	if err != nil {
+		log.Warn("Invalid membership notification", "payload", n.Extra)
+		return
+	}
+	security.Registry.InvalidateContext(ctx, uid)
+}
+
+// Listen starts listening to the membership changes notified by all the
+// processes of the application in the default database, and in the other
+// databases when their memberships are first loaded. It does nothing if
+// this process already listens.
+func Listen() {
+	listeners.Lock()
+	listeners.active = true
+	listeners.Unlock()
+	if database := models.DefaultDatabase(); database != nil {
+		listen(database)
+	}
+}
+
+// listen starts listening to the membership changes of the given database,
+// unless this process does not listen to membership changes or already
+// listens to those of this database.
+func listen(database *models.Database) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	if !listeners.active {
+		return
+	}
+	if _, ok := listeners.byName[database.Name()]; ok {
+		return
+	}
+	l := pq.NewListener(database.Params().ConnectionString(), time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Warn("Membership listener connection error", "database", database.Name(), "error", err)
+			}
+		})
+	if err := l.Listen(Channel); err != nil {
+		log.Panic("Unable to listen to membership changes", "database", database.Name(), "error", err)
+	}
+	ctx := models.ContextWithDatabase(context.Background(), database)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case n := <-l.Notify:
+				handleNotification(ctx, n)
+			case <-time.After(90 * time.Second):
+				go l.Ping()
+			case <-done:
+				return
+			}
+		}
+	}()
+	listeners.byName[database.Name()] = &listener{
+		pqListener: l,
+		done:       done,
+	}
+}
+
+// stop stops this listener
+func (l *listener) stop() {
+	close(l.done)
+	l.pqListener.Close()
+}
+
+// StopListening stops listening to membership changes in all databases
+func StopListening() {
+	listeners.Lock()
+	defer listeners.Unlock()
+	listeners.active = false
+	for name, l := range listeners.byName {
+		l.stop()
+		delete(listeners.byName, name)
+	}
+}
+
+// forget stops listening to the membership changes of the given database,
+// which has been closed, and discards its memberships from security.Registry
+// since their changes are not notified anymore.
+func forget(database *models.Database) {
+	listeners.Lock()
+	if l, ok := listeners.byName[database.Name()]; ok {
+		l.stop()
+		delete(listeners.byName, database.Name())
+	}
+	listeners.Unlock()
+	security.Registry.InvalidateAllContext(models.ContextWithDatabase(context.Background(), database))
+}
+
+// InvalidateAll notifies all the processes of the application that
+// the memberships of all users of the default database must be loaded
+// again, e.g. after they have been modified directly in the database.
+func InvalidateAll() error {
+	return notify(context.Background(), allUsers)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package memberships
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/lib/pq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testStore is a security.MembershipStore whose
+// memberships are modified by another process.
+type testStore map[int64][]string
+
+func (s testStore) Load(ctx context.Context, uid int64) ([]string, error) { return s[uid], nil }
+
+func (s testStore) Add(ctx context.Context, uid int64, groupID string) error { return nil }
+
+func (s testStore) Remove(ctx context.Context, uid int64, groupID string) error { return nil }
+
+func (s testStore) RemoveAll(ctx context.Context, uid int64) error { return nil }
+
+func TestMemberships(t *testing.T) {
+	Convey("Bootstrapping memberships before models should panic", t, func() {
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Testing the GroupMembership model", t, func() {
+		models.BootStrap()
+		fInfos := models.Registry.MustGet(ModelName).FieldsGet()
+		So(fInfos, ShouldContainKey, "user_id")
+		So(fInfos, ShouldContainKey, "group_id")
+	})
+	Convey("Testing notifications", t, func() {
+		group := security.Registry.NewGroup("memberships_test", "Test")
+		store := testStore{}
+		security.Registry.SetMembershipStore(store)
+		So(security.Registry.HasMembership(2, group), ShouldBeFalse)
+		So(security.Registry.HasMembership(3, group), ShouldBeFalse)
+		otherCtx := security.ContextWithDatabaseName(context.Background(), "other")
+		So(security.Registry.HasMembershipContext(otherCtx, 2, group), ShouldBeFalse)
+		store[2] = []string{"memberships_test"}
+		store[3] = []string{"memberships_test"}
+		Convey("A notification should reload the memberships of its user", func() {
+			handleNotification(context.Background(), &pq.Notification{Channel: Channel, Extra: "2"})
+			So(security.Registry.HasMembership(2, group), ShouldBeTrue)
+			So(security.Registry.HasMembership(3, group), ShouldBeFalse)
+		})
+		Convey("A notification should only reload the memberships of its database", func() {
+			handleNotification(otherCtx, nil)
+			So(security.Registry.HasMembership(2, group), ShouldBeFalse)
+			So(security.Registry.HasMembershipContext(otherCtx, 2, group), ShouldBeTrue)
+		})
+		Convey("Invalid notifications should be ignored", func() {
+			handleNotification(context.Background(), &pq.Notification{Channel: Channel, Extra: "abc"})
+			So(security.Registry.HasMembership(2, group), ShouldBeFalse)
+		})
+		Convey("A lost connection should reload all memberships", func() {
+			handleNotification(context.Background(), nil)
+			So(security.Registry.HasMembership(2, group), ShouldBeTrue)
+			So(security.Registry.HasMembership(3, group), ShouldBeTrue)
+		})
+		Convey("A notification for all users should reload all memberships", func() {
+			handleNotification(context.Background(), &pq.Notification{Channel: Channel, Extra: allUsers})
+			So(security.Registry.HasMembership(2, group), ShouldBeTrue)
+			So(security.Registry.HasMembership(3, group), ShouldBeTrue)
+		})
+		Reset(func() {
+			security.Registry.UnregisterGroup(group)
+			security.Registry.SetMembershipStore(nil)
+		})
+	})
+}
@@ -111,20 +111,22 @@ func NewCollection() *Collection {
 }
 
 // A Menu is the representation of a single menu item
+//
+// The xml tags describe the attributes of the menuitem XML definition.
 type Menu struct {
-	ID               int64
-	XMLID            string
-	Name             string
-	ParentID         string
-	Parent           *Menu
-	ParentCollection *Collection
-	Children         *Collection
-	Sequence         uint8
-	ActionID         string
-	Action           *actions.Action
-	HasChildren      bool
-	HasAction        bool
-	WebIcon          string
+	ID               int64           `xml:"-"`
+	XMLID            string          `xml:"id,attr"`
+	Name             string          `xml:"name,attr"`
+	ParentID         string          `xml:"parent,attr"`
+	Parent           *Menu           `xml:"-"`
+	ParentCollection *Collection     `xml:"-"`
+	Children         *Collection     `xml:"-"`
+	Sequence         uint8           `xml:"sequence,attr"`
+	ActionID         string          `xml:"action,attr"`
+	Action           *actions.Action `xml:"-"`
+	HasChildren      bool            `xml:"-"`
+	HasAction        bool            `xml:"-"`
+	WebIcon          string          `xml:"web_icon,attr"`
 	names            map[string]string
 }
 
@@ -86,12 +86,12 @@ func declareCommonMixin() {
 	commonMixin.addMethod("SortedByField", commonMixinSortedByField)
 	commonMixin.addMethod("Filtered", commonMixinFiltered)
 	commonMixin.addMethod("GetRecord", commonMixinGetRecord)
-	commonMixin.addMethod("CheckExecutionPermission", commonMixinCheckExecutionPermission)
-	commonMixin.addMethod("SQLFromCondition", commonMixinSQLFromCondition)
-	commonMixin.addMethod("WithEnv", commonMixinWithEnv)
-	commonMixin.addMethod("WithContext", commonMixinWithContext)
-	commonMixin.addMethod("WithNewContext", commonMixinWithNewContext)
-	commonMixin.addMethod("Sudo", commonMixinSudo)
+	commonMixin.addMethod("CheckExecutionPermission", commonMixinCheckExecutionPermission).Private()
+	commonMixin.addMethod("SQLFromCondition", commonMixinSQLFromCondition).Private()
+	commonMixin.addMethod("WithEnv", commonMixinWithEnv).Private()
+	commonMixin.addMethod("WithContext", commonMixinWithContext).Private()
+	commonMixin.addMethod("WithNewContext", commonMixinWithNewContext).Private()
+	commonMixin.addMethod("Sudo", commonMixinSudo).Private()
 }
 
 // New creates a memory only record from the given data.
@@ -105,14 +105,25 @@ func commonMixinNew(rc *RecordCollection, data RecordData) *RecordCollection {
 // Create inserts a record in the database from the given data.
 // Returns the created RecordCollection.
 func commonMixinCreate(rc *RecordCollection, data RecordData) *RecordCollection {
+	rc.checkFieldsWriteAccess(data)
 	return rc.create(data)
 }
 
 // Read reads the database and returns a slice of FieldMap of the given model.
+//
+// Fields that the current user is not allowed to access are not returned.
 func commonMixinRead(rc *RecordCollection, fields FieldNames) []RecordData {
 	var res []RecordData
 	// Check if we have id in fields, and add it otherwise
 	fields = addIDIfNotPresent(fields)
+	// Remove the fields the user cannot access
+	accessibleFields := make(FieldNames, 0, len(fields))
+	for _, fName := range fields {
+		if rc.model.FieldAccessibleBy(fName, rc.env.uid) {
+			accessibleFields = append(accessibleFields, fName)
+		}
+	}
+	fields = accessibleFields
 	// Do the actual reading
 	for _, rec := range rc.Records() {
 		fData := NewModelData(rc.model)
@@ -138,6 +149,7 @@ func commonMixinLoad(rc *RecordCollection, fields ...FieldName) *RecordCollectio
 // records in the database with the given data.
 // Data can be either a struct pointer or a FieldMap.`,
 func commonMixinWrite(rc *RecordCollection, data RecordData) bool {
+	rc.checkFieldsWriteAccess(data)
 	return rc.update(data)
 }
 
@@ -232,11 +244,17 @@ func commonMixinSearchByName(rc *RecordCollection, name string, op operator.Oper
 // FieldsGet returns the definition of each field.
 // The embedded fields are included.
 // The string, help, and selection (if present) attributes are translated.
+// Fields that the current user is not allowed to access are not returned.
 //
 // The result map is indexed by the fields JSON names.
 func commonMixinFieldsGet(rc *RecordCollection, args FieldsGetArgs) map[string]*FieldInfo {
 	// Get the field informations
 	res := rc.model.FieldsGet(args.Fields...)
+	for fName := range res {
+		if !rc.model.FieldAccessibleBy(rc.model.FieldName(fName), rc.env.uid) {
+			delete(res, fName)
+		}
+	}
 
 	// Translate attributes when required
 	lang := rc.Env().Context().GetString("lang")
@@ -304,7 +322,7 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 	var warnings []string
 	filters := make(map[FieldName]Conditioner)
 
-	err := SimulateInNewEnvironment(rc.Env().Uid(), func(env Environment) {
+	err := SimulateInNewEnvironmentContext(rc.Env().Ctx(), rc.Env().Uid(), func(env Environment) {
 		values := params.Values.Underlying().FieldMap
 		data := NewModelDataFromRS(rc.WithEnv(env), values)
 		if rc.IsNotEmpty() {
@@ -315,9 +333,9 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 		if id, _ := nbutils.CastToInteger(data.Get(ID)); id != 0 {
 			rs = rc.WithEnv(env).withIds([]int64{id})
 			rs = rs.WithContext("hexya_onchange_origin", rs.First().Wrap())
-			rs.WithContext("hexya_force_compute_write", true).update(data)
+			rs.WithContext("hexya_force_compute_write", true).withComputeWrite().update(data)
 		} else {
-			rs = rc.WithEnv(env).WithContext("hexya_force_compute_write", true).create(data)
+			rs = rc.WithEnv(env).WithContext("hexya_force_compute_write", true).withComputeWrite().create(data)
 		}
 		// Set inverse fields
 		for field := range values {
@@ -359,7 +377,7 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 						todo = append(todo, f)
 					}
 				}
-				rrs.WithContext("hexya_force_compute_write", true).Call("Write", vals)
+				rrs.WithContext("hexya_force_compute_write", true).withComputeWrite().Call("Write", vals)
 			}
 			// Warning
 			if fi.onChangeWarning != "" {
@@ -574,8 +592,8 @@ func commonMixinSudo(rc *RecordCollection, userID ...int64) *RecordCollection {
 func declareBaseMixin() {
 	baseMixin := NewMixinModel("BaseMixin")
 	baseMixin.InheritModel(Registry.MustGet("CommonMixin"))
-	baseMixin.addMethod("ComputeLastUpdate", baseMixinComputeLastUpdate)
-	baseMixin.addMethod("ComputeDisplayName", baseMixinComputeDisplayName)
+	baseMixin.addMethod("ComputeLastUpdate", baseMixinComputeLastUpdate).Private()
+	baseMixin.addMethod("ComputeDisplayName", baseMixinComputeDisplayName).Private()
 	baseMixin.fields.add(&Field{
 		model:       baseMixin,
 		name:        "CreateDate",
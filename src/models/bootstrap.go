@@ -89,6 +89,8 @@ func processUpdates() {
 						for k, v := range value.(FieldContexts) {
 							fi.contexts[k] = v
 						}
+					case "groups_add":
+						fi.groups = append(fi.groups, value.([]*security.Group)...)
 					default:
 						fi.SetProperty(property, value)
 					}
@@ -193,6 +195,7 @@ func addMixinMethods(mixinModel, model *Model) {
 					funcValue: wrapFunctionForMethodLayer(lf.funcValue),
 					mixedIn:   true,
 					method:    emi,
+					module:    lf.module,
 				}
 				emi.nextLayer[&ml] = firstMixedLayer
 				firstMixedLayer = &ml
@@ -208,7 +211,7 @@ func addMixinMethods(mixinModel, model *Model) {
 			// The method does not exist
 			newMethInfo := copyMethod(model, methInfo)
 			for i := 0; i < len(layersInv); i++ {
-				newMethInfo.addMethodLayer(layersInv[i].funcValue)
+				newMethInfo.addMethodLayer(layersInv[i].funcValue, layersInv[i].module)
 			}
 			model.methods.set(methName, newMethInfo)
 		}
@@ -474,14 +477,14 @@ func checkFieldMethodsExist() {
 	}
 }
 
-// loadManualSequencesFromDB fetches manual sequences from DB and updates registry
+// loadManualSequencesFromDB fetches manual sequences from the default DB and updates registry
 func loadManualSequencesFromDB() {
 	if db == nil {
 		// Happens when bootstrapping models without DB for tests
 		return
 	}
 	adapter := adapters[db.DriverName()]
-	for _, dbSeq := range adapter.sequences("%_manseq") {
+	for _, dbSeq := range adapter.sequences(db, "%_manseq") {
 		seq := &Sequence{
 			JSON:      dbSeq.Name,
 			Start:     dbSeq.StartValue,
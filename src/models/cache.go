@@ -29,6 +29,31 @@ type cache struct {
 	data       map[string]map[int64]FieldMap                    // cache data values by model and id
 	x2mRelated map[string]map[int64]map[string]map[string]int64 // o2m and r2m relations by model, id, field, context
 	m2mLinks   map[string]map[[2]int64]bool                     // many2many relations by relation model and ids
+	journals   []*cacheJournal                                  // journals of the open savepoints, innermost last
+}
+
+// A cacheRecordKey identifies a record in the cache
+type cacheRecordKey struct {
+	model string
+	id    int64
+}
+
+// A cacheJournal records the state of the cache entries before they are first
+// modified inside a savepoint, so that they can be restored if the savepoint
+// is rolled back. A nil map value means that the entry did not exist.
+type cacheJournal struct {
+	data       map[cacheRecordKey]FieldMap
+	x2mRelated map[cacheRecordKey]map[string]map[string]int64
+	m2mLinks   map[string]map[[2]int64]bool
+}
+
+// newCacheJournal returns a new empty cacheJournal
+func newCacheJournal() *cacheJournal {
+	return &cacheJournal{
+		data:       make(map[cacheRecordKey]FieldMap),
+		x2mRelated: make(map[cacheRecordKey]map[string]map[string]int64),
+		m2mLinks:   make(map[string]map[[2]int64]bool),
+	}
 }
 
 // notInCacheError is returned when a request in cache returns no entry
@@ -105,6 +130,7 @@ func (c *cache) updateEntryByRef(mi *Model, id int64, jsonName string, value int
 func (c *cache) setDataValue(model string, id int64, jsonName string, value interface{}) {
 	c.Lock()
 	defer c.Unlock()
+	c.journalRecord(model, id)
 	if _, ok := c.data[model]; !ok {
 		c.data[model] = make(map[int64]FieldMap)
 	}
@@ -119,6 +145,7 @@ func (c *cache) setDataValue(model string, id int64, jsonName string, value inte
 func (c *cache) setX2MValue(model string, id int64, jsonName string, relID int64, ctxSlug string) {
 	c.Lock()
 	defer c.Unlock()
+	c.journalRecord(model, id)
 	if _, ok := c.x2mRelated[model]; !ok {
 		c.x2mRelated[model] = make(map[int64]map[string]map[string]int64)
 	}
@@ -162,6 +189,7 @@ func (c *cache) getX2MValue(model string, id int64, jsonName string, ctxSlug str
 func (c *cache) deleteFieldData(model string, id int64, jsonName string) {
 	c.Lock()
 	defer c.Unlock()
+	c.journalRecord(model, id)
 	delete(c.data[model][id], jsonName)
 	if _, exists := c.x2mRelated[model][id]; exists {
 		delete(c.x2mRelated[model][id], jsonName)
@@ -172,6 +200,7 @@ func (c *cache) deleteFieldData(model string, id int64, jsonName string) {
 func (c *cache) deleteData(model string, id int64) {
 	c.Lock()
 	defer c.Unlock()
+	c.journalRecord(model, id)
 	delete(c.data[model], id)
 	delete(c.x2mRelated[model], id)
 }
@@ -184,6 +213,7 @@ func (c *cache) removeM2MLinks(fi *Field, id int64) {
 	if _, exists := c.m2mLinks[fi.m2mRelModel.name]; !exists {
 		return
 	}
+	c.journalM2MLinks(fi.m2mRelModel.name)
 	index := (strings.Compare(fi.m2mOurField.name, fi.m2mTheirField.name) + 1) / 2
 	for link := range c.m2mLinks[fi.m2mRelModel.name] {
 		if link[index] == id {
@@ -197,6 +227,7 @@ func (c *cache) removeM2MLinks(fi *Field, id int64) {
 func (c *cache) addM2MLink(fi *Field, id int64, values []int64) {
 	c.Lock()
 	defer c.Unlock()
+	c.journalM2MLinks(fi.m2mRelModel.name)
 	if _, exists := c.m2mLinks[fi.m2mRelModel.name]; !exists {
 		c.m2mLinks[fi.m2mRelModel.name] = make(map[[2]int64]bool)
 	}
@@ -261,6 +292,30 @@ func (c *cache) invalidateRecord(mi *Model, id int64) {
 	}
 }
 
+// clear removes all entries from the cache.
+func (c *cache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.journals) > 0 {
+		for model, records := range c.data {
+			for id := range records {
+				c.journalRecord(model, id)
+			}
+		}
+		for model, records := range c.x2mRelated {
+			for id := range records {
+				c.journalRecord(model, id)
+			}
+		}
+		for relModel := range c.m2mLinks {
+			c.journalM2MLinks(relModel)
+		}
+	}
+	c.data = make(map[string]map[int64]FieldMap)
+	c.x2mRelated = make(map[string]map[int64]map[string]map[string]int64)
+	c.m2mLinks = make(map[string]map[[2]int64]bool)
+}
+
 // removeEntry removes the given entry from cache
 func (c *cache) removeEntry(mi *Model, id int64, fieldName, ctxSlug string) {
 	if !c.checkIfInCache(mi, []int64{id}, []string{fieldName}, ctxSlug, true) {
@@ -388,6 +443,128 @@ func (c *cache) getRelatedRefCommon(mi *Model, id int64, path string, ctxSlug st
 	return mi, id, exprs[0], nil
 }
 
+// startJournal starts recording the changes of the cache for a new savepoint.
+func (c *cache) startJournal() {
+	c.Lock()
+	defer c.Unlock()
+	c.journals = append(c.journals, newCacheJournal())
+}
+
+// releaseJournal ends the journal of the innermost savepoint, keeping the changes.
+//
+// The entries it recorded are merged into the journal of the enclosing
+// savepoint, if any, so that they are restored if the latter is rolled back.
+func (c *cache) releaseJournal() {
+	c.Lock()
+	defer c.Unlock()
+	journal := c.journals[len(c.journals)-1]
+	c.journals = c.journals[:len(c.journals)-1]
+	if len(c.journals) == 0 {
+		return
+	}
+	parent := c.journals[len(c.journals)-1]
+	for key, fMap := range journal.data {
+		if _, exists := parent.data[key]; !exists {
+			parent.data[key] = fMap
+			parent.x2mRelated[key] = journal.x2mRelated[key]
+		}
+	}
+	for relModel, links := range journal.m2mLinks {
+		if _, exists := parent.m2mLinks[relModel]; !exists {
+			parent.m2mLinks[relModel] = links
+		}
+	}
+}
+
+// rollbackJournal ends the journal of the innermost savepoint, restoring
+// the entries it recorded to their state at the start of the savepoint.
+func (c *cache) rollbackJournal() {
+	c.Lock()
+	defer c.Unlock()
+	journal := c.journals[len(c.journals)-1]
+	c.journals = c.journals[:len(c.journals)-1]
+	for key, fMap := range journal.data {
+		if fMap == nil {
+			delete(c.data[key.model], key.id)
+		} else {
+			if _, ok := c.data[key.model]; !ok {
+				c.data[key.model] = make(map[int64]FieldMap)
+			}
+			c.data[key.model][key.id] = fMap
+		}
+		if x2m := journal.x2mRelated[key]; x2m == nil {
+			delete(c.x2mRelated[key.model], key.id)
+		} else {
+			if _, ok := c.x2mRelated[key.model]; !ok {
+				c.x2mRelated[key.model] = make(map[int64]map[string]map[string]int64)
+			}
+			c.x2mRelated[key.model][key.id] = x2m
+		}
+	}
+	for relModel, links := range journal.m2mLinks {
+		if links == nil {
+			delete(c.m2mLinks, relModel)
+			continue
+		}
+		c.m2mLinks[relModel] = links
+	}
+}
+
+// journalRecord records the data and x2m relations of the given record in the
+// journal of the innermost savepoint if they are not recorded yet.
+// It must be called with the lock held, before modifying the record.
+func (c *cache) journalRecord(model string, id int64) {
+	if len(c.journals) == 0 {
+		return
+	}
+	journal := c.journals[len(c.journals)-1]
+	key := cacheRecordKey{model: model, id: id}
+	if _, exists := journal.data[key]; exists {
+		return
+	}
+	var fMap FieldMap
+	if data, ok := c.data[model][id]; ok {
+		fMap = make(FieldMap, len(data))
+		for k, v := range data {
+			fMap[k] = v
+		}
+	}
+	var x2m map[string]map[string]int64
+	if rel, ok := c.x2mRelated[model][id]; ok {
+		x2m = make(map[string]map[string]int64, len(rel))
+		for field, slugs := range rel {
+			x2m[field] = make(map[string]int64, len(slugs))
+			for slug, relID := range slugs {
+				x2m[field][slug] = relID
+			}
+		}
+	}
+	// We store a nil FieldMap for absent records so that the key exists
+	journal.data[key] = fMap
+	journal.x2mRelated[key] = x2m
+}
+
+// journalM2MLinks records the many2many links of the given relation model in
+// the journal of the innermost savepoint if they are not recorded yet.
+// It must be called with the lock held, before modifying the links.
+func (c *cache) journalM2MLinks(relModel string) {
+	if len(c.journals) == 0 {
+		return
+	}
+	journal := c.journals[len(c.journals)-1]
+	if _, exists := journal.m2mLinks[relModel]; exists {
+		return
+	}
+	var links map[[2]int64]bool
+	if current, ok := c.m2mLinks[relModel]; ok {
+		links = make(map[[2]int64]bool, len(current))
+		for link := range current {
+			links[link] = true
+		}
+	}
+	journal.m2mLinks[relModel] = links
+}
+
 // newCache creates a pointer to a new cache instance.
 func newCache() *cache {
 	res := cache{
@@ -188,6 +188,19 @@ func (c Condition) PredicatesWithField(f *Field) []*predicate {
 	return res
 }
 
+// FieldNames returns the field paths used in the predicates of this
+// condition and of its nested conditions.
+func (c Condition) FieldNames() FieldNames {
+	var res FieldNames
+	for _, exprs := range c.getAllExpressions(nil) {
+		if len(exprs) == 0 {
+			continue
+		}
+		res = append(res, joinFieldNames(exprs, ExprSep))
+	}
+	return res
+}
+
 // String method for the Condition. Recursively print all predicates.
 func (c Condition) String() string {
 	var res string
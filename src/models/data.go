@@ -4,8 +4,11 @@
 package models
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -15,10 +18,28 @@ import (
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
 )
 
-// LoadCSVDataFile loads the data of the given file into the database.
+// A dataRecord is a record to load into the database from a data file.
+type dataRecord struct {
+	model      string
+	externalID string
+	// values of the record by field name. Values can be strings formatted
+	// as in CSV data files, JSON values, dataRef or []*dataRecord for one2many fields.
+	values map[string]interface{}
+}
+
+// A dataRef is a list of external IDs referencing records of a relation field.
+type dataRef []string
+
+// LoadCSVDataFile loads the data of the given file into the default database.
 func LoadCSVDataFile(fileName string) {
+	LoadCSVDataFileContext(context.Background(), fileName)
+}
+
+// LoadCSVDataFileContext loads the data of the given file into the database of ctx.
+func LoadCSVDataFileContext(ctx context.Context, fileName string) {
 	log.Info("Importing data file", "fileName", fileName)
 	csvFile, err := os.Open(fileName)
 	if err != nil {
@@ -29,20 +50,7 @@ func LoadCSVDataFile(fileName string) {
 	elements := strings.Split(filepath.Base(fileName), "_")
 	modelName := strings.Split(elements[0], ".")[0]
 	modelName = strings.TrimLeft(modelName, "01234567890-")
-	var (
-		update  bool
-		version int
-	)
-	if len(elements) == 2 {
-		mod := strings.Split(elements[1], ".")[0]
-		ver, err := strconv.Atoi(mod)
-		switch {
-		case strings.ToLower(mod) == "update":
-			update = true
-		case err == nil:
-			version = ver
-		}
-	}
+	version, update := dataFileVersion(fileName)
 
 	r := csv.NewReader(csvFile)
 	headers, err := r.Read()
@@ -50,7 +58,7 @@ func LoadCSVDataFile(fileName string) {
 		log.Panic("Unable to read CSV headers in data file", "error", err, "fileName", fileName)
 	}
 
-	err = ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+	err = ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
 		rc := env.Pool(modelName)
 		// JSONize all field names
 		for i, header := range headers {
@@ -66,23 +74,9 @@ func LoadCSVDataFile(fileName string) {
 
 			values := getRecordValuesMap(headers, modelName, record, env, line, fileName)
 
-			externalID := values["id"]
+			externalID, _ := values["id"].(string)
 			delete(values, "id")
-			values["hexya_external_id"] = externalID
-			values["hexya_version"] = version
-			// We deliberately call Search directly without Call so as not to be polluted by Search overrides
-			// such as "Active test".
-			rec := rc.Search(rc.Model().Field(rc.model.FieldName("HexyaExternalID")).Equals(externalID)).Limit(1)
-			switch {
-			case rec.Len() == 0:
-				vals := NewModelData(rc.model, values)
-				rc.applyDefaults(vals, true)
-				rc.Call("Create", vals)
-			case rec.Len() == 1:
-				if version > rec.Get(rec.model.FieldName("HexyaVersion")).(int) || update {
-					rec.Call("Write", NewModelData(rc.model, values))
-				}
-			}
+			loadDataRecord(rc, externalID, values, version, update)
 			line++
 		}
 	})
@@ -92,61 +86,252 @@ func LoadCSVDataFile(fileName string) {
 	log.Debug("Data file imported successfully", "fileName", fileName)
 }
 
+// dataFileVersion returns the version of the given data file and whether
+// existing records must be updated, from the file name suffix.
+//
+// - "Model.csv" returns 0 and false
+//
+// - "Model_3.csv" returns 3 and false
+//
+// - "Model_update.csv" returns 0 and true
+func dataFileVersion(fileName string) (int, bool) {
+	elements := strings.Split(filepath.Base(fileName), "_")
+	if len(elements) < 2 {
+		return 0, false
+	}
+	mod := strings.Split(elements[len(elements)-1], ".")[0]
+	if strings.ToLower(mod) == "update" {
+		return 0, true
+	}
+	if ver, err := strconv.Atoi(mod); err == nil {
+		return ver, false
+	}
+	return 0, false
+}
+
+// loadDataRecord creates or updates the record of rc's model with the given
+// external ID and values and returns it.
+//
+// - If no record exists with this external ID, it is created with the given version.
+//
+// - If a record exists and its version is below the given version or if update is
+// true, it is updated with the given values and version.
+//
+// - Otherwise, the existing record is left untouched.
+//
+// If externalID is empty, a new record is always created with a generated external ID.
+func loadDataRecord(rc *RecordCollection, externalID string, values FieldMap, version int, update bool) *RecordCollection {
+	values["hexya_version"] = version
+	rec := rc.env.Pool(rc.model.name)
+	if externalID != "" {
+		values["hexya_external_id"] = externalID
+		// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+		// such as "Active test".
+		rec = rc.Search(rc.Model().Field(rc.model.FieldName("HexyaExternalID")).Equals(externalID)).Limit(1)
+	}
+	switch {
+	case rec.IsEmpty():
+		vals := NewModelData(rc.model, values)
+		rc.applyDefaults(vals, true)
+		return rc.Call("Create", vals).(RecordSet).Collection()
+	case version > rec.Get(rec.model.FieldName("HexyaVersion")).(int) || update:
+		rec.Call("Write", NewModelData(rc.model, values))
+	}
+	return rec
+}
+
 func getRecordValuesMap(headers []string, modelName string, record []string, env Environment, line int, fileName string) FieldMap {
 	values := make(map[string]interface{})
 	model := Registry.MustGet(modelName)
 	for i := 0; i < len(headers); i++ {
+		if headers[i] == "id" {
+			values[headers[i]] = record[i]
+			continue
+		}
 		fi := model.getRelatedFieldInfo(model.FieldName(headers[i]))
-		var (
-			val interface{}
-			err error
-		)
-		switch {
-		case headers[i] == "id":
-			val = record[i]
-		case fi.fieldType == fieldtype.Integer:
-			val, err = strconv.ParseInt(record[i], 0, 64)
-			if err != nil {
-				log.Panic("Error while converting integer", "fileName", fileName, "line", line, "field", headers[i], "value", record[i], "error", err)
-			}
-		case fi.fieldType == fieldtype.Float:
-			val, err = strconv.ParseFloat(record[i], 64)
-			if err != nil {
-				log.Panic("Error while converting float", "fileName", fileName, "line", line, "field", headers[i], "value", record[i], "error", err)
+		val, ok := convertDataValue(env, fi, record[i], fileName, "line", line, "field", headers[i])
+		if !ok {
+			continue
+		}
+		values[headers[i]] = val
+	}
+	return values
+}
+
+// convertDataValue converts the given value of a data file for the given
+// field to a value that can be written to the database.
+//
+// String and JSON number values are read as in CSV data files. The returned boolean is
+// false if the field must not be set.
+//
+// logCtx are additional key/value pairs to log in case of error.
+func convertDataValue(env Environment, fi *Field, value interface{}, fileName string, logCtx ...interface{}) (interface{}, bool) {
+	logCtx = append([]interface{}{"fileName", fileName}, logCtx...)
+	switch val := value.(type) {
+	case string:
+		return convertDataStringValue(env, fi, val, fileName, logCtx...)
+	case json.Number:
+		return convertDataStringValue(env, fi, string(val), fileName, logCtx...)
+	case dataRef:
+		return findDataRefRecords(env, fi, val, logCtx...), true
+	case []interface{}:
+		refs := make(dataRef, len(val))
+		for i, v := range val {
+			ref, ok := v.(string)
+			if !ok {
+				log.Panic("Relation values must be external IDs", append(logCtx, "value", value)...)
 			}
-		case fi.fieldType.IsFKRelationType():
-			val = env.Pool(fi.relatedModelName)
-			if record[i] != "" {
-				relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field(fi.relatedModel.FieldName("HexyaExternalID")).Equals(record[i]))
-				if relRC.Len() != 1 {
-					log.Panic("Unable to find related record from external ID", "fileName", fileName, "line", line, "field", headers[i], "value", record[i])
-				}
-				val = relRC
+			refs[i] = ref
+		}
+		return findDataRefRecords(env, fi, refs, logCtx...), true
+	case nil:
+		if fi.fieldType.IsRelationType() {
+			return env.Pool(fi.relatedModelName), true
+		}
+		return nil, true
+	}
+	var (
+		res interface{}
+		err error
+	)
+	switch fi.fieldType {
+	case fieldtype.Integer:
+		res, err = nbutils.CastToInteger(value)
+	case fieldtype.Float:
+		res, err = nbutils.CastToFloat(value)
+	default:
+		res = value
+	}
+	if err != nil {
+		log.Panic("Error while converting value", append(logCtx, "value", value, "error", err)...)
+	}
+	return res, true
+}
+
+// convertDataStringValue converts the given string value of a data file
+// for the given field, as in CSV data files.
+func convertDataStringValue(env Environment, fi *Field, value string, fileName string, logCtx ...interface{}) (interface{}, bool) {
+	var (
+		val interface{}
+		err error
+	)
+	switch {
+	case fi.fieldType == fieldtype.Integer:
+		val, err = strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			log.Panic("Error while converting integer", append(logCtx, "value", value, "error", err)...)
+		}
+	case fi.fieldType == fieldtype.Float:
+		val, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Panic("Error while converting float", append(logCtx, "value", value, "error", err)...)
+		}
+	case fi.fieldType.IsFKRelationType():
+		val = env.Pool(fi.relatedModelName)
+		if value != "" {
+			val = findDataRefRecords(env, fi, dataRef{value}, logCtx...)
+		}
+	case fi.fieldType == fieldtype.Many2Many:
+		ids := strings.Split(value, "|")
+		relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field(fi.relatedModel.FieldName("HexyaExternalID")).In(ids))
+		val = relRC
+	case fi.fieldType == fieldtype.Binary:
+		if value == "" {
+			return nil, false
+		}
+		dir := filepath.Dir(fileName)
+		bFileName := filepath.Join(dir, value)
+		fileContent, err := ioutil.ReadFile(bFileName)
+		if err != nil {
+			log.Panic("Unable to open file with binary data", append(logCtx, "value", value, "error", err)...)
+		}
+		val = base64.StdEncoding.EncodeToString(fileContent)
+	case fi.fieldType == fieldtype.Boolean:
+		val = false
+		if res, _ := strconv.ParseBool(value); res {
+			val = true
+		}
+	default:
+		val = value
+	}
+	return val, true
+}
+
+// findDataRefRecords returns the records of the related model of the given field
+// with the given external IDs. It panics if a record cannot be found, or if
+// several external IDs are given for a foreign key field.
+func findDataRefRecords(env Environment, fi *Field, refs dataRef, logCtx ...interface{}) *RecordCollection {
+	if !fi.fieldType.IsRelationType() {
+		log.Panic("External IDs can only be given for relation fields", append(logCtx, "value", refs)...)
+	}
+	if fi.fieldType.IsFKRelationType() && len(refs) > 1 {
+		log.Panic("Only one external ID can be given for a foreign key field", append(logCtx, "value", refs)...)
+	}
+	relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field(fi.relatedModel.FieldName("HexyaExternalID")).In([]string(refs)))
+	if relRC.Len() != len(refs) {
+		log.Panic("Unable to find related record from external ID", append(logCtx, "value", refs)...)
+	}
+	return relRC
+}
+
+// loadDataRecords loads the given records of the given data file into the database
+// of ctx, with the same semantics as LoadCSVDataFile.
+func loadDataRecords(ctx context.Context, fileName string, records []*dataRecord) {
+	version, update := dataFileVersion(fileName)
+	err := ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+		for _, rec := range records {
+			if rec.externalID == "" {
+				log.Panic("Missing ID of data record", "fileName", fileName, "model", rec.model)
 			}
-		case fi.fieldType == fieldtype.Many2Many:
-			ids := strings.Split(record[i], "|")
-			relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field(fi.relatedModel.FieldName("HexyaExternalID")).In(ids))
-			val = relRC
-		case fi.fieldType == fieldtype.Binary:
-			if record[i] == "" {
-				continue
+			loadDataRecordTree(env, rec, fileName, version, update)
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	log.Debug("Data file imported successfully", "fileName", fileName)
+}
+
+// loadDataRecordTree loads the given record and its nested one2many records.
+//
+// Nested records without external ID get one from their parent's external ID,
+// the field name and their position, so that they can be updated on
+// subsequent loads.
+func loadDataRecordTree(env Environment, rec *dataRecord, fileName string, version int, update bool) *RecordCollection {
+	rc := env.Pool(rec.model)
+	values := make(FieldMap)
+	children := make(map[*Field][]*dataRecord)
+	for fName, value := range rec.values {
+		fi, ok := rc.model.fields.Get(fName)
+		if !ok {
+			log.Panic("Unknown field in data record", "fileName", fileName, "model", rec.model, "id", rec.externalID, "field", fName)
+		}
+		if nested, ok := value.([]*dataRecord); ok {
+			if fi.fieldType != fieldtype.One2Many {
+				log.Panic("Nested records can only be given for one2many fields", "fileName", fileName, "model", rec.model, "id", rec.externalID, "field", fName)
 			}
-			dir := filepath.Dir(fileName)
-			bFileName := filepath.Join(dir, record[i])
-			fileContent, err := ioutil.ReadFile(bFileName)
-			if err != nil {
-				log.Panic("Unable to open file with binary data", "error", err, "line", line, "field", headers[i], "value", record[i])
+			children[fi] = nested
+			continue
+		}
+		val, ok := convertDataValue(env, fi, value, fileName, "model", rec.model, "id", rec.externalID, "field", fName)
+		if !ok {
+			continue
+		}
+		values[fi.json] = val
+	}
+	res := loadDataRecord(rc, rec.externalID, values, version, update)
+	for fi, nested := range children {
+		for i, child := range nested {
+			child.model = fi.relatedModelName
+			if child.externalID == "" {
+				child.externalID = fmt.Sprintf("%s_%s_%d", rec.externalID, fi.json, i+1)
 			}
-			val = base64.StdEncoding.EncodeToString(fileContent)
-		case fi.fieldType == fieldtype.Boolean:
-			val = false
-			if res, _ := strconv.ParseBool(record[i]); res {
-				val = true
+			if child.values == nil {
+				child.values = make(map[string]interface{})
 			}
-		default:
-			val = record[i]
+			child.values[fi.jsonReverseFK] = res
+			loadDataRecordTree(env, child, fileName, version, update)
 		}
-		values[headers[i]] = val
 	}
-	return values
+	return res
 }
@@ -0,0 +1,281 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/beevik/etree"
+	"gopkg.in/yaml.v2"
+)
+
+// A fileDataRecord is a record as defined in JSON and YAML data files
+type fileDataRecord struct {
+	Model  string                 `json:"model" yaml:"model"`
+	ID     string                 `json:"id" yaml:"id"`
+	Values map[string]interface{} `json:"values" yaml:"values"`
+}
+
+// LoadXMLDataFile loads the records of the given XML data file into the default database.
+//
+// Records are defined by <record> tags inside hexya/data:
+//
+//	<hexya>
+//	    <data>
+//	        <record model="User" id="user_peter">
+//	            <field name="Name">Peter</field>
+//	            <field name="Profile" ref="profile_peter"/>
+//	            <field name="IsStaff" eval="true"/>
+//	            <field name="Posts">
+//	                <record id="post_peter_1">
+//	                    <field name="Title">Peter's Post</field>
+//	                    <field name="Content"><p>Hello</p></field>
+//	                </record>
+//	            </field>
+//	        </record>
+//	    </data>
+//	</hexya>
+//
+// Field values are given:
+//
+// - as the text of the field tag, formatted as in CSV data files. If the field tag
+// has children elements, the value is the inner XML of the tag.
+//
+// - with a 'ref' attribute holding the external ID of the related record,
+// or a '|' separated list of external IDs for many2many fields.
+//
+// - with an 'eval' attribute holding a JSON value.
+//
+// - as nested record tags for one2many fields.
+//
+// The external ID, version and update semantics are the same as LoadCSVDataFile.
+func LoadXMLDataFile(fileName string) {
+	LoadXMLDataFileContext(context.Background(), fileName)
+}
+
+// LoadXMLDataFileContext loads the records of the given XML data file into the database of ctx.
+func LoadXMLDataFileContext(ctx context.Context, fileName string) {
+	log.Info("Importing data file", "fileName", fileName)
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(fileName); err != nil {
+		log.Panic("Error loading XML data file", "fileName", fileName, "error", err)
+	}
+	var records []*dataRecord
+	for _, dataTag := range doc.FindElements("hexya/data") {
+		for _, object := range dataTag.ChildElements() {
+			if object.Tag != "record" {
+				log.Panic("Unknown XML tag in data file", "fileName", fileName, "tag", object.Tag)
+			}
+			rec := xmlDataRecord(object, fileName)
+			if rec.model == "" {
+				log.Panic("Missing model of data record", "fileName", fileName, "id", rec.externalID)
+			}
+			records = append(records, rec)
+		}
+	}
+	loadDataRecords(ctx, fileName, records)
+}
+
+// xmlDataRecord returns the dataRecord defined by the given <record> element.
+func xmlDataRecord(element *etree.Element, fileName string) *dataRecord {
+	rec := dataRecord{
+		model:      element.SelectAttrValue("model", ""),
+		externalID: element.SelectAttrValue("id", ""),
+		values:     make(map[string]interface{}),
+	}
+	for _, field := range element.ChildElements() {
+		if field.Tag != "field" {
+			log.Panic("Unknown XML tag in data record", "fileName", fileName, "id", rec.externalID, "tag", field.Tag)
+		}
+		name := field.SelectAttrValue("name", "")
+		if name == "" {
+			log.Panic("Missing name of field in data record", "fileName", fileName, "id", rec.externalID)
+		}
+		switch {
+		case field.SelectAttr("ref") != nil:
+			rec.values[name] = dataRef(strings.Split(field.SelectAttrValue("ref", ""), "|"))
+		case field.SelectAttr("eval") != nil:
+			var val interface{}
+			if err := json.Unmarshal([]byte(field.SelectAttrValue("eval", "")), &val); err != nil {
+				log.Panic("Unable to evaluate field value", "fileName", fileName, "id", rec.externalID, "field", name, "error", err)
+			}
+			rec.values[name] = val
+		case len(field.SelectElements("record")) > 0:
+			var nested []*dataRecord
+			for _, child := range field.SelectElements("record") {
+				nested = append(nested, xmlDataRecord(child, fileName))
+			}
+			rec.values[name] = nested
+		case len(field.ChildElements()) > 0:
+			rec.values[name] = xmlInnerContent(field, fileName)
+		default:
+			rec.values[name] = field.Text()
+		}
+	}
+	return &rec
+}
+
+// xmlInnerContent returns the inner XML of the given element
+func xmlInnerContent(element *etree.Element, fileName string) string {
+	doc := etree.NewDocument()
+	for _, token := range element.Copy().Child {
+		doc.AddChild(token)
+	}
+	res, err := doc.WriteToString()
+	if err != nil {
+		log.Panic("Unable to read XML content", "fileName", fileName, "element", element.Tag, "error", err)
+	}
+	return strings.TrimSpace(res)
+}
+
+// LoadJSONDataFile loads the records of the given JSON data file into the default database.
+//
+// The file must hold a list of records defined as follows:
+//
+//	[
+//	    {
+//	        "model": "User",
+//	        "id": "user_peter",
+//	        "values": {
+//	            "Name": "Peter",
+//	            "Profile": "profile_peter",
+//	            "Tags": ["tag_book", "tag_film"],
+//	            "IsStaff": true,
+//	            "Posts": [
+//	                {"id": "post_peter_1", "values": {"Title": "Peter's Post"}}
+//	            ]
+//	        }
+//	    }
+//	]
+//
+// Relation fields are given as external IDs, or as a list of external IDs
+// for many2many fields. One2many fields can be given as a list of nested records.
+//
+// The external ID, version and update semantics are the same as LoadCSVDataFile.
+func LoadJSONDataFile(fileName string) {
+	LoadJSONDataFileContext(context.Background(), fileName)
+}
+
+// LoadJSONDataFileContext loads the records of the given JSON data file into the database of ctx.
+func LoadJSONDataFileContext(ctx context.Context, fileName string) {
+	log.Info("Importing data file", "fileName", fileName)
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		log.Panic("Unable to read JSON data file", "fileName", fileName, "error", err)
+	}
+	var fileRecords []fileDataRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&fileRecords); err != nil {
+		log.Panic("Unable to parse JSON data file", "fileName", fileName, "error", err)
+	}
+	loadFileDataRecords(ctx, fileName, fileRecords)
+}
+
+// LoadYAMLDataFile loads the records of the given YAML data file into the default database.
+//
+// The file must hold a list of records with the same structure as in LoadJSONDataFile.
+//
+//	# data/010-users.yaml
+//	- model: User
+//	  id: user_peter
+//	  values:
+//	    Name: Peter
+//	    Tags: [tag_book, tag_film]
+//	    Posts:
+//	      - id: post_peter_1
+//	        values:
+//	          Title: Peter's Post
+//
+// The external ID, version and update semantics are the same as LoadCSVDataFile.
+func LoadYAMLDataFile(fileName string) {
+	LoadYAMLDataFileContext(context.Background(), fileName)
+}
+
+// LoadYAMLDataFileContext loads the records of the given YAML data file into the database of ctx.
+func LoadYAMLDataFileContext(ctx context.Context, fileName string) {
+	log.Info("Importing data file", "fileName", fileName)
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		log.Panic("Unable to read YAML data file", "fileName", fileName, "error", err)
+	}
+	var fileRecords []fileDataRecord
+	if err := yaml.Unmarshal(data, &fileRecords); err != nil {
+		log.Panic("Unable to parse YAML data file", "fileName", fileName, "error", err)
+	}
+	for _, fr := range fileRecords {
+		for k, v := range fr.Values {
+			fr.Values[k] = normalizeYAMLValue(v)
+		}
+	}
+	loadFileDataRecords(ctx, fileName, fileRecords)
+}
+
+// loadFileDataRecords loads the given records read from a JSON or YAML data file.
+func loadFileDataRecords(ctx context.Context, fileName string, fileRecords []fileDataRecord) {
+	records := make([]*dataRecord, len(fileRecords))
+	for i, fr := range fileRecords {
+		if fr.Model == "" {
+			log.Panic("Missing model of data record", "fileName", fileName, "id", fr.ID)
+		}
+		records[i] = newDataRecordFromFile(fr, fileName)
+	}
+	loadDataRecords(ctx, fileName, records)
+}
+
+// newDataRecordFromFile returns a dataRecord from the given fileDataRecord,
+// converting lists of objects to nested records.
+func newDataRecordFromFile(fr fileDataRecord, fileName string) *dataRecord {
+	rec := dataRecord{
+		model:      fr.Model,
+		externalID: fr.ID,
+		values:     make(map[string]interface{}),
+	}
+	for fName, value := range fr.Values {
+		rec.values[fName] = value
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			continue
+		}
+		if _, isObject := list[0].(map[string]interface{}); !isObject {
+			continue
+		}
+		nested := make([]*dataRecord, len(list))
+		for i, item := range list {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				log.Panic("Nested records must all be objects", "fileName", fileName, "id", fr.ID, "field", fName)
+			}
+			var child fileDataRecord
+			child.ID, _ = obj["id"].(string)
+			child.Values, _ = obj["values"].(map[string]interface{})
+			nested[i] = newDataRecordFromFile(child, fileName)
+		}
+		rec.values[fName] = nested
+	}
+	return &rec
+}
+
+// normalizeYAMLValue recursively converts the map[interface{}]interface{}
+// returned by the YAML parser into map[string]interface{}.
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			res[fmt.Sprint(k)] = normalizeYAMLValue(v)
+		}
+		return res
+	case []interface{}:
+		for i, v := range val {
+			val[i] = normalizeYAMLValue(v)
+		}
+	}
+	return value
+}
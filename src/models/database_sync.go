@@ -4,29 +4,38 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/logging"
 )
 
-// SyncDatabase creates or updates database tables with the data in the model registry
+// SyncDatabase creates or updates the tables of the default database
+// with the data in the model registry
 func SyncDatabase() {
-	log.Info("Updating database schema")
+	DefaultDatabase().Sync()
+}
+
+// Sync creates or updates the tables of this database with the data in the model registry
+func (d *Database) Sync() {
+	log.Info("Updating database schema", "database", d.name)
 	adapter := adapters[db.DriverName()]
-	dbTables := adapter.tables()
+	dbTables := adapter.tables(d.db)
 	// Create or update sequences
-	updateDBSequences()
+	updateDBSequences(d)
 	// Create or update existing tables
 	for tableName, model := range Registry.registryByTableName {
 		if model.IsMixin() || model.IsManual() {
 			continue
 		}
 		if _, ok := dbTables[tableName]; !ok {
-			createDBTable(model)
+			createDBTable(d, model)
 		}
-		updateDBColumns(model)
-		updateDBIndexes(model)
+		updateDBColumns(d, model)
+		updateDBIndexes(d, model)
 	}
 	// Setup constraints
 	for _, model := range Registry.registryByTableName {
@@ -34,19 +43,19 @@ func SyncDatabase() {
 			continue
 		}
 		buildSQLErrorSubstitutionMap(model)
-		updateDBForeignKeyConstraints(model)
-		updateDBConstraints(model)
+		updateDBForeignKeyConstraints(d, model)
+		updateDBConstraints(d, model)
 	}
 	// Run init method on each model
 	for _, model := range Registry.registryByTableName {
 		if model.IsMixin() {
 			continue
 		}
-		runInit(model)
+		runInit(d, model)
 	}
 
 	// Drop DB tables that are not in the models
-	for dbTable := range adapter.tables() {
+	for dbTable := range adapter.tables(d.db) {
 		var modelExists bool
 		for tableName, model := range Registry.registryByTableName {
 			if dbTable != tableName || model.IsMixin() {
@@ -56,9 +65,63 @@ func SyncDatabase() {
 			break
 		}
 		if !modelExists {
-			dropDBTable(dbTable)
+			dropDBTable(d, dbTable)
+		}
+	}
+}
+
+// CheckDatabaseSchema returns an error if the schema of one of the connected
+// databases is not up to date with the models, that is if a table of a model
+// or a column of a stored field is missing. This happens if SyncDatabase has
+// not been run after modules have been updated.
+func CheckDatabaseSchema() error {
+	if db == nil {
+		return errors.New("not connected to database")
+	}
+	for _, d := range ConnectedDatabases() {
+		if err := d.CheckSchema(); err != nil {
+			return fmt.Errorf("database %s: %w", d.name, err)
+		}
+	}
+	return nil
+}
+
+// CheckSchema returns an error if the schema of this database is not up
+// to date with the models.
+//
+// Once the check succeeded, it is not run again and nil is returned.
+func (d *Database) CheckSchema() (rErr error) {
+	d.schemaChecked.Lock()
+	defer d.schemaChecked.Unlock()
+	if d.schemaChecked.ok {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rErr = logging.LogPanicData(r)
+		}
+	}()
+	adapter := adapters[db.DriverName()]
+	dbTables := adapter.tables(d.db)
+	for tableName, model := range Registry.registryByTableName {
+		if model.IsMixin() || model.IsManual() {
+			continue
+		}
+		if !dbTables[tableName] {
+			return fmt.Errorf("table %s of model %s does not exist", tableName, model.name)
+		}
+		dbColumns := adapter.columns(d.db, tableName)
+		for colName, fi := range model.fields.registryByJSON {
+			if !fi.isStored() {
+				continue
+			}
+			if _, ok := dbColumns[colName]; !ok {
+				return fmt.Errorf("column %s of table %s does not exist", colName, tableName)
+			}
 		}
 	}
+	d.schemaChecked.ok = true
+	return nil
 }
 
 // buildSQLErrorSubstitutionMap populates the sqlErrors map of the
@@ -80,7 +143,7 @@ func buildSQLErrorSubstitutionMap(model *Model) {
 }
 
 // updateDBSequences creates sequences in the DB from data in the registry.
-func updateDBSequences() {
+func updateDBSequences(d *Database) {
 	adapter := adapters[db.DriverName()]
 	// Create or alter boot sequences
 	for _, sequence := range Registry.sequences {
@@ -88,19 +151,19 @@ func updateDBSequences() {
 			continue
 		}
 		exists := false
-		for _, dbSeq := range adapter.sequences("%_bootseq") {
+		for _, dbSeq := range adapter.sequences(d.db, "%_bootseq") {
 			if sequence.JSON == dbSeq.Name {
 				exists = true
 			}
 		}
 		if !exists {
-			adapter.createSequence(sequence.JSON, sequence.Increment, sequence.Start)
+			adapter.createSequence(d.db, sequence.JSON, sequence.Increment, sequence.Start)
 			continue
 		}
-		adapter.alterSequence(sequence.JSON, sequence.Increment, sequence.Start)
+		adapter.alterSequence(d.db, sequence.JSON, sequence.Increment, sequence.Start)
 	}
 	// Drop unused boot sequences
-	for _, dbSeq := range adapter.sequences("%_bootseq") {
+	for _, dbSeq := range adapter.sequences(d.db, "%_bootseq") {
 		var sequenceExists bool
 		for _, sequence := range Registry.sequences {
 			if sequence.JSON == dbSeq.Name {
@@ -109,14 +172,14 @@ func updateDBSequences() {
 			}
 		}
 		if !sequenceExists {
-			adapter.dropSequence(dbSeq.Name)
+			adapter.dropSequence(d.db, dbSeq.Name)
 		}
 	}
 }
 
 // createDBTable creates a table in the database from the given Model
 // It only creates the primary key. Call updateDBColumns to create columns.
-func createDBTable(m *Model) {
+func createDBTable(d *Database, m *Model) {
 	adapter := adapters[db.DriverName()]
 	var columns []string
 	for colName, fi := range m.fields.registryByJSON {
@@ -134,21 +197,21 @@ CREATE TABLE %s (
 		query += ",\n\t" + strings.Join(columns, ",\n\t")
 	}
 	query += "\n)"
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // dropDBTable drops the given table in the database
-func dropDBTable(tableName string) {
+func dropDBTable(d *Database, tableName string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`DROP TABLE %s`, adapter.quoteTableName(tableName))
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // updateDBColumns synchronizes the colums of the database with the
 // given Model.
-func updateDBColumns(mi *Model) {
+func updateDBColumns(d *Database, mi *Model) {
 	adapter := adapters[db.DriverName()]
-	dbColumns := adapter.columns(mi.tableName)
+	dbColumns := adapter.columns(d.db, mi.tableName)
 	// create or update columns from registry data
 	for colName, fi := range mi.fields.registryByJSON {
 		if colName == "id" || !fi.isStored() {
@@ -156,27 +219,27 @@ func updateDBColumns(mi *Model) {
 		}
 		dbColData, ok := dbColumns[colName]
 		if !ok {
-			createDBColumn(fi)
+			createDBColumn(d, fi)
 			continue
 		}
 		if dbColData.DataType != adapter.typeSQL(fi) {
-			updateDBColumnDataType(fi)
+			updateDBColumnDataType(d, fi)
 		}
 		if (dbColData.IsNullable == "NO" && !adapter.fieldIsNotNull(fi)) ||
 			(dbColData.IsNullable == "YES" && adapter.fieldIsNotNull(fi)) {
-			updateDBColumnNullable(fi)
+			updateDBColumnNullable(d, fi)
 		}
 	}
 	// drop columns that no longer exist
 	for colName := range dbColumns {
 		if _, ok := mi.fields.registryByJSON[colName]; !ok {
-			dropDBColumn(mi.tableName, colName)
+			dropDBColumn(d, mi.tableName, colName)
 		}
 	}
 }
 
 // createDBColumn insert the column described by Field in the database
-func createDBColumn(fi *Field) {
+func createDBColumn(d *Database, fi *Field) {
 	if !fi.isStored() {
 		log.Panic("createDBColumn should not be called on non stored fields", "model", fi.model.name, "field", fi.json)
 	}
@@ -186,34 +249,34 @@ func createDBColumn(fi *Field) {
 		ALTER TABLE %s
 		ADD COLUMN %s %s
 	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.columnSQLDefinition(fi, true))
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 	// Set default value if defined
 	if fi.defaultFunc != nil {
 		updateQuery := fmt.Sprintf(`
 			UPDATE %s SET %s = ? WHERE %s IS NULL
 		`, adapter.quoteTableName(fi.model.tableName), fi.json, fi.json)
 		var defaultValue interface{}
-		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		SimulateInNewEnvironmentContext(ContextWithDatabase(context.Background(), d), security.SuperUserID, func(env Environment) {
 			defaultValue = fi.defaultFunc(env)
 		})
-		dbExecuteNoTx(updateQuery, defaultValue)
+		dbExecuteNoTx(d.db, updateQuery, defaultValue)
 	}
 	// Add not null if required
-	updateDBColumnNullable(fi)
+	updateDBColumnNullable(d, fi)
 }
 
 // updateDBColumnDataType updates the data type in database for the given Field
-func updateDBColumnDataType(fi *Field) {
+func updateDBColumnDataType(d *Database, fi *Field) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		ALTER COLUMN %s SET DATA TYPE %s
 	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.typeSQL(fi))
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // updateDBColumnNullable updates the NULL/NOT NULL data in database for the given Field
-func updateDBColumnNullable(fi *Field) {
+func updateDBColumnNullable(d *Database, fi *Field) {
 	adapter := adapters[db.DriverName()]
 	var verb string
 	if adapter.fieldIsNotNull(fi) {
@@ -226,128 +289,128 @@ func updateDBColumnNullable(fi *Field) {
 		ALTER COLUMN %s %s NOT NULL
 	`, adapter.quoteTableName(fi.model.tableName), fi.json, verb)
 	query, _ = sanitizeQuery(query)
-	_, err := db.Exec(query)
+	_, err := d.db.Exec(query)
 	if err != nil {
 		log.Warn("unable to change NOT NULL constraint", "model", fi.model.name, "field", fi.name, "verb", verb)
 	}
 }
 
 // dropDBColumn drops the column colName from table tableName in database
-func dropDBColumn(tableName, colName string) {
+func dropDBColumn(d *Database, tableName, colName string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		DROP COLUMN %s
 	`, adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // updateDBForeignKeyConstraints creates or updates fk constraints
 // based on the data of the given Model
-func updateDBForeignKeyConstraints(m *Model) {
+func updateDBForeignKeyConstraints(d *Database, m *Model) {
 	adapter := adapters[db.DriverName()]
 	for colName, fi := range m.fields.registryByJSON {
-		fkContraintInDB := adapter.constraintExists(fmt.Sprintf("%s_%s_fkey", m.tableName, colName))
+		fkContraintInDB := adapter.constraintExists(d.db, fmt.Sprintf("%s_%s_fkey", m.tableName, colName))
 		fieldIsFK := fi.fieldType.IsFKRelationType() && fi.isStored()
 		switch {
 		case fieldIsFK && !fkContraintInDB:
-			createFKConstraint(m.tableName, colName, fi.relatedModel.tableName, string(fi.onDelete))
+			createFKConstraint(d, m.tableName, colName, fi.relatedModel.tableName, string(fi.onDelete))
 		case !fieldIsFK && fkContraintInDB:
-			dropFKConstraint(m.tableName, colName)
+			dropFKConstraint(d, m.tableName, colName)
 		}
 	}
 }
 
 // updateDBConstraints creates or updates sql constraints
 // based on the data of the given Model
-func updateDBConstraints(m *Model) {
+func updateDBConstraints(d *Database, m *Model) {
 	adapter := adapters[db.DriverName()]
 	for constraintName, constraint := range m.sqlConstraints {
-		if !adapter.constraintExists(constraintName) {
-			createConstraint(m.tableName, constraintName, constraint.sql)
+		if !adapter.constraintExists(d.db, constraintName) {
+			createConstraint(d, m.tableName, constraintName, constraint.sql)
 		}
 	}
 dbConLoop:
-	for _, dbConstraintName := range adapter.constraints(fmt.Sprintf("%%_%s_mancon", m.tableName)) {
+	for _, dbConstraintName := range adapter.constraints(d.db, fmt.Sprintf("%%_%s_mancon", m.tableName)) {
 		for constraintName := range m.sqlConstraints {
 			if constraintName == dbConstraintName {
 				continue dbConLoop
 			}
 		}
-		dropConstraint(m.tableName, dbConstraintName)
+		dropConstraint(d, m.tableName, dbConstraintName)
 	}
 }
 
 // createFKConstraint creates an FK constraint for the given column that references the given targetTable
-func createFKConstraint(tableName, colName, targetTable, ondelete string) {
+func createFKConstraint(d *Database, tableName, colName, targetTable, ondelete string) {
 	adapter := adapters[db.DriverName()]
 	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", colName, adapter.quoteTableName(targetTable), ondelete)
-	createConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), constraint)
+	createConstraint(d, tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), constraint)
 }
 
 // dropFKConstraint drops an FK constraint for colName in the given table
-func dropFKConstraint(tableName, colName string) {
-	dropConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName))
+func dropFKConstraint(d *Database, tableName, colName string) {
+	dropConstraint(d, tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName))
 }
 
 // createConstraint creates a constraint in the given table
-func createConstraint(tableName, constraintName, sql string) {
+func createConstraint(d *Database, tableName, constraintName, sql string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		ALTER TABLE %s ADD CONSTRAINT %s %s
 	`, adapter.quoteTableName(tableName), constraintName, sql)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // dropConstraint drops a constraint with the given name
-func dropConstraint(tableName, constraintName string) {
+func dropConstraint(d *Database, tableName, constraintName string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s
 	`, adapter.quoteTableName(tableName), constraintName)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // updateDBIndexes creates or updates indexes based on the data of
 // the given Model
-func updateDBIndexes(m *Model) {
+func updateDBIndexes(d *Database, m *Model) {
 	adapter := adapters[db.DriverName()]
 	for colName, fi := range m.fields.registryByJSON {
-		indexInDB := adapter.indexExists(m.tableName, fmt.Sprintf("%s_%s_index", m.tableName, colName))
+		indexInDB := adapter.indexExists(d.db, m.tableName, fmt.Sprintf("%s_%s_index", m.tableName, colName))
 		switch {
 		case fi.index && !indexInDB:
-			createColumnIndex(m.tableName, colName)
+			createColumnIndex(d, m.tableName, colName)
 		case indexInDB && !fi.index:
-			dropColumnIndex(m.tableName, colName)
+			dropColumnIndex(d, m.tableName, colName)
 		}
 	}
 }
 
 // createColumnIndex creates an column index for colName in the given table
-func createColumnIndex(tableName, colName string) {
+func createColumnIndex(d *Database, tableName, colName string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		CREATE INDEX %s ON %s (%s)
 	`, fmt.Sprintf("%s_%s_index", tableName, colName), adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // dropColumnIndex drops a column index for colName in the given table
-func dropColumnIndex(tableName, colName string) {
+func dropColumnIndex(d *Database, tableName, colName string) {
 	query := fmt.Sprintf(`
 		DROP INDEX IF EXISTS %s
 	`, fmt.Sprintf("%s_%s_index", tableName, colName))
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.db, query)
 }
 
 // runInit runs the Init function of the given model if it exists
-func runInit(model *Model) {
+func runInit(d *Database, model *Model) {
 	if _, exists := model.methods.Get("Init"); exists {
-		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		err := ExecuteInNewEnvironmentContext(ContextWithDatabase(context.Background(), d), security.SuperUserID, func(env Environment) {
 			env.Pool(model.name).Call("Init")
 		})
 		if err != nil {
-			log.Panic("Error while calling Init function", "model", model.name, "error", err)
+			log.Panic("Error while calling Init function", "database", d.name, "model", model.name, "error", err)
 		}
 	}
 }
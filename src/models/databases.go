@@ -0,0 +1,454 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+	"github.com/jmoiron/sqlx"
+)
+
+// A Database is a connection pool to one of the databases served by the
+// application.
+//
+// All the databases share the model definitions. The default database is
+// connected with DBConnect and the others with ConnectDatabase, with the
+// same connection parameters except for the database name.
+type Database struct {
+	name   string
+	db     *sqlx.DB
+	params ConnectionParams
+	// replicas are the read replicas of the database and nextReplica
+	// the counter used to run read only transactions on them in turn.
+	replicas    []*replica
+	nextReplica uint32
+	// schemaChecked is set once CheckSchema succeeded, since the
+	// schema is only updated by Sync before the server starts.
+	schemaChecked struct {
+		sync.Mutex
+		ok bool
+	}
+	// users is the number of requests using the database and lastUsed
+	// the time at which the last one ended. pinned is set if the database
+	// has been connected with ConnectDatabase. They are protected by the
+	// lock of databases.
+	users    int
+	lastUsed time.Time
+	pinned   bool
+}
+
+// Name returns the name of this database
+func (d *Database) Name() string {
+	return d.name
+}
+
+// Params returns the connection parameters of this database
+func (d *Database) Params() ConnectionParams {
+	return d.params
+}
+
+// databases is the registry of the databases to which the application is connected
+var databases = struct {
+	sync.RWMutex
+	byName     map[string]*Database
+	defaultDB  *Database
+	closeHooks []func(*Database)
+}{
+	byName: make(map[string]*Database),
+}
+
+// ErrTooManyDatabases is returned by AcquireDatabase when the maximum number
+// of connected databases is reached and all of them are serving requests.
+var ErrTooManyDatabases = errors.New("too many databases connected")
+
+// connectDatabase returns a new Database connected with the given parameters
+func connectDatabase(params ConnectionParams) (*Database, error) {
+	connStr := params.ConnectionString()
+	conn, err := sqlx.Connect(params.Driver, connStr)
+	if err != nil {
+		return nil, err
+	}
+	res := &Database{
+		name:     params.DBName,
+		db:       conn,
+		params:   params,
+		lastUsed: time.Now(),
+	}
+	params.configurePool(res.db)
+	log.Info("Connected to database", "driver", params.Driver, "connStr", connStr)
+	res.replicas = connectReplicas(params)
+	return res, nil
+}
+
+// DefaultDatabase returns the database connected with DBConnect,
+// or nil if the application is not connected to a database.
+//
+// The default database is used by Environments whose context.Context
+// does not hold a database.
+func DefaultDatabase() *Database {
+	databases.RLock()
+	defer databases.RUnlock()
+	return databases.defaultDB
+}
+
+// ConnectDatabase returns the database with the given name, connecting to it
+// with the connection parameters of the default database if necessary.
+//
+// The database is never closed by AcquireDatabase, since it is used outside of
+// requests, for instance to update its schema. It panics if the application is
+// not connected to the default database or if the connection fails.
+func ConnectDatabase(name string) *Database {
+	database, params := pinConnectedDatabase(name)
+	if database != nil {
+		return database
+	}
+	params.DBName = name
+	newDB, err := connectDatabase(params)
+	if err != nil {
+		log.Panic("Unable to connect to database", "name", name, "error", err)
+	}
+	database = addPinnedDatabase(newDB)
+	if database != newDB {
+		newDB.close()
+	}
+	return database
+}
+
+// pinConnectedDatabase marks the connected database with the given name so
+// that it is never closed by AcquireDatabase, and returns it. It returns nil
+// and the connection parameters of the default database if it is not connected.
+func pinConnectedDatabase(name string) (*Database, ConnectionParams) {
+	databases.Lock()
+	defer databases.Unlock()
+	if databases.defaultDB == nil {
+		log.Panic("Unable to connect to database before the default database", "name", name)
+	}
+	database, ok := databases.byName[name]
+	if !ok {
+		return nil, databases.defaultDB.params
+	}
+	database.pinned = true
+	return database, databases.defaultDB.params
+}
+
+// addPinnedDatabase registers the given newly connected database as pinned and
+// returns it, unless a database with the same name has been connected in the
+// meantime, in which case this one is pinned and returned instead.
+func addPinnedDatabase(database *Database) *Database {
+	databases.Lock()
+	defer databases.Unlock()
+	if existing, ok := databases.byName[database.name]; ok {
+		existing.pinned = true
+		return existing
+	}
+	database.pinned = true
+	databases.byName[database.name] = database
+	return database
+}
+
+// AcquireDatabase returns the database with the given name for a request,
+// connecting to it with the connection parameters of the default database if
+// necessary. Release must be called on the returned database once the request
+// is served.
+//
+// The databases other than the default database that serve no request are
+// closed when they have been idle for longer than the DatabaseIdleTimeout of
+// the default database, or when connecting to a new database would exceed its
+// MaxDatabases, starting with the least recently used. ErrTooManyDatabases is
+// returned if MaxDatabases is reached and no database can be closed. The
+// databases connected with ConnectDatabase are never closed.
+//
+// The connection is established without blocking the other databases, and
+// its error is returned if it fails.
+func AcquireDatabase(name string) (*Database, error) {
+	database, params, err := acquireConnectedDatabase(name)
+	if database != nil || err != nil {
+		return database, err
+	}
+	params.DBName = name
+	newDB, err := connectDatabase(params)
+	if err != nil {
+		return nil, err
+	}
+	database, err = addAcquiredDatabase(newDB)
+	if database != newDB {
+		newDB.close()
+	}
+	return database, err
+}
+
+// acquireConnectedDatabase closes the idle databases and acquires the
+// connected database with the given name. If it is not connected, it returns
+// nil and the connection parameters of the default database, after closing
+// the least recently used database if MaxDatabases is reached.
+func acquireConnectedDatabase(name string) (*Database, ConnectionParams, error) {
+	var closed []*Database
+	defer func() {
+		closeDatabases(closed)
+	}()
+	databases.Lock()
+	defer databases.Unlock()
+	if databases.defaultDB == nil {
+		log.Panic("Unable to connect to database before the default database", "name", name)
+	}
+	params := databases.defaultDB.params
+	closed = closeIdleDatabases(params.DatabaseIdleTimeout, name)
+	if database, ok := databases.byName[name]; ok {
+		database.users++
+		return database, params, nil
+	}
+	evicted, err := makeRoom(params)
+	closed = append(closed, evicted...)
+	return nil, params, err
+}
+
+// addAcquiredDatabase registers the given newly connected database and
+// acquires it, unless a database with the same name has been connected in
+// the meantime, in which case this one is acquired and returned instead.
+func addAcquiredDatabase(database *Database) (*Database, error) {
+	var closed []*Database
+	defer func() {
+		closeDatabases(closed)
+	}()
+	databases.Lock()
+	defer databases.Unlock()
+	if existing, ok := databases.byName[database.name]; ok {
+		existing.users++
+		return existing, nil
+	}
+	evicted, err := makeRoom(databases.defaultDB.params)
+	closed = evicted
+	if err != nil {
+		return nil, err
+	}
+	database.users++
+	databases.byName[database.name] = database
+	return database, nil
+}
+
+// makeRoom removes the least recently used database from the registry if the
+// MaxDatabases of the given parameters is reached, and returns it. It returns
+// ErrTooManyDatabases if there is no database to remove.
+//
+// The lock of databases must be held by the caller.
+func makeRoom(params ConnectionParams) ([]*Database, error) {
+	if params.MaxDatabases <= 0 || len(databases.byName)-1 < params.MaxDatabases {
+		return nil, nil
+	}
+	lru := leastRecentlyUsedDatabase()
+	if lru == nil {
+		return nil, ErrTooManyDatabases
+	}
+	removeDatabase(lru)
+	return []*Database{lru}, nil
+}
+
+// closeDatabases closes the given databases, which have been removed
+// from the registry, and calls the hooks registered with OnDatabaseClose.
+//
+// The lock of databases must not be held by the caller.
+func closeDatabases(closed []*Database) {
+	if len(closed) == 0 {
+		return
+	}
+	databases.RLock()
+	hooks := databases.closeHooks
+	databases.RUnlock()
+	for _, d := range closed {
+		d.close()
+		for _, hook := range hooks {
+			hook(d)
+		}
+	}
+}
+
+// Release signals that a request for which the database has
+// been returned by AcquireDatabase has been served.
+func (d *Database) Release() {
+	databases.Lock()
+	defer databases.Unlock()
+	d.users--
+	d.lastUsed = time.Now()
+}
+
+// OnDatabaseClose registers a function to call when a database
+// is closed by AcquireDatabase, for instance to release the
+// resources that a module keeps for this database.
+func OnDatabaseClose(fnct func(*Database)) {
+	databases.Lock()
+	defer databases.Unlock()
+	databases.closeHooks = append(databases.closeHooks, fnct)
+}
+
+// closeIdleDatabases removes from the registry the databases other than the
+// default database and the database with the given name that serve no request
+// and have been idle for longer than timeout, and returns them. It does nothing
+// if timeout is zero.
+//
+// The lock of databases must be held by the caller.
+func closeIdleDatabases(timeout time.Duration, name string) []*Database {
+	if timeout <= 0 {
+		return nil
+	}
+	var res []*Database
+	for _, database := range databases.byName {
+		if !database.evictable() || database.name == name || time.Since(database.lastUsed) <= timeout {
+			continue
+		}
+		res = append(res, database)
+	}
+	for _, database := range res {
+		removeDatabase(database)
+	}
+	return res
+}
+
+// leastRecentlyUsedDatabase returns the database other than the default
+// database that serves no request and has been idle for the longest time,
+// or nil if there is none.
+//
+// The lock of databases must be held by the caller.
+func leastRecentlyUsedDatabase() *Database {
+	var res *Database
+	for _, database := range databases.byName {
+		if !database.evictable() {
+			continue
+		}
+		if res == nil || database.lastUsed.Before(res.lastUsed) {
+			res = database
+		}
+	}
+	return res
+}
+
+// evictable returns true if this database can be closed because
+// it is idle, that is if it is not the default database, it has
+// not been connected with ConnectDatabase and it serves no request.
+//
+// The lock of databases must be held by the caller.
+func (d *Database) evictable() bool {
+	return d != databases.defaultDB && !d.pinned && d.users == 0
+}
+
+// removeDatabase removes the given database from the registry.
+//
+// The lock of databases must be held by the caller.
+func removeDatabase(database *Database) {
+	delete(databases.byName, database.name)
+	log.Info("Closing idle database", "name", database.name, "lastUsed", database.lastUsed)
+}
+
+// close closes the connection pools of this database and of its replicas
+func (d *Database) close() {
+	err := d.db.Close()
+	log.Info("Closed database", "name", d.name, "error", err)
+	for _, rep := range d.replicas {
+		err := rep.db.Close()
+		log.Info("Closed read replica", "name", d.name, "host", rep.host, "error", err)
+	}
+}
+
+// GetDatabase returns the connected database with the given name
+// and true, or nil and false if there is none.
+func GetDatabase(name string) (*Database, bool) {
+	databases.RLock()
+	defer databases.RUnlock()
+	database, ok := databases.byName[name]
+	return database, ok
+}
+
+// ConnectedDatabases returns the databases to which the application
+// is connected, sorted by name.
+func ConnectedDatabases() []*Database {
+	databases.RLock()
+	defer databases.RUnlock()
+	res := make([]*Database, 0, len(databases.byName))
+	for _, database := range databases.byName {
+		res = append(res, database)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// ListDatabases returns the names of all the databases of the server of
+// the default database to which the application can connect, whether it
+// is connected to them or not.
+func ListDatabases() (res []string, rErr error) {
+	defaultDB := DefaultDatabase()
+	if defaultDB == nil {
+		return nil, errors.New("not connected to database")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rErr = logging.LogPanicData(r)
+		}
+	}()
+	return adapters[defaultDB.db.DriverName()].databases(defaultDB.db), nil
+}
+
+// ErrNoDatabase is returned by the functions executing in a new Environment
+// when their context.Context holds a nil database.
+var ErrNoDatabase = errors.New("no database selected")
+
+// databaseKey is the key of the database in a context.Context
+type databaseKey struct{}
+
+// ContextWithDatabase returns a copy of ctx which holds the given database,
+// so that the Environments created with ctx are bound to it.
+//
+// If database is nil, Environments cannot be created with the returned
+// context instead of being bound to the default database. This is used to
+// make sure that requests for which no database could be selected do not
+// access the default database.
+//
+// The name of the database is also set with security.ContextWithDatabaseName,
+// so that the group memberships of security.Registry are those of this database.
+func ContextWithDatabase(ctx context.Context, database *Database) context.Context {
+	if database != nil {
+		ctx = security.ContextWithDatabaseName(ctx, database.name)
+	}
+	return context.WithValue(ctx, databaseKey{}, database)
+}
+
+// ContextFromAuthentication returns a context.Context bound to the database
+// named in the given authentication context under security.DatabaseKey, so that
+// authentication backends can search their users in the database of the request.
+//
+// The returned context holds a nil database if the named database is not
+// connected, and it is context.Background() if authCtx holds no database name.
+func ContextFromAuthentication(authCtx *types.Context) context.Context {
+	if authCtx == nil || !authCtx.HasKey(security.DatabaseKey) {
+		return context.Background()
+	}
+	database, _ := GetDatabase(authCtx.GetString(security.DatabaseKey))
+	return ContextWithDatabase(context.Background(), database)
+}
+
+// DatabaseFromContext returns the database held by ctx, or the default
+// database if ctx does not hold any. It returns nil if ctx holds a nil
+// database.
+//
+// If ctx only holds a database name set with security.ContextWithDatabaseName,
+// for instance by the stores of security.AuthenticationRegistry, the connected
+// database with this name is returned, or nil if there is none.
+func DatabaseFromContext(ctx context.Context) *Database {
+	database, ok := ctx.Value(databaseKey{}).(*Database)
+	if ok {
+		return database
+	}
+	if name, ok := security.DatabaseNameFromContext(ctx); ok {
+		database, _ = GetDatabase(name)
+		return database
+	}
+	return DefaultDatabase()
+}
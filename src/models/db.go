@@ -15,11 +15,19 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/hexya/src/tools/tracing"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -41,6 +49,32 @@ type ConnectionParams struct {
 	SSLCert  string
 	SSLKey   string
 	SSLCA    string
+	// StatementTimeout is the default maximum duration of SQL statements
+	// in transactions. There is no limit if it is zero.
+	StatementTimeout time.Duration
+	// Replicas are the read replicas of the database on which the read only
+	// environments are run. Their empty fields are taken from these parameters
+	// and their database name is always DBName.
+	Replicas []ConnectionParams
+	// MaxReplicationLag is the replication lag above which a replica is not used
+	// by read only environments. There is no limit if it is zero.
+	MaxReplicationLag time.Duration
+	// MaxOpenConns is the maximum number of open connections of the connection
+	// pool of the database and of each of its replicas. There is no limit if it is zero.
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections kept by each
+	// connection pool. The default of database/sql is used if it is zero.
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum duration for which a connection may be
+	// reused. Connections are not closed because of their age if it is zero.
+	ConnMaxLifetime time.Duration
+	// MaxDatabases is the maximum number of databases other than the default
+	// database that AcquireDatabase keeps connected. There is no limit if it is zero.
+	MaxDatabases int
+	// DatabaseIdleTimeout is the duration after which the databases other than
+	// the default database that served no request are closed by AcquireDatabase.
+	// They are not closed because of their idle time if it is zero.
+	DatabaseIdleTimeout time.Duration
 }
 
 // ConnectionString returns the connection string for these connection params
@@ -49,6 +83,15 @@ func (cp ConnectionParams) ConnectionString() string {
 	return adapter.connectionString(cp)
 }
 
+// configurePool applies the connection pool settings of these connection params to conn
+func (cp ConnectionParams) configurePool(conn *sqlx.DB) {
+	conn.SetMaxOpenConns(cp.MaxOpenConns)
+	if cp.MaxIdleConns != 0 {
+		conn.SetMaxIdleConns(cp.MaxIdleConns)
+	}
+	conn.SetConnMaxLifetime(cp.ConnMaxLifetime)
+}
+
 // A ColumnData holds information from the db schema about one column
 type ColumnData struct {
 	ColumnName    string
@@ -75,38 +118,55 @@ type dbAdapter interface {
 	//
 	// If null is true, then the column will be nullable, whatever the field defines
 	columnSQLDefinition(fi *Field, null bool) string
-	// tables returns a map of table names of the database
-	tables() map[string]bool
+	// tables returns a map of table names of the given database
+	tables(conn *sqlx.DB) map[string]bool
 	// columns returns a list of ColumnData for the given tableName
-	columns(tableName string) map[string]ColumnData
+	columns(conn *sqlx.DB, tableName string) map[string]ColumnData
 	// fieldIsNull returns true if the given Field results in a
 	// NOT NULL column in database.
 	fieldIsNotNull(fi *Field) bool
 	// quoteTableName returns the given table name with sql quotes
 	quoteTableName(string) string
 	// indexExists returns true if an index with the given name exists in the given table
-	indexExists(table string, name string) bool
+	indexExists(conn *sqlx.DB, table string, name string) bool
 	// constraintExists returns true if a constraint with the given name exists
-	constraintExists(name string) bool
+	constraintExists(conn *sqlx.DB, name string) bool
 	// constraints returns a list of all constraints matching the given SQL pattern
-	constraints(pattern string) []string
+	constraints(conn *sqlx.DB, pattern string) []string
 	// setTransactionIsolation returns the SQL string to set the transaction isolation
 	// level to serializable
 	setTransactionIsolation() string
+	// setTransactionReadOnly returns the SQL string to set the transaction read only
+	setTransactionReadOnly() string
+	// setReplicaTransaction returns the SQL string to set the transaction read only
+	// with an isolation level that is supported by read replicas
+	setReplicaTransaction() string
+	// setStatementTimeout returns the SQL string to set the maximum duration
+	// of the statements of the transaction, or no limit if timeout is zero.
+	setStatementTimeout(timeout time.Duration) string
 	// createSequence creates a DB sequence with the given name
-	createSequence(name string, increment, start int64)
+	createSequence(conn *sqlx.DB, name string, increment, start int64)
 	// dropSequence drop the DB sequence with the given name
-	dropSequence(name string)
+	dropSequence(conn *sqlx.DB, name string)
 	// alterSequence modifies the DB sequence given by name
-	alterSequence(name string, increment, restart int64)
+	alterSequence(conn *sqlx.DB, name string, increment, restart int64)
 	// nextSequenceValue returns the next value of the given given sequence
-	nextSequenceValue(name string) int64
+	nextSequenceValue(conn *sqlx.DB, name string) int64
 	// sequences returns a list of all sequences matching the given SQL pattern
-	sequences(pattern string) []seqData
+	sequences(conn *sqlx.DB, pattern string) []seqData
+	// replicationLag returns the replication lag of the given replica.
+	// The query is cancelled when ctx is done.
+	replicationLag(ctx context.Context, conn *sqlx.DB) time.Duration
+	// databases returns the names of the databases of the server of the given
+	// database to which the application can connect, sorted by name.
+	databases(conn *sqlx.DB) []string
 	// childrenIdsQuery returns a query that finds all descendant of the given
 	// a record from table including itself. The query has a placeholder for the
 	// record's ID
 	childrenIdsQuery(table string) string
+	// typedError returns the error of the exceptions package matching the
+	// given database error, or err itself if there is none.
+	typedError(err error) error
 	// substituteErrorMessage substitutes the given error's message by newMsg
 	substituteErrorMessage(err error, newMsg string) error
 	// isSerializationError returns true if the given error is a serialization error
@@ -123,123 +183,351 @@ func registerDBAdapter(name string, adapter dbAdapter) {
 // Cursor is a wrapper around a database transaction
 type Cursor struct {
 	tx *sqlx.Tx
+	// database is the database of the transaction
+	database *Database
+	// readOnly is true if the transaction is read only,
+	// in which case it may run on a read replica
+	readOnly bool
+	// ctx is the context of the transaction. Queries are cancelled
+	// and the transaction is rolled back when it is done.
+	ctx context.Context
+	// cancel cancels ctx, which forces the rollback of the transaction
+	cancel context.CancelFunc
+	// txSpan is the tracing span of the transaction
+	txSpan *tracing.Span
+	// span is the current tracing span, that is the span of the method
+	// layer being executed or txSpan. It is the parent of query spans.
+	span *tracing.Span
+	// savepoints is the number of savepoints created in the transaction,
+	// from which the names of the savepoints are made unique.
+	savepoints int
 }
 
 // Execute a query without returning any rows. It panics in case of error.
 // The args are for any placeholder parameters in the query.
 func (c *Cursor) Execute(query string, args ...interface{}) sql.Result {
-	return dbExecute(c.tx, query, args...)
+	return dbExecute(c, query, args...)
 }
 
 // Get queries a row into the database and maps the result into dest.
 // The query must return only one row. Get panics on errors
 func (c *Cursor) Get(dest interface{}, query string, args ...interface{}) {
-	dbGet(c.tx, dest, query, args...)
+	dbGet(c, dest, query, args...)
 }
 
 // Select queries multiple rows and map the result into dest which must be a slice.
 // Select panics on errors.
 func (c *Cursor) Select(dest interface{}, query string, args ...interface{}) {
-	dbSelect(c.tx, dest, query, args...)
+	dbSelect(c, dest, query, args...)
+}
+
+// openCursors holds the cursors whose transaction has not been committed
+// or rolled back yet, so that they can be rolled back on shutdown.
+var openCursors = struct {
+	sync.Mutex
+	cursors map[*Cursor]bool
+}{
+	cursors: make(map[*Cursor]bool),
 }
 
-// newCursor returns a new db cursor on the given database
-func newCursor(db *sqlx.DB) *Cursor {
-	adapter := adapters[db.DriverName()]
-	tx := db.MustBegin()
-	dbExecute(tx, adapter.setTransactionIsolation())
-	return &Cursor{
-		tx: tx,
+// newCursor returns a new db cursor on the given database bound to ctx.
+// Its transaction is a child of the tracing span of ctx, if any.
+//
+// If readOnly is true, the transaction is read only and runs
+// on a read replica of the database if there is one available.
+func newCursor(ctx context.Context, database *Database, readOnly bool) *Cursor {
+	adapter := adapters[database.db.DriverName()]
+	ctx, cancel := context.WithCancel(ctx)
+	txSpan := tracing.StartSpan("transaction", tracing.KindInternal, tracing.SpanFromContext(ctx))
+	txSpan.SetAttribute("db.name", database.name)
+	conn := database.db
+	if readOnly {
+		txSpan.SetAttribute("db.read_only", true)
+		conn = database.readConn()
+	}
+	onReplica := conn != database.db
+	cr := &Cursor{
+		tx:       conn.MustBeginTx(ctx, nil),
+		database: database,
+		readOnly: readOnly,
+		ctx:      ctx,
+		cancel:   cancel,
+		txSpan:   txSpan,
+		span:     txSpan,
+	}
+	openCursors.Lock()
+	openCursors.cursors[cr] = true
+	openCursors.Unlock()
+	switch {
+	case onReplica:
+		dbExecute(cr, adapter.setReplicaTransaction())
+	case readOnly:
+		dbExecute(cr, adapter.setTransactionIsolation())
+		dbExecute(cr, adapter.setTransactionReadOnly())
+	default:
+		dbExecute(cr, adapter.setTransactionIsolation())
 	}
+	if database.params.StatementTimeout > 0 {
+		dbExecute(cr, adapter.setStatementTimeout(database.params.StatementTimeout))
+	}
+	return cr
+}
+
+// commit the transaction of this cursor
+func (c *Cursor) commit() error {
+	err := c.tx.Commit()
+	c.release("commit", err)
+	return err
+}
+
+// rollback the transaction of this cursor
+func (c *Cursor) rollback() error {
+	err := c.tx.Rollback()
+	c.release("rollback", err)
+	return err
+}
+
+// release ends the tracing span of the transaction of this cursor, which
+// ended with the given outcome and error, and removes it from the open cursors.
+func (c *Cursor) release(outcome string, err error) {
+	c.endTxSpan(outcome, err)
+	c.cancel()
+	openCursors.Lock()
+	delete(openCursors.cursors, c)
+	openCursors.Unlock()
 }
 
-// DBParams returns the DB connection parameters currently in use
+// savepoint creates a new savepoint in the transaction of this cursor and returns its name
+func (c *Cursor) savepoint() string {
+	c.savepoints++
+	name := fmt.Sprintf("hexya_savepoint_%d", c.savepoints)
+	c.Execute(fmt.Sprintf("SAVEPOINT %s", name))
+	return name
+}
+
+// releaseSavepoint releases the savepoint with the given name, keeping its changes
+func (c *Cursor) releaseSavepoint(name string) {
+	c.Execute(fmt.Sprintf("RELEASE SAVEPOINT %s", name))
+}
+
+// rollbackToSavepoint rolls back the changes made since the savepoint with the given name
+func (c *Cursor) rollbackToSavepoint(name string) {
+	c.Execute(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name))
+}
+
+// endTxSpan ends the tracing span of the transaction of this cursor
+// which ended with the given outcome and error.
+func (c *Cursor) endTxSpan(outcome string, err error) {
+	c.txSpan.SetAttribute("hexya.transaction.outcome", outcome)
+	if err != nil {
+		c.txSpan.SetError(err.Error())
+	}
+	c.txSpan.End()
+}
+
+// openCursorsPollInterval is the interval at which RollbackOpenTransactions
+// checks whether the open transactions have been released.
+const openCursorsPollInterval = 50 * time.Millisecond
+
+// RollbackOpenTransactions waits until the transactions of all environments
+// are committed or rolled back by the functions running in them, or until
+// ctx is done. In the latter case, it forces the rollback of the transactions
+// still open and returns their number.
+//
+// It is meant to be called on shutdown, after requests and workers have
+// been given the time to complete, and before DBClose. The forced rollback
+// cancels the context of the transactions, so that the functions still
+// running in these environments fail on their next query and release their
+// environment themselves.
+func RollbackOpenTransactions(ctx context.Context) int {
+	ticker := time.NewTicker(openCursorsPollInterval)
+	defer ticker.Stop()
+	for openCursorsCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return cancelOpenCursors()
+		}
+	}
+	return 0
+}
+
+// openCursorsCount returns the number of open cursors
+func openCursorsCount() int {
+	openCursors.Lock()
+	defer openCursors.Unlock()
+	return len(openCursors.cursors)
+}
+
+// cancelOpenCursors cancels the context of all open cursors,
+// which rolls back their transaction, and returns their number.
+func cancelOpenCursors() int {
+	openCursors.Lock()
+	defer openCursors.Unlock()
+	for cr := range openCursors.cursors {
+		cr.cancel()
+	}
+	return len(openCursors.cursors)
+}
+
+// DBParams returns the connection parameters of the default database
 func DBParams() ConnectionParams {
 	return connParams
 }
 
-// DBConnect connects to a database using the given driver and arguments.
+// DBConnect connects to the default database using the given driver and arguments.
+//
+// Other databases of the same server can then be connected with ConnectDatabase.
 func DBConnect(params ConnectionParams) {
+	database, err := connectDatabase(params)
+	if err != nil {
+		log.Panic("Unable to connect to database", "name", params.DBName, "error", err)
+	}
+	databases.Lock()
+	defer databases.Unlock()
+	databases.byName[params.DBName] = database
+	databases.defaultDB = database
+	db = database.db
 	connParams = params
-	connStr := DBParams().ConnectionString()
-	db = sqlx.MustConnect(params.Driver, connStr)
-	log.Info("Connected to database", "driver", params.Driver, "connStr", connStr)
+	security.SetDefaultDatabaseName(params.DBName)
 }
 
 // DBClose is a wrapper around sqlx.Close
-// It closes the connection to the database
+// It closes the connections to all the databases
 func DBClose() {
-	err := db.Close()
-	log.Info("Closed database", "error", err)
+	databases.Lock()
+	defer databases.Unlock()
+	for name, database := range databases.byName {
+		database.close()
+		if database != databases.defaultDB {
+			delete(databases.byName, name)
+		}
+	}
+}
+
+// DBPing checks that all the connected databases are reachable
+func DBPing() error {
+	if db == nil {
+		return errors.New("not connected to database")
+	}
+	for _, database := range ConnectedDatabases() {
+		err := database.db.Ping()
+		switch {
+		case err == nil:
+			continue
+		case database == DefaultDatabase():
+			return err
+		default:
+			return fmt.Errorf("database %s: %w", database.name, err)
+		}
+	}
+	return nil
+}
+
+// DBStats returns the statistics of the connection pools of all
+// the databases and of their read replicas
+func DBStats() sql.DBStats {
+	var res sql.DBStats
+	for _, database := range ConnectedDatabases() {
+		conns := []*sqlx.DB{database.db}
+		for _, rep := range database.replicas {
+			conns = append(conns, rep.db)
+		}
+		for _, conn := range conns {
+			stats := conn.Stats()
+			res.MaxOpenConnections += stats.MaxOpenConnections
+			res.OpenConnections += stats.OpenConnections
+			res.InUse += stats.InUse
+			res.Idle += stats.Idle
+			res.WaitCount += stats.WaitCount
+			res.WaitDuration += stats.WaitDuration
+			res.MaxIdleClosed += stats.MaxIdleClosed
+			res.MaxLifetimeClosed += stats.MaxLifetimeClosed
+		}
+	}
+	return res
 }
 
 // dbExecute is a wrapper around sqlx.MustExec
 // It executes a query that returns no row
-func dbExecute(cr *sqlx.Tx, query string, args ...interface{}) sql.Result {
+func dbExecute(cr *Cursor, query string, args ...interface{}) sql.Result {
 	query, args = sanitizeQuery(query, args...)
+	span := startQuerySpan(cr, query, args)
 	t := time.Now()
-	res, err := cr.Exec(query, args...)
-	logSQLResult(err, t, query, args...)
+	res, err := cr.tx.ExecContext(cr.ctx, query, args...)
+	logSQLResult(span, err, t, query, args...)
 	return res
 }
 
-// dbExecuteNoTx simply executes the given query in the database without any transaction
-func dbExecuteNoTx(query string, args ...interface{}) sql.Result {
+// dbExecuteNoTx simply executes the given query in the given database without any transaction
+func dbExecuteNoTx(conn *sqlx.DB, query string, args ...interface{}) sql.Result {
 	query, args = sanitizeQuery(query, args...)
+	span := startQuerySpan(nil, query, args)
 	t := time.Now()
-	res, err := db.Exec(query, args...)
-	logSQLResult(err, t, query, args...)
+	res, err := conn.ExecContext(context.Background(), query, args...)
+	logSQLResult(span, err, t, query, args...)
 	return res
 }
 
 // dbGet is a wrapper around sqlx.Get
 // It gets the value of a single row found by the given query and arguments
 // It panics in case of error
-func dbGet(cr *sqlx.Tx, dest interface{}, query string, args ...interface{}) {
+func dbGet(cr *Cursor, dest interface{}, query string, args ...interface{}) {
 	query, args = sanitizeQuery(query, args...)
+	span := startQuerySpan(cr, query, args)
 	t := time.Now()
-	err := cr.Get(dest, query, args...)
-	logSQLResult(err, t, query, args)
+	err := cr.tx.GetContext(cr.ctx, dest, query, args...)
+	logSQLResult(span, err, t, query, args)
 }
 
 // dbGetNoTx is a wrapper around sqlx.Get outside a transaction
-// It gets the value of a single row found by the
-// given query and arguments
-func dbGetNoTx(dest interface{}, query string, args ...interface{}) {
+// It gets the value of a single row found in the given
+// database by the given query and arguments
+func dbGetNoTx(conn *sqlx.DB, dest interface{}, query string, args ...interface{}) {
+	dbGetNoTxContext(context.Background(), conn, dest, query, args...)
+}
+
+// dbGetNoTxContext is the same as dbGetNoTx but the query
+// is cancelled when the given context is done.
+func dbGetNoTxContext(ctx context.Context, conn *sqlx.DB, dest interface{}, query string, args ...interface{}) {
 	query, args = sanitizeQuery(query, args...)
+	span := startQuerySpan(nil, query, args)
 	t := time.Now()
-	err := db.Get(dest, query, args...)
-	logSQLResult(err, t, query, args)
+	err := conn.GetContext(ctx, dest, query, args...)
+	logSQLResult(span, err, t, query, args)
 }
 
 // dbSelect is a wrapper around sqlx.Select
 // It gets the value of a multiple rows found by the given query and arguments
 // dest must be a slice. It panics in case of error
-func dbSelect(cr *sqlx.Tx, dest interface{}, query string, args ...interface{}) {
+func dbSelect(cr *Cursor, dest interface{}, query string, args ...interface{}) {
 	query, args = sanitizeQuery(query, args...)
+	span := startQuerySpan(cr, query, args)
 	t := time.Now()
-	err := cr.Select(dest, query, args...)
-	logSQLResult(err, t, query, args)
+	err := cr.tx.SelectContext(cr.ctx, dest, query, args...)
+	logSQLResult(span, err, t, query, args)
 }
 
-// dbSelect is a wrapper around sqlx.Select outside a transaction
-// It gets the value of a multiple rows found by the given query and arguments
+// dbSelectNoTx is a wrapper around sqlx.Select outside a transaction
+// It gets the value of a multiple rows found in the given database by the given query and arguments
 // dest must be a slice. It panics in case of error
-func dbSelectNoTx(dest interface{}, query string, args ...interface{}) {
+func dbSelectNoTx(conn *sqlx.DB, dest interface{}, query string, args ...interface{}) {
 	query, args = sanitizeQuery(query, args...)
+	span := startQuerySpan(nil, query, args)
 	t := time.Now()
-	err := db.Select(dest, query, args...)
-	logSQLResult(err, t, query, args)
+	err := conn.SelectContext(context.Background(), dest, query, args...)
+	logSQLResult(span, err, t, query, args)
 }
 
 // dbQuery is a wrapper around sqlx.Queryx
 // It returns a sqlx.Rowsx found by the given query and arguments
 // It panics in case of error
-func dbQuery(cr *sqlx.Tx, query string, args ...interface{}) *sqlx.Rows {
+func dbQuery(cr *Cursor, query string, args ...interface{}) *sqlx.Rows {
 	query, args = sanitizeQuery(query, args...)
+	span := startQuerySpan(cr, query, args)
 	t := time.Now()
-	rows, err := cr.Queryx(query, args...)
-	logSQLResult(err, t, query, args)
+	rows, err := cr.tx.QueryxContext(cr.ctx, query, args...)
+	logSQLResult(span, err, t, query, args)
 	return rows
 }
 
@@ -255,14 +543,56 @@ func sanitizeQuery(query string, args ...interface{}) (string, []interface{}) {
 	return q, args
 }
 
+// startQuerySpan starts the tracing span of the given query, child of the
+// current span of cr. If cr is nil, the query span is the root of a new trace.
+func startQuerySpan(cr *Cursor, query string, args []interface{}) *tracing.Span {
+	if !tracing.Enabled() {
+		return nil
+	}
+	var parent *tracing.Span
+	if cr != nil {
+		parent = cr.span
+	}
+	operation := "SQL"
+	if words := strings.Fields(query); len(words) > 0 {
+		operation = strings.ToUpper(words[0])
+	}
+	span := tracing.StartSpan(operation, tracing.KindClient, parent)
+	span.SetAttribute("db.system", db.DriverName())
+	if cr != nil {
+		span.SetAttribute("db.name", cr.database.name)
+	}
+	span.SetAttribute("db.operation", operation)
+	span.SetAttribute("db.statement", query)
+	span.SetAttribute("db.args", argTypes(args))
+	return span
+}
+
+// argTypes returns the types of the given query args, such as
+// "[string int64 <nil>]". Only the types are exported in tracing spans,
+// since the values may be secrets or personal data.
+func argTypes(args []interface{}) string {
+	res := make([]string, len(args))
+	for i, arg := range args {
+		res[i] = fmt.Sprintf("%T", arg)
+	}
+	return fmt.Sprintf("%v", res)
+}
+
 // Log the result of the given sql query started at start time with the
-// given args, and error. This function panics after logging if error is not nil.
-func logSQLResult(err error, start time.Time, query string, args ...interface{}) {
+// given args, and error and end its tracing span.
+// This function panics after logging if error is not nil.
+func logSQLResult(span *tracing.Span, err error, start time.Time, query string, args ...interface{}) {
+	metrics.ObserveSQLQuery(start, err)
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.End()
 	logCtx := log.New("query", query, "args", strutils.TrimArgs(args), "duration", time.Now().Sub(start))
 	if err != nil {
 		// We don't log.Panic to keep db error information in recovery
 		logCtx.Error("Error while executing query", "error", err)
-		panic(err)
+		panic(adapters[db.DriverName()].typedError(err))
 	}
 	logCtx.Debug("Query executed")
 }
@@ -15,11 +15,15 @@
 package models
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
@@ -144,11 +148,11 @@ func (d *postgresAdapter) fieldIsNotNull(fi *Field) bool {
 	return false
 }
 
-// tables returns a map of table names of the database
-func (d *postgresAdapter) tables() map[string]bool {
+// tables returns a map of table names of the given database
+func (d *postgresAdapter) tables(conn *sqlx.DB) map[string]bool {
 	var resList []string
 	query := "SELECT table_name FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')"
-	if err := db.Select(&resList, query); err != nil {
+	if err := conn.Select(&resList, query); err != nil {
 		log.Panic("Unable to get list of tables from database", "error", err)
 	}
 	res := make(map[string]bool, len(resList))
@@ -164,14 +168,14 @@ func (d *postgresAdapter) quoteTableName(tableName string) string {
 }
 
 // columns returns a list of ColumnData for the given tableName
-func (d *postgresAdapter) columns(tableName string) map[string]ColumnData {
+func (d *postgresAdapter) columns(conn *sqlx.DB, tableName string) map[string]ColumnData {
 	query := fmt.Sprintf(`
 		SELECT column_name, data_type, is_nullable, column_default
 		FROM information_schema.columns
 		WHERE table_schema NOT IN ('pg_catalog', 'information_schema') AND table_name = '%s'
 	`, tableName)
 	var colData []ColumnData
-	if err := db.Select(&colData, query); err != nil {
+	if err := conn.Select(&colData, query); err != nil {
 		log.Panic("Unable to get list of columns for table", "table", tableName, "error", err)
 	}
 	res := make(map[string]ColumnData, len(colData))
@@ -182,43 +186,43 @@ func (d *postgresAdapter) columns(tableName string) map[string]ColumnData {
 }
 
 // indexExists returns true if an index with the given name exists in the given table
-func (d *postgresAdapter) indexExists(table string, name string) bool {
+func (d *postgresAdapter) indexExists(conn *sqlx.DB, table string, name string) bool {
 	query := fmt.Sprintf("SELECT COUNT(*) FROM pg_indexes WHERE tablename = '%s' AND indexname = '%s'", table, name)
 	var cnt int
-	dbGetNoTx(&cnt, query)
+	dbGetNoTx(conn, &cnt, query)
 	return cnt > 0
 }
 
 // constraintExists returns true if a constraint with the given name exists in the given table
-func (d *postgresAdapter) constraintExists(name string) bool {
+func (d *postgresAdapter) constraintExists(conn *sqlx.DB, name string) bool {
 	query := fmt.Sprintf("SELECT COUNT(*) FROM pg_constraint WHERE conname = '%s'", name)
 	var cnt int
-	dbGetNoTx(&cnt, query)
+	dbGetNoTx(conn, &cnt, query)
 	return cnt > 0
 }
 
 // constraints returns a list of all constraints matching the given SQL pattern
-func (d *postgresAdapter) constraints(pattern string) []string {
+func (d *postgresAdapter) constraints(conn *sqlx.DB, pattern string) []string {
 	query := "SELECT conname FROM pg_constraint WHERE conname ILIKE ?"
 	var res []string
-	dbSelectNoTx(&res, query, pattern)
+	dbSelectNoTx(conn, &res, query, pattern)
 	return res
 }
 
 // createSequence creates a DB sequence with the given name
-func (d *postgresAdapter) createSequence(name string, increment, start int64) {
+func (d *postgresAdapter) createSequence(conn *sqlx.DB, name string, increment, start int64) {
 	query := fmt.Sprintf("CREATE SEQUENCE %s INCREMENT BY %d START WITH %d", name, increment, start)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(conn, query)
 }
 
 // dropSequence drops the DB sequence with the given name
-func (d *postgresAdapter) dropSequence(name string) {
+func (d *postgresAdapter) dropSequence(conn *sqlx.DB, name string) {
 	query := fmt.Sprintf("DROP SEQUENCE IF EXISTS %s", name)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(conn, query)
 }
 
 // alterSequence modifies the DB sequence given by name
-func (d *postgresAdapter) alterSequence(name string, increment, restart int64) {
+func (d *postgresAdapter) alterSequence(conn *sqlx.DB, name string, increment, restart int64) {
 	query := fmt.Sprintf(`ALTER SEQUENCE %s`, name)
 	if increment != 0 {
 		query += fmt.Sprintf(` INCREMENT BY %d`, increment)
@@ -226,31 +230,93 @@ func (d *postgresAdapter) alterSequence(name string, increment, restart int64) {
 	if restart != 0 {
 		query += fmt.Sprintf(` RESTART WITH %d`, restart)
 	}
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(conn, query)
 }
 
 // nextSequenceValue returns the next value of the given given sequence
-func (d *postgresAdapter) nextSequenceValue(name string) int64 {
+func (d *postgresAdapter) nextSequenceValue(conn *sqlx.DB, name string) int64 {
 	query := fmt.Sprintf("SELECT nextval('%s')", name)
 	var val int64
-	dbGetNoTx(&val, query)
+	dbGetNoTx(conn, &val, query)
 	return val
 }
 
 // sequences returns a list of all sequences matching the given SQL pattern
-func (d *postgresAdapter) sequences(pattern string) []seqData {
+func (d *postgresAdapter) sequences(conn *sqlx.DB, pattern string) []seqData {
 	query := "SELECT sequence_name, start_value, increment FROM information_schema.sequences WHERE sequence_name ILIKE ?"
 	var res []seqData
-	dbSelectNoTx(&res, query, pattern)
+	dbSelectNoTx(conn, &res, query, pattern)
 	return res
 }
 
+// databases returns the names of the databases of the server of the given
+// database to which the application can connect, sorted by name.
+func (d *postgresAdapter) databases(conn *sqlx.DB) []string {
+	query := "SELECT datname FROM pg_database WHERE datistemplate = FALSE AND datallowconn AND datname != 'postgres' ORDER BY datname"
+	var res []string
+	dbSelectNoTx(conn, &res, query)
+	return res
+}
+
+// replicationLag returns the replication lag of the given replica, that is the
+// age of the last replayed transaction unless the WAL receiver is streaming and
+// all the received WAL has been replayed. It is zero on primary servers.
+//
+// It panics if the WAL receiver of the replica is not streaming, since the
+// replica may then be arbitrarily stale without lagging behind what it received.
+func (d *postgresAdapter) replicationLag(ctx context.Context, conn *sqlx.DB) time.Duration {
+	query := `
+		SELECT pg_is_in_recovery() AS in_recovery,
+			EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming') AS streaming,
+			COALESCE(pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(), FALSE) AS replayed,
+			COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) AS lag`
+	var res struct {
+		InRecovery bool    `db:"in_recovery"`
+		Streaming  bool    `db:"streaming"`
+		Replayed   bool    `db:"replayed"`
+		Lag        float64 `db:"lag"`
+	}
+	dbGetNoTxContext(ctx, conn, &res, query)
+	switch {
+	case !res.InRecovery:
+		return 0
+	case !res.Streaming:
+		log.Panic("WAL receiver of replica is not streaming")
+	case res.Replayed:
+		return 0
+	}
+	return time.Duration(res.Lag * float64(time.Second))
+}
+
 // setTransactionIsolation returns the SQL string to set the
 // transaction isolation level to serializable
 func (d *postgresAdapter) setTransactionIsolation() string {
 	return "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"
 }
 
+// setTransactionReadOnly returns the SQL string to set the transaction read only
+func (d *postgresAdapter) setTransactionReadOnly() string {
+	return "SET TRANSACTION READ ONLY"
+}
+
+// setReplicaTransaction returns the SQL string to set the transaction read only.
+// The isolation level is repeatable read since serializable transactions
+// cannot run on hot standby servers.
+func (d *postgresAdapter) setReplicaTransaction() string {
+	return "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY"
+}
+
+// setStatementTimeout returns the SQL string to set the maximum duration
+// of the statements of the transaction, or no limit if timeout is zero.
+func (d *postgresAdapter) setStatementTimeout(timeout time.Duration) string {
+	ms := int64(timeout / time.Millisecond)
+	if timeout > 0 && ms == 0 {
+		// statement_timeout is in milliseconds and 0 means no limit
+		ms = 1
+	}
+	return fmt.Sprintf("SET LOCAL statement_timeout = %d", ms)
+}
+
 // childrenIdsQuery returns a query that finds all descendant of the given
 // a record from table including itself. The query has a placeholder for the
 // record's ID
@@ -272,14 +338,45 @@ FROM    recursive_query_children_ids`, d.quoteTableName(table), d.quoteTableName
 	return res
 }
 
-// substituteErrorMessage substitutes the given error's message by newMsg
-func (d *postgresAdapter) substituteErrorMessage(err error, newMsg string) error {
-	pgError, ok := err.(*pq.Error)
+// typedError returns the error of the exceptions package matching the
+// given database error, or err itself if there is none.
+//
+// Serialization errors are returned as is so that the
+// transaction can be retried.
+func (d *postgresAdapter) typedError(err error) error {
+	pqErr, ok := err.(*pq.Error)
 	if !ok {
 		return err
 	}
-	pgError.Message = newMsg
-	return pgError
+	switch {
+	case pqErr.Code.Class() == "23":
+		// Integrity constraint violation
+		return exceptions.ConstraintError{
+			Constraint: pqErr.Constraint,
+			Message:    pqErr.Message,
+			Err:        err,
+		}
+	case pqErr.Code == "25006":
+		// Read only SQL transaction
+		return exceptions.AccessError{
+			Message: "This operation is not allowed in a read only transaction",
+		}
+	}
+	return err
+}
+
+// substituteErrorMessage substitutes the given error's message by newMsg
+func (d *postgresAdapter) substituteErrorMessage(err error, newMsg string) error {
+	switch e := err.(type) {
+	case *pq.Error:
+		e.Message = newMsg
+		return e
+	case exceptions.ConstraintError:
+		e.Message = newMsg
+		e.Err = d.substituteErrorMessage(e.Err, newMsg)
+		return e
+	}
+	return err
 }
 
 // isSerializationError returns true if the given error is a serialization error
@@ -0,0 +1,73 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/src/models/operator"
+)
+
+// ParseDomain returns a Condition on the given model from the given
+// Odoo-like domain, i.e. a list of [field, operator, value] triplets
+// and '&', '|' or '!' operators in prefix notation.
+//
+// Field names in the domain can be given as JSON or Go names, and can be
+// paths such as "user_id.profile_id.age". Successive terms that are not
+// joined by an operator are implicitly joined with AND.
+//
+// This is the inverse function of Condition.Serialize.
+// It panics if the domain is malformed.
+func ParseDomain(domain []interface{}, model *Model) *Condition {
+	res := newCondition()
+	for i := 0; i < len(domain); {
+		var cond *Condition
+		cond, i = parseDomainTerm(domain, i, model)
+		res = res.AndCond(cond)
+	}
+	return res
+}
+
+// parseDomainTerm parses the domain term starting at position i of
+// domain. It returns the Condition of this term and the position of
+// the next term.
+func parseDomainTerm(domain []interface{}, i int, model *Model) (*Condition, int) {
+	if i >= len(domain) {
+		log.Panic("Unexpected end of domain", "domain", domain)
+	}
+	switch term := domain[i].(type) {
+	case string:
+		switch term {
+		case "&", "|":
+			left, next := parseDomainTerm(domain, i+1, model)
+			right, next := parseDomainTerm(domain, next, model)
+			if term == "|" {
+				return left.OrCond(right), next
+			}
+			return left.AndCond(right), next
+		case "!":
+			cond, next := parseDomainTerm(domain, i+1, model)
+			return newCondition().AndNotCond(cond), next
+		}
+	case []interface{}:
+		return parseDomainLeaf(term, model), i + 1
+	}
+	log.Panic("Invalid term in domain", "term", domain[i], "domain", domain)
+	// Unreachable
+	return nil, 0
+}
+
+// parseDomainLeaf returns the Condition of the given [field, operator, value] triplet.
+func parseDomainLeaf(leaf []interface{}, model *Model) *Condition {
+	if len(leaf) != 3 {
+		log.Panic("Domain leaf must be of the form [field, operator, value]", "leaf", leaf)
+	}
+	field, ok := leaf[0].(string)
+	if !ok {
+		log.Panic("Domain leaf field must be a string", "leaf", leaf)
+	}
+	op := operator.Operator(leaf[1].(string))
+	if !op.IsValid() {
+		log.Panic("Unknown operator in domain leaf", "operator", op, "leaf", leaf)
+	}
+	return model.Field(model.FieldName(field)).AddOperator(op, leaf[2])
+}
@@ -15,10 +15,15 @@
 package models
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/logging"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
 )
 
 // DBSerializationMaxRetries defines the number of time a
@@ -31,11 +36,14 @@ const DBSerializationMaxRetries uint8 = 5
 const maxRecursionDepth uint8 = 100
 
 // An Environment stores various contextual data used by the models:
-// - the database cursor (current open transaction),
+// - the database and its cursor (current open transaction),
 // - the current user ID (for access rights checking)
 // - the current context (for storing arbitrary metadata).
-// The Environment also stores caches.
+// - the context.Context of the transaction (for cancellation).
+// The Environment also stores caches and the hooks of its transaction.
 type Environment struct {
+	ctx            context.Context
+	database       *Database
 	cr             *Cursor
 	uid            int64
 	context        *types.Context
@@ -45,6 +53,36 @@ type Environment struct {
 	previousMethod *Method
 	recursions     uint8
 	nextNegativeID int64
+	apiScope       *APIScope
+	hooks          *transactionHooks
+	// computeWrite is true when the framework writes computed values, in
+	// which case the access rights on fields are not checked. Contrary to
+	// context keys, it cannot be set by clients.
+	computeWrite bool
+}
+
+// An APIScope restricts what a client can do through RPC in an
+// Environment, for instance when it is authenticated by an API key.
+type APIScope struct {
+	// ReadOnly is true if the client cannot modify the database
+	ReadOnly bool
+	// Models are the names of the models whose methods can be called
+	// through RPC. If Models is empty, all models are allowed.
+	Models []string
+}
+
+// AllowsModel returns true if the methods of the model with
+// the given name can be called through RPC in this scope.
+func (as APIScope) AllowsModel(modelName string) bool {
+	if len(as.Models) == 0 {
+		return true
+	}
+	for _, m := range as.Models {
+		if m == modelName {
+			return true
+		}
+	}
+	return false
 }
 
 // Cr returns a pointer to the Cursor of the Environment
@@ -52,6 +90,16 @@ func (env Environment) Cr() *Cursor {
 	return env.cr
 }
 
+// Database returns the database of the Environment
+func (env Environment) Database() *Database {
+	return env.database
+}
+
+// ReadOnly returns true if the transaction of the Environment is read only
+func (env Environment) ReadOnly() bool {
+	return env.cr.readOnly
+}
+
 // Uid returns the user id of the Environment
 func (env Environment) Uid() int64 {
 	return env.uid
@@ -62,13 +110,143 @@ func (env Environment) Context() *types.Context {
 	return env.context
 }
 
+// Ctx returns the context.Context of the Environment.
+//
+// When it is done, the queries being executed are cancelled and
+// the transaction of the Environment is rolled back.
+func (env Environment) Ctx() context.Context {
+	return env.ctx
+}
+
+// environmentKey is the key of the Environment in a context.Context
+type environmentKey struct{}
+
+// ContextWithEnvironment returns a copy of the context.Context of env which
+// holds env, so that the functions it is given to, such as the membership
+// store of security.Registry, run their queries in the transaction of env.
+//
+// The returned context must not be used after the end of the transaction.
+func ContextWithEnvironment(env Environment) context.Context {
+	ctx := security.ContextWithDatabaseName(env.ctx, env.database.name)
+	return context.WithValue(ctx, environmentKey{}, env)
+}
+
+// EnvironmentFromContext returns the Environment held by
+// ctx and true, or false if ctx does not hold any.
+func EnvironmentFromContext(ctx context.Context) (Environment, bool) {
+	env, ok := ctx.Value(environmentKey{}).(Environment)
+	return env, ok
+}
+
+// APIScope returns the APIScope of the Environment,
+// or nil if the Environment is not restricted.
+func (env Environment) APIScope() *APIScope {
+	return env.apiScope
+}
+
+// WithAPIScope returns a copy of this Environment restricted to the given scope.
+// If scope is nil, the Environment is returned unchanged.
+//
+// If the scope is read only, the transaction of the Environment is set
+// read only, so that any write to the database fails.
+func (env Environment) WithAPIScope(scope *APIScope) Environment {
+	if scope == nil {
+		return env
+	}
+	if scope.ReadOnly {
+		env.cr.Execute(adapters[db.DriverName()].setTransactionReadOnly())
+	}
+	env.apiScope = scope
+	return env
+}
+
+// SetStatementTimeout sets the maximum duration of the SQL statements
+// executed in the transaction of this Environment. A statement that runs
+// longer is cancelled and makes the transaction fail. If timeout is zero,
+// statements are not limited.
+//
+// It overrides the default statement timeout of the database connection
+// parameters and applies until the end of the transaction.
+func (env Environment) SetStatementTimeout(timeout time.Duration) {
+	env.cr.Execute(adapters[db.DriverName()].setStatementTimeout(timeout))
+}
+
+// Savepoint executes fnct within a savepoint of the transaction of this Environment.
+//
+// If fnct panics, the changes made by fnct in the database and in the cache of the
+// Environment are rolled back and the recovered error is returned, so that the
+// transaction can go on. Otherwise, the changes are kept and nil is returned.
+// Savepoints can be nested.
+//
+// The OnCommit hooks registered by a failed fnct are discarded and its OnRollback
+// hooks are called after rolling back to the savepoint.
+//
+// Serialization errors and errors due to the cancellation of the context are not
+// recovered, since the whole transaction must be rolled back and retried.
+//
+//	for _, line := range lines {
+//	    if err := env.Savepoint(func(env models.Environment) {
+//	        h.SaleOrderLine().Create(env, line)
+//	    }); err != nil {
+//	        log.Warn("Skipping invalid line", "error", err)
+//	    }
+//	}
+func (env Environment) Savepoint(fnct func(Environment)) (rError error) {
+	name := env.cr.savepoint()
+	env.cache.startJournal()
+	hooksMark := env.hooks.mark()
+	defer func() {
+		r := recover()
+		if r == nil {
+			env.cache.releaseJournal()
+			env.cr.releaseSavepoint(name)
+			return
+		}
+		env.cache.rollbackJournal()
+		if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) || env.ctx.Err() != nil {
+			panic(r)
+		}
+		env.cr.rollbackToSavepoint(name)
+		env.hooks.rollbackTo(hooksMark)
+		rError = logging.LogPanicData(r)
+	}()
+	fnct(env)
+	return nil
+}
+
+// OnCommit registers fnct to be called after the transaction of this
+// Environment is committed. It is not called if the transaction is rolled back.
+//
+// Side effects that must only happen if the changes are persisted, such as
+// sending emails or calling webhooks, should be performed in OnCommit hooks.
+// If the transaction is retried after a serialization error, the hooks
+// registered during the failed attempt are discarded, so that they are
+// called only once, for the attempt that commits.
+//
+// Hooks are called in their registration order after the end of the
+// transaction, so that they must not use this Environment. A hook that
+// panics is logged and does not change the outcome of the transaction.
+func (env Environment) OnCommit(fnct func()) {
+	env.hooks.addOnCommit(fnct)
+}
+
+// OnRollback registers fnct to be called after the transaction of this
+// Environment is rolled back, or after the savepoint in which it is
+// registered is rolled back. It is not called if the transaction commits.
+//
+// As for OnCommit, the hooks registered during an attempt that is retried
+// after a serialization error are discarded without being called.
+func (env Environment) OnRollback(fnct func()) {
+	env.hooks.addOnRollback(fnct)
+}
+
 // commit the transaction of this environment.
 //
 // WARNING: Do NOT call Commit on Environment instances that you
 // did not create yourself with NewEnvironment. The framework will
 // automatically commit the Environment.
-func (env Environment) commit() {
-	env.Cr().tx.Commit()
+func (env Environment) commit() error {
+	return env.Cr().commit()
 }
 
 // rollback the transaction of this environment.
@@ -77,7 +255,7 @@ func (env Environment) commit() {
 // did not create yourself with NewEnvironment. Just panic instead
 // for the framework to roll back automatically for you.
 func (env Environment) rollback() {
-	env.Cr().tx.Rollback()
+	env.Cr().rollback()
 }
 
 // checkRecursion panics if the recursion depth limit is reached
@@ -115,17 +293,22 @@ func (env Environment) DumpCache() string {
 	return res
 }
 
-// newEnvironment returns a new Environment for the given user ID
+// newEnvironment returns a new Environment for the given user ID on the
+// given database bound to ctx. If readOnly is true, its transaction is read
+// only and may run on a read replica.
 //
 // WARNING: Callers to newEnvironment should ensure to either call commit()
 // or rollback() on the returned Environment after operation to release
 // the database connection.
-func newEnvironment(uid int64) Environment {
+func newEnvironment(ctx context.Context, database *Database, uid int64, readOnly bool) Environment {
 	env := Environment{
-		cr:      newCursor(db),
-		uid:     uid,
-		context: types.NewContext(),
-		cache:   newCache(),
+		ctx:      ctx,
+		database: database,
+		cr:       newCursor(ctx, database, readOnly),
+		uid:      uid,
+		context:  types.NewContext(),
+		cache:    newCache(),
+		hooks:    newTransactionHooks(),
 	}
 	return env
 }
@@ -138,28 +321,83 @@ func newEnvironment(uid int64) Environment {
 // errors are automatically retried several times before returning an
 // error if they still occur.
 func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) error {
-	return doExecuteInNewEnvironment(uid, 0, fnct)
+	return doExecuteInNewEnvironment(context.Background(), uid, 0, false, fnct)
 }
 
-func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment)) (rError error) {
-	env := newEnvironment(uid)
+// ExecuteInNewEnvironmentContext executes the given fnct in a new Environment
+// bound to ctx within a new transaction, as ExecuteInNewEnvironment.
+//
+// The Environment is bound to the database of ctx, if any, and to the default
+// database otherwise. ErrNoDatabase is returned if ctx holds a nil database.
+//
+// If ctx is done before the end of the transaction, the query being executed
+// is cancelled, the transaction is rolled back and an error is returned.
+func ExecuteInNewEnvironmentContext(ctx context.Context, uid int64, fnct func(Environment)) error {
+	return doExecuteInNewEnvironment(ctx, uid, 0, false, fnct)
+}
+
+// ExecuteInReadOnlyEnvironment executes the given fnct in a new Environment
+// within a new read only transaction, as ExecuteInNewEnvironment.
+//
+// The transaction runs on a read replica of the database if one is available,
+// that is if it is reachable and its replication lag is not greater than the
+// MaxReplicationLag of the connection parameters. It runs on the primary
+// database otherwise. Creating, writing or unlinking records in fnct fails
+// with an exceptions.AccessError.
+//
+// Since replicas may lag behind the primary, fnct may not see the
+// changes committed just before. It is best suited to heavy queries
+// such as aggregates or reports.
+func ExecuteInReadOnlyEnvironment(uid int64, fnct func(Environment)) error {
+	return doExecuteInNewEnvironment(context.Background(), uid, 0, true, fnct)
+}
+
+// ExecuteInReadOnlyEnvironmentContext executes the given fnct in a new Environment
+// bound to ctx within a new read only transaction, as ExecuteInReadOnlyEnvironment.
+func ExecuteInReadOnlyEnvironmentContext(ctx context.Context, uid int64, fnct func(Environment)) error {
+	return doExecuteInNewEnvironment(ctx, uid, 0, true, fnct)
+}
+
+func doExecuteInNewEnvironment(ctx context.Context, uid int64, retries uint8, readOnly bool, fnct func(Environment)) (rError error) {
+	database := DatabaseFromContext(ctx)
+	if database == nil {
+		return ErrNoDatabase
+	}
+	env := newEnvironment(ctx, database, uid, readOnly)
 	defer func() {
 		if r := recover(); r != nil {
 			env.rollback()
-			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) && ctx.Err() == nil {
 				// Transaction error
 				retries++
 				if retries < DBSerializationMaxRetries {
-					if doExecuteInNewEnvironment(uid, retries, fnct) == nil {
+					// The hooks are registered again by the retry,
+					// which calls them depending on its own outcome.
+					env.hooks.discard()
+					metrics.SerializationRetries.Inc()
+					if doExecuteInNewEnvironment(ctx, uid, retries, readOnly, fnct) == nil {
 						rError = nil
 						return
 					}
 				}
+				r = exceptions.ConcurrencyError{
+					Message: "The operation could not be completed because of concurrent updates, please try again",
+					Err:     err,
+				}
 			}
+			env.hooks.rolledBack()
 			rError = logging.LogPanicData(r)
 			return
 		}
-		env.commit()
+		if err := env.commit(); err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			env.hooks.rolledBack()
+			rError = logging.LogPanicData(err)
+			return
+		}
+		env.hooks.committed()
 	}()
 	fnct(env)
 	return nil
@@ -171,28 +409,46 @@ func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment))
 // This function always rolls back the transaction but returns an error
 // only if fnct panicked during its execution.
 func SimulateInNewEnvironment(uid int64, fnct func(Environment)) error {
-	return doSimulateInNewEnvironment(uid, 0, fnct)
+	return doSimulateInNewEnvironment(context.Background(), uid, 0, fnct)
 }
 
-func doSimulateInNewEnvironment(uid int64, retries uint8, fnct func(Environment)) (rError error) {
-	env := newEnvironment(uid)
+// SimulateInNewEnvironmentContext executes the given fnct in a new Environment
+// bound to ctx within a new transaction, as SimulateInNewEnvironment.
+func SimulateInNewEnvironmentContext(ctx context.Context, uid int64, fnct func(Environment)) error {
+	return doSimulateInNewEnvironment(ctx, uid, 0, fnct)
+}
+
+func doSimulateInNewEnvironment(ctx context.Context, uid int64, retries uint8, fnct func(Environment)) (rError error) {
+	database := DatabaseFromContext(ctx)
+	if database == nil {
+		return ErrNoDatabase
+	}
+	env := newEnvironment(ctx, database, uid, false)
 	defer func() {
 		env.rollback()
 		if r := recover(); r != nil {
-			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) && ctx.Err() == nil {
 				// Transaction error. We try again even if we rollback anyway
 				// to be as close as ExecuteInNewEnvironment as possible
 				retries++
 				if retries < DBSerializationMaxRetries {
-					if doSimulateInNewEnvironment(uid, retries, fnct) == nil {
+					env.hooks.discard()
+					metrics.SerializationRetries.Inc()
+					if doSimulateInNewEnvironment(ctx, uid, retries, fnct) == nil {
 						rError = nil
 						return
 					}
 				}
+				r = exceptions.ConcurrencyError{
+					Message: "The operation could not be completed because of concurrent updates, please try again",
+					Err:     err,
+				}
 			}
+			env.hooks.rolledBack()
 			rError = logging.LogPanicData(r)
 			return
 		}
+		env.hooks.rolledBack()
 	}()
 	fnct(env)
 	return
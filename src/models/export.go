@@ -0,0 +1,179 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+	"github.com/hexya-erp/hexya/src/tools/xlsxutils"
+)
+
+// An ExportFormat is a file format in which records can be exported
+type ExportFormat string
+
+// Available export formats
+const (
+	// CSVFormat exports records in the CSV format read by LoadCSVDataFile
+	CSVFormat ExportFormat = "csv"
+	// XLSXFormat exports records as an Excel spreadsheet
+	XLSXFormat ExportFormat = "xlsx"
+)
+
+// exportExcludedFields are the fields that are not exported by default
+var exportExcludedFields = map[string]bool{
+	"ID":              true,
+	"HexyaExternalID": true,
+	"HexyaVersion":    true,
+	"CreateDate":      true,
+	"CreateUID":       true,
+	"WriteDate":       true,
+	"WriteUID":        true,
+}
+
+// Export returns the records of this RecordCollection in the given format.
+//
+// The first column is always the records external IDs with the "ID" header,
+// followed by one column per given field. Fields can be paths such as
+// "Partner.Country.Name", in which case the value at the end of the path is
+// exported. Relation fields are exported as the external IDs of the related
+// records, separated by '|' for many2many and one2many fields.
+//
+// If no fields are given, all stored fields that can be imported back are
+// exported, except binary fields.
+//
+// The CSV output can be loaded back with LoadCSVDataFile. Binary fields cannot
+// be exported in CSV since LoadCSVDataFile expects a file name for them.
+func (rc *RecordCollection) Export(fields []FieldName, format ExportFormat) []byte {
+	if len(fields) == 0 {
+		fields = rc.defaultExportFields()
+	}
+	rows := rc.exportRows(fields)
+	var buf bytes.Buffer
+	switch format {
+	case CSVFormat:
+		for _, f := range fields {
+			if rc.model.getRelatedFieldInfo(f).fieldType == fieldtype.Binary {
+				log.Panic("Binary fields cannot be exported to CSV", "model", rc.model.name, "field", f.Name())
+			}
+		}
+		w := csv.NewWriter(&buf)
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for i, val := range row {
+				record[i] = exportValueToString(val)
+			}
+			if err := w.Write(record); err != nil {
+				log.Panic("Unable to write CSV export", "model", rc.model.name, "error", err)
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Panic("Unable to write CSV export", "model", rc.model.name, "error", err)
+		}
+	case XLSXFormat:
+		if err := xlsxutils.WriteSheet(&buf, rc.model.name, rows); err != nil {
+			log.Panic("Unable to write XLSX export", "model", rc.model.name, "error", err)
+		}
+	default:
+		log.Panic("Unknown export format", "format", format)
+	}
+	return buf.Bytes()
+}
+
+// defaultExportFields returns the fields to export when none are given,
+// sorted by name.
+func (rc *RecordCollection) defaultExportFields() []FieldName {
+	var fNames []string
+	for fName, fi := range rc.model.fields.registryByName {
+		switch {
+		case exportExcludedFields[fName]:
+			continue
+		case fi.isComputedField(), fi.isRelatedField():
+			continue
+		case fi.fieldType == fieldtype.Binary:
+			continue
+		case fi.isStored(), fi.fieldType == fieldtype.Many2Many:
+			fNames = append(fNames, fName)
+		}
+	}
+	sort.Strings(fNames)
+	res := make([]FieldName, len(fNames))
+	for i, fName := range fNames {
+		res[i] = rc.model.FieldName(fName)
+	}
+	return res
+}
+
+// exportRows returns the headers and values of the given fields for each
+// record of this RecordCollection.
+//
+// Values are either string, bool, int64 or float64.
+func (rc *RecordCollection) exportRows(fields []FieldName) [][]interface{} {
+	externalIDField := rc.model.FieldName("HexyaExternalID")
+	headers := []interface{}{"ID"}
+	for _, f := range fields {
+		headers = append(headers, f.Name())
+	}
+	res := [][]interface{}{headers}
+	for _, rec := range rc.Records() {
+		row := []interface{}{rec.Get(externalIDField)}
+		for _, f := range fields {
+			row = append(row, exportValue(rec.model.getRelatedFieldInfo(f), rec.Get(f)))
+		}
+		res = append(res, row)
+	}
+	return res
+}
+
+// exportValue converts the given value of the given field for export.
+func exportValue(fi *Field, value interface{}) interface{} {
+	switch {
+	case fi.fieldType.IsRelationType():
+		relRC := value.(RecordSet).Collection()
+		if relRC.IsEmpty() {
+			return ""
+		}
+		extIDField := relRC.model.FieldName("HexyaExternalID")
+		var extIDs []string
+		for _, rec := range relRC.Records() {
+			extIDs = append(extIDs, rec.Get(extIDField).(string))
+		}
+		return strings.Join(extIDs, "|")
+	case fi.fieldType == fieldtype.Integer:
+		res, _ := nbutils.CastToInteger(value)
+		return res
+	case fi.fieldType == fieldtype.Float:
+		res, _ := nbutils.CastToFloat(value)
+		return res
+	case fi.fieldType == fieldtype.Boolean:
+		return reflect.ValueOf(value).Bool()
+	}
+	if z, ok := value.(interface{ IsZero() bool }); ok && z.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// exportValueToString returns the string representation of the given
+// exported value, as read by LoadCSVDataFile.
+func exportValueToString(value interface{}) string {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
@@ -239,6 +240,7 @@ type Field struct {
 	inverse          string
 	filter           *Condition
 	contexts         FieldContexts
+	groups           []*security.Group
 	ctxType          ctxType
 	updates          []map[string]interface{}
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
@@ -52,6 +53,7 @@ type Binary struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a binary field for the given models.FieldsCollection with the given name.
@@ -86,6 +88,7 @@ type Boolean struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a boolean field for the given models.FieldsCollection with the given name.
@@ -126,6 +129,7 @@ type Char struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a char field for the given models.FieldsCollection with the given name.
@@ -163,6 +167,7 @@ type Date struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a date field for the given models.FieldsCollection with the given name.
@@ -200,6 +205,7 @@ type DateTime struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a datetime field for the given models.FieldsCollection with the given name.
@@ -236,6 +242,7 @@ type Float struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField adds this datetime field for the given models.FieldsCollection with the given name.
@@ -278,6 +285,7 @@ type HTML struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a html field for the given models.FieldsCollection with the given name.
@@ -313,6 +321,7 @@ type Integer struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a datetime field for the given models.FieldsCollection with the given name.
@@ -354,6 +363,7 @@ type Many2Many struct {
 	Filter           models.Conditioner
 	Inverse          models.Methoder
 	Default          func(models.Environment) interface{}
+	Groups           []*security.Group
 }
 
 // DeclareField creates a many2many field for the given models.FieldsCollection with the given name.
@@ -420,6 +430,7 @@ type Many2One struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a many2one field for the given models.FieldsCollection with the given name.
@@ -474,6 +485,7 @@ type One2Many struct {
 	Filter          models.Conditioner
 	Inverse         models.Methoder
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a one2many field for the given models.FieldsCollection with the given name.
@@ -520,6 +532,7 @@ type One2One struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a one2one field for the given models.FieldsCollection with the given name.
@@ -575,6 +588,7 @@ type Rev2One struct {
 	Filter          models.Conditioner
 	Inverse         models.Methoder
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a rev2one field for the given models.FieldsCollection with the given name.
@@ -619,6 +633,7 @@ type Selection struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a selection field for the given models.FieldsCollection with the given name.
@@ -659,6 +674,7 @@ type Text struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	Groups          []*security.Group
 }
 
 // DeclareField creates a text field for the given models.FieldsCollection with the given name.
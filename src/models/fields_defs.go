@@ -7,6 +7,7 @@ import (
 	"reflect"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
@@ -82,6 +83,10 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 	if noc := val.FieldByName("NoCopy"); noc.IsValid() {
 		noCopy = noc.Bool()
 	}
+	var groups []*security.Group
+	if grps := val.FieldByName("Groups"); grps.IsValid() {
+		groups = grps.Interface().([]*security.Group)
+	}
 	fInfo := &Field{
 		model:           fc.model,
 		name:            name,
@@ -109,6 +114,7 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 		onChangeFilters: onchangeFilters,
 		constraint:      constraint,
 		contexts:        contexts,
+		groups:          groups,
 	}
 	return fInfo
 }
@@ -250,6 +256,8 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		}
 	case "contexts":
 		f.contexts = value.(FieldContexts)
+	case "groups":
+		f.groups = value.([]*security.Group)
 	default:
 		log.Panic("Unknown property", "property", property, "value", value)
 	}
@@ -399,6 +407,18 @@ func (f *Field) AddContexts(value FieldContexts) *Field {
 	return f
 }
 
+// SetGroups overrides the value of the Groups parameter of this Field
+func (f *Field) SetGroups(value ...*security.Group) *Field {
+	f.addUpdate("groups", value)
+	return f
+}
+
+// AddGroups adds the given groups to the Groups parameter of this Field
+func (f *Field) AddGroups(value ...*security.Group) *Field {
+	f.addUpdate("groups_add", value)
+	return f
+}
+
 // SetDefault overrides the value of the Default parameter of this Field
 func (f *Field) SetDefault(value func(Environment) interface{}) *Field {
 	f.addUpdate("defaultFunc", value)
@@ -0,0 +1,317 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+const (
+	// importExternalIDSuffix is the suffix of a CSV header for a relation
+	// field whose values are given as external IDs.
+	importExternalIDSuffix = "/id"
+	// importSavepoint is the name of the savepoint of a whole import
+	importSavepoint = "hexya_import"
+)
+
+// An ImportError is an error that occurred on a line of an imported file.
+type ImportError struct {
+	// Line is the line number in the imported file, starting at 1 with the headers line
+	Line int
+	// Field is the header of the column in error, if the error is about a single value
+	Field string
+	// Value is the value in error, if the error is about a single value
+	Value string
+	// Message describes the error
+	Message string
+}
+
+// Error returns the description of this ImportError
+func (ie ImportError) Error() string {
+	if ie.Field == "" {
+		return fmt.Sprintf("line %d: %s", ie.Line, ie.Message)
+	}
+	return fmt.Sprintf("line %d, field %s, value '%s': %s", ie.Line, ie.Field, ie.Value, ie.Message)
+}
+
+// An ImportReport is the result of an import.
+type ImportReport struct {
+	// DryRun is true if the import was only simulated
+	DryRun bool
+	// Created is the number of records that have been created, or
+	// that would have been if there were no errors.
+	Created int
+	// Updated is the number of records that have been updated, or
+	// that would have been if there were no errors.
+	Updated int
+	// Errors holds all the errors encountered during the import.
+	Errors []ImportError
+}
+
+// HasErrors returns true if errors occurred during the import.
+// In this case, no record has been imported at all.
+func (ir *ImportReport) HasErrors() bool {
+	return len(ir.Errors) > 0
+}
+
+// addError adds an ImportError to this report
+func (ir *ImportReport) addError(line int, field, value, msg string) {
+	ir.Errors = append(ir.Errors, ImportError{
+		Line:    line,
+		Field:   field,
+		Value:   value,
+		Message: msg,
+	})
+}
+
+// An importColumn is a parsed header of an imported file
+type importColumn struct {
+	header     string
+	field      *Field
+	externalID bool
+}
+
+// ImportCSVData imports the CSV data read from r into the records of the given model
+// as user uid, in a new transaction.
+//
+// If dryRun is true, the import is made inside SimulateInNewEnvironment, so that the
+// returned report tells what would happen without modifying the database.
+//
+// The returned error is only set if the import could not be carried out at all.
+// Errors in the data are returned in the ImportReport. See RecordCollection.ImportCSV
+// for the expected file format.
+func ImportCSVData(uid int64, modelName string, r io.Reader, dryRun bool) (*ImportReport, error) {
+	execute := ExecuteInNewEnvironment
+	if dryRun {
+		execute = SimulateInNewEnvironment
+	}
+	var report *ImportReport
+	err := execute(uid, func(env Environment) {
+		report = env.Pool(modelName).ImportCSV(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun
+	return report, nil
+}
+
+// ImportCSV imports the CSV data read from r into records of this RecordCollection's model.
+//
+// The first line of the CSV data holds the headers which are:
+//
+// - "ID" for the column of the records external IDs. Records with an existing
+// external ID are updated, the others are created.
+//
+// - A field name, either as its real name or its JSON name. Values of relation
+// fields are matched with the names of the related records.
+//
+// - A relation field name followed by "/id", in which case its values are
+// the external IDs of the related records.
+//
+// Many2many values are given as a '|' separated list. Binary values are given
+// as base64 encoded strings.
+//
+// The import is transactional: all lines are validated and written, but if any
+// error occurs, nothing is imported and all errors are listed in the returned
+// report. It panics only if the data cannot be read.
+func (rc *RecordCollection) ImportCSV(r io.Reader) *ImportReport {
+	report := new(ImportReport)
+	reader := csv.NewReader(r)
+	headers, err := reader.Read()
+	if err != nil {
+		log.Panic("Unable to read CSV headers", "model", rc.model.name, "error", err)
+	}
+	columns := rc.parseImportHeaders(headers, report)
+	if report.HasErrors() {
+		return report
+	}
+	rc.env.cr.Execute(fmt.Sprintf("SAVEPOINT %s", importSavepoint))
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.addError(line, "", "", err.Error())
+			if _, ok := err.(*csv.ParseError); ok && record != nil {
+				continue
+			}
+			break
+		}
+		rc.importLine(columns, record, line, report)
+	}
+	if report.HasErrors() {
+		rc.env.cr.Execute(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", importSavepoint))
+		rc.env.cache.clear()
+		return report
+	}
+	rc.env.cr.Execute(fmt.Sprintf("RELEASE SAVEPOINT %s", importSavepoint))
+	return report
+}
+
+// parseImportHeaders returns the importColumn of each given header.
+// Errors are added to the given report.
+func (rc *RecordCollection) parseImportHeaders(headers []string, report *ImportReport) []importColumn {
+	columns := make([]importColumn, len(headers))
+	for i, header := range headers {
+		columns[i].header = header
+		if strings.ToLower(header) == "id" {
+			continue
+		}
+		fName := strings.TrimSuffix(header, importExternalIDSuffix)
+		columns[i].externalID = fName != header
+		fi, ok := rc.model.fields.Get(fName)
+		switch {
+		case !ok:
+			report.addError(1, header, "", "Unknown field")
+			continue
+		case fi.fieldType == fieldtype.One2Many, fi.fieldType == fieldtype.Rev2One:
+			report.addError(1, header, "", "One2many fields cannot be imported")
+		case fi.isComputedField() && fi.inverse == "":
+			report.addError(1, header, "", "Computed fields cannot be imported")
+		case columns[i].externalID && !fi.fieldType.IsRelationType():
+			report.addError(1, header, "", "Only relation fields can be given as external IDs")
+		}
+		columns[i].field = fi
+	}
+	return columns
+}
+
+// importLine imports the given record at the given line, adding errors
+// to the given report. Changes made by a line in error are rolled back.
+func (rc *RecordCollection) importLine(columns []importColumn, record []string, line int, report *ImportReport) {
+	values := make(FieldMap)
+	var externalID string
+	var convertErr bool
+	for i, col := range columns {
+		if col.field == nil {
+			externalID = record[i]
+			continue
+		}
+		val, err := rc.convertImportValue(col, record[i])
+		if err != nil {
+			report.addError(line, col.header, record[i], err.Error())
+			convertErr = true
+			continue
+		}
+		values[col.field.json] = val
+	}
+	if convertErr {
+		return
+	}
+	err := rc.env.Savepoint(func(Environment) {
+		var rec *RecordCollection
+		if externalID != "" {
+			// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+			rec = rc.Search(rc.model.Field(rc.model.FieldName("HexyaExternalID")).Equals(externalID)).Limit(1)
+		}
+		if rec != nil && rec.Len() == 1 {
+			rec.Call("Write", NewModelData(rc.model, values))
+			report.Updated++
+			return
+		}
+		if externalID != "" {
+			values["hexya_external_id"] = externalID
+		}
+		vals := NewModelData(rc.model, values)
+		rc.applyDefaults(vals, true)
+		rc.Call("Create", vals)
+		report.Created++
+	})
+	if err != nil {
+		report.addError(line, "", "", importErrorMessage(rc.substituteSQLErrorMessage(err)))
+	}
+}
+
+// convertImportValue converts the given string value of the given column
+// to a value that can be written to the database.
+func (rc *RecordCollection) convertImportValue(col importColumn, value string) (interface{}, error) {
+	fi := col.field
+	switch fi.fieldType {
+	case fieldtype.Integer:
+		if value == "" {
+			return int64(0), nil
+		}
+		return strconv.ParseInt(value, 0, 64)
+	case fieldtype.Float:
+		if value == "" {
+			return float64(0), nil
+		}
+		return strconv.ParseFloat(value, 64)
+	case fieldtype.Boolean:
+		if value == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(value)
+	case fieldtype.Date:
+		if value == "" {
+			return dates.Date{}, nil
+		}
+		return dates.ParseDateWithLayout(dates.DefaultServerDateFormat, value)
+	case fieldtype.DateTime:
+		if value == "" {
+			return dates.DateTime{}, nil
+		}
+		return dates.ParseDateTimeWithLayout(dates.DefaultServerDateTimeFormat, value)
+	case fieldtype.Selection:
+		if _, ok := fi.selection[value]; value != "" && len(fi.selection) > 0 && !ok {
+			return nil, fmt.Errorf("value is not one of the selection keys")
+		}
+		return value, nil
+	case fieldtype.Many2One, fieldtype.One2One:
+		if value == "" {
+			return rc.env.Pool(fi.relatedModelName), nil
+		}
+		return rc.findImportRelatedRecords(col, []string{value})
+	case fieldtype.Many2Many:
+		if value == "" {
+			return rc.env.Pool(fi.relatedModelName), nil
+		}
+		return rc.findImportRelatedRecords(col, strings.Split(value, "|"))
+	}
+	return value, nil
+}
+
+// findImportRelatedRecords returns the records of the related model of the given
+// column that match the given keys, either by external ID or by name.
+func (rc *RecordCollection) findImportRelatedRecords(col importColumn, keys []string) (*RecordCollection, error) {
+	relModel := col.field.relatedModel
+	res := rc.env.Pool(relModel.name)
+	for _, key := range keys {
+		var relRC *RecordCollection
+		if col.externalID {
+			relRC = rc.env.Pool(relModel.name).Search(relModel.Field(relModel.FieldName("HexyaExternalID")).Equals(key))
+		} else {
+			relRC = rc.env.Pool(relModel.name).Call("SearchByName", key, operator.Equals, newCondition(), 0).(RecordSet).Collection()
+		}
+		switch relRC.Len() {
+		case 0:
+			return nil, fmt.Errorf("no related record found for '%s'", key)
+		case 1:
+			res = res.Union(relRC)
+		default:
+			return nil, fmt.Errorf("several related records found for '%s'", key)
+		}
+	}
+	return res, nil
+}
+
+// importErrorMessage returns the message to put in an import report
+// from the given recovered panic data.
+func importErrorMessage(r interface{}) string {
+	if err, ok := r.(error); ok {
+		return strings.TrimSpace(exceptions.Message(err))
+	}
+	return strings.TrimSpace(fmt.Sprint(r))
+}
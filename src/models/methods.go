@@ -16,6 +16,9 @@ package models
 
 import (
 	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/hexya-erp/hexya/src/models/security"
@@ -103,6 +106,18 @@ func (mc *MethodsCollection) AllowAllToGroup(group *security.Group) {
 	}
 }
 
+// AllMethods returns all the methods of this collection sorted by name
+func (mc *MethodsCollection) AllMethods() []*Method {
+	res := make([]*Method, 0, len(mc.registry))
+	for _, meth := range mc.registry {
+		res = append(res, meth)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
 // RevokeAllFromGroup revokes permissions on all CRUD methods given by AllowAllToGroup
 func (mc *MethodsCollection) RevokeAllFromGroup(group *security.Group) {
 	for mName := range unauthorizedMethods {
@@ -139,6 +154,7 @@ type Method struct {
 	nextLayer     map[*methodLayer]*methodLayer
 	groups        map[*security.Group]bool
 	groupsCallers map[callerGroup]bool
+	private       bool
 }
 
 // MethodType returns the methodType of a Method
@@ -146,13 +162,14 @@ func (m *Method) MethodType() reflect.Type {
 	return m.methodType
 }
 
-// addMethodLayer adds the given layer to this Method.
-func (m *Method) addMethodLayer(val reflect.Value) {
+// addMethodLayer adds the given layer defined in the given module to this Method.
+func (m *Method) addMethodLayer(val reflect.Value, module string) {
 	m.Lock()
 	defer m.Unlock()
 	ml := methodLayer{
 		funcValue: wrapFunctionForMethodLayer(val),
 		method:    m,
+		module:    module,
 	}
 	if m.topLayer != nil {
 		m.nextLayer[&ml] = m.topLayer
@@ -205,6 +222,49 @@ func (m *Method) RevokeGroup(group *security.Group) *Method {
 	return m
 }
 
+// Private marks this method as private, so that it cannot be called
+// through RPC. Private methods can still be called from Go code, subject
+// to the execution permissions of the method.
+func (m *Method) Private() *Method {
+	m.Lock()
+	defer m.Unlock()
+	m.private = true
+	return m
+}
+
+// Public marks this method as public, so that it can be called through RPC
+// by the users having the execution permission. This is the default.
+func (m *Method) Public() *Method {
+	m.Lock()
+	defer m.Unlock()
+	m.private = false
+	return m
+}
+
+// IsPublic returns true if this method can be called through RPC.
+func (m *Method) IsPublic() bool {
+	m.RLock()
+	defer m.RUnlock()
+	return !m.private
+}
+
+// AllowedGroups returns the groups that have been granted the execution
+// permission on this method whatever the caller, sorted by ID.
+//
+// These are the groups whose members can call this method through RPC.
+func (m *Method) AllowedGroups() []*security.Group {
+	m.RLock()
+	defer m.RUnlock()
+	res := make([]*security.Group, 0, len(m.groups))
+	for group := range m.groups {
+		res = append(res, group)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID() < res[j].ID()
+	})
+	return res
+}
+
 // Underlying returns the underlysing method data object
 func (m *Method) Underlying() *Method {
 	return m
@@ -229,6 +289,22 @@ type methodLayer struct {
 	method    *Method
 	mixedIn   bool
 	funcValue reflect.Value
+	module    string
+}
+
+// funcModule returns the path of the package in which the given
+// function is defined, that is the module defining a method layer.
+func funcModule(fnctVal reflect.Value) string {
+	fnct := runtime.FuncForPC(fnctVal.Pointer())
+	if fnct == nil {
+		return ""
+	}
+	name := fnct.Name()
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
 }
 
 // copyMethod creates a new method without any method layer for
@@ -241,6 +317,7 @@ func copyMethod(m *Model, method *Method) *Method {
 		nextLayer:     make(map[*methodLayer]*methodLayer),
 		groups:        make(map[*security.Group]bool),
 		groupsCallers: make(map[callerGroup]bool),
+		private:       method.private,
 	}
 }
 
@@ -400,7 +477,7 @@ func (m *Method) finalize(fnct interface{}) *Method {
 	}
 	m.checkMethodAndFnctType(fnct)
 	val := reflect.ValueOf(fnct)
-	m.addMethodLayer(val)
+	m.addMethodLayer(val, funcModule(val))
 	m.methodType = val.Type()
 	return m
 }
@@ -416,7 +493,7 @@ func (m *Method) Extend(fnct interface{}) *Method {
 		m.checkSignaturesMatch(val)
 	}
 	m.methodType = val.Type()
-	m.addMethodLayer(val)
+	m.addMethodLayer(val, funcModule(val))
 	return m
 }
 
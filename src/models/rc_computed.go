@@ -135,7 +135,7 @@ func (rc *RecordCollection) applyMethod(methodName string) {
 			}
 		}
 		if doUpdate {
-			rec.WithContext("hexya_force_compute_write", true).Call("Write", data)
+			rec.WithContext("hexya_force_compute_write", true).withComputeWrite().Call("Write", data)
 		}
 	}
 }
@@ -55,3 +55,11 @@ func (rc *RecordCollection) Sudo(userId ...int64) *RecordCollection {
 	newEnv.uid = uid
 	return rc.WithEnv(newEnv)
 }
+
+// withComputeWrite returns a copy of the current RecordCollection in which
+// computed values can be written whatever the access rights on fields.
+func (rc *RecordCollection) withComputeWrite() *RecordCollection {
+	newEnv := *rc.env
+	newEnv.computeWrite = true
+	return rc.WithEnv(newEnv)
+}
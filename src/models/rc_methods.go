@@ -20,7 +20,9 @@ import (
 	"time"
 
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/hexya/src/tools/tracing"
 )
 
 // Call calls the given method name methName on the given RecordCollection
@@ -63,7 +65,20 @@ func (rc *RecordCollection) CallMulti(methName string, args ...interface{}) []in
 	if rc.env.currentLayer != nil && rc.env.currentLayer.method != methInfo {
 		rSet.env.previousMethod = rc.env.currentLayer.method
 	}
+	var completed bool
+	if span := rc.startLayerSpan(methName, methLayer); span != nil {
+		parentSpan := rc.env.cr.span
+		rc.env.cr.span = span
+		defer func() {
+			if !completed {
+				span.SetError("method panicked")
+			}
+			rc.env.cr.span = parentSpan
+			span.End()
+		}()
+	}
 	res := rSet.callMulti(methLayer, args...)
+	completed = true
 	for i, r := range res {
 		switch r.(type) {
 		case RecordSet:
@@ -79,6 +94,21 @@ func (rc *RecordCollection) CallMulti(methName string, args ...interface{}) []in
 	return res
 }
 
+// startLayerSpan starts the tracing span of the call of the given method layer
+// on rc. It returns nil if tracing is disabled.
+func (rc *RecordCollection) startLayerSpan(methName string, methLayer *methodLayer) *tracing.Span {
+	if !tracing.Enabled() || rc.env.cr == nil {
+		return nil
+	}
+	span := tracing.StartSpan(fmt.Sprintf("%s.%s", rc.model.name, methName), tracing.KindInternal, rc.env.cr.span)
+	span.SetAttribute("hexya.model", rc.model.name)
+	span.SetAttribute("hexya.method", methName)
+	span.SetAttribute("hexya.module", methLayer.module)
+	span.SetAttribute("hexya.uid", rc.env.uid)
+	span.SetAttribute("hexya.records", len(rc.ids))
+	return span
+}
+
 // Super returns a RecordSet with a modified callstack so that call to the current
 // method will execute the next method layer.
 //
@@ -131,6 +161,48 @@ func (rc *RecordCollection) callMulti(methLayer *methodLayer, args ...interface{
 	return res
 }
 
+// CallRPC calls the given method name methName on the given RecordCollection
+// with the given arguments and returns (only) the first result as interface{}.
+//
+// Contrary to Call, CallRPC panics if the method is private. It must be used
+// by RPC endpoints which call model methods on behalf of a client.
+func (rc *RecordCollection) CallRPC(methName string, args ...interface{}) interface{} {
+	rc.CheckRPCAccess(methName)
+	return rc.Call(methName, args...)
+}
+
+// CheckRPCAccess panics if the method with the given name cannot be
+// called through RPC because it does not exist, is private, or
+// belongs to a model that is not allowed by the APIScope of the
+// Environment.
+//
+// If dontPanic is true, this function returns false instead of panicking.
+// Execution permissions are not checked by this function but when the
+// method is called.
+func (rc *RecordCollection) CheckRPCAccess(methName string, dontPanic ...bool) bool {
+	methInfo, ok := rc.model.methods.Get(methName)
+	scopeOK := rc.env.apiScope == nil || rc.env.apiScope.AllowsModel(rc.model.name)
+	if ok && methInfo.IsPublic() && scopeOK {
+		return true
+	}
+	if len(dontPanic) > 0 && dontPanic[0] {
+		return false
+	}
+	if !ok {
+		log.Panic("Unknown method in model", "model", rc.model.name, "method", methName)
+	}
+	if !scopeOK {
+		panicWithError(exceptions.AccessError{
+			Message: rc.T("Model %s cannot be accessed with this API scope", rc.model.name),
+		}, "model", rc.model.name, "method", methName, "uid", rc.env.uid)
+	}
+	panicWithError(exceptions.AccessError{
+		Message: rc.T("Private method %s.%s() cannot be called through RPC", rc.model.name, methName),
+	}, "model", rc.model.name, "method", methName, "uid", rc.env.uid)
+	// Unreachable
+	return false
+}
+
 // CheckExecutionPermission panics if the current user is not allowed to
 // execute the given method.
 //
@@ -146,7 +218,7 @@ func (rc *RecordCollection) CheckExecutionPermission(method *Method, dontPanic .
 		// We are calling Super on the same method, so it's ok
 		return true
 	}
-	userGroups := security.Registry.UserGroups(rc.env.uid)
+	userGroups := security.Registry.UserGroupsContext(ContextWithEnvironment(*rc.env), rc.env.uid)
 	for group := range userGroups {
 		if method.groups[group] {
 			return true
@@ -165,9 +237,10 @@ func (rc *RecordCollection) CheckExecutionPermission(method *Method, dontPanic .
 	if caller != nil {
 		methodCaller = fmt.Sprintf("%s.%s()", caller.model.name, caller.name)
 	}
-	log.Panic("You are not allowed to execute this method", "model", rc.ModelName(),
-		"method", fmt.Sprintf("%s.%s()", method.model.name, method.name), "uid", rc.env.uid,
-		"methodCaller", methodCaller)
+	methodName := fmt.Sprintf("%s.%s()", method.model.name, method.name)
+	panicWithError(exceptions.AccessError{
+		Message: rc.T("You are not allowed to execute method %s", methodName),
+	}, "model", rc.ModelName(), "method", methodName, "uid", rc.env.uid, "methodCaller", methodCaller)
 	// Unreachable
 	return false
 }
@@ -18,6 +18,7 @@ import (
 	"fmt"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 )
 
@@ -161,7 +162,9 @@ func (rc *RecordCollection) createRelatedRecord(path FieldName, vals RecordData)
 		if len(exprs) > 1 {
 			target = rc.Get(joinFieldNames(exprs[:len(exprs)-1], ExprSep)).(RecordSet).Collection()
 			if target.IsEmpty() {
-				log.Panic("Target record does not exist", "recordset", rc, "path", joinFieldNames(exprs[:len(exprs)-1], ExprSep))
+				panicWithError(exceptions.MissingError{
+					Message: rc.T("Target record of %s does not exist", joinFieldNames(exprs[:len(exprs)-1], ExprSep)),
+				}, "recordset", rc, "path", joinFieldNames(exprs[:len(exprs)-1], ExprSep))
 			}
 			target = target.Records()[0]
 		}
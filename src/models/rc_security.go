@@ -14,7 +14,10 @@
 
 package models
 
-import "github.com/hexya-erp/hexya/src/models/security"
+import (
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
 
 // addRecordRuleConditions adds the RecordRule conditions on the query of this
 // RecordSet for the user with the given uid and for the given perm Permission.
@@ -30,7 +33,7 @@ func (rc *RecordCollection) addRecordRuleConditions(uid int64, perm security.Per
 		}
 	}
 	// Add groups rules
-	userGroups := security.Registry.UserGroups(uid)
+	userGroups := security.Registry.UserGroupsContext(ContextWithEnvironment(*rc.env), uid)
 	groupCondition := newCondition()
 	for group := range userGroups {
 		for _, rule := range rSet.model.rulesRegistry.rulesByGroup[group.ID()] {
@@ -46,3 +49,82 @@ func (rc *RecordCollection) addRecordRuleConditions(uid int64, perm security.Per
 	*rc = *rSet
 	return rc
 }
+
+// isAccessibleBy returns true if the user with the given uid can
+// read and write this field, i.e. if this field is not restricted
+// to groups or if the user belongs to one of them.
+//
+// Members of the admin group can access all fields.
+func (f *Field) isAccessibleBy(uid int64) bool {
+	if len(f.groups) == 0 || uid == security.SuperUserID {
+		return true
+	}
+	userGroups := security.Registry.UserGroups(uid)
+	if _, ok := userGroups[security.GroupAdmin]; ok {
+		return true
+	}
+	for _, group := range f.groups {
+		if _, ok := userGroups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldAccessibleBy returns true if the user with the given uid can
+// read and write the given field of this model.
+//
+// If field is a path, all the fields of the path must be accessible.
+// Related fields are accessible only if their target is accessible too.
+func (m *Model) FieldAccessibleBy(field FieldName, uid int64) bool {
+	exprs := splitFieldNames(field, ExprSep)
+	fi := m.fields.MustGet(exprs[0].JSON())
+	if !fi.isAccessibleBy(uid) {
+		return false
+	}
+	if fi.isRelatedField() && !m.FieldAccessibleBy(fi.relatedPath, uid) {
+		return false
+	}
+	if len(exprs) > 1 && fi.relatedModel != nil {
+		return fi.relatedModel.FieldAccessibleBy(joinFieldNames(exprs[1:], ExprSep), uid)
+	}
+	return true
+}
+
+// CheckFieldsAccess panics if the current user cannot access one of the
+// given fields or field paths of this RecordCollection's model.
+//
+// It must be called on fields that are used to filter, order or group
+// records on behalf of a client, since their values could otherwise be
+// inferred from the results.
+func (rc *RecordCollection) CheckFieldsAccess(fields ...FieldName) {
+	for _, fName := range fields {
+		if rc.model.FieldAccessibleBy(fName, rc.env.uid) {
+			continue
+		}
+		panicWithError(exceptions.AccessError{
+			Message: rc.T("You are not allowed to access field %s of %s", fName.Name(), rc.ModelName()),
+		}, "model", rc.ModelName(), "field", fName.Name(), "uid", rc.env.uid)
+	}
+}
+
+// checkFieldsWriteAccess panics if the data given to Create or Write
+// holds a value for a field that the current user cannot access.
+func (rc *RecordCollection) checkFieldsWriteAccess(data RecordData) {
+	if rc.env.computeWrite {
+		// Computed values are written by the framework
+		return
+	}
+	fieldNames := data.Underlying().FieldNames()
+	for f := range data.Underlying().ToCreate {
+		fieldNames = append(fieldNames, rc.model.FieldName(f))
+	}
+	for _, fName := range fieldNames {
+		if rc.model.FieldAccessibleBy(fName, rc.env.uid) {
+			continue
+		}
+		panicWithError(exceptions.AccessError{
+			Message: rc.T("You are not allowed to write field %s of %s", fName.Name(), rc.ModelName()),
+		}, "model", rc.ModelName(), "field", fName.Name(), "uid", rc.env.uid)
+	}
+}
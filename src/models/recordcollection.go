@@ -25,6 +25,7 @@ import (
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -148,6 +149,7 @@ func (rc *RecordCollection) new(data RecordData) *RecordCollection {
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Create")
 func (rc *RecordCollection) create(data RecordData) *RecordCollection {
+	rc.checkNotReadOnly("create")
 	defer func() {
 		if r := recover(); r != nil {
 			panic(rc.substituteSQLErrorMessage(r))
@@ -188,6 +190,17 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 	return rSet
 }
 
+// checkNotReadOnly panics with an AccessError if the Environment of rc is read only,
+// since the given operation would fail at the database level anyway.
+func (rc *RecordCollection) checkNotReadOnly(operation string) {
+	if !rc.env.ReadOnly() {
+		return
+	}
+	panicWithError(exceptions.AccessError{
+		Message: rc.T("Cannot %s %s records in a read only environment", operation, rc.ModelName()),
+	}, "model", rc.ModelName(), "operation", operation)
+}
+
 // createReverseRelationRecords creates the reverse records of relation fields when
 // the given data contains such directive.
 func (rc *RecordCollection) createReverseRelationRecords(data RecordData) {
@@ -336,7 +349,8 @@ func (rc *RecordCollection) addContextsFieldsValues(fMap FieldMap) FieldMap {
 // CheckConstraints executes the constraint method for each field defined
 // in the given FieldNames.
 // Each method is only executed once, even if it is called by several fields.
-// It panics as soon as one constraint fails.
+// It panics as soon as one constraint fails, with an exceptions.ValidationError
+// if the constraint method did not panic with an error.
 func (rc *RecordCollection) CheckConstraints(fields FieldNames) {
 	if rc.env.context.GetBool("hexya_skip_check_constraints") {
 		return
@@ -348,6 +362,14 @@ func (rc *RecordCollection) CheckConstraints(fields FieldNames) {
 			methods[fi.constraint] = true
 		}
 	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(error); ok {
+				panic(r)
+			}
+			panic(exceptions.ValidationError{Message: strings.TrimSpace(fmt.Sprint(r))})
+		}
+	}()
 	for method := range methods {
 		for _, rec := range rc.Records() {
 			rec.Call(method)
@@ -370,6 +392,7 @@ func (rc *RecordCollection) addAccessFieldsCreateData(fMap *FieldMap) {
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Write")
 func (rc *RecordCollection) update(data RecordData) bool {
+	rc.checkNotReadOnly("write")
 	if !rc.hasNegIds && rc.ForceLoad(ID).IsEmpty() {
 		return true
 	}
@@ -455,7 +478,9 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 		query, args := rc.query.updateQuery(fMap)
 		res := rc.env.cr.Execute(query, args...)
 		if num, _ := res.RowsAffected(); num == 0 {
-			log.Panic("Unexpected noop on update (num = 0)", "model", rc.ModelName(), "values", fMap, "query", query, "args", args)
+			panicWithError(exceptions.MissingError{
+				Message: rc.T("Some %s records do not exist or have been deleted", rc.ModelName()),
+			}, "model", rc.ModelName(), "values", fMap, "query", query, "args", args)
 		}
 	}
 	for _, rec := range rc.Records() {
@@ -652,6 +677,11 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 	if !ok {
 		return r
 	}
+	if cErr, ok := err.(exceptions.ConstraintError); ok {
+		if errorString, exists := rc.model.sqlErrors[cErr.Constraint]; exists {
+			return adapters[db.DriverName()].substituteErrorMessage(cErr, errorString)
+		}
+	}
 	for constraintName, constraint := range rc.model.sqlConstraints {
 		if strings.Contains(err.Error(), constraintName) {
 			res := adapters[db.DriverName()].substituteErrorMessage(err, constraint.errorString)
@@ -665,6 +695,7 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Unlink() or rs.Call("Unlink")
 func (rc *RecordCollection) unlink() int64 {
+	rc.checkNotReadOnly("unlink")
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Unlink"))
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Unlink)
 	ids := rSet.Ids()
@@ -827,7 +858,7 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 	rSet = rSet.substituteRelatedInQuery()
 	dbFields := filterOnDBFields(rSet.model, subFields)
 	query, args, substs := rSet.query.selectQuery(dbFields)
-	rows := dbQuery(rSet.env.cr.tx, query, args...)
+	rows := dbQuery(rSet.env.cr, query, args...)
 	defer rows.Close()
 	var ids []int64
 	for rows.Next() {
@@ -1067,7 +1098,7 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregate
 
 	query, args := rSet.query.selectGroupQuery(rSet.fieldsGroupOperators(dbFields))
 	var res []GroupAggregateRow
-	rows := dbQuery(rSet.env.cr.tx, query, args...)
+	rows := dbQuery(rSet.env.cr, query, args...)
 	defer rows.Close()
 
 	for rows.Next() {
@@ -1225,7 +1256,9 @@ func (rc *RecordCollection) Model() *Model {
 func (rc *RecordCollection) GetRecord(externalID string) *RecordCollection {
 	res := rc.Search(rc.model.Field(rc.model.FieldName("HexyaExternalID")).Equals(externalID))
 	if res.IsEmpty() {
-		log.Panic("Unknown external ID", "model", rc.model.name, "externalID", externalID)
+		panicWithError(exceptions.MissingError{
+			Message: rc.T("Unknown external ID %s for model %s", externalID, rc.model.name),
+		}, "model", rc.model.name, "externalID", externalID)
 	}
 	return res
 }
@@ -15,8 +15,10 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -90,6 +92,18 @@ func (mc *modelCollection) MustGetSequence(nameOrJSON string) *Sequence {
 	return s
 }
 
+// AllModels returns all the models of the collection sorted by name
+func (mc *modelCollection) AllModels() []*Model {
+	res := make([]*Model, 0, len(mc.registryByName))
+	for _, mi := range mc.registryByName {
+		res = append(res, mi)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
 // add the given Model to the modelCollection
 func (mc *modelCollection) add(mi *Model) {
 	if _, exists := mc.Get(mi.name); exists {
@@ -369,6 +383,16 @@ func (m *Model) ordersFromStrings(exprs []string) []orderPredicate {
 	return res
 }
 
+// OrderFieldNames returns the field names used by the given
+// order expressions, such as "Name desc".
+func (m *Model) OrderFieldNames(exprs ...string) FieldNames {
+	var res FieldNames
+	for _, op := range m.ordersFromStrings(exprs) {
+		res = append(res, op.field)
+	}
+	return res
+}
+
 // JSONizeFieldName returns the json name of the given fieldName
 // If fieldName is already the json name, returns it without modifying it.
 // fieldName may be a dot separated path from this model.
@@ -626,9 +650,11 @@ func CreateSequence(name string, increment, start int64) *Sequence {
 		boot:      boot,
 	}
 	if !boot {
-		// Create the sequence on the fly if we already bootstrapped.
-		// Otherwise, this will be done in Bootstrap
-		adapters[db.DriverName()].createSequence(seq.JSON, seq.Increment, seq.Start)
+		// Create the sequence on the fly in all connected databases if we
+		// already bootstrapped. Otherwise, this will be done in Bootstrap
+		for _, d := range ConnectedDatabases() {
+			adapters[db.DriverName()].createSequence(d.db, seq.JSON, seq.Increment, seq.Start)
+		}
 	}
 	Registry.addSequence(seq)
 	return seq
@@ -645,7 +671,9 @@ func (s *Sequence) Drop() {
 		if s.boot {
 			log.Panic("Boot Sequences cannot be dropped after bootstrap")
 		}
-		adapters[db.DriverName()].dropSequence(s.JSON)
+		for _, d := range ConnectedDatabases() {
+			adapters[db.DriverName()].dropSequence(d.db, s.JSON)
+		}
 	}
 }
 
@@ -666,25 +694,35 @@ func (s *Sequence) Alter(increment, restart int64) {
 		s.Increment = increment
 	}
 	if !boot {
-		adapters[db.DriverName()].alterSequence(s.JSON, increment, restart)
+		for _, d := range ConnectedDatabases() {
+			adapters[db.DriverName()].alterSequence(d.db, s.JSON, increment, restart)
+		}
 	}
 }
 
-// NextValue returns the next value of this Sequence
+// NextValue returns the next value of this Sequence in the default database
 func (s *Sequence) NextValue() int64 {
+	return s.NextValueIn(DefaultDatabase())
+}
+
+// NextValueIn returns the next value of this Sequence in the given database
+func (s *Sequence) NextValueIn(d *Database) int64 {
 	adapter := adapters[db.DriverName()]
-	return adapter.nextSequenceValue(s.JSON)
+	return adapter.nextSequenceValue(d.db, s.JSON)
 }
 
-// FreeTransientModels remove transient models records from database which are
-// older than the given timeout.
+// FreeTransientModels remove transient models records from all connected
+// databases which are older than the given timeout.
 func FreeTransientModels() {
-	for _, model := range Registry.registryByName {
-		if model.IsTransient() {
-			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
-				createDate := model.FieldName("CreateDate")
-				model.Search(env, model.Field(createDate).Lower(dates.Now().Add(-transientModelTimeout))).Call("Unlink")
-			})
+	for _, d := range ConnectedDatabases() {
+		ctx := ContextWithDatabase(context.Background(), d)
+		for _, model := range Registry.registryByName {
+			if model.IsTransient() {
+				ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+					createDate := model.FieldName("CreateDate")
+					model.Search(env, model.Field(createDate).Lower(dates.Now().Add(-transientModelTimeout))).Call("Unlink")
+				})
+			}
 		}
 	}
 }
@@ -0,0 +1,176 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// replicaCheckInterval is the minimum interval between two checks of
+	// the replication lag of a replica
+	replicaCheckInterval = time.Second
+	// replicaCheckTimeout is the maximum duration of a check of the
+	// replication lag of a replica
+	replicaCheckTimeout = 500 * time.Millisecond
+)
+
+// A replica is a read replica of a Database
+type replica struct {
+	sync.Mutex
+	host string
+	db   *sqlx.DB
+	// checkedAt is the time of the last check of the
+	// replica and ok its result
+	checkedAt time.Time
+	ok        bool
+	// checking is true while the replica is being checked
+	checking bool
+}
+
+// replicaParams returns the connection parameters of the given replica of the
+// database connected with params. The empty fields of the replica parameters,
+// including the connection pool settings, are taken from params and the
+// database name is always the one of params.
+func replicaParams(params, replica ConnectionParams) ConnectionParams {
+	res := params
+	res.Replicas = nil
+	res.MaxReplicationLag = 0
+	for _, field := range []struct {
+		value *string
+		rep   string
+	}{
+		{&res.Driver, replica.Driver},
+		{&res.Host, replica.Host},
+		{&res.Port, replica.Port},
+		{&res.User, replica.User},
+		{&res.Password, replica.Password},
+		{&res.SSLMode, replica.SSLMode},
+		{&res.SSLCert, replica.SSLCert},
+		{&res.SSLKey, replica.SSLKey},
+		{&res.SSLCA, replica.SSLCA},
+	} {
+		if field.rep != "" {
+			*field.value = field.rep
+		}
+	}
+	if replica.StatementTimeout != 0 {
+		res.StatementTimeout = replica.StatementTimeout
+	}
+	if replica.MaxOpenConns != 0 {
+		res.MaxOpenConns = replica.MaxOpenConns
+	}
+	if replica.MaxIdleConns != 0 {
+		res.MaxIdleConns = replica.MaxIdleConns
+	}
+	if replica.ConnMaxLifetime != 0 {
+		res.ConnMaxLifetime = replica.ConnMaxLifetime
+	}
+	return res
+}
+
+// connectReplicas returns the replicas of the database connected with params.
+//
+// The connections to the replicas are opened lazily, so that
+// an unreachable replica does not prevent the application to start.
+func connectReplicas(params ConnectionParams) []*replica {
+	res := make([]*replica, len(params.Replicas))
+	for i, rep := range params.Replicas {
+		repParams := replicaParams(params, rep)
+		conn, err := sqlx.Open(repParams.Driver, repParams.ConnectionString())
+		if err != nil {
+			log.Panic("Unable to open replica", "database", params.DBName, "host", repParams.Host, "error", err)
+		}
+		repParams.configurePool(conn)
+		res[i] = &replica{
+			host: repParams.Host,
+			db:   conn,
+		}
+		log.Info("Added read replica", "database", params.DBName, "host", repParams.Host)
+	}
+	return res
+}
+
+// available returns true if this replica is reachable and its
+// replication lag is not greater than maxLag, or if maxLag is zero.
+//
+// The result is cached for replicaCheckInterval. The replica is checked by a
+// single caller at a time and for at most replicaCheckTimeout, the other
+// callers get the previous result in the meantime.
+func (r *replica) available(maxLag time.Duration) bool {
+	ok, check := r.status()
+	if !check {
+		return ok
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+	lag, err := r.replicationLag(ctx)
+	ok = err == nil && (maxLag == 0 || lag <= maxLag)
+	if !ok {
+		log.Warn("Read replica is not available", "host", r.host, "lag", lag, "maxLag", maxLag, "error", err)
+	}
+	r.setStatus(ok)
+	return ok
+}
+
+// status returns the cached availability of this replica and true if the
+// caller must check the replica again, in which case it must call setStatus.
+func (r *replica) status() (bool, bool) {
+	r.Lock()
+	defer r.Unlock()
+	if r.checking || time.Since(r.checkedAt) < replicaCheckInterval {
+		return r.ok, false
+	}
+	r.checking = true
+	return r.ok, true
+}
+
+// setStatus caches the result of the check of this replica
+func (r *replica) setStatus(ok bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.ok = ok
+	r.checkedAt = time.Now()
+	r.checking = false
+}
+
+// replicationLag returns the replication lag of this replica.
+// The query is cancelled when ctx is done.
+func (r *replica) replicationLag(ctx context.Context) (lag time.Duration, rErr error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if err, ok := rec.(error); ok {
+				rErr = err
+				return
+			}
+			rErr = fmt.Errorf("%v", rec)
+		}
+	}()
+	return adapters[r.db.DriverName()].replicationLag(ctx, r.db), nil
+}
+
+// readConn returns the connection pool on which read only transactions are run.
+// It is the next replica in turn that is available, or the primary database if
+// there is no replica or if none is available.
+func (d *Database) readConn() *sqlx.DB {
+	n := len(d.replicas)
+	if n == 0 {
+		return d.db
+	}
+	start := int(atomic.AddUint32(&d.nextReplica, 1))
+	for i := 0; i < n; i++ {
+		rep := d.replicas[(start+i)%n]
+		if rep.available(d.params.MaxReplicationLag) {
+			return rep.db
+		}
+	}
+	log.Warn("No read replica available, running read only transaction on primary", "database", d.name)
+	return d.db
+}
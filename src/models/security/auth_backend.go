@@ -15,6 +15,7 @@
 package security
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/hexya-erp/hexya/src/models/types"
@@ -23,6 +24,10 @@ import (
 // AuthenticationRegistry is the authentication registry of the application
 var AuthenticationRegistry *AuthBackendRegistry
 
+// DatabaseKey is the key of the context given to Authenticate that holds
+// the name of the database selected for the request, if any.
+const DatabaseKey = "database"
+
 // A UserNotFoundError should be returned by backends when the user is not known
 type UserNotFoundError string
 
@@ -40,6 +45,23 @@ func (ice InvalidCredentialsError) Error() string {
 	return fmt.Sprintf("Wrong credentials for user %s", string(ice))
 }
 
+// A SecondFactorRequiredError is returned by AuthBackendRegistry.Authenticate
+// when the credentials of the user are valid but the user must also give a
+// second factor to be authenticated.
+//
+// Enrolled is false if the user must first enroll a second factor, because
+// the user belongs to a group which requires it.
+type SecondFactorRequiredError struct {
+	UID      int64
+	Login    string
+	Enrolled bool
+}
+
+// Error returns the error message
+func (sfre SecondFactorRequiredError) Error() string {
+	return fmt.Sprintf("Second factor required for user %s", sfre.Login)
+}
+
 // An AuthBackend is an interface that is capable of authenticating a
 // user and tell whether a user is a member of a given group.
 type AuthBackend interface {
@@ -53,12 +75,34 @@ type AuthBackend interface {
 	Authenticate(login, secret string, context *types.Context) (int64, error)
 }
 
+// A SecondFactorBackend verifies the second factor of users.
+//
+// The user with the given uid is the user of the database
+// of the given context.Context.
+type SecondFactorBackend interface {
+	// Enrolled returns true if the user with the given uid has
+	// enrolled a second factor.
+	Enrolled(ctx context.Context, uid int64) bool
+	// Verify returns true if code is a valid second factor
+	// for the user with the given uid.
+	Verify(ctx context.Context, uid int64, code string) bool
+}
+
 // An AuthBackendRegistry holds an ordered list of AuthBackend instances
 // that enables authentication against several backends.
 // A pointer to AuthBackendRegistry is itself an AuthBackend that can be
 // used in another AuthBackendRegistry.
+//
+// An AuthBackendRegistry may also have a SecondFactorBackend, in which case
+// users that have enrolled a second factor or that belong to a group which
+// requires it are only authenticated after their second factor is verified.
+//
+// Failed attempts are throttled if a ThrottlingPolicy is set with SetThrottling.
 type AuthBackendRegistry struct {
-	backends []AuthBackend
+	backends         []AuthBackend
+	secondFactor     SecondFactorBackend
+	throttlingPolicy ThrottlingPolicy
+	attempts         LoginAttemptStore
 }
 
 // RegisterBackend registers the given backend in this registry.
@@ -69,10 +113,94 @@ func (ar *AuthBackendRegistry) RegisterBackend(backend AuthBackend) {
 	ar.backends = append([]AuthBackend{backend}, ar.backends...)
 }
 
+// SetSecondFactorBackend sets the backend that verifies the second
+// factor of users authenticated by this registry.
+func (ar *AuthBackendRegistry) SetSecondFactorBackend(backend SecondFactorBackend) {
+	ar.secondFactor = backend
+}
+
 // Authenticate tries to authenticate the user with the given uid and secret.
 // Backends are polled in order. The user is authenticated as soon as one
 // backend authenticates his uid with the given secret.
+//
+// If the user must also give a second factor, the uid is returned with a
+// SecondFactorRequiredError. The second factor must then be verified
+// with VerifySecondFactor.
+//
+// If the registry throttles failed attempts, it returns a LoginThrottledError
+// without checking the credentials when the login, or the address given in
+// the RemoteAddrKey of the context, has too many recent failures.
+//
+// Attempts are counted as failures of the login before the credentials are
+// checked, so that concurrent attempts cannot exceed the throttling policy.
+// The failures of the login are reset once the user is fully authenticated.
+//
+// Failures and second factors are checked in the database named in the
+// DatabaseKey of the context.
 func (ar *AuthBackendRegistry) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	var addr string
+	if context != nil {
+		addr = context.GetString(RemoteAddrKey)
+	}
+	ctx := ContextFromAuthentication(context)
+	failures, err := ar.reserveAttempt(ctx, login, addr)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := ar.authenticate(login, secret, context)
+	switch err.(type) {
+	case nil:
+	case UserNotFoundError, InvalidCredentialsError:
+		ar.recordFailure(ctx, login, addr, failures, err)
+		return uid, err
+	default:
+		return uid, err
+	}
+	return uid, ar.CheckSecondFactor(ctx, uid, login)
+}
+
+// CheckSecondFactor returns a SecondFactorRequiredError if the user with the
+// given uid and login must give a second factor to be authenticated. Otherwise
+// the failed attempts of the login are reset.
+//
+// It is called by Authenticate, and must be called for users authenticated by
+// other means than the backends, such as a single sign-on provider. The user
+// is the user of the database of ctx.
+func (ar *AuthBackendRegistry) CheckSecondFactor(ctx context.Context, uid int64, login string) error {
+	enrolled := ar.secondFactor != nil && ar.secondFactor.Enrolled(ctx, uid)
+	if enrolled || Registry.SecondFactorRequiredContext(ctx, uid) {
+		return SecondFactorRequiredError{UID: uid, Login: login, Enrolled: enrolled}
+	}
+	if ar.attempts != nil {
+		ar.attempts.Reset(ctx, loginKey(login))
+	}
+	return nil
+}
+
+// VerifySecondFactor returns an InvalidCredentialsError if the given code is
+// not a valid second factor for the user with the given uid and login in the
+// database of ctx.
+//
+// Failed verifications are throttled with the failed attempts of the login.
+func (ar *AuthBackendRegistry) VerifySecondFactor(ctx context.Context, uid int64, login, code string) error {
+	failures, err := ar.reserveAttempt(ctx, login, "")
+	if err != nil {
+		return err
+	}
+	if ar.secondFactor == nil || !ar.secondFactor.Verify(ctx, uid, code) {
+		err := InvalidCredentialsError(login)
+		ar.recordFailure(ctx, login, "", failures, err)
+		return err
+	}
+	if ar.attempts != nil {
+		ar.attempts.Reset(ctx, loginKey(login))
+	}
+	return nil
+}
+
+// authenticate polls the backends of the registry in order
+// to authenticate the user with the given login and secret.
+func (ar *AuthBackendRegistry) authenticate(login, secret string, context *types.Context) (int64, error) {
 	for _, backend := range ar.backends {
 		uid, err := backend.Authenticate(login, secret, context)
 		if err != nil {
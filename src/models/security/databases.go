@@ -0,0 +1,68 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/models/types"
+)
+
+// databaseNameKey is the key of the database name in a context.Context
+type databaseNameKey struct{}
+
+// defaultDatabase holds the name of the default database of the application
+var defaultDatabase struct {
+	sync.RWMutex
+	name string
+}
+
+// SetDefaultDatabaseName sets the name of the default database of the
+// application, that is the database of the contexts that hold no database
+// name. It is called when connecting to the default database.
+func SetDefaultDatabaseName(name string) {
+	defaultDatabase.Lock()
+	defer defaultDatabase.Unlock()
+	defaultDatabase.name = name
+}
+
+// ContextWithDatabaseName returns a copy of ctx which holds the given
+// database name, so that the memberships of Registry and the stores of
+// AuthenticationRegistry are read and written in this database.
+func ContextWithDatabaseName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, databaseNameKey{}, name)
+}
+
+// DatabaseNameFromContext returns the database name held by
+// ctx and true, or false if ctx does not hold any.
+func DatabaseNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(databaseNameKey{}).(string)
+	return name, ok
+}
+
+// ContextFromAuthentication returns a context.Context which holds the
+// database name given in the DatabaseKey of the given authentication
+// context, if any.
+func ContextFromAuthentication(authCtx *types.Context) context.Context {
+	if authCtx == nil || !authCtx.HasKey(DatabaseKey) {
+		return context.Background()
+	}
+	return ContextWithDatabaseName(context.Background(), authCtx.GetString(DatabaseKey))
+}
+
+// databaseName returns the name of the database of ctx, which is
+// empty for the default database.
+func databaseName(ctx context.Context) string {
+	name, ok := DatabaseNameFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	defaultDatabase.RLock()
+	defer defaultDatabase.RUnlock()
+	if name == defaultDatabase.name {
+		return ""
+	}
+	return name
+}
@@ -15,8 +15,10 @@
 package security
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 )
 
 const (
@@ -53,9 +55,10 @@ type InheritanceInfo int8
 // - A user can belong to one or several groups, and thus inherit from the
 // permissions of the groups.
 type Group struct {
-	id       string
-	name     string
-	inherits map[*Group]bool
+	id           string
+	name         string
+	inherits     map[*Group]bool
+	secondFactor bool
 }
 
 // String function for group
@@ -73,6 +76,18 @@ func (g *Group) Name() string {
 	return g.name
 }
 
+// RequireSecondFactor sets whether the members of this group
+// must authenticate with a second factor.
+func (g *Group) RequireSecondFactor(required bool) {
+	g.secondFactor = required
+}
+
+// SecondFactorRequired returns true if the members of this
+// group must authenticate with a second factor.
+func (g *Group) SecondFactorRequired() bool {
+	return g.secondFactor
+}
+
 // ImpliedGroups returns a slice of all Groups implied by this Group
 func (g *Group) ImpliedGroups() []*Group {
 	var res []*Group
@@ -87,11 +102,56 @@ func (g *Group) Implies(other *Group) bool {
 	return g.inherits[other]
 }
 
+// loadRetryDelay is the delay during which the memberships of a user
+// are not loaded again after the store failed to load them. The user
+// is a member of no group during this delay.
+const loadRetryDelay = 5 * time.Second
+
+// A MembershipStore persists the native group memberships of users, so
+// that they are shared by all the processes of the application.
+//
+// Each method is given the context.Context of its caller, which may
+// hold the transaction in which the memberships must be read or written.
+type MembershipStore interface {
+	// Load returns the IDs of the groups of which the user
+	// with the given uid is a native member.
+	Load(ctx context.Context, uid int64) ([]string, error)
+	// Add makes the user with the given uid a native member of the group with the given ID
+	Add(ctx context.Context, uid int64, groupID string) error
+	// Remove removes the user with the given uid from the group with the given ID
+	Remove(ctx context.Context, uid int64, groupID string) error
+	// RemoveAll removes the user with the given uid from all groups
+	RemoveAll(ctx context.Context, uid int64) error
+}
+
+// A membershipKey identifies the memberships of a user in a database.
+// The database is empty for the default database.
+type membershipKey struct {
+	database string
+	uid      int64
+}
+
 // A GroupCollection keeps a list of groups
+//
+// If the collection has a MembershipStore, the memberships of each user are
+// loaded from the store the first time they are needed and membership
+// changes are saved in the store. Invalidate must then be called when the
+// memberships of a user have been modified by another process.
+//
+// The methods suffixed by Context pass the given context.Context to the
+// store, so that memberships are read and written in the transaction it
+// holds, if any. The memberships of a user are kept for each database given
+// by ContextWithDatabaseName, since the same uid designates different users
+// in different databases. The other methods use context.Background(), that
+// is the default database.
 type GroupCollection struct {
 	sync.RWMutex
 	groups      map[string]*Group
-	memberships map[int64]map[*Group]InheritanceInfo
+	memberships map[membershipKey]map[*Group]InheritanceInfo
+	store       MembershipStore
+	loaded      map[membershipKey]bool
+	loadFailed  map[membershipKey]time.Time
+	epoch       int64
 }
 
 // NewGroup creates a new Group with the given id, name and inherited groups
@@ -134,117 +194,379 @@ func (gc *GroupCollection) inheritedBy(group *Group, result *[]*Group) {
 	}
 }
 
-// UnregisterGroup removes the group with the given ID from this GroupCollection
+// UnregisterGroup removes the group with the given ID from this GroupCollection.
+// The memberships of the group are removed from memory but not from the store.
 func (gc *GroupCollection) UnregisterGroup(group *Group) {
+	gc.Lock()
+	defer gc.Unlock()
 	// remove links from inheriting groups
 	for _, grp := range gc.groups {
 		delete(grp.inherits, group)
 	}
 	// remove memberships
-	for uid := range gc.memberships {
-		gc.RemoveMembership(uid, group)
+	for key := range gc.memberships {
+		gc.removeMembership(key, group)
 	}
 	// Remove the group itself
-	gc.Lock()
-	defer gc.Unlock()
 	delete(gc.groups, group.ID())
 }
 
 // GetGroup returns the group with the given groupID or nil if not found
 func (gc *GroupCollection) GetGroup(groupID string) *Group {
+	gc.RLock()
+	defer gc.RUnlock()
 	return gc.groups[groupID]
 }
 
+// SetMembershipStore sets the store in which the memberships of this
+// collection are persisted. Memberships that are in memory are discarded
+// and will be loaded from the store.
+func (gc *GroupCollection) SetMembershipStore(store MembershipStore) {
+	gc.Lock()
+	defer gc.Unlock()
+	gc.store = store
+	gc.memberships = make(map[membershipKey]map[*Group]InheritanceInfo)
+	gc.loaded = make(map[membershipKey]bool)
+	gc.loadFailed = make(map[membershipKey]time.Time)
+	gc.epoch++
+}
+
+// key returns the key of the memberships of the user with the given uid in
+// the database of ctx. Memberships that are not persisted in a store are the
+// same in all databases. It must be called with the lock held.
+func (gc *GroupCollection) key(ctx context.Context, uid int64) membershipKey {
+	if gc.store == nil {
+		return membershipKey{uid: uid}
+	}
+	return membershipKey{database: databaseName(ctx), uid: uid}
+}
+
+// Invalidate discards the memberships of the user with the given uid
+// so that they are loaded again from the store when next needed.
+func (gc *GroupCollection) Invalidate(uid int64) {
+	gc.InvalidateContext(context.Background(), uid)
+}
+
+// InvalidateContext discards the memberships of the user with the given
+// uid in the database of ctx, as Invalidate.
+func (gc *GroupCollection) InvalidateContext(ctx context.Context, uid int64) {
+	gc.Lock()
+	defer gc.Unlock()
+	if gc.store == nil {
+		return
+	}
+	key := gc.key(ctx, uid)
+	delete(gc.memberships, key)
+	delete(gc.loaded, key)
+	delete(gc.loadFailed, key)
+	gc.epoch++
+}
+
+// InvalidateAll discards the memberships of all users in all databases
+// so that they are loaded again from the store when needed.
+func (gc *GroupCollection) InvalidateAll() {
+	gc.Lock()
+	defer gc.Unlock()
+	if gc.store == nil {
+		return
+	}
+	gc.memberships = make(map[membershipKey]map[*Group]InheritanceInfo)
+	gc.loaded = make(map[membershipKey]bool)
+	gc.loadFailed = make(map[membershipKey]time.Time)
+	gc.epoch++
+}
+
+// InvalidateAllContext discards the memberships of all
+// users in the database of ctx, as InvalidateAll.
+func (gc *GroupCollection) InvalidateAllContext(ctx context.Context) {
+	gc.Lock()
+	defer gc.Unlock()
+	if gc.store == nil {
+		return
+	}
+	database := databaseName(ctx)
+	for key := range gc.memberships {
+		if key.database == database {
+			delete(gc.memberships, key)
+		}
+	}
+	for key := range gc.loaded {
+		if key.database == database {
+			delete(gc.loaded, key)
+		}
+	}
+	for key := range gc.loadFailed {
+		if key.database == database {
+			delete(gc.loadFailed, key)
+		}
+	}
+	gc.epoch++
+}
+
+// membershipStore returns the MembershipStore of this collection and
+// the key of the memberships of the user with the given uid in the
+// database of ctx.
+func (gc *GroupCollection) membershipStore(ctx context.Context, uid int64) (MembershipStore, membershipKey) {
+	gc.RLock()
+	defer gc.RUnlock()
+	return gc.store, gc.key(ctx, uid)
+}
+
+// ensureLoaded loads the memberships of the user with the given uid in the
+// database of ctx from the store if they have not been loaded yet, and returns
+// their key.
+//
+// Memberships loaded while they are being invalidated are discarded
+// and loaded again, so that changes are never missed. If the store
+// fails to load them, they are not loaded again before loadRetryDelay.
+func (gc *GroupCollection) ensureLoaded(ctx context.Context, uid int64) membershipKey {
+	var key membershipKey
+	for attempt := 0; attempt < 3; attempt++ {
+		gc.RLock()
+		key = gc.key(ctx, uid)
+		store, loaded, failed, epoch := gc.store, gc.loaded[key], gc.loadFailed[key], gc.epoch
+		gc.RUnlock()
+		if store == nil || loaded || time.Since(failed) < loadRetryDelay {
+			return key
+		}
+		groupIDs, err := store.Load(ctx, uid)
+		if err != nil {
+			log.Warn("Unable to load group memberships", "uid", uid, "database", key.database, "error", err)
+			gc.setLoadFailed(key, epoch)
+			return key
+		}
+		if gc.setLoadedMemberships(key, groupIDs, epoch) {
+			return key
+		}
+	}
+	log.Warn("Unable to load group memberships", "uid", uid, "database", key.database, "error", "memberships invalidated while loading")
+	return key
+}
+
+// setLoadFailed records that the memberships with the given key could
+// not be loaded, unless memberships have been invalidated since the
+// given epoch.
+func (gc *GroupCollection) setLoadFailed(key membershipKey, epoch int64) {
+	gc.Lock()
+	defer gc.Unlock()
+	if gc.epoch != epoch {
+		return
+	}
+	gc.loadFailed[key] = time.Now()
+}
+
+// setLoadedMemberships sets the memberships with the given key to the
+// groups with the given IDs, unless memberships have been invalidated
+// since the given epoch. It returns false if they have been invalidated.
+func (gc *GroupCollection) setLoadedMemberships(key membershipKey, groupIDs []string, epoch int64) bool {
+	gc.Lock()
+	defer gc.Unlock()
+	if gc.epoch != epoch {
+		return false
+	}
+	if gc.loaded[key] {
+		return true
+	}
+	delete(gc.memberships, key)
+	for _, groupID := range groupIDs {
+		group, ok := gc.groups[groupID]
+		if !ok {
+			log.Warn("Ignoring membership of unknown group", "uid", key.uid, "database", key.database, "group", groupID)
+			continue
+		}
+		gc.addMembership(key, group, NativeGroup)
+	}
+	if key.uid == SuperUserID && GroupAdmin != nil {
+		gc.addMembership(key, GroupAdmin, NativeGroup)
+	}
+	gc.loaded[key] = true
+	delete(gc.loadFailed, key)
+	return true
+}
+
 // AddMembership adds the user defined by its uid to the
 // given group and also to all groups that inherit this group.
 // inherit is set to true when this method is called on an
 // inherited group recursively. You should normally leave it
 // unset.
 func (gc *GroupCollection) AddMembership(uid int64, group *Group, inherit ...bool) {
-	var inheritingGroups []*Group
-	gc.inheritedBy(group, &inheritingGroups)
-	for _, grp := range inheritingGroups {
-		gc.AddMembership(uid, grp, true)
-	}
-	gc.Lock()
-	defer gc.Unlock()
+	gc.AddMembershipContext(context.Background(), uid, group, inherit...)
+}
+
+// AddMembershipContext adds the user defined by its uid to the given group
+// as AddMembership, saving the membership in the transaction of ctx.
+func (gc *GroupCollection) AddMembershipContext(ctx context.Context, uid int64, group *Group, inherit ...bool) {
+	key := gc.ensureLoaded(ctx, uid)
 	mode := NativeGroup
 	if len(inherit) > 0 && inherit[0] {
 		mode = InheritedGroup
 	}
-	if _, exists := gc.memberships[uid]; !exists {
-		gc.memberships[uid] = make(map[*Group]InheritanceInfo)
+	if store, _ := gc.membershipStore(ctx, uid); store != nil && mode == NativeGroup {
+		if err := store.Add(ctx, uid, group.ID()); err != nil {
+			log.Panic("Unable to save group membership", "uid", uid, "group", group.ID(), "error", err)
+		}
 	}
-	gc.memberships[uid][group] = mode
+	gc.Lock()
+	defer gc.Unlock()
+	gc.addMembership(key, group, mode)
+}
+
+// addMembership adds the user of the given key to the given group
+// with the given mode and to all groups that inherit this group.
+// It must be called with the lock held.
+func (gc *GroupCollection) addMembership(key membershipKey, group *Group, mode InheritanceInfo) {
+	if _, exists := gc.memberships[key]; !exists {
+		gc.memberships[key] = make(map[*Group]InheritanceInfo)
+	}
+	var inheritingGroups []*Group
+	gc.inheritedBy(group, &inheritingGroups)
+	for _, grp := range inheritingGroups {
+		gc.memberships[key][grp] = InheritedGroup
+	}
+	gc.memberships[key][group] = mode
 }
 
 // RemoveMembership removes the user with the given uid from the given group
 // and all groups that inherit from this group.
 func (gc *GroupCollection) RemoveMembership(uid int64, group *Group) {
-	if _, exists := gc.memberships[uid][group]; !exists {
+	gc.RemoveMembershipContext(context.Background(), uid, group)
+}
+
+// RemoveMembershipContext removes the user with the given uid from the given
+// group as RemoveMembership, saving the change in the transaction of ctx.
+func (gc *GroupCollection) RemoveMembershipContext(ctx context.Context, uid int64, group *Group) {
+	if !gc.HasMembershipContext(ctx, uid, group) {
 		return
 	}
-	gc.doRemoveMembership(uid, group)
+	store, key := gc.membershipStore(ctx, uid)
+	if store != nil {
+		if err := store.Remove(ctx, uid, group.ID()); err != nil {
+			log.Panic("Unable to remove group membership", "uid", uid, "group", group.ID(), "error", err)
+		}
+	}
+	gc.Lock()
+	defer gc.Unlock()
+	gc.removeMembership(key, group)
+}
+
+// removeMembership removes the user of the given key from the given group
+// and recomputes the inherited memberships. It must be called with the lock held.
+func (gc *GroupCollection) removeMembership(key membershipKey, group *Group) {
+	if _, exists := gc.memberships[key][group]; !exists {
+		return
+	}
+	gc.doRemoveMembership(key, group)
 	// Re-Add membership for all existing groups to compute inheritance
-	for grp, ii := range gc.memberships[uid] {
+	for grp, ii := range gc.memberships[key] {
 		if ii == NativeGroup {
-			gc.AddMembership(uid, grp)
+			gc.addMembership(key, grp, NativeGroup)
 		}
 	}
 }
 
-// doRemoveMembership actually removes the user with the given uid from the
+// doRemoveMembership actually removes the user of the given key from the
 // given Group and all groups that inherit from this Group.
-func (gc *GroupCollection) doRemoveMembership(uid int64, group *Group) {
-	gc.Lock()
-	defer gc.Unlock()
+// It must be called with the lock held.
+func (gc *GroupCollection) doRemoveMembership(key membershipKey, group *Group) {
 	// Remove our group
-	delete(gc.memberships[uid], group)
+	delete(gc.memberships[key], group)
 	// Remove all inherited groups
 	for _, grp := range group.ImpliedGroups() {
-		if gc.memberships[uid][grp] == InheritedGroup {
-			delete(gc.memberships[uid], grp)
+		if gc.memberships[key][grp] == InheritedGroup {
+			delete(gc.memberships[key], grp)
 		}
 	}
 }
 
 // RemoveAllMembershipsForUser removes the given uid from all groups
 func (gc *GroupCollection) RemoveAllMembershipsForUser(uid int64) {
-	gc.doRemoveAllMembershipsForUser(uid)
-	if uid == SuperUserID {
-		gc.AddMembership(SuperUserID, GroupAdmin)
-	}
+	gc.RemoveAllMembershipsForUserContext(context.Background(), uid)
 }
 
-// doRemoveAllMembershipsForUser actually removes the given uid from all groups
-func (gc *GroupCollection) doRemoveAllMembershipsForUser(uid int64) {
+// RemoveAllMembershipsForUserContext removes the given uid from all groups
+// as RemoveAllMembershipsForUser, saving the change in the transaction of ctx.
+func (gc *GroupCollection) RemoveAllMembershipsForUserContext(ctx context.Context, uid int64) {
+	store, key := gc.membershipStore(ctx, uid)
+	if store != nil {
+		if err := store.RemoveAll(ctx, uid); err != nil {
+			log.Panic("Unable to remove group memberships", "uid", uid, "error", err)
+		}
+	}
 	gc.Lock()
 	defer gc.Unlock()
-	delete(gc.memberships, uid)
+	if store != nil {
+		gc.loaded[key] = true
+		delete(gc.loadFailed, key)
+	}
+	delete(gc.memberships, key)
+	if uid == SuperUserID {
+		gc.addMembership(key, GroupAdmin, NativeGroup)
+	}
 }
 
 // HasMembership returns true id the given uid is a member of the given group
 func (gc *GroupCollection) HasMembership(uid int64, group *Group) bool {
+	return gc.HasMembershipContext(context.Background(), uid, group)
+}
+
+// HasMembershipContext returns true if the given uid is a member of the
+// given group, loading its memberships in the transaction of ctx if needed.
+func (gc *GroupCollection) HasMembershipContext(ctx context.Context, uid int64, group *Group) bool {
 	if group == GroupEveryone {
 		return true
 	}
-	_, ok := gc.memberships[uid][group]
+	key := gc.ensureLoaded(ctx, uid)
+	gc.RLock()
+	defer gc.RUnlock()
+	_, ok := gc.memberships[key][group]
 	return ok
 }
 
 // UserGroups returns the slice of groups the user with the given
 // uid belongs to, including inherited groups.
 func (gc *GroupCollection) UserGroups(uid int64) map[*Group]InheritanceInfo {
-	res := make(map[*Group]InheritanceInfo, len(gc.memberships[uid])+1)
-	for k, v := range gc.memberships[uid] {
+	return gc.UserGroupsContext(context.Background(), uid)
+}
+
+// UserGroupsContext returns the groups of the user with the given uid as
+// UserGroups, loading its memberships in the transaction of ctx if needed.
+func (gc *GroupCollection) UserGroupsContext(ctx context.Context, uid int64) map[*Group]InheritanceInfo {
+	key := gc.ensureLoaded(ctx, uid)
+	gc.RLock()
+	defer gc.RUnlock()
+	res := make(map[*Group]InheritanceInfo, len(gc.memberships[key])+1)
+	for k, v := range gc.memberships[key] {
 		res[k] = v
 	}
 	res[GroupEveryone] = NativeGroup
 	return res
 }
 
+// SecondFactorRequired returns true if the user with the given uid belongs
+// to a group whose members must authenticate with a second factor.
+func (gc *GroupCollection) SecondFactorRequired(uid int64) bool {
+	return gc.SecondFactorRequiredContext(context.Background(), uid)
+}
+
+// SecondFactorRequiredContext returns true if the user with the given uid
+// must authenticate with a second factor as SecondFactorRequired, loading
+// its memberships in the transaction of ctx if needed.
+func (gc *GroupCollection) SecondFactorRequiredContext(ctx context.Context, uid int64) bool {
+	key := gc.ensureLoaded(ctx, uid)
+	gc.RLock()
+	defer gc.RUnlock()
+	for group := range gc.memberships[key] {
+		if group.secondFactor {
+			return true
+		}
+	}
+	return GroupEveryone != nil && GroupEveryone.secondFactor
+}
+
 // AllGroups returns a slice with all the groups of the collection
 func (gc *GroupCollection) AllGroups() []*Group {
+	gc.RLock()
+	defer gc.RUnlock()
 	res := make([]*Group, len(gc.groups))
 	i := 0
 	for _, group := range gc.groups {
@@ -258,7 +580,9 @@ func (gc *GroupCollection) AllGroups() []*Group {
 func NewGroupCollection() *GroupCollection {
 	gc := GroupCollection{
 		groups:      make(map[string]*Group),
-		memberships: make(map[int64]map[*Group]InheritanceInfo),
+		memberships: make(map[membershipKey]map[*Group]InheritanceInfo),
+		loaded:      make(map[membershipKey]bool),
+		loadFailed:  make(map[membershipKey]time.Time),
 	}
 	return &gc
 }
@@ -23,6 +23,7 @@ func init() {
 
 	Registry = NewGroupCollection()
 	AuthenticationRegistry = new(AuthBackendRegistry)
+	AuthenticationRegistry.SetThrottling(DefaultThrottlingPolicy, NewMemoryAttemptStore())
 	GroupAdmin = Registry.NewGroup(GroupAdminID, "Admin Group")
 	Registry.AddMembership(SuperUserID, GroupAdmin)
 	GroupEveryone = Registry.NewGroup(GroupEveryoneID, "Everyone")
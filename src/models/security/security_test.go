@@ -15,7 +15,12 @@
 package security
 
 import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/types"
 	. "github.com/smartystreets/goconvey/convey"
@@ -189,3 +194,326 @@ func TestAuthBackend(t *testing.T) {
 		So(id, ShouldEqual, 0)
 	})
 }
+
+type simpleSecondFactorBackend map[int64]string
+
+func (s simpleSecondFactorBackend) Enrolled(ctx context.Context, uid int64) bool {
+	_, ok := s[uid]
+	return ok
+}
+
+func (s simpleSecondFactorBackend) Verify(ctx context.Context, uid int64, code string) bool {
+	return s.Enrolled(ctx, uid) && s[uid] == code
+}
+
+func TestSecondFactor(t *testing.T) {
+	Convey("Testing second factor authentication", t, func() {
+		registry := new(AuthBackendRegistry)
+		registry.RegisterBackend(simpleAuthBackend{})
+		Convey("Without second factor backend, the user should be authenticated", func() {
+			id, err := registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, 1)
+			So(registry.VerifySecondFactor(context.Background(), 1, "admin", "123456"), ShouldEqual, InvalidCredentialsError("admin"))
+		})
+		Convey("Enrolled users should give a second factor", func() {
+			registry.SetSecondFactorBackend(simpleSecondFactorBackend{1: "123456"})
+			id, err := registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldResemble, SecondFactorRequiredError{UID: 1, Login: "admin", Enrolled: true})
+			So(err.Error(), ShouldEqual, "Second factor required for user admin")
+			So(id, ShouldEqual, 1)
+			So(registry.VerifySecondFactor(context.Background(), 1, "admin", "123456"), ShouldBeNil)
+			So(registry.VerifySecondFactor(context.Background(), 1, "admin", "654321"), ShouldEqual, InvalidCredentialsError("admin"))
+			_, err = registry.Authenticate("admin", "wrong", nil)
+			So(err, ShouldEqual, InvalidCredentialsError("admin"))
+		})
+		Convey("Members of groups requiring a second factor should give one", func() {
+			registry.SetSecondFactorBackend(simpleSecondFactorBackend{})
+			So(GroupAdmin.SecondFactorRequired(), ShouldBeFalse)
+			So(Registry.SecondFactorRequired(1), ShouldBeFalse)
+			GroupAdmin.RequireSecondFactor(true)
+			So(GroupAdmin.SecondFactorRequired(), ShouldBeTrue)
+			So(Registry.SecondFactorRequired(1), ShouldBeTrue)
+			So(Registry.SecondFactorRequired(2), ShouldBeFalse)
+			_, err := registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldResemble, SecondFactorRequiredError{UID: 1, Login: "admin", Enrolled: false})
+			GroupAdmin.RequireSecondFactor(false)
+			_, err = registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+// countingAuthBackend counts the credentials it checks
+type countingAuthBackend struct {
+	checks *int32
+}
+
+func (a countingAuthBackend) Authenticate(login, secret string, ctx *types.Context) (int64, error) {
+	atomic.AddInt32(a.checks, 1)
+	return simpleAuthBackend{}.Authenticate(login, secret, ctx)
+}
+
+// racingAttemptStore is a MemoryAttemptStore in which another attempt
+// is counted each time failures are read, as if it ran concurrently.
+type racingAttemptStore struct {
+	*MemoryAttemptStore
+}
+
+func (s racingAttemptStore) Failures(ctx context.Context, key string) (int, time.Time) {
+	failures, last := s.MemoryAttemptStore.Failures(ctx, key)
+	s.MemoryAttemptStore.RecordFailure(ctx, key, time.Now(), time.Hour)
+	return failures, last
+}
+
+func TestThrottling(t *testing.T) {
+	Convey("Testing throttling of failed login attempts", t, func() {
+		registry := new(AuthBackendRegistry)
+		registry.RegisterBackend(simpleAuthBackend{})
+		store := NewMemoryAttemptStore()
+		policy := ThrottlingPolicy{
+			FreeFailures:       2,
+			Delay:              time.Hour,
+			MaxDelay:           4 * time.Hour,
+			MaxFailures:        5,
+			LockoutDuration:    24 * time.Hour,
+			MaxAddressFailures: 3,
+			Window:             48 * time.Hour,
+		}
+		registry.SetThrottling(policy, store)
+		ctx := types.NewContext().WithKey(RemoteAddrKey, "192.0.2.1")
+		Convey("Delays should increase progressively up to the lockout", func() {
+			now := time.Now()
+			until, locked := policy.blockedUntil(2, now, policy.MaxFailures)
+			So(until.IsZero(), ShouldBeTrue)
+			So(locked, ShouldBeFalse)
+			until, _ = policy.blockedUntil(3, now, policy.MaxFailures)
+			So(until, ShouldEqual, now.Add(time.Hour))
+			until, _ = policy.blockedUntil(4, now, policy.MaxFailures)
+			So(until, ShouldEqual, now.Add(2*time.Hour))
+			until, locked = policy.blockedUntil(5, now, policy.MaxFailures)
+			So(until, ShouldEqual, now.Add(24*time.Hour))
+			So(locked, ShouldBeTrue)
+			policy.MaxFailures = 0
+			until, _ = policy.blockedUntil(10, now, policy.MaxFailures)
+			So(until, ShouldEqual, now.Add(4*time.Hour))
+		})
+		Convey("Free failures should not delay the next attempt", func() {
+			_, err := registry.Authenticate("admin", "wrong", nil)
+			So(err, ShouldEqual, InvalidCredentialsError("admin"))
+			_, err = registry.Authenticate("Admin", "wrong", nil)
+			So(err, ShouldEqual, UserNotFoundError("Admin"))
+			uid, err := registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 1)
+			failures, _ := store.Failures(context.Background(), loginKey("admin"))
+			So(failures, ShouldEqual, 0)
+		})
+		Convey("Further failures should refuse attempts without checking credentials", func() {
+			for i := 0; i < 3; i++ {
+				registry.Authenticate("admin", "wrong", nil)
+			}
+			_, err := registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, LoginThrottledError{})
+			So(err.(LoginThrottledError).Locked, ShouldBeFalse)
+			So(err.(LoginThrottledError).RetryAfter, ShouldHappenAfter, time.Now().Add(59*time.Minute))
+			Convey("Unlocking the login should allow it to log in again", func() {
+				registry.Unlock(context.Background(), "ADMIN")
+				_, err := registry.Authenticate("admin", "secret", nil)
+				So(err, ShouldBeNil)
+			})
+		})
+		Convey("The account should be locked after the maximum number of failures", func() {
+			store.attempts[memoryAttemptKey{key: loginKey("admin")}] = loginAttempt{failures: 5, last: time.Now()}
+			_, err := registry.Authenticate("admin", "secret", nil)
+			So(err.(LoginThrottledError).Locked, ShouldBeTrue)
+			So(err.Error(), ShouldStartWith, "Too many failed login attempts for user admin")
+		})
+		Convey("Old failures should be forgotten", func() {
+			store.attempts[memoryAttemptKey{key: loginKey("admin")}] = loginAttempt{failures: 5, last: time.Now().Add(-72 * time.Hour)}
+			_, err := registry.Authenticate("admin", "wrong", nil)
+			So(err, ShouldEqual, InvalidCredentialsError("admin"))
+			failures, _ := store.Failures(context.Background(), loginKey("admin"))
+			So(failures, ShouldEqual, 1)
+		})
+		Convey("Failures should be counted in the database of the authentication context", func() {
+			_, err := registry.Authenticate("admin", "wrong", types.NewContext().WithKey(DatabaseKey, "other"))
+			So(err, ShouldEqual, InvalidCredentialsError("admin"))
+			failures, _ := store.Failures(ContextWithDatabaseName(context.Background(), "other"), loginKey("admin"))
+			So(failures, ShouldEqual, 1)
+			failures, _ = store.Failures(context.Background(), loginKey("admin"))
+			So(failures, ShouldEqual, 0)
+		})
+		Convey("Addresses with too many failures should be blocked", func() {
+			for _, login := range []string{"alice", "bob", "carol"} {
+				_, err := registry.Authenticate(login, "secret", ctx)
+				So(err, ShouldHaveSameTypeAs, UserNotFoundError(""))
+			}
+			_, err := registry.Authenticate("admin", "secret", ctx)
+			So(err.(LoginThrottledError).Locked, ShouldBeTrue)
+			uid, err := registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 1)
+			registry.UnlockAddress(context.Background(), "192.0.2.1")
+			_, err = registry.Authenticate("admin", "secret", ctx)
+			So(err, ShouldBeNil)
+		})
+		Convey("Attempts counted since the check should be taken into account", func() {
+			registry.SetThrottling(policy, racingAttemptStore{store})
+			store.attempts[memoryAttemptKey{key: loginKey("admin")}] = loginAttempt{failures: 1, last: time.Now()}
+			_, err := registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldBeNil)
+			store.attempts[memoryAttemptKey{key: loginKey("admin")}] = loginAttempt{failures: 2, last: time.Now()}
+			_, err = registry.Authenticate("admin", "secret", nil)
+			So(err, ShouldHaveSameTypeAs, LoginThrottledError{})
+			So(err.(LoginThrottledError).Locked, ShouldBeFalse)
+			failures, _ := store.Failures(context.Background(), loginKey("admin"))
+			So(failures, ShouldEqual, 4)
+		})
+		Convey("Concurrent attempts should not exceed the throttling policy", func() {
+			var checks int32
+			registry := new(AuthBackendRegistry)
+			registry.RegisterBackend(countingAuthBackend{checks: &checks})
+			registry.SetThrottling(policy, store)
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					registry.Authenticate("admin", "wrong", nil)
+				}()
+			}
+			wg.Wait()
+			So(checks, ShouldEqual, policy.FreeFailures+1)
+		})
+		Convey("Failed second factor verifications should be throttled", func() {
+			registry.SetSecondFactorBackend(simpleSecondFactorBackend{1: "123456"})
+			for i := 0; i < 3; i++ {
+				So(registry.VerifySecondFactor(context.Background(), 1, "admin", "000000"), ShouldEqual, InvalidCredentialsError("admin"))
+			}
+			So(registry.VerifySecondFactor(context.Background(), 1, "admin", "123456"), ShouldHaveSameTypeAs, LoginThrottledError{})
+		})
+	})
+}
+
+type simpleMembershipStore struct {
+	memberships map[int64]map[string]bool
+	loads       int
+	failing     bool
+	lastCtx     context.Context
+}
+
+type ctxKey struct{}
+
+func (s *simpleMembershipStore) Load(ctx context.Context, uid int64) ([]string, error) {
+	s.loads++
+	s.lastCtx = ctx
+	if s.failing {
+		return nil, errors.New("store unavailable")
+	}
+	var res []string
+	for groupID := range s.memberships[uid] {
+		res = append(res, groupID)
+	}
+	return res, nil
+}
+
+func (s *simpleMembershipStore) Add(ctx context.Context, uid int64, groupID string) error {
+	s.lastCtx = ctx
+	if s.memberships[uid] == nil {
+		s.memberships[uid] = make(map[string]bool)
+	}
+	s.memberships[uid][groupID] = true
+	return nil
+}
+
+func (s *simpleMembershipStore) Remove(ctx context.Context, uid int64, groupID string) error {
+	delete(s.memberships[uid], groupID)
+	return nil
+}
+
+func (s *simpleMembershipStore) RemoveAll(ctx context.Context, uid int64) error {
+	delete(s.memberships, uid)
+	return nil
+}
+
+func TestMembershipStore(t *testing.T) {
+	Convey("Testing group memberships persisted in a store", t, func() {
+		gc := NewGroupCollection()
+		parent := gc.NewGroup("store_parent", "Parent")
+		child := gc.NewGroup("store_child", "Child", parent)
+		gc.AddMembership(2, parent)
+		store := &simpleMembershipStore{
+			memberships: map[int64]map[string]bool{
+				3: {"store_child": true, "unknown": true},
+			},
+		}
+		gc.SetMembershipStore(store)
+		Convey("Memberships should be loaded lazily from the store", func() {
+			So(gc.HasMembership(2, parent), ShouldBeFalse)
+			So(store.loads, ShouldEqual, 1)
+			So(gc.HasMembership(3, child), ShouldBeTrue)
+			So(gc.HasMembership(3, parent), ShouldBeTrue)
+			So(gc.UserGroups(3)[parent], ShouldEqual, InheritedGroup)
+			So(store.loads, ShouldEqual, 2)
+		})
+		Convey("Membership changes should be saved in the store", func() {
+			gc.AddMembership(4, child)
+			So(store.memberships[4], ShouldResemble, map[string]bool{"store_child": true})
+			So(gc.HasMembership(4, parent), ShouldBeTrue)
+			gc.RemoveMembership(4, child)
+			So(store.memberships[4], ShouldBeEmpty)
+			So(gc.HasMembership(4, parent), ShouldBeFalse)
+			gc.AddMembership(4, parent)
+			gc.RemoveAllMembershipsForUser(4)
+			So(store.memberships[4], ShouldBeNil)
+			So(gc.HasMembership(4, parent), ShouldBeFalse)
+		})
+		Convey("Invalidated memberships should be loaded again", func() {
+			So(gc.HasMembership(5, parent), ShouldBeFalse)
+			store.Add(context.Background(), 5, "store_parent")
+			So(gc.HasMembership(5, parent), ShouldBeFalse)
+			gc.Invalidate(5)
+			So(gc.HasMembership(5, parent), ShouldBeTrue)
+			store.Remove(context.Background(), 5, "store_parent")
+			gc.InvalidateAll()
+			So(gc.HasMembership(5, parent), ShouldBeFalse)
+		})
+		Convey("The context of the caller should be given to the store", func() {
+			ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+			So(gc.HasMembershipContext(ctx, 6, parent), ShouldBeFalse)
+			So(store.lastCtx.Value(ctxKey{}), ShouldEqual, "caller")
+			store.lastCtx = nil
+			gc.AddMembershipContext(ctx, 6, parent)
+			So(store.lastCtx.Value(ctxKey{}), ShouldEqual, "caller")
+		})
+		Convey("Memberships should be kept per database", func() {
+			other := ContextWithDatabaseName(context.Background(), "other")
+			So(gc.HasMembership(5, parent), ShouldBeFalse)
+			store.Add(context.Background(), 5, "store_parent")
+			So(gc.HasMembershipContext(other, 5, parent), ShouldBeTrue)
+			So(gc.HasMembership(5, parent), ShouldBeFalse)
+			gc.InvalidateAllContext(other)
+			So(gc.HasMembership(5, parent), ShouldBeFalse)
+			gc.InvalidateAllContext(context.Background())
+			So(gc.HasMembership(5, parent), ShouldBeTrue)
+		})
+		Convey("The default database should be the database of contexts without database", func() {
+			SetDefaultDatabaseName("main")
+			So(gc.HasMembership(5, parent), ShouldBeFalse)
+			store.Add(context.Background(), 5, "store_parent")
+			So(gc.HasMembershipContext(ContextWithDatabaseName(context.Background(), "main"), 5, parent), ShouldBeFalse)
+			SetDefaultDatabaseName("")
+		})
+		Convey("Failed loads should not be retried before the retry delay", func() {
+			store.failing = true
+			So(gc.HasMembership(3, child), ShouldBeFalse)
+			So(gc.HasMembership(3, child), ShouldBeFalse)
+			So(store.loads, ShouldEqual, 1)
+			store.failing = false
+			gc.Invalidate(3)
+			So(gc.HasMembership(3, child), ShouldBeTrue)
+			So(store.loads, ShouldEqual, 2)
+		})
+	})
+}
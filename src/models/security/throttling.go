@@ -0,0 +1,291 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RemoteAddrKey is the key of the context given to Authenticate
+	// that holds the IP address of the client.
+	RemoteAddrKey = "remote_addr"
+	// LoginKeyPrefix and AddressKeyPrefix are the prefixes of the keys
+	// of the failed attempts of logins and addresses in a LoginAttemptStore.
+	LoginKeyPrefix   = "login:"
+	AddressKeyPrefix = "addr:"
+)
+
+// A LoginThrottledError is returned by AuthBackendRegistry.Authenticate when
+// the login or the address of the client has too many recent failures. The
+// credentials are not checked until RetryAfter.
+//
+// Locked is true if the account is locked after reaching the maximum number
+// of failures, and false if the attempt is only delayed.
+type LoginThrottledError struct {
+	Login      string
+	RetryAfter time.Time
+	Locked     bool
+}
+
+// Error returns the error message
+func (lte LoginThrottledError) Error() string {
+	return fmt.Sprintf("Too many failed login attempts for user %s, retry after %s", lte.Login, lte.RetryAfter.Format(time.RFC3339))
+}
+
+// A ThrottlingPolicy defines how failed login attempts
+// slow down and eventually lock further attempts.
+type ThrottlingPolicy struct {
+	// FreeFailures is the number of failures of a login after which
+	// further attempts are delayed.
+	FreeFailures int
+	// Delay is the delay after the first delayed failure. It is doubled
+	// at each further failure, up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// MaxFailures is the number of failures after which the account is
+	// locked for LockoutDuration. Zero disables lockouts.
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// MaxAddressFailures is the number of failures from a single address,
+	// whatever the login, after which the address is blocked for
+	// LockoutDuration. Zero disables address blocking.
+	MaxAddressFailures int
+	// Window is the duration after the last failure after
+	// which the failures of a login or address are forgotten.
+	Window time.Duration
+}
+
+// DefaultThrottlingPolicy is the throttling policy of AuthenticationRegistry
+var DefaultThrottlingPolicy = ThrottlingPolicy{
+	FreeFailures:       3,
+	Delay:              time.Second,
+	MaxDelay:           30 * time.Second,
+	MaxFailures:        10,
+	LockoutDuration:    15 * time.Minute,
+	MaxAddressFailures: 100,
+	Window:             time.Hour,
+}
+
+// blockedUntil returns the time until which attempts are refused after the
+// given number of failures, the last of which happened at last. max is the
+// number of failures after which attempts are locked.
+func (tp ThrottlingPolicy) blockedUntil(failures int, last time.Time, max int) (time.Time, bool) {
+	switch {
+	case max > 0 && failures >= max:
+		return last.Add(tp.LockoutDuration), true
+	case failures <= tp.FreeFailures || tp.Delay == 0:
+		return time.Time{}, false
+	}
+	delay := tp.Delay
+	for i := tp.FreeFailures + 1; i < failures && (tp.MaxDelay == 0 || delay < tp.MaxDelay); i++ {
+		delay *= 2
+	}
+	if tp.MaxDelay > 0 && delay > tp.MaxDelay {
+		delay = tp.MaxDelay
+	}
+	return last.Add(delay), false
+}
+
+// A LoginAttemptStore records the failed login attempts. Keys identify
+// either a login or an address.
+//
+// Stores shared between the processes of the application allow failures
+// to be counted whatever the process that served the attempts. They should
+// record them in the database of the given context.Context, as given by
+// ContextWithDatabaseName.
+type LoginAttemptStore interface {
+	// Failures returns the number of failures recorded for
+	// the given key and the time of the last one.
+	Failures(ctx context.Context, key string) (int, time.Time)
+	// RecordFailure records a failure at the given time for the given key
+	// and returns the number of failures, which starts again from one if
+	// the last failure is older than window.
+	RecordFailure(ctx context.Context, key string, t time.Time, window time.Duration) int
+	// Reset forgets the failures of the given key
+	Reset(ctx context.Context, key string)
+}
+
+// loginAttempt holds the failures of a key in a MemoryAttemptStore
+type loginAttempt struct {
+	failures int
+	last     time.Time
+}
+
+// A memoryAttemptKey identifies the failures of a key in a database
+type memoryAttemptKey struct {
+	database string
+	key      string
+}
+
+// A MemoryAttemptStore is a LoginAttemptStore that keeps
+// the failed attempts in the memory of the process.
+type MemoryAttemptStore struct {
+	sync.Mutex
+	attempts map[memoryAttemptKey]loginAttempt
+}
+
+// Failures returns the number of failures recorded for
+// the given key and the time of the last one.
+func (mas *MemoryAttemptStore) Failures(ctx context.Context, key string) (int, time.Time) {
+	mas.Lock()
+	defer mas.Unlock()
+	attempt := mas.attempts[memoryAttemptKey{database: databaseName(ctx), key: key}]
+	return attempt.failures, attempt.last
+}
+
+// RecordFailure records a failure at the given time for the
+// given key and returns the number of failures.
+func (mas *MemoryAttemptStore) RecordFailure(ctx context.Context, key string, t time.Time, window time.Duration) int {
+	mas.Lock()
+	defer mas.Unlock()
+	mKey := memoryAttemptKey{database: databaseName(ctx), key: key}
+	attempt := mas.attempts[mKey]
+	if t.Sub(attempt.last) > window {
+		attempt.failures = 0
+	}
+	attempt.failures++
+	attempt.last = t
+	mas.attempts[mKey] = attempt
+	return attempt.failures
+}
+
+// Reset forgets the failures of the given key
+func (mas *MemoryAttemptStore) Reset(ctx context.Context, key string) {
+	mas.Lock()
+	defer mas.Unlock()
+	delete(mas.attempts, memoryAttemptKey{database: databaseName(ctx), key: key})
+}
+
+// NewMemoryAttemptStore returns a new empty MemoryAttemptStore
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		attempts: make(map[memoryAttemptKey]loginAttempt),
+	}
+}
+
+var _ LoginAttemptStore = new(MemoryAttemptStore)
+
+// loginKey returns the key of the attempts store for the given login.
+// Logins are not case sensitive so that changing the case of a login
+// does not reset its failures.
+func loginKey(login string) string {
+	return LoginKeyPrefix + strings.ToLower(login)
+}
+
+// addressKey returns the key of the attempts store for the given address
+func addressKey(addr string) string {
+	return AddressKeyPrefix + addr
+}
+
+// SetThrottling sets the policy and the store with which failed
+// login attempts are throttled by this registry.
+func (ar *AuthBackendRegistry) SetThrottling(policy ThrottlingPolicy, store LoginAttemptStore) {
+	ar.throttlingPolicy = policy
+	ar.attempts = store
+}
+
+// checkThrottling returns a LoginThrottledError if attempts
+// for the given login or from the given address are blocked.
+//
+// It also returns the number of failures of the login.
+func (ar *AuthBackendRegistry) checkThrottling(ctx context.Context, login, addr string) (int, error) {
+	if ar.attempts == nil {
+		return 0, nil
+	}
+	now := time.Now()
+	loginFailures, last := ar.attempts.Failures(ctx, loginKey(login))
+	until, locked := ar.throttlingPolicy.blockedUntil(loginFailures, last, ar.throttlingPolicy.MaxFailures)
+	if addr != "" && !now.Before(until) {
+		failures, last := ar.attempts.Failures(ctx, addressKey(addr))
+		if ar.throttlingPolicy.MaxAddressFailures > 0 && failures >= ar.throttlingPolicy.MaxAddressFailures {
+			until, locked = last.Add(ar.throttlingPolicy.LockoutDuration), true
+		}
+	}
+	if now.Before(until) {
+		return loginFailures, ar.refuseAttempt(login, addr, until, locked)
+	}
+	return loginFailures, nil
+}
+
+// reserveAttempt checks the throttling of the given login and address and
+// counts the attempt as a failure of the login before its credentials are
+// checked. It returns the number of failures of the login, including this
+// attempt.
+//
+// Attempts of the same login that were counted between the check and the
+// increment are considered as failures that just happened, so that checking
+// the throttling and counting the attempt is atomic.
+func (ar *AuthBackendRegistry) reserveAttempt(ctx context.Context, login, addr string) (int, error) {
+	failures, err := ar.checkThrottling(ctx, login, addr)
+	if err != nil || ar.attempts == nil {
+		return 0, err
+	}
+	now := time.Now()
+	policy := ar.throttlingPolicy
+	count := ar.attempts.RecordFailure(ctx, loginKey(login), now, policy.Window)
+	if count <= failures+1 {
+		return count, nil
+	}
+	until, locked := policy.blockedUntil(count-1, now, policy.MaxFailures)
+	if now.Before(until) {
+		return count, ar.refuseAttempt(login, addr, until, locked)
+	}
+	return count, nil
+}
+
+// refuseAttempt logs and returns the LoginThrottledError of an
+// attempt of the given login from the given address.
+func (ar *AuthBackendRegistry) refuseAttempt(login, addr string, until time.Time, locked bool) error {
+	log.Info("Login attempt refused", "event", "login_throttled", "login", login, "remote_addr", addr, "retry_after", until, "locked", locked)
+	return LoginThrottledError{Login: login, RetryAfter: until, Locked: locked}
+}
+
+// recordFailure records a failed login attempt for the given login from the
+// given address. failures is the number of failures of the login returned by
+// reserveAttempt, which already counted the attempt.
+func (ar *AuthBackendRegistry) recordFailure(ctx context.Context, login, addr string, failures int, err error) {
+	log.Warn("Login failed", "event", "login_failure", "login", login, "remote_addr", addr, "error", err)
+	if ar.attempts == nil {
+		return
+	}
+	now := time.Now()
+	policy := ar.throttlingPolicy
+	if policy.MaxFailures > 0 && failures == policy.MaxFailures {
+		log.Warn("Account locked", "event", "account_locked", "login", login, "remote_addr", addr,
+			"failures", failures, "until", now.Add(policy.LockoutDuration))
+	}
+	if addr == "" {
+		return
+	}
+	failures = ar.attempts.RecordFailure(ctx, addressKey(addr), now, policy.Window)
+	if policy.MaxAddressFailures > 0 && failures == policy.MaxAddressFailures {
+		log.Warn("Address blocked", "event", "address_blocked", "remote_addr", addr,
+			"failures", failures, "until", now.Add(policy.LockoutDuration))
+	}
+}
+
+// Unlock forgets the failed login attempts of the given login in the
+// database of ctx, so that its user can log in again immediately.
+func (ar *AuthBackendRegistry) Unlock(ctx context.Context, login string) {
+	if ar.attempts == nil {
+		return
+	}
+	ar.attempts.Reset(ctx, loginKey(login))
+	log.Info("Account unlocked", "event", "account_unlocked", "login", login)
+}
+
+// UnlockAddress forgets the failed login attempts from
+// the given address in the database of ctx.
+func (ar *AuthBackendRegistry) UnlockAddress(ctx context.Context, addr string) {
+	if ar.attempts == nil {
+		return
+	}
+	ar.attempts.Reset(ctx, addressKey(addr))
+	log.Info("Address unlocked", "event", "address_unlocked", "remote_addr", addr)
+}
@@ -160,6 +160,11 @@ func TestModelDeclaration(t *testing.T) {
 				rc.Get(rc.Model().FieldName("Profile")).(*RecordCollection).Set(Registry.MustGet("Profile").FieldName("City"), value)
 			})
 
+		userModel.NewMethod("InternalReference",
+			func(rc *RecordCollection) string {
+				return fmt.Sprintf("USR-%d", rc.Ids()[0])
+			}).Private()
+
 		userModel.NewMethod("ComputeNum",
 			func(rc *RecordCollection) *ModelData {
 				return NewModelData(rc.Model())
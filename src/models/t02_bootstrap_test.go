@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
@@ -140,6 +141,11 @@ func TestFieldModification(t *testing.T) {
 		nameField.AddContexts(userDependent)
 		lastUpdateShouldResemble(nameField, "contexts_add", userDependent)
 		nameField.SetContexts(nil)
+		nameField.SetGroups(security.GroupAdmin)
+		lastUpdateShouldResemble(nameField, "groups", []*security.Group{security.GroupAdmin})
+		nameField.AddGroups(security.GroupEveryone)
+		lastUpdateShouldResemble(nameField, "groups_add", []*security.Group{security.GroupEveryone})
+		nameField.SetGroups()
 		nameField.SetOnchange(nil)
 		nameField.SetOnchange(Registry.MustGet("User").Methods().MustGet("OnChangeName"))
 		nameField.SetOnchangeWarning(Registry.MustGet("User").Methods().MustGet("OnChangeNameWarning"))
@@ -362,14 +368,14 @@ func TestIllegalMethods(t *testing.T) {
 
 func TestBootStrap(t *testing.T) {
 	// Creating a dummy table to check that it is correctly removed by Bootstrap
-	dbExecuteNoTx("CREATE TABLE IF NOT EXISTS shouldbedeleted (id serial NOT NULL PRIMARY KEY)")
+	dbExecuteNoTx(db, "CREATE TABLE IF NOT EXISTS shouldbedeleted (id serial NOT NULL PRIMARY KEY)")
 
 	// Creating a manual sequence that must be loaded in the registry
-	dbExecuteNoTx(`CREATE SEQUENCE test_manseq INCREMENT BY 5 START WITH 1`)
+	dbExecuteNoTx(db, `CREATE SEQUENCE test_manseq INCREMENT BY 5 START WITH 1`)
 
 	Convey("Database creation should run fine", t, func() {
 		Convey("Dummy table should exist", func() {
-			So(TestAdapter.tables(), ShouldContainKey, "shouldbedeleted")
+			So(TestAdapter.tables(db), ShouldContainKey, "shouldbedeleted")
 		})
 		Convey("Bootstrap should not panic", func() {
 			BootStrap()
@@ -386,7 +392,7 @@ func TestBootStrap(t *testing.T) {
 		})
 		Convey("Creating SQL view should run fine", func() {
 			So(func() {
-				dbExecuteNoTx(`DROP VIEW IF EXISTS user_view;
+				dbExecuteNoTx(db, `DROP VIEW IF EXISTS user_view;
 					CREATE VIEW user_view AS (
 						SELECT u.id, u.name, p.city, u.active
 						FROM "user" u
@@ -395,7 +401,7 @@ func TestBootStrap(t *testing.T) {
 			}, ShouldNotPanic)
 		})
 		Convey("All models should have a DB table", func() {
-			dbTables := TestAdapter.tables()
+			dbTables := TestAdapter.tables(db)
 			for tableName, mi := range Registry.registryByTableName {
 				if mi.IsMixin() || mi.IsManual() {
 					continue
@@ -404,21 +410,21 @@ func TestBootStrap(t *testing.T) {
 			}
 		})
 		Convey("All DB tables should have a model", func() {
-			for dbTable := range TestAdapter.tables() {
+			for dbTable := range TestAdapter.tables(db) {
 				So(Registry.registryByTableName, ShouldContainKey, dbTable)
 			}
 		})
 		Convey("Table constraints should have been created", func() {
-			So(TestAdapter.constraints("%_mancon"), ShouldHaveLength, 1)
-			So(TestAdapter.constraints("%_mancon")[0], ShouldEqual, "nums_premium_user_mancon")
+			So(TestAdapter.constraints(db, "%_mancon"), ShouldHaveLength, 1)
+			So(TestAdapter.constraints(db, "%_mancon")[0], ShouldEqual, "nums_premium_user_mancon")
 		})
 		Convey("Boot Sequence should be created", func() {
-			So(TestAdapter.sequences("%_bootseq"), ShouldHaveLength, 1)
-			So(TestAdapter.sequences("%_bootseq")[0].Name, ShouldEqual, "test_sequence_bootseq")
+			So(TestAdapter.sequences(db, "%_bootseq"), ShouldHaveLength, 1)
+			So(TestAdapter.sequences(db, "%_bootseq")[0].Name, ShouldEqual, "test_sequence_bootseq")
 		})
 		Convey("Manual sequences should be loaded in registry", func() {
-			So(TestAdapter.sequences("%_manseq"), ShouldHaveLength, 1)
-			So(TestAdapter.sequences("%_manseq")[0].Name, ShouldEqual, "test_manseq")
+			So(TestAdapter.sequences(db, "%_manseq"), ShouldHaveLength, 1)
+			So(TestAdapter.sequences(db, "%_manseq")[0].Name, ShouldEqual, "test_manseq")
 			seq, ok := Registry.GetSequence("Test")
 			So(ok, ShouldBeTrue)
 			So(seq.JSON, ShouldEqual, "test_manseq")
@@ -470,7 +476,7 @@ func TestBootStrap(t *testing.T) {
 			if mi.IsMixin() || mi.IsManual() {
 				continue
 			}
-			dbExecuteNoTx(fmt.Sprintf(`TRUNCATE TABLE "%s" CASCADE`, tn))
+			dbExecuteNoTx(db, fmt.Sprintf(`TRUNCATE TABLE "%s" CASCADE`, tn))
 		}
 	})
 }
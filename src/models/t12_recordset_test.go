@@ -15,9 +15,11 @@
 package models
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -182,8 +184,10 @@ func TestCreateRecordSet(t *testing.T) {
 			})
 			env.Pool("User").Call("Create", userRobData)
 		})
-		So(err, ShouldNotBeNil)
-		So(err.Error(), ShouldStartWith, "pq: Premium users must have positive nums")
+		var constraintErr exceptions.ConstraintError
+		So(errors.As(err, &constraintErr), ShouldBeTrue)
+		So(constraintErr.Constraint, ShouldEqual, "nums_premium_user_mancon")
+		So(err.Error(), ShouldEqual, "Premium users must have positive nums")
 	})
 	group1 := security.Registry.NewGroup("group1", "Group 1")
 	Convey("Testing access control list on creation (create only)", t, func() {
@@ -752,7 +756,7 @@ func TestUpdateRecordSet(t *testing.T) {
 			userModel := Registry.MustGet("User")
 			userWill := env.Pool("User").Search(env.Pool("User").Model().Field(email).Equals("will.smith@example.com"))
 			userWill.Call("Write", NewModelData(userModel).Set(nums, 0).Set(isPremium, true))
-		}).Error(), ShouldStartWith, "pq: Premium users must have positive nums")
+		}).Error(), ShouldEqual, "Premium users must have positive nums")
 	})
 
 	group1 := security.Registry.NewGroup("group1", "Group 1")
@@ -15,6 +15,7 @@
 package models
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -55,6 +56,31 @@ func TestMethods(t *testing.T) {
 				So(res1.FieldMap, ShouldContainKey, "decorated_name")
 				So(res1.FieldMap["decorated_name"], ShouldEqual, "User: Jane A. Smith [<jane.smith@example.com>]")
 			})
+			Convey("Calling methods through RPC", func() {
+				users := env.Pool("User")
+				users = users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+				res := users.CallRPC("PrefixedUser", "Prefix")
+				So(res.([]string)[0], ShouldEqual, "Prefix: Jane A. Smith [<jane.smith@example.com>]")
+				So(users.Model().Methods().MustGet("PrefixedUser").IsPublic(), ShouldBeTrue)
+				So(users.Model().Methods().MustGet("UpdateCity").IsPublic(), ShouldBeTrue)
+				So(users.Model().Methods().MustGet("InternalReference").IsPublic(), ShouldBeFalse)
+				So(users.Model().Methods().MustGet("Sudo").IsPublic(), ShouldBeFalse)
+				So(users.CheckRPCAccess("InternalReference", true), ShouldBeFalse)
+				So(users.CheckRPCAccess("UnknownMethod", true), ShouldBeFalse)
+				So(func() { users.CallRPC("InternalReference") }, ShouldPanic)
+				So(func() { users.CallRPC("Sudo") }, ShouldPanic)
+				So(func() { users.CallRPC("UnknownMethod") }, ShouldPanic)
+				So(users.Call("InternalReference"), ShouldEqual, fmt.Sprintf("USR-%d", users.Ids()[0]))
+			})
+			Convey("Listing allowed groups of methods", func() {
+				methods := Registry.MustGet("User").Methods()
+				So(methods.MustGet("PrefixedUser").AllowedGroups(), ShouldContain, security.GroupEveryone)
+				So(methods.MustGet("Create").AllowedGroups(), ShouldContain, security.GroupAdmin)
+				So(methods.MustGet("Create").AllowedGroups(), ShouldNotContain, security.GroupEveryone)
+				allMethods := methods.AllMethods()
+				So(len(allMethods), ShouldBeGreaterThan, 1)
+				So(allMethods[0].Name() < allMethods[1].Name(), ShouldBeTrue)
+			})
 		}), ShouldBeNil)
 	})
 }
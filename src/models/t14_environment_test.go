@@ -15,10 +15,15 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -30,7 +35,7 @@ func TestEnvironment(t *testing.T) {
 			users := env.Pool("User")
 			userJane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
 			Convey("Checking WithEnv", func() {
-				env2 := newEnvironment(2)
+				env2 := newEnvironment(context.Background(), DefaultDatabase(), 2, false)
 				userJane1 := userJane.Call("WithEnv", env2).(RecordSet).Collection()
 				So(userJane1.Env().Uid(), ShouldEqual, 2)
 				So(userJane.Env().Uid(), ShouldEqual, 1)
@@ -267,7 +272,7 @@ X2M Links
 	Convey("Testing db error retries", t, func() {
 		Convey("ExecuteInNewEnvironment should retry db errors up to max retries", func() {
 			var retries uint8
-			So(doExecuteInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doExecuteInNewEnvironment(context.Background(), security.SuperUserID, 0, false, func(env Environment) {
 				retries++
 				panic(&pq.Error{Code: "40001"})
 			}), ShouldNotBeNil)
@@ -275,7 +280,7 @@ X2M Links
 		})
 		Convey("ExecuteInNewEnvironment should retry db errors and stop when ok", func() {
 			var retries uint8
-			So(doExecuteInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doExecuteInNewEnvironment(context.Background(), security.SuperUserID, 0, false, func(env Environment) {
 				retries++
 				if retries < 3 {
 					panic(&pq.Error{Code: "40001"})
@@ -285,7 +290,7 @@ X2M Links
 		})
 		Convey("SimulateInNewEnvironment should retry db errors up to max retries", func() {
 			var retries uint8
-			So(doSimulateInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doSimulateInNewEnvironment(context.Background(), security.SuperUserID, 0, func(env Environment) {
 				retries++
 				panic(&pq.Error{Code: "40001"})
 			}), ShouldNotBeNil)
@@ -293,7 +298,7 @@ X2M Links
 		})
 		Convey("SimulateInNewEnvironment should retry db errors and stop when ok", func() {
 			var retries uint8
-			So(doSimulateInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doSimulateInNewEnvironment(context.Background(), security.SuperUserID, 0, func(env Environment) {
 				retries++
 				if retries < 3 {
 					panic(&pq.Error{Code: "40001"})
@@ -302,4 +307,466 @@ X2M Links
 			So(retries, ShouldEqual, 3)
 		})
 	})
+	Convey("Testing Environment database", t, func() {
+		Convey("The default database should be connected", func() {
+			So(DefaultDatabase(), ShouldNotBeNil)
+			So(DatabaseFromContext(context.Background()), ShouldEqual, DefaultDatabase())
+			So(ConnectDatabase(DefaultDatabase().Name()), ShouldEqual, DefaultDatabase())
+			So(ConnectedDatabases(), ShouldContain, DefaultDatabase())
+			names, err := ListDatabases()
+			So(err, ShouldBeNil)
+			So(names, ShouldContain, DefaultDatabase().Name())
+		})
+		Convey("The Environment should be bound to the database of its context", func() {
+			ctx := ContextWithDatabase(context.Background(), DefaultDatabase())
+			So(SimulateInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				So(env.Database(), ShouldEqual, DefaultDatabase())
+			}), ShouldBeNil)
+		})
+		Convey("A nil database in the context should return ErrNoDatabase", func() {
+			ctx := ContextWithDatabase(context.Background(), nil)
+			var called bool
+			err := ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				called = true
+			})
+			So(err, ShouldEqual, ErrNoDatabase)
+			So(SimulateInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				called = true
+			}), ShouldEqual, ErrNoDatabase)
+			So(called, ShouldBeFalse)
+		})
+		Convey("Acquiring databases should close idle databases", func() {
+			params := DefaultDatabase().Params()
+			newDatabase := func(name string, lastUsed time.Time) *Database {
+				conn, err := sqlx.Open(params.Driver, params.ConnectionString())
+				So(err, ShouldBeNil)
+				database := &Database{name: name, db: conn, params: params, lastUsed: lastUsed}
+				databases.Lock()
+				databases.byName[name] = database
+				databases.Unlock()
+				return database
+			}
+			setLimits := func(max int, timeout time.Duration) {
+				databases.Lock()
+				databases.defaultDB.params.MaxDatabases = max
+				databases.defaultDB.params.DatabaseIdleTimeout = timeout
+				databases.Unlock()
+			}
+			var closed []string
+			OnDatabaseClose(func(d *Database) {
+				closed = append(closed, d.Name())
+			})
+			Convey("Databases idle for longer than the timeout should be closed", func() {
+				newDatabase("idle_test", time.Now().Add(-time.Hour))
+				newDatabase("recent_test", time.Now())
+				setLimits(0, time.Minute)
+				database, err := AcquireDatabase(DefaultDatabase().Name())
+				So(err, ShouldBeNil)
+				So(database, ShouldEqual, DefaultDatabase())
+				database.Release()
+				_, ok := GetDatabase("idle_test")
+				So(ok, ShouldBeFalse)
+				_, ok = GetDatabase("recent_test")
+				So(ok, ShouldBeTrue)
+				So(closed, ShouldResemble, []string{"idle_test"})
+			})
+			Convey("Databases serving requests should not be closed", func() {
+				busy := newDatabase("busy_test", time.Now().Add(-time.Hour))
+				setLimits(1, time.Minute)
+				database, err := AcquireDatabase("busy_test")
+				So(err, ShouldBeNil)
+				So(database, ShouldEqual, busy)
+				_, err = AcquireDatabase("other_test")
+				So(err, ShouldEqual, ErrTooManyDatabases)
+				_, ok := GetDatabase("busy_test")
+				So(ok, ShouldBeTrue)
+				database.Release()
+				So(closed, ShouldBeEmpty)
+			})
+			Convey("Databases connected with ConnectDatabase should not be closed", func() {
+				pinned := newDatabase("pinned_test", time.Now().Add(-time.Hour))
+				databases.Lock()
+				pinned.pinned = true
+				databases.Unlock()
+				setLimits(1, time.Minute)
+				database, err := AcquireDatabase(DefaultDatabase().Name())
+				So(err, ShouldBeNil)
+				database.Release()
+				_, err = AcquireDatabase("other_test")
+				So(err, ShouldEqual, ErrTooManyDatabases)
+				_, ok := GetDatabase("pinned_test")
+				So(ok, ShouldBeTrue)
+				So(closed, ShouldBeEmpty)
+			})
+			Convey("Failed connections should return an error without blocking the other databases", func() {
+				_, err := AcquireDatabase("hexya_missing_test_database")
+				So(err, ShouldNotBeNil)
+				_, ok := GetDatabase("hexya_missing_test_database")
+				So(ok, ShouldBeFalse)
+				database, err := AcquireDatabase(DefaultDatabase().Name())
+				So(err, ShouldBeNil)
+				So(database, ShouldEqual, DefaultDatabase())
+				database.Release()
+				So(ConnectedDatabases(), ShouldContain, DefaultDatabase())
+			})
+			Reset(func() {
+				databases.Lock()
+				for _, name := range []string{"idle_test", "recent_test", "busy_test", "pinned_test"} {
+					delete(databases.byName, name)
+				}
+				databases.defaultDB.params = params
+				databases.closeHooks = nil
+				databases.Unlock()
+			})
+		})
+	})
+	Convey("Testing read only environments", t, func() {
+		Convey("Records should be readable in a read only environment", func() {
+			So(ExecuteInReadOnlyEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.ReadOnly(), ShouldBeTrue)
+				So(env.Pool("User").SearchAll().IsNotEmpty(), ShouldBeTrue)
+			}), ShouldBeNil)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.ReadOnly(), ShouldBeFalse)
+			}), ShouldBeNil)
+		})
+		Convey("Modifying records in a read only environment should return an AccessError", func() {
+			var accessErr exceptions.AccessError
+			err := ExecuteInReadOnlyEnvironment(security.SuperUserID, func(env Environment) {
+				users := env.Pool("User")
+				users.Call("Create", NewModelData(users.Model()).Set(Name, "Read Only User"))
+			})
+			So(errors.As(err, &accessErr), ShouldBeTrue)
+			err = ExecuteInReadOnlyEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("User").SearchAll().Limit(1).Call("Write", NewModelData(env.Pool("User").Model()).Set(Name, "Read Only User"))
+			})
+			So(errors.As(err, &accessErr), ShouldBeTrue)
+			err = ExecuteInReadOnlyEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("User").SearchAll().Limit(1).Call("Unlink")
+			})
+			So(errors.As(err, &accessErr), ShouldBeTrue)
+			err = ExecuteInReadOnlyEnvironment(security.SuperUserID, func(env Environment) {
+				env.Cr().Execute("UPDATE \"user\" SET name = 'Read Only User'")
+			})
+			So(errors.As(err, &accessErr), ShouldBeTrue)
+		})
+		Convey("Replica parameters should default to the primary ones", func() {
+			params := ConnectionParams{Driver: "postgres", Host: "primary", Port: "5432", User: "hexya", DBName: "hexya",
+				MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: time.Hour,
+				Replicas: []ConnectionParams{{Host: "replica", DBName: "other", MaxIdleConns: 2}}}
+			repParams := replicaParams(params, params.Replicas[0])
+			So(repParams.Host, ShouldEqual, "replica")
+			So(repParams.Port, ShouldEqual, "5432")
+			So(repParams.User, ShouldEqual, "hexya")
+			So(repParams.DBName, ShouldEqual, "hexya")
+			So(repParams.MaxOpenConns, ShouldEqual, 10)
+			So(repParams.MaxIdleConns, ShouldEqual, 2)
+			So(repParams.ConnMaxLifetime, ShouldEqual, time.Hour)
+			So(repParams.Replicas, ShouldBeEmpty)
+		})
+		Convey("Read only transactions on the primary should be serializable", func() {
+			var isolation string
+			So(ExecuteInReadOnlyEnvironment(security.SuperUserID, func(env Environment) {
+				env.Cr().Get(&isolation, "SHOW transaction_isolation")
+			}), ShouldBeNil)
+			So(isolation, ShouldEqual, "serializable")
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.WithAPIScope(&APIScope{ReadOnly: true}).Cr().Get(&isolation, "SHOW transaction_isolation")
+			}), ShouldBeNil)
+			So(isolation, ShouldEqual, "serializable")
+		})
+		Convey("Unreachable replicas should be checked with a timeout", func() {
+			params := DefaultDatabase().Params()
+			params.Replicas = []ConnectionParams{{Host: "10.255.255.1", Port: "5432"}}
+			replicas := connectReplicas(params)
+			defer replicas[0].db.Close()
+			start := time.Now()
+			So(replicas[0].available(0), ShouldBeFalse)
+			So(time.Since(start), ShouldBeLessThan, 2*replicaCheckTimeout)
+			So(replicas[0].checking, ShouldBeFalse)
+		})
+		Convey("Read only transactions should run on available replicas", func() {
+			params := DefaultDatabase().Params()
+			params.Replicas = []ConnectionParams{{}}
+			database := &Database{name: params.DBName, db: DefaultDatabase().db, params: params}
+			database.replicas = connectReplicas(params)
+			defer database.replicas[0].db.Close()
+			So(database.readConn(), ShouldEqual, database.replicas[0].db)
+			So(database.replicas[0].available(time.Hour), ShouldBeTrue)
+			Convey("The primary should be used when no replica is available", func() {
+				database.replicas[0].ok = false
+				database.replicas[0].checkedAt = time.Now()
+				So(database.readConn(), ShouldEqual, database.db)
+			})
+			Convey("Replicas being checked should not be checked again", func() {
+				database.replicas[0].ok = false
+				database.replicas[0].checkedAt = time.Time{}
+				database.replicas[0].checking = true
+				So(database.readConn(), ShouldEqual, database.db)
+				So(database.replicas[0].checkedAt.IsZero(), ShouldBeTrue)
+				database.replicas[0].checking = false
+				So(database.readConn(), ShouldEqual, database.replicas[0].db)
+			})
+		})
+	})
+	Convey("Testing transaction hooks", t, func() {
+		var commits, rollbacks int
+		registerHooks := func(env Environment) {
+			env.OnCommit(func() { commits++ })
+			env.OnRollback(func() { rollbacks++ })
+		}
+		Convey("Commit hooks should be called after a commit", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				registerHooks(env)
+				So(commits, ShouldEqual, 0)
+			})
+			So(err, ShouldBeNil)
+			So(commits, ShouldEqual, 1)
+			So(rollbacks, ShouldEqual, 0)
+		})
+		Convey("Rollback hooks should be called after a rollback", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				registerHooks(env)
+				panic("rollback")
+			})
+			So(err, ShouldNotBeNil)
+			So(commits, ShouldEqual, 0)
+			So(rollbacks, ShouldEqual, 1)
+		})
+		Convey("Rollback hooks should be called after a simulation", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, registerHooks)
+			So(err, ShouldBeNil)
+			So(commits, ShouldEqual, 0)
+			So(rollbacks, ShouldEqual, 1)
+		})
+		Convey("Hooks of retried attempts should be discarded", func() {
+			var attempts int
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				attempts++
+				registerHooks(env)
+				if attempts < 3 {
+					panic(&pq.Error{Code: "40001"})
+				}
+			})
+			So(err, ShouldBeNil)
+			So(attempts, ShouldEqual, 3)
+			So(commits, ShouldEqual, 1)
+			So(rollbacks, ShouldEqual, 0)
+		})
+		Convey("Hooks of the last attempt should be rolled back after all retries", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				registerHooks(env)
+				panic(&pq.Error{Code: "40001"})
+			})
+			var concurrencyErr exceptions.ConcurrencyError
+			So(errors.As(err, &concurrencyErr), ShouldBeTrue)
+			So(commits, ShouldEqual, 0)
+			So(rollbacks, ShouldEqual, 1)
+		})
+		Convey("Hooks of failed savepoints should be rolled back with the savepoint", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				spErr := env.Savepoint(func(env Environment) {
+					registerHooks(env)
+					panic("savepoint failure")
+				})
+				So(spErr, ShouldNotBeNil)
+				So(rollbacks, ShouldEqual, 1)
+				env.Savepoint(registerHooks)
+			})
+			So(err, ShouldBeNil)
+			So(commits, ShouldEqual, 1)
+			So(rollbacks, ShouldEqual, 1)
+		})
+		Convey("Panicking hooks should not prevent the others from being called", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.OnCommit(func() { panic("hook failure") })
+				registerHooks(env)
+			})
+			So(err, ShouldBeNil)
+			So(commits, ShouldEqual, 1)
+		})
+	})
+	Convey("Testing savepoints", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			nameField := users.Model().FieldName("Name")
+			user := users.Call("Create", NewModelData(users.Model()).Set(Name, "Savepoint User")).(RecordSet).Collection()
+			Convey("A successful savepoint should keep its changes", func() {
+				err := env.Savepoint(func(env Environment) {
+					user.WithEnv(env).Set(nameField, "Saved User")
+				})
+				So(err, ShouldBeNil)
+				So(user.Get(nameField), ShouldEqual, "Saved User")
+				So(users.Search(users.Model().Field(Name).Equals("Saved User")).Len(), ShouldEqual, 1)
+			})
+			Convey("A failed savepoint should roll back the database and the cache", func() {
+				err := env.Savepoint(func(env Environment) {
+					user.WithEnv(env).Set(nameField, "Failed User")
+					users.WithEnv(env).Call("Create", NewModelData(users.Model()).Set(Name, "Failed Created User"))
+					panic("bad line")
+				})
+				So(err, ShouldNotBeNil)
+				So(exceptions.Message(err), ShouldEqual, "bad line")
+				So(user.Get(nameField), ShouldEqual, "Savepoint User")
+				So(users.Search(users.Model().Field(Name).In([]string{"Failed User", "Failed Created User"})).IsEmpty(), ShouldBeTrue)
+				Convey("The transaction should go on after a failed savepoint", func() {
+					users.Call("Create", NewModelData(users.Model()).Set(Name, "After Savepoint User"))
+					So(users.Search(users.Model().Field(Name).Equals("After Savepoint User")).Len(), ShouldEqual, 1)
+				})
+			})
+			Convey("Savepoints should be nestable", func() {
+				err := env.Savepoint(func(env Environment) {
+					user.WithEnv(env).Set(nameField, "Outer User")
+					innerErr := env.Savepoint(func(env Environment) {
+						user.WithEnv(env).Set(nameField, "Inner User")
+						panic("inner failure")
+					})
+					So(innerErr, ShouldNotBeNil)
+					So(user.Get(nameField), ShouldEqual, "Outer User")
+					env.Savepoint(func(env Environment) {
+						user.WithEnv(env).Set(nameField, "Released Inner User")
+					})
+					panic("outer failure")
+				})
+				So(err, ShouldNotBeNil)
+				So(user.Get(nameField), ShouldEqual, "Savepoint User")
+			})
+			Convey("Serialization errors should not be recovered", func() {
+				So(func() {
+					env.Savepoint(func(env Environment) {
+						panic(&pq.Error{Code: "40001"})
+					})
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing Environment context", t, func() {
+		Convey("The context of the Environment should be the given one", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			So(SimulateInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				So(env.Ctx(), ShouldEqual, ctx)
+				So(env.Pool("User").Env().Ctx(), ShouldEqual, ctx)
+			}), ShouldBeNil)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.Ctx(), ShouldEqual, context.Background())
+			}), ShouldBeNil)
+		})
+		Convey("The Environment should be retrieved from its context", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				ctx := ContextWithEnvironment(env)
+				ctxEnv, ok := EnvironmentFromContext(ctx)
+				So(ok, ShouldBeTrue)
+				So(ctxEnv.Cr(), ShouldEqual, env.Cr())
+				_, ok = EnvironmentFromContext(env.Ctx())
+				So(ok, ShouldBeFalse)
+			}), ShouldBeNil)
+		})
+		Convey("Cancelling the context should roll back the transaction", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			var retries uint8
+			So(ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				retries++
+				users := env.Pool("User")
+				users.Call("Create", NewModelData(users.Model()).Set(Name, "Cancelled User"))
+				cancel()
+				env.Cr().Execute("SELECT 1")
+			}), ShouldNotBeNil)
+			So(retries, ShouldEqual, 1)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				users := env.Pool("User")
+				So(users.Search(users.Model().Field(Name).Equals("Cancelled User")).IsEmpty(), ShouldBeTrue)
+			}), ShouldBeNil)
+		})
+		Convey("A done context should fail the transaction at commit", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			So(ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				cancel()
+			}), ShouldNotBeNil)
+		})
+		Convey("Statements longer than the statement timeout should be cancelled", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.SetStatementTimeout(10 * time.Millisecond)
+				env.Cr().Execute("SELECT pg_sleep(1)")
+			}), ShouldNotBeNil)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.SetStatementTimeout(10 * time.Millisecond)
+				env.SetStatementTimeout(0)
+				env.Cr().Execute("SELECT pg_sleep(0.05)")
+			}), ShouldBeNil)
+		})
+	})
+	Convey("Testing open transactions rollback", t, func() {
+		Convey("Released transactions should not be rolled back", func() {
+			env := newEnvironment(context.Background(), DefaultDatabase(), security.SuperUserID, false)
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				env.rollback()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			So(RollbackOpenTransactions(ctx), ShouldEqual, 0)
+		})
+		Convey("Transactions still open after the timeout should be rolled back by their owner", func() {
+			env := newEnvironment(context.Background(), DefaultDatabase(), security.SuperUserID, false)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			So(RollbackOpenTransactions(ctx), ShouldEqual, 1)
+			So(func() { env.Cr().Execute("SELECT 1") }, ShouldPanic)
+			env.rollback()
+			So(openCursorsCount(), ShouldEqual, 0)
+		})
+	})
+	Convey("Testing query span arguments", t, func() {
+		So(argTypes([]interface{}{"$2a$10$secret", int64(3), nil}), ShouldEqual, "[string int64 <nil>]")
+		So(argTypes(nil), ShouldEqual, "[]")
+	})
+	Convey("Testing typed errors", t, func() {
+		Convey("Typed errors should be returned unwrapped with debug information", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				panic(exceptions.ValidationError{Message: "Invalid data"})
+			})
+			var validationErr exceptions.ValidationError
+			So(errors.As(err, &validationErr), ShouldBeTrue)
+			So(validationErr.Message, ShouldEqual, "Invalid data")
+			So(validationErr.Debug, ShouldNotBeEmpty)
+			So(err.Error(), ShouldEqual, "Invalid data")
+		})
+		Convey("Private method calls through RPC should return an AccessError", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("User").CallRPC("InternalReference")
+			})
+			var accessErr exceptions.AccessError
+			So(errors.As(err, &accessErr), ShouldBeTrue)
+			So(accessErr.Message, ShouldEqual, "Private method User.InternalReference() cannot be called through RPC")
+		})
+		Convey("Unknown external IDs should return a MissingError", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("User").GetRecord("unknown_external_id")
+			})
+			var missingErr exceptions.MissingError
+			So(errors.As(err, &missingErr), ShouldBeTrue)
+		})
+		Convey("Failed constraint methods should return a ValidationError", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tags := env.Pool("Tag")
+				tags.Call("Create", NewModelData(tags.Model()).
+					Set(Name, "Same").
+					Set(tags.Model().FieldName("Description"), "Same"))
+			})
+			var validationErr exceptions.ValidationError
+			So(errors.As(err, &validationErr), ShouldBeTrue)
+			So(validationErr.Message, ShouldEqual, "Tag name and description must be different")
+		})
+		Convey("Serialization errors should return a ConcurrencyError after max retries", func() {
+			err := doExecuteInNewEnvironment(context.Background(), security.SuperUserID, 0, false, func(env Environment) {
+				panic(&pq.Error{Code: "40001"})
+			})
+			var concurrencyErr exceptions.ConcurrencyError
+			So(errors.As(err, &concurrencyErr), ShouldBeTrue)
+			var pqErr *pq.Error
+			So(errors.As(err, &pqErr), ShouldBeTrue)
+			So(string(pqErr.Code), ShouldEqual, "40001")
+		})
+	})
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -511,14 +512,101 @@ func TestBaseModelMethods(t *testing.T) {
 	})
 }
 
+func TestFieldAccessControl(t *testing.T) {
+	fieldGroup := security.Registry.NewGroup("field_group", "Field Group")
+	usersGroup := security.Registry.NewGroup("field_users_group", "Field Users Group")
+	security.Registry.AddMembership(2, usersGroup)
+	userModel := Registry.MustGet("User")
+	for _, methName := range []string{"Load", "Write", "Create", "FieldsGet"} {
+		userModel.methods.MustGet(methName).AllowGroup(usersGroup)
+	}
+	emailField := userModel.fields.MustGet("Email")
+	emailField.groups = []*security.Group{fieldGroup}
+	defer func() {
+		emailField.groups = nil
+		for _, methName := range []string{"Load", "Write", "Create", "FieldsGet"} {
+			userModel.methods.MustGet(methName).RevokeGroup(usersGroup)
+		}
+		security.Registry.UnregisterGroup(usersGroup)
+		security.Registry.UnregisterGroup(fieldGroup)
+	}()
+	writeErr := exceptions.AccessError{Message: "You are not allowed to write field Email of User"}
+	Convey("Testing field access control", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			user := env.Pool("User").Call("Create", NewModelData(userModel).
+				Set(Name, "Restricted User").
+				Set(email, "restricted@example.com")).(RecordSet).Collection()
+			Convey("Read should not return restricted fields to non members", func() {
+				data := user.Sudo(2).Call("Read", FieldNames{Name, email}).([]RecordData)
+				So(data, ShouldHaveLength, 1)
+				So(data[0].Underlying().Get(Name), ShouldEqual, "Restricted User")
+				So(data[0].Underlying().Has(email), ShouldBeFalse)
+				data = user.Call("Read", FieldNames{Name, email}).([]RecordData)
+				So(data[0].Underlying().Get(email), ShouldEqual, "restricted@example.com")
+			})
+			Convey("FieldsGet should not return restricted fields to non members", func() {
+				fInfos := user.Sudo(2).Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
+				So(fInfos, ShouldContainKey, "name")
+				So(fInfos, ShouldNotContainKey, "email")
+				fInfos = user.Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
+				So(fInfos, ShouldContainKey, "email")
+			})
+			Convey("Writing restricted fields should panic for non members", func() {
+				So(func() {
+					user.Sudo(2).Call("Write", NewModelData(userModel).Set(email, "written@example.com"))
+				}, ShouldPanicWith, writeErr)
+				So(func() {
+					env.Pool("User").Sudo(2).Call("Create", NewModelData(userModel).
+						Set(Name, "Other Restricted User").
+						Set(email, "other@example.com"))
+				}, ShouldPanicWith, writeErr)
+				So(user.Get(email), ShouldEqual, "restricted@example.com")
+			})
+			Convey("Filtering, ordering or grouping on restricted fields should panic for non members", func() {
+				accessErr := exceptions.AccessError{Message: "You are not allowed to access field Email of User"}
+				cond := userModel.Field(Name).Equals("Restricted User").
+					AndCond(userModel.Field(email).Equals("restricted@example.com").Or().Field(Name).IsNull())
+				So(cond.FieldNames(), ShouldHaveLength, 3)
+				So(cond.FieldNames(), ShouldContain, email)
+				So(userModel.OrderFieldNames("Name desc", "Email"), ShouldResemble, FieldNames{Name, email})
+				So(func() { user.Sudo(2).CheckFieldsAccess(Name) }, ShouldNotPanic)
+				So(func() { user.Sudo(2).CheckFieldsAccess(cond.FieldNames()...) }, ShouldPanicWith, accessErr)
+				So(func() { user.Sudo(2).CheckFieldsAccess(userModel.OrderFieldNames("Email")...) }, ShouldPanicWith, accessErr)
+				So(func() { user.CheckFieldsAccess(cond.FieldNames()...) }, ShouldNotPanic)
+			})
+			Convey("Members of the field groups should access restricted fields", func() {
+				security.Registry.AddMembership(2, fieldGroup)
+				defer security.Registry.RemoveMembership(2, fieldGroup)
+				data := user.Sudo(2).Call("Read", FieldNames{Name, email}).([]RecordData)
+				So(data[0].Underlying().Get(email), ShouldEqual, "restricted@example.com")
+				So(func() {
+					user.Sudo(2).Call("Write", NewModelData(userModel).Set(email, "member@example.com"))
+				}, ShouldNotPanic)
+				So(user.Get(email), ShouldEqual, "member@example.com")
+			})
+			Convey("Context keys should not bypass the access check", func() {
+				So(func() {
+					user.Sudo(2).WithContext("hexya_force_compute_write", true).Call("Write", NewModelData(userModel).Set(email, "forced@example.com"))
+				}, ShouldPanicWith, writeErr)
+			})
+			Convey("Computed values should be written by the framework", func() {
+				So(func() {
+					user.Sudo(2).withComputeWrite().Call("Write", NewModelData(userModel).Set(email, "computed@example.com"))
+				}, ShouldNotPanic)
+				So(user.Get(email), ShouldEqual, "computed@example.com")
+			})
+		}), ShouldBeNil)
+	})
+}
+
 func TestPostBootSequences(t *testing.T) {
 	Convey("Testing manual sequences after bootstrap", t, func() {
 		testSeq := Registry.MustGetSequence("Test")
 		testSeq.Drop()
 		seq := CreateSequence("ManualSequence", 1, 1)
 		So(seq.JSON, ShouldEqual, "manual_sequence_manseq")
-		So(TestAdapter.sequences("%_manseq"), ShouldHaveLength, 1)
-		So(TestAdapter.sequences("%_manseq")[0].Name, ShouldEqual, "manual_sequence_manseq")
+		So(TestAdapter.sequences(db, "%_manseq"), ShouldHaveLength, 1)
+		So(TestAdapter.sequences(db, "%_manseq")[0].Name, ShouldEqual, "manual_sequence_manseq")
 		So(seq.NextValue(), ShouldEqual, 1)
 		So(seq.NextValue(), ShouldEqual, 2)
 		seq.Alter(2, 5)
@@ -526,7 +614,7 @@ func TestPostBootSequences(t *testing.T) {
 		So(seq.NextValue(), ShouldEqual, 7)
 		So(func() { CreateSequence("ManualSequence", 1, 1) }, ShouldPanic)
 		seq.Drop()
-		So(TestAdapter.sequences("%_manseq"), ShouldHaveLength, 0)
+		So(TestAdapter.sequences(db, "%_manseq"), ShouldHaveLength, 0)
 	})
 	Convey("Boot sequences cannot be altered or dropped after bootstrap", t, func() {
 		bootSeq := Registry.MustGetSequence("TestSequence")
@@ -4,6 +4,7 @@
 package models
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models/security"
@@ -126,6 +127,116 @@ func TestDataLoading(t *testing.T) {
 				So(func() { LoadCSVDataFile("testdata/001Post.csv") }, ShouldPanic)
 				So(func() { LoadCSVDataFile("testdata/002Post.csv") }, ShouldPanic)
 			})
+			Convey("Loading records from XML, JSON and YAML data files", func() {
+				LoadXMLDataFile("testdata/020-users.xml")
+				xmlUser := userObj.Search(userObj.Model().Field(email).Equals("xml.user@hexya.io"))
+				So(xmlUser.Len(), ShouldEqual, 1)
+				So(xmlUser.Get(Name), ShouldEqual, "XML User")
+				So(xmlUser.Get(nums), ShouldEqual, 4)
+				So(xmlUser.Get(isStaff), ShouldBeTrue)
+				xmlPost := xmlUser.Get(posts).(RecordSet).Collection()
+				So(xmlPost.Len(), ShouldEqual, 1)
+				So(xmlPost.Get(xmlPost.Model().FieldName("HexyaExternalID")), ShouldEqual, "xml_user_1_posts_ids_1")
+				So(xmlPost.Get(title), ShouldEqual, "XML Post")
+				So(xmlPost.Get(content), ShouldEqual, "<p>Hello <b>World</b></p>")
+				So(xmlPost.Get(tags).(RecordSet).Collection().Len(), ShouldEqual, 2)
+
+				LoadJSONDataFile("testdata/021-users.json")
+				jsonUser := userObj.Search(userObj.Model().Field(email).Equals("json.user@hexya.io"))
+				So(jsonUser.Get(Name), ShouldEqual, "JSON User")
+				So(jsonUser.Get(nums), ShouldEqual, 5)
+				So(jsonUser.Get(size), ShouldEqual, 1.81)
+				jsonPost := jsonUser.Get(posts).(RecordSet).Collection()
+				So(jsonPost.Get(title), ShouldEqual, "JSON Post")
+				So(jsonPost.Get(tags).(RecordSet).Collection().Len(), ShouldEqual, 1)
+
+				LoadYAMLDataFile("testdata/022-users.yaml")
+				yamlUser := userObj.Search(userObj.Model().Field(email).Equals("yaml.user@hexya.io"))
+				So(yamlUser.Get(Name), ShouldEqual, "YAML User")
+				So(yamlUser.Get(nums), ShouldEqual, 6)
+				So(yamlUser.Get(isStaff), ShouldBeTrue)
+				So(yamlUser.Get(posts).(RecordSet).Collection().Get(title), ShouldEqual, "YAML Post")
+
+				LoadYAMLDataFile("testdata/022-users.yaml")
+				So(userObj.Search(userObj.Model().Field(email).Equals("yaml.user@hexya.io")).Len(), ShouldEqual, 1)
+				LoadYAMLDataFile("testdata/022-users_update.yaml")
+				yamlUser.Load()
+				So(yamlUser.Get(Name), ShouldEqual, "YAML User Updated")
+				So(yamlUser.Get(hexyaVersion), ShouldEqual, 0)
+			})
+			Convey("Exporting records to CSV and XLSX", func() {
+				users := userObj.Search(userObj.Model().Field(email).Equals("peter@hexya.io"))
+				csvData := string(users.Export([]FieldName{Name, email, nums, isStaff}, CSVFormat))
+				So(csvData, ShouldEqual, "ID,Name,Email,Nums,IsStaff\nexternal_id_231,Pete,peter@hexya.io,3,false\n")
+				posts := env.Pool("Post").Search(env.Pool("Post").Model().Field(title).Equals("Nick's Post"))
+				postsData := string(posts.Export([]FieldName{title, posts.Model().FieldName("User"), tags, posts.Model().FieldName("User.Name")}, CSVFormat))
+				So(postsData, ShouldStartWith, "ID,Title,User,Tags,User.Name\npost_id_2,Nick's Post,external_id_3,")
+				So(postsData, ShouldContainSubstring, "tag_book")
+				So(postsData, ShouldEndWith, ",Nick\n")
+				So(func() { posts.Export([]FieldName{posts.Model().FieldName("Attachment")}, CSVFormat) }, ShouldPanic)
+				xlsxData := posts.Export([]FieldName{title, posts.Model().FieldName("Attachment")}, XLSXFormat)
+				So(string(xlsxData[:2]), ShouldEqual, "PK")
+				So(func() { posts.Export([]FieldName{title}, ExportFormat("ods")) }, ShouldPanic)
+			})
+			Convey("Importing records with a validation report", func() {
+				postObj := env.Pool("Post")
+				report := postObj.ImportCSV(strings.NewReader(`ID,Title,User/id,Tags
+import_post_1,Imported Post,external_id_3,Book|Film
+`))
+				So(report.HasErrors(), ShouldBeFalse)
+				So(report.Created, ShouldEqual, 1)
+				importedPost := postObj.Search(postObj.Model().Field(title).Equals("Imported Post"))
+				So(importedPost.Len(), ShouldEqual, 1)
+				So(importedPost.Get(importedPost.Model().FieldName("HexyaExternalID")), ShouldEqual, "import_post_1")
+				So(importedPost.Get(tags).(RecordSet).Collection().Len(), ShouldEqual, 2)
+				report = postObj.ImportCSV(strings.NewReader(`ID,Title
+import_post_1,Imported Post Modified
+`))
+				So(report.HasErrors(), ShouldBeFalse)
+				So(report.Updated, ShouldEqual, 1)
+				So(importedPost.Get(title), ShouldEqual, "Imported Post Modified")
+
+				report = userObj.ImportCSV(strings.NewReader(`ID,Name,Email,Nums,IsPremium
+import_user_1,Jane Import,jane.import@hexya.io,abc,false
+import_user_2,John Import,john.import@hexya.io,0,true
+import_user_3,Will Import,will.import@hexya.io,2,false
+`))
+				So(report.Errors, ShouldHaveLength, 2)
+				So(report.Errors[0].Line, ShouldEqual, 2)
+				So(report.Errors[0].Field, ShouldEqual, "Nums")
+				So(report.Errors[0].Value, ShouldEqual, "abc")
+				So(report.Errors[1].Line, ShouldEqual, 3)
+				So(report.Errors[1].Message, ShouldContainSubstring, "Premium users must have positive nums")
+				So(report.Created, ShouldEqual, 1)
+				So(userObj.Search(userObj.Model().Field(Name).Equals("Will Import")).Len(), ShouldEqual, 0)
+
+				report = postObj.ImportCSV(strings.NewReader(`ID,Title,Unknown,Title/id
+import_post_2,Post
+`))
+				So(report.Errors, ShouldHaveLength, 2)
+				So(report.Errors[0].Field, ShouldEqual, "Unknown")
+				So(report.Errors[1].Field, ShouldEqual, "Title/id")
+
+				report, err := ImportCSVData(security.SuperUserID, "User", strings.NewReader(`ID,Name,Email
+import_user_4,Dry Import,dry.import@hexya.io
+`), true)
+				So(err, ShouldBeNil)
+				So(report.DryRun, ShouldBeTrue)
+				So(report.HasErrors(), ShouldBeFalse)
+				So(report.Created, ShouldEqual, 1)
+				So(userObj.Search(userObj.Model().Field(Name).Equals("Dry Import")).Len(), ShouldEqual, 0)
+			})
+			Convey("Parsing domains", func() {
+				cond := ParseDomain([]interface{}{
+					"|", []interface{}{"name", "=", "Peter"}, []interface{}{"Name", "=", "Nick"},
+					[]interface{}{"is_staff", "=", true},
+				}, userObj.Model())
+				So(userObj.Search(cond).Len(), ShouldEqual, 2)
+				cond = ParseDomain([]interface{}{"!", []interface{}{"name", "in", []interface{}{"Peter", "Nick"}}}, userObj.Model())
+				So(userObj.Search(cond).Len(), ShouldEqual, userObj.SearchAll().Len()-2)
+				So(func() { ParseDomain([]interface{}{"&", []interface{}{"name", "=", "Peter"}}, userObj.Model()) }, ShouldPanic)
+				So(func() { ParseDomain([]interface{}{[]interface{}{"name", "=="}}, userObj.Model()) }, ShouldPanic)
+			})
 		}), ShouldBeNil)
 	})
 }
@@ -0,0 +1,101 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sync"
+)
+
+// transactionHooks holds the functions to call at the end of the
+// transaction of an Environment, depending on its outcome.
+type transactionHooks struct {
+	sync.Mutex
+	onCommit   []func()
+	onRollback []func()
+}
+
+// newTransactionHooks returns a pointer to a new empty transactionHooks instance
+func newTransactionHooks() *transactionHooks {
+	return &transactionHooks{}
+}
+
+// addOnCommit registers fnct to be called after the transaction is committed
+func (th *transactionHooks) addOnCommit(fnct func()) {
+	th.Lock()
+	defer th.Unlock()
+	th.onCommit = append(th.onCommit, fnct)
+}
+
+// addOnRollback registers fnct to be called after the transaction is rolled back
+func (th *transactionHooks) addOnRollback(fnct func()) {
+	th.Lock()
+	defer th.Unlock()
+	th.onRollback = append(th.onRollback, fnct)
+}
+
+// mark returns the number of registered commit and rollback hooks,
+// so that the hooks registered afterwards can be told apart.
+func (th *transactionHooks) mark() [2]int {
+	th.Lock()
+	defer th.Unlock()
+	return [2]int{len(th.onCommit), len(th.onRollback)}
+}
+
+// rollbackTo discards the hooks registered since the given mark and calls
+// the rollback hooks among them. It is called when a savepoint is rolled back.
+func (th *transactionHooks) rollbackTo(mark [2]int) {
+	th.Lock()
+	rollbackHooks := th.onRollback[mark[1]:]
+	th.onCommit = th.onCommit[:mark[0]]
+	th.onRollback = th.onRollback[:mark[1]:mark[1]]
+	th.Unlock()
+	runHooks(rollbackHooks)
+}
+
+// discard removes all the registered hooks without calling them.
+// It is called when a transaction is retried, since the hooks are
+// registered again during the retry.
+func (th *transactionHooks) discard() {
+	th.Lock()
+	defer th.Unlock()
+	th.onCommit = nil
+	th.onRollback = nil
+}
+
+// committed calls the commit hooks and discards all hooks
+func (th *transactionHooks) committed() {
+	th.Lock()
+	hooks := th.onCommit
+	th.onCommit = nil
+	th.onRollback = nil
+	th.Unlock()
+	runHooks(hooks)
+}
+
+// rolledBack calls the rollback hooks and discards all hooks
+func (th *transactionHooks) rolledBack() {
+	th.Lock()
+	hooks := th.onRollback
+	th.onCommit = nil
+	th.onRollback = nil
+	th.Unlock()
+	runHooks(hooks)
+}
+
+// runHooks calls the given hooks in order. A hook that panics is
+// logged and does not prevent the following hooks from being called,
+// since the outcome of the transaction cannot be changed anymore.
+func runHooks(hooks []func()) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("Transaction hook panicked", "error", fmt.Sprintf("%v", r))
+				}
+			}()
+			hook()
+		}()
+	}
+}
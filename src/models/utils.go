@@ -16,6 +16,8 @@ package models
 
 import (
 	"strings"
+
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 )
 
 var (
@@ -27,6 +29,13 @@ var (
 	Name = fieldName{name: "Name", json: "name"}
 )
 
+// panicWithError logs the given error with the given context and panics with
+// it, so that it is returned unwrapped by ExecuteInNewEnvironment.
+func panicWithError(err error, ctx ...interface{}) {
+	log.Error(exceptions.Message(err), ctx...)
+	panic(err)
+}
+
 // jsonizeExpr returns an expression slice with field names changed to the fields json names
 // Computation is made relatively to the given Model
 // e.g. [User Profile Name] -> [user_id profile_id name]
@@ -4,8 +4,13 @@
 package models
 
 import (
+	"fmt"
+	"reflect"
+	"runtime"
 	"sync"
 	"time"
+
+	"github.com/hexya-erp/hexya/src/tools/metrics"
 )
 
 // A WorkerFunction can be executed in a loop in background every given LoopPeriod.
@@ -67,7 +72,7 @@ func RunWorkerLoop() {
 			for {
 				select {
 				case <-ticker.C:
-					wf.Run()
+					runWorker(wf)
 				case <-workerStop:
 					workerGroup.Done()
 					return
@@ -77,6 +82,29 @@ func RunWorkerLoop() {
 	}
 }
 
+// runWorker runs the given worker function and records its run in the metrics
+func runWorker(wf WorkerFunction) {
+	start := time.Now()
+	defer func() {
+		r := recover()
+		metrics.ObserveWorkerRun(workerName(wf), start, r != nil)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	wf.Run()
+}
+
+// workerName returns the name of the given worker function, which is the
+// name of its function if it has been created with NewWorkerFunction or
+// its type otherwise.
+func workerName(wf WorkerFunction) string {
+	if w, ok := wf.(*workerFunction); ok {
+		return runtime.FuncForPC(reflect.ValueOf(w.fnct).Pointer()).Name()
+	}
+	return fmt.Sprintf("%T", wf)
+}
+
 // StopWorkerLoop stops the hexya core worker loop.
 //
 // Calling this method if the core worker loop is not running will cause panic.
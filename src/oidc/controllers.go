@@ -0,0 +1,128 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+)
+
+// DefaultRedirect is the path to which users are redirected after
+// logging in when no redirect parameter is given.
+var DefaultRedirect = "/web"
+
+// Session keys of the data of the authorization requests in progress
+const (
+	sessionProvider = "oidc_provider"
+	sessionState    = "oidc_state"
+	sessionNonce    = "oidc_nonce"
+	sessionVerifier = "oidc_verifier"
+	sessionRedirect = "oidc_redirect"
+)
+
+// login is the controller that redirects the user to the authorization
+// endpoint of the provider. The state, nonce and PKCE code verifier of the
+// request are stored in the session to be checked by the callback.
+func login(c *server.Context) {
+	p, ok := GetProvider(c.Param("provider"))
+	if !ok {
+		c.AbortWithJSONError(http.StatusNotFound, fmt.Errorf("unknown provider %s", c.Param("provider")))
+		return
+	}
+	values := make(map[string]string)
+	for _, key := range []string{sessionState, sessionNonce, sessionVerifier} {
+		value, err := randomString(32)
+		if err != nil {
+			c.AbortWithJSONError(http.StatusInternalServerError, err)
+			return
+		}
+		values[key] = value
+	}
+	authURL, err := p.AuthCodeURL(values[sessionState], values[sessionNonce], values[sessionVerifier])
+	if err != nil {
+		c.AbortWithJSONError(http.StatusBadGateway, err)
+		return
+	}
+	sess := c.Session()
+	for key, value := range values {
+		sess.Set(key, value)
+	}
+	sess.Set(sessionProvider, p.Name)
+	sess.Set(sessionRedirect, safeRedirect(c.Query("redirect")))
+	if err = sess.Save(); err != nil {
+		c.AbortWithJSONError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// callback is the controller to which the provider redirects the user after
+// authentication. It exchanges the authorization code for an ID token, checks
+// it and logs the user in.
+func callback(c *server.Context) {
+	p, ok := GetProvider(c.Param("provider"))
+	if !ok {
+		c.AbortWithJSONError(http.StatusNotFound, fmt.Errorf("unknown provider %s", c.Param("provider")))
+		return
+	}
+	sess := c.Session()
+	provider, _ := sess.Get(sessionProvider).(string)
+	state, _ := sess.Get(sessionState).(string)
+	nonce, _ := sess.Get(sessionNonce).(string)
+	verifier, _ := sess.Get(sessionVerifier).(string)
+	redirect, _ := sess.Get(sessionRedirect).(string)
+	// The authorization request data can only be used once
+	for _, key := range []string{sessionProvider, sessionState, sessionNonce, sessionVerifier, sessionRedirect} {
+		sess.Delete(key)
+	}
+	if err := sess.Save(); err != nil {
+		c.AbortWithJSONError(http.StatusInternalServerError, err)
+		return
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		c.AbortWithJSONError(http.StatusUnauthorized, fmt.Errorf("authentication failed: %s %s", errCode, c.Query("error_description")))
+		return
+	}
+	if state == "" || provider != p.Name || c.Query("state") != state {
+		c.AbortWithJSONError(http.StatusBadRequest, errors.New("invalid state"))
+		return
+	}
+	idToken, err := p.Exchange(c.Query("code"), verifier)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusUnauthorized, err)
+		return
+	}
+	claims, err := p.VerifyIDToken(idToken, nonce)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusUnauthorized, err)
+		return
+	}
+	uid, err := p.authenticate(c.Request.Context(), claims)
+	if err == nil {
+		err = c.LogInUser(uid, claims.String(p.loginClaim()))
+	}
+	switch err.(type) {
+	case nil, security.SecondFactorRequiredError:
+		// With a second factor, the session is pending and the
+		// web client asks for the code before going on.
+		c.Redirect(http.StatusFound, redirect)
+	default:
+		c.AbortWithJSONError(c.AuthenticationFailureStatus(err), err)
+	}
+}
+
+// safeRedirect returns the given redirect path if it is a local path,
+// so that the login endpoint cannot be used to redirect users to other
+// sites, or DefaultRedirect otherwise.
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return DefaultRedirect
+	}
+	return redirect
+}
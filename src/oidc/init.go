@@ -0,0 +1,72 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package oidc implements single sign-on with OpenID Connect providers such as
+Keycloak, with the authorization code flow and PKCE.
+
+Providers are registered with RegisterProvider. For each provider, BootStrap
+adds the following endpoints:
+
+	GET /auth/oidc/{provider}/login?redirect=/web
+	GET /auth/oidc/{provider}/callback
+
+The login endpoint redirects the user to the provider, which redirects the
+user back to the callback endpoint with an authorization code. The code is
+exchanged for an ID token whose signature is checked against the key set
+of the provider, and the user linked to the issuer and the subject of the
+token is logged in. Existing users are linked with LinkUser.
+*/
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+const (
+	// Path is the path of the OpenID Connect endpoints
+	Path = "/auth/oidc"
+	// IdentityModelName is the name of the model in which the links between
+	// the identities at the providers and the users are stored
+	IdentityModelName = "OIDCIdentity"
+)
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+)
+
+// BootStrap adds the OpenID Connect controllers of the registered
+// providers to the controllers registry.
+//
+// It must be called after the models have been bootstrapped and
+// before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("OpenID Connect is already bootstrapped")
+	}
+	if !models.BootStrapped() {
+		log.Panic("Models must be bootstrapped before OpenID Connect")
+	}
+	grp := controllers.Registry.AddGroup(Path)
+	grp.AddController(http.MethodGet, "/:provider/login", login)
+	grp.AddController(http.MethodGet, "/:provider/callback", callback)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("oidc")
+	identity := models.NewModel(IdentityModelName)
+	identity.AddFields(map[string]models.FieldDefinition{
+		"Issuer":  fields.Char{Required: true, Index: true, Help: "Issuer of the ID tokens of the identity"},
+		"Subject": fields.Char{Required: true, Help: "Subject of the identity at the issuer"},
+		"UserID":  fields.Integer{String: "User ID", Required: true, Index: true},
+	})
+	identity.AddSQLConstraint("unique_identity", "unique(issuer, subject)",
+		"An OIDC identity can only be linked to one user")
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	// Register hash functions used by JWT algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Leeway is the clock skew tolerated when checking the validity dates of tokens
+var Leeway = time.Minute
+
+// Claims are the claims of an ID token
+type Claims map[string]interface{}
+
+// String returns the value of the claim with the given name
+// if it is a string, or an empty string otherwise.
+func (c Claims) String(name string) string {
+	res, _ := c[name].(string)
+	return res
+}
+
+// Strings returns the values of the claim with the given name
+// if it is a string or a list of strings.
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var res []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// Time returns the value of the claim with the given name as a time.
+// ok is false if the claim does not exist or is not a number.
+func (c Claims) Time(name string) (time.Time, bool) {
+	value, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// A jwtHeader is the header of a JSON Web Token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// A jwt is a parsed, but not verified, JSON Web Token
+type jwt struct {
+	header       jwtHeader
+	claims       Claims
+	signingInput string
+	signature    []byte
+}
+
+// parseJWT parses the given compact serialized JSON Web Token
+// without verifying its signature.
+func parseJWT(token string) (*jwt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var res jwt
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header: %s", err)
+	}
+	if err = json.Unmarshal(headerData, &res.header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %s", err)
+	}
+	claimsData, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims: %s", err)
+	}
+	if err = json.Unmarshal(claimsData, &res.claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %s", err)
+	}
+	res.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %s", err)
+	}
+	res.signingInput = parts[0] + "." + parts[1]
+	return &res, nil
+}
+
+// verifySignature checks the signature of the token with the given key
+func (t *jwt) verifySignature(key crypto.PublicKey) error {
+	var hash crypto.Hash
+	switch t.header.Alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %s", t.header.Alg)
+	}
+	h := hash.New()
+	h.Write([]byte(t.signingInput))
+	digest := h.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if t.header.Alg[0] != 'R' {
+			return fmt.Errorf("algorithm %s does not match RSA key", t.header.Alg)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, t.signature)
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if t.header.Alg[0] != 'E' || len(t.signature) != 2*size {
+			return fmt.Errorf("algorithm %s does not match EC key", t.header.Alg)
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key type")
+}
+
+// A jsonWebKey is a public key of a JSON Web Key Set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the public key of this JSON Web Key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
@@ -0,0 +1,324 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testIdP is a minimal OpenID Connect provider for the tests
+type testIdP struct {
+	server    *httptest.Server
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    Claims
+}
+
+func newTestIdP() *testIdP {
+	idp := new(testIdP)
+	idp.rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	idp.ecKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{
+				{Kty: "RSA", Kid: "rsa", Use: "sig", N: enc(idp.rsaKey.N.Bytes()), E: enc(big.NewInt(int64(idp.rsaKey.E)).Bytes())},
+				{Kty: "EC", Kid: "ec", Crv: "P-256", X: enc(idp.ecKey.X.Bytes()), Y: enc(idp.ecKey.Y.Bytes())},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		clientID, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		switch {
+		case clientID != "hexya" || secret != "s3cret":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client"}`))
+		case r.PostForm.Get("code") != "good-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+		default:
+			claims := idp.validClaims()
+			claims["nonce"] = idp.nonce
+			json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign("RS256", claims)})
+		}
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+// validClaims returns valid claims for the client of the tests
+func (idp *testIdP) validClaims() Claims {
+	claims := Claims{
+		"iss":                idp.server.URL,
+		"sub":                "1234",
+		"aud":                "hexya",
+		"exp":                float64(time.Now().Add(5 * time.Minute).Unix()),
+		"iat":                float64(time.Now().Unix()),
+		"preferred_username": "jdoe",
+		"groups":             []interface{}{"accounting"},
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	return claims
+}
+
+// sign returns a JWT with the given claims signed with the given algorithm
+func (idp *testIdP) sign(alg string, claims Claims) string {
+	kid := "rsa"
+	if alg[0] == 'E' {
+		kid = "ec"
+	}
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func performRequest(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newTestIdP()
+	defer idp.server.Close()
+	provider := &Provider{
+		Name:         "test",
+		IssuerURL:    idp.server.URL,
+		ClientID:     "hexya",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost/auth/oidc/test/callback",
+		GroupMapping: map[string]string{"accounting": "oidc_accounting", "sales": "oidc_sales"},
+	}
+	RegisterProvider(provider)
+	accounting := security.Registry.NewGroup("oidc_accounting", "Accounting")
+	sales := security.Registry.NewGroup("oidc_sales", "Sales")
+	var resolvedLogin string
+	ResolveUser = func(ctx context.Context, p *Provider, login string, claims Claims) (int64, error) {
+		resolvedLogin = login
+		return 7, nil
+	}
+	defer func() { ResolveUser = resolveUser }()
+
+	Convey("Bootstrapping OpenID Connect before models should panic", t, func() {
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Bootstrapping", t, func() {
+		models.BootStrap()
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+		So(func() { RegisterProvider(&Provider{Name: "test"}) }, ShouldPanic)
+	})
+	Convey("Verifying ID tokens", t, func() {
+		Convey("Valid RSA and EC signed tokens should be accepted", func() {
+			claims, err := provider.VerifyIDToken(idp.sign("RS256", idp.validClaims()), "")
+			So(err, ShouldBeNil)
+			So(claims.String("preferred_username"), ShouldEqual, "jdoe")
+			So(claims.Strings("groups"), ShouldResemble, []string{"accounting"})
+			_, err = provider.VerifyIDToken(idp.sign("ES256", idp.validClaims()), "")
+			So(err, ShouldBeNil)
+		})
+		Convey("Tampered tokens should be rejected", func() {
+			token := idp.sign("RS256", idp.validClaims())
+			parts := strings.Split(token, ".")
+			claims := idp.validClaims()
+			claims["preferred_username"] = "admin"
+			payload, _ := json.Marshal(claims)
+			parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+			_, err := provider.VerifyIDToken(strings.Join(parts, "."), "")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Tokens with an invalid audience, authorized party, issuer or nonce should be rejected", func() {
+			claims := idp.validClaims()
+			claims["aud"] = []interface{}{"other"}
+			_, err := provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldNotBeNil)
+			claims = idp.validClaims()
+			claims["aud"] = []interface{}{"hexya", "other"}
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldNotBeNil)
+			claims["azp"] = "other"
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldNotBeNil)
+			claims["azp"] = "hexya"
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldBeNil)
+			claims = idp.validClaims()
+			claims["azp"] = "other"
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldNotBeNil)
+			claims = idp.validClaims()
+			claims["iss"] = "http://evil.example.com"
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldNotBeNil)
+			claims = idp.validClaims()
+			claims["nonce"] = "abc"
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "def")
+			So(err, ShouldNotBeNil)
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "abc")
+			So(err, ShouldBeNil)
+		})
+		Convey("Expired and not yet valid tokens should be rejected", func() {
+			claims := idp.validClaims()
+			claims["exp"] = float64(time.Now().Add(-time.Hour).Unix())
+			_, err := provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldNotBeNil)
+			claims = idp.validClaims()
+			claims["nbf"] = float64(time.Now().Add(time.Hour).Unix())
+			_, err = provider.VerifyIDToken(idp.sign("RS256", claims), "")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Unsigned tokens should be rejected", func() {
+			header, _ := json.Marshal(jwtHeader{Alg: "none", Kid: "rsa"})
+			payload, _ := json.Marshal(idp.validClaims())
+			token := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+			_, err := provider.VerifyIDToken(token, "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Authenticating with verified claims", t, func() {
+		security.Registry.AddMembership(7, sales)
+		Convey("Valid claims should authenticate their user and sync its groups", func() {
+			uid, err := provider.authenticate(context.Background(), idp.validClaims())
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 7)
+			So(resolvedLogin, ShouldEqual, "jdoe")
+			So(security.Registry.HasMembership(7, accounting), ShouldBeTrue)
+			So(security.Registry.HasMembership(7, sales), ShouldBeFalse)
+		})
+		Convey("Claims without login should be rejected", func() {
+			claims := idp.validClaims()
+			delete(claims, "preferred_username")
+			_, err := provider.authenticate(context.Background(), claims)
+			So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+		})
+		Convey("Claims without issuer or subject should not resolve to a user", func() {
+			claims := idp.validClaims()
+			delete(claims, "sub")
+			_, err := resolveUser(context.Background(), provider, "jdoe", claims)
+			So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+			claims = idp.validClaims()
+			delete(claims, "iss")
+			_, err = resolveUser(context.Background(), provider, "jdoe", claims)
+			So(err, ShouldHaveSameTypeAs, security.InvalidCredentialsError(""))
+		})
+		Convey("ID tokens should not be accepted as passwords", func() {
+			_, err := security.AuthenticationRegistry.Authenticate("jdoe", idp.sign("RS256", idp.validClaims()), nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+		})
+		security.Registry.RemoveAllMembershipsForUser(7)
+	})
+	Convey("Testing the login flow", t, func() {
+		Convey("Unknown providers should return 404", func() {
+			w := performRequest(Path+"/unknown/login", nil)
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("A callback without login should fail", func() {
+			w := performRequest(Path+"/test/callback?code=good-code&state=abc", nil)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Logging in should redirect to the provider with PKCE", func() {
+			w := performRequest(Path+"/test/login?redirect=/web/home", nil)
+			So(w.Code, ShouldEqual, http.StatusFound)
+			location, err := url.Parse(w.Header().Get("Location"))
+			So(err, ShouldBeNil)
+			So(location.Path, ShouldEqual, "/authorize")
+			params := location.Query()
+			So(params.Get("client_id"), ShouldEqual, "hexya")
+			So(params.Get("code_challenge_method"), ShouldEqual, "S256")
+			So(params.Get("state"), ShouldNotBeEmpty)
+			idp.challenge = params.Get("code_challenge")
+			idp.nonce = params.Get("nonce")
+			cookies := w.Result().Cookies()
+			Convey("A callback with another state should fail", func() {
+				w := performRequest(Path+"/test/callback?code=good-code&state=abc", cookies)
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+			Convey("A callback with an invalid code should fail", func() {
+				w := performRequest(Path+"/test/callback?code=bad-code&state="+params.Get("state"), cookies)
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Body.String(), ShouldContainSubstring, "invalid_grant")
+			})
+			Convey("A callback with an error of the provider should fail", func() {
+				w := performRequest(Path+"/test/callback?error=access_denied&state="+params.Get("state"), cookies)
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Body.String(), ShouldContainSubstring, "access_denied")
+			})
+			Convey("A valid callback should log the user in", func() {
+				resolvedLogin = ""
+				w := performRequest(Path+"/test/callback?code=good-code&state="+params.Get("state"), cookies)
+				So(w.Code, ShouldEqual, http.StatusFound)
+				So(w.Header().Get("Location"), ShouldEqual, "/web/home")
+				So(resolvedLogin, ShouldEqual, "jdoe")
+				So(w.Result().Cookies(), ShouldNotBeEmpty)
+				Convey("The authorization request cannot be used twice", func() {
+					w := performRequest(Path+"/test/callback?code=good-code&state="+params.Get("state"), w.Result().Cookies())
+					So(w.Code, ShouldEqual, http.StatusBadRequest)
+				})
+				security.Registry.RemoveAllMembershipsForUser(7)
+			})
+		})
+	})
+	Convey("Testing redirect paths", t, func() {
+		So(safeRedirect("/web#action=1"), ShouldEqual, "/web#action=1")
+		So(safeRedirect(""), ShouldEqual, DefaultRedirect)
+		So(safeRedirect("https://evil.example.com"), ShouldEqual, DefaultRedirect)
+		So(safeRedirect("//evil.example.com"), ShouldEqual, DefaultRedirect)
+		So(safeRedirect("/\\evil.example.com"), ShouldEqual, DefaultRedirect)
+	})
+}
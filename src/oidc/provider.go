@@ -0,0 +1,314 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+)
+
+// A Provider is an OpenID Connect identity provider with which
+// users can log in with the authorization code flow.
+type Provider struct {
+	// Name identifies the provider in the login and callback URLs
+	Name string
+	// IssuerURL is the issuer of the provider, from which the
+	// discovery document is fetched.
+	IssuerURL string
+	// ClientID and ClientSecret are the credentials of Hexya at the provider.
+	// ClientSecret may be empty for public clients.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the callback endpoint of this
+	// provider, e.g. https://erp.example.com/auth/oidc/keycloak/callback
+	RedirectURL string
+	// Scopes requested to the provider. Defaults to openid, profile and email.
+	Scopes []string
+	// LoginClaim is the claim holding the login of the users created at
+	// their first login. Defaults to preferred_username. Users are always
+	// identified by the issuer and the subject of their ID tokens.
+	LoginClaim string
+	// GroupsClaim is the claim holding the groups of the user at the
+	// provider. Defaults to groups.
+	GroupsClaim string
+	// GroupMapping maps the groups of the GroupsClaim to the IDs of
+	// security groups. Users are added to the mapped groups of their
+	// claims and removed from the other mapped groups at each login.
+	GroupMapping map[string]string
+	// CreateUsers is set to create the users logging in for the first time.
+	// Users are not created if a user with the same login already exists.
+	CreateUsers bool
+	// HTTPClient is the client used to query the provider.
+	// Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu          sync.RWMutex
+	discovery   *discoveryDocument
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// keysRefreshInterval is the minimum interval between two fetches of the key
+// set of a provider, so that tokens with unknown key ids cannot be used to
+// flood the provider with requests.
+const keysRefreshInterval = time.Minute
+
+// A discoveryDocument holds the provider metadata used by Hexya
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// A tokenResponse is the response of the token endpoint
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// loginClaim returns the claim holding the login of the user
+func (p *Provider) loginClaim() string {
+	if p.LoginClaim == "" {
+		return "preferred_username"
+	}
+	return p.LoginClaim
+}
+
+// groupsClaim returns the claim holding the groups of the user
+func (p *Provider) groupsClaim() string {
+	if p.GroupsClaim == "" {
+		return "groups"
+	}
+	return p.GroupsClaim
+}
+
+// client returns the HTTP client to query the provider
+func (p *Provider) client() *http.Client {
+	if p.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return p.HTTPClient
+}
+
+// getJSON gets the given URL and decodes its JSON body into dest
+func (p *Provider) getJSON(u string, dest interface{}) error {
+	resp, err := p.client().Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, u)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// metadata returns the discovery document of the provider,
+// fetching it on first call.
+func (p *Provider) metadata() (*discoveryDocument, error) {
+	p.mu.RLock()
+	doc := p.discovery
+	p.mu.RUnlock()
+	if doc != nil {
+		return doc, nil
+	}
+	doc = new(discoveryDocument)
+	if err := p.getJSON(strings.TrimSuffix(p.IssuerURL, "/")+"/.well-known/openid-configuration", doc); err != nil {
+		return nil, fmt.Errorf("unable to get discovery document of %s: %s", p.Name, err)
+	}
+	if doc.Issuer != strings.TrimSuffix(p.IssuerURL, "/") && doc.Issuer != p.IssuerURL {
+		return nil, fmt.Errorf("issuer %s of discovery document does not match %s", doc.Issuer, p.IssuerURL)
+	}
+	p.mu.Lock()
+	p.discovery = doc
+	p.mu.Unlock()
+	return doc, nil
+}
+
+// publicKey returns the signing key of the provider with the given key id.
+// The key set of the provider is fetched again if the key is unknown, so
+// that key rotations are taken into account.
+func (p *Provider) publicKey(kid string) (crypto.PublicKey, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	recent := time.Since(p.keysFetched) < keysRefreshInterval
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if recent {
+		return nil, fmt.Errorf("unknown key %s for provider %s", kid, p.Name)
+	}
+	doc, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = p.getJSON(doc.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to get key set of %s: %s", p.Name, err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.publicKey()
+		if err != nil {
+			log.Warn("Ignoring invalid key of OIDC provider", "provider", p.Name, "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = pub
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s for provider %s", kid, p.Name)
+	}
+	return key, nil
+}
+
+// VerifyIDToken checks the signature and the claims of the given ID token
+// and returns its claims. If nonce is not empty, the nonce claim of the
+// token must be equal to it.
+func (p *Provider) VerifyIDToken(token, nonce string) (Claims, error) {
+	doc, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.publicKey(t.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.verifySignature(key); err != nil {
+		return nil, err
+	}
+	claims := t.claims
+	if claims.String("iss") != doc.Issuer {
+		return nil, fmt.Errorf("unexpected token issuer %s", claims.String("iss"))
+	}
+	audiences := claims.Strings("aud")
+	if !strutils.IsIn(p.ClientID, audiences...) {
+		return nil, errors.New("token is not issued for this client")
+	}
+	// The authorized party must be this client if it is given, and it must
+	// be given if the token has other audiences.
+	if _, ok := claims["azp"]; (ok || len(audiences) > 1) && claims.String("azp") != p.ClientID {
+		return nil, errors.New("token is not issued to this client")
+	}
+	now := time.Now()
+	exp, ok := claims.Time("exp")
+	if !ok || now.After(exp.Add(Leeway)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Before(nbf.Add(-Leeway)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if nonce != "" && claims.String("nonce") != nonce {
+		return nil, errors.New("invalid token nonce")
+	}
+	return claims, nil
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint of the provider
+// to which users are redirected to log in, with the given state, nonce and
+// PKCE code verifier.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	doc, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange exchanges the given authorization code for tokens at the token
+// endpoint of the provider and returns the ID token.
+func (p *Provider) Exchange(code, verifier string) (string, error) {
+	doc, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.ClientID)
+	req, err := http.NewRequest(http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var tr tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("invalid token response: %s", err)
+	}
+	switch {
+	case tr.Error != "":
+		return "", fmt.Errorf("token request failed: %s %s", tr.Error, tr.ErrorDescription)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("token request failed with status %s", resp.Status)
+	case tr.IDToken == "":
+		return "", errors.New("no ID token in token response")
+	}
+	return tr.IDToken, nil
+}
+
+// randomString returns a random URL safe string of n bytes
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
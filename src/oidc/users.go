@@ -0,0 +1,195 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package oidc
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/users"
+)
+
+// providers is the registry of the OpenID Connect providers
+var providers = struct {
+	sync.RWMutex
+	byName map[string]*Provider
+}{
+	byName: make(map[string]*Provider),
+}
+
+// RegisterProvider registers the given provider so that users can log in with it.
+// It panics if a provider with the same name is already registered.
+func RegisterProvider(p *Provider) {
+	providers.Lock()
+	defer providers.Unlock()
+	if _, exists := providers.byName[p.Name]; exists {
+		log.Panic("OIDC provider already registered", "name", p.Name)
+	}
+	providers.byName[p.Name] = p
+}
+
+// GetProvider returns the registered provider with the given name
+func GetProvider(name string) (*Provider, bool) {
+	providers.RLock()
+	defer providers.RUnlock()
+	p, ok := providers.byName[name]
+	return p, ok
+}
+
+// AllProviders returns all the registered providers sorted by name
+func AllProviders() []*Provider {
+	providers.RLock()
+	defer providers.RUnlock()
+	res := make([]*Provider, 0, len(providers.byName))
+	for _, p := range providers.byName {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// ResolveUser returns the uid of the user authenticated by the given provider
+// with the given claims in the database of ctx. login is the value of the
+// login claim.
+//
+// It should return a security.UserNotFoundError if the user does not exist
+// and cannot be created. The default implementation returns the user linked
+// to the issuer and the subject of the claims. If there is none and CreateUsers
+// is set on the provider, it creates a new user with the given login in the
+// User model and links it. Existing users are never linked implicitly: they
+// must be linked with LinkUser. It can be replaced by applications with other
+// user models.
+var ResolveUser = resolveUser
+
+// resolveUser is the default implementation of ResolveUser
+func resolveUser(ctx context.Context, p *Provider, login string, claims Claims) (int64, error) {
+	issuer, subject := claims.String("iss"), claims.String("sub")
+	if issuer == "" || subject == "" {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	if !users.Available() {
+		return 0, security.UserNotFoundError(login)
+	}
+	var uid int64
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		if linked := linkedUser(env, issuer, subject); linked != 0 {
+			if users.Exists(env, linked) {
+				uid = linked
+			}
+			return
+		}
+		if !p.CreateUsers {
+			return
+		}
+		if users.FindByLogin(env, login) != 0 {
+			log.Warn("OIDC identity not linked to the existing user with the same login", "provider", p.Name,
+				"issuer", issuer, "subject", subject, "login", login)
+			return
+		}
+		uid = users.Create(env, users.Profile{Login: login, Name: claims.String("name"), Email: claims.String("email")})
+		createIdentity(env, issuer, subject, uid)
+		log.Info("Created user on first OIDC login", "provider", p.Name, "login", login, "uid", uid)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if uid == 0 {
+		return 0, security.UserNotFoundError(login)
+	}
+	return uid, nil
+}
+
+// LinkUser links the identity with the given subject at the given issuer
+// to the user with the given uid in the database of ctx, so that this user
+// is logged in when authenticated by a provider of this issuer with this subject.
+//
+// It returns an error if the identity is already linked.
+func LinkUser(ctx context.Context, issuer, subject string, uid int64) error {
+	return models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		if linked := linkedUser(env, issuer, subject); linked != 0 {
+			log.Panic("OIDC identity is already linked", "issuer", issuer, "subject", subject, "uid", linked)
+		}
+		createIdentity(env, issuer, subject, uid)
+	})
+}
+
+// UnlinkUser removes the link of the identity with the given
+// subject at the given issuer in the database of ctx.
+func UnlinkUser(ctx context.Context, issuer, subject string) error {
+	return models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		identity := findIdentity(env, issuer, subject)
+		if identity.IsEmpty() {
+			log.Panic("Unknown OIDC identity", "issuer", issuer, "subject", subject)
+		}
+		identity.Call("Unlink")
+	})
+}
+
+// findIdentity returns the identity record with the given issuer and subject
+func findIdentity(env models.Environment, issuer, subject string) *models.RecordCollection {
+	model := models.Registry.MustGet(IdentityModelName)
+	return env.Pool(IdentityModelName).Search(
+		model.Field(model.FieldName("Issuer")).Equals(issuer).
+			And().Field(model.FieldName("Subject")).Equals(subject)).Fetch()
+}
+
+// linkedUser returns the uid of the user linked to the identity
+// with the given issuer and subject or 0 if there is none.
+func linkedUser(env models.Environment, issuer, subject string) int64 {
+	identity := findIdentity(env, issuer, subject)
+	if identity.IsEmpty() {
+		return 0
+	}
+	return identity.Get(identity.Model().FieldName("UserID")).(int64)
+}
+
+// createIdentity links the identity with the given issuer and
+// subject to the user with the given uid.
+func createIdentity(env models.Environment, issuer, subject string, uid int64) {
+	rc := env.Pool(IdentityModelName)
+	rc.Call("Create", models.NewModelData(rc.Model()).
+		Set(rc.Model().FieldName("Issuer"), issuer).
+		Set(rc.Model().FieldName("Subject"), subject).
+		Set(rc.Model().FieldName("UserID"), uid))
+}
+
+// syncGroups adds the user with the given uid to the security groups mapped
+// from its groups claim and removes it from the other mapped groups, in the
+// database of ctx.
+func (p *Provider) syncGroups(ctx context.Context, uid int64, claims Claims) {
+	if len(p.GroupMapping) == 0 {
+		return
+	}
+	granted := make(map[string]bool)
+	for _, claimGroup := range claims.Strings(p.groupsClaim()) {
+		granted[claimGroup] = true
+	}
+	mapped := make(map[string]bool)
+	for claimGroup, groupID := range p.GroupMapping {
+		mapped[groupID] = mapped[groupID] || granted[claimGroup]
+	}
+	for _, groupID := range users.SyncGroups(ctx, uid, mapped) {
+		log.Warn("Unknown group in OIDC group mapping", "provider", p.Name, "group", groupID)
+	}
+}
+
+// authenticate returns the uid of the user authenticated by the given
+// verified claims in the database of ctx and synchronizes its groups.
+func (p *Provider) authenticate(ctx context.Context, claims Claims) (int64, error) {
+	login := claims.String(p.loginClaim())
+	if login == "" {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	uid, err := ResolveUser(ctx, p, login, claims)
+	if err != nil {
+		return 0, err
+	}
+	p.syncGroups(ctx, uid, claims)
+	return uid, nil
+}
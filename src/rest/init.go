@@ -0,0 +1,70 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package rest implements a REST API for all the models of the application.
+
+The API is mounted in the controllers registry by BootStrap and exposes
+the following routes for each model:
+
+	GET    /api/v1/{model}       List records
+	POST   /api/v1/{model}       Create a record
+	GET    /api/v1/{model}/{id}  Read a record
+	PATCH  /api/v1/{model}/{id}  Update a record
+	DELETE /api/v1/{model}/{id}  Delete a record
+
+An OpenAPI 3 document describing these routes is served at /api/openapi.json.
+
+All requests are executed in the Environment of the authenticated user, so that
+method execution permissions and record rules apply.
+*/
+package rest
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+const (
+	// BasePath is the path of the controllers group of the REST API
+	BasePath = "/api"
+	// Version is the version of the REST API. It is part of the path of the routes.
+	Version = "v1"
+)
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+	// openAPIDocument is the OpenAPI document of the REST API computed at bootstrap
+	openAPIDocument map[string]interface{}
+)
+
+// BootStrap adds the REST API controllers to the controllers registry.
+//
+// It must be called after the models have been bootstrapped and
+// before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("REST API is already bootstrapped")
+	}
+	if !models.BootStrapped() {
+		log.Panic("Models must be bootstrapped before the REST API")
+	}
+	openAPIDocument = OpenAPIDocument()
+	grp := controllers.Registry.AddGroup(BasePath)
+	grp.AddMiddleWare(authenticate)
+	grp.AddController(http.MethodGet, "/openapi.json", getOpenAPIDocument)
+	grp.AddController(http.MethodGet, "/"+Version+"/:model", searchRecords)
+	grp.AddController(http.MethodPost, "/"+Version+"/:model", createRecord)
+	grp.AddController(http.MethodGet, "/"+Version+"/:model/:id", readRecord)
+	grp.AddController(http.MethodPatch, "/"+Version+"/:model/:id", updateRecord)
+	grp.AddController(http.MethodDelete, "/"+Version+"/:model/:id", deleteRecord)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("rest")
+}
@@ -0,0 +1,287 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/server"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification of the generated document
+const OpenAPIVersion = "3.0.3"
+
+// getOpenAPIDocument is the controller that serves the OpenAPI document
+func getOpenAPIDocument(c *server.Context) {
+	c.JSON(http.StatusOK, openAPIDocument)
+}
+
+// OpenAPIDocument returns the OpenAPI document of the REST API
+// generated from the fields of the exposed models.
+//
+// Models must have been bootstrapped before calling this function.
+func OpenAPIDocument() map[string]interface{} {
+	paths := make(map[string]interface{})
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code":    map[string]interface{}{"type": "integer"},
+						"message": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+	for _, model := range models.Registry.AllModels() {
+		if _, ok := exposedModel(model.Name()); !ok {
+			continue
+		}
+		schemas[model.Name()] = modelSchema(model)
+		paths[fmt.Sprintf("/%s", model.Name())] = collectionPathItem(model)
+		paths[fmt.Sprintf("/%s/{id}", model.Name())] = recordPathItem(model)
+	}
+	return map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "Hexya REST API",
+			"version": Version,
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": fmt.Sprintf("%s/%s", BasePath, Version)},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"basicAuth": []string{}},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth": map[string]interface{}{
+					"type":   "http",
+					"scheme": "basic",
+				},
+			},
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(schemaRef("Error")),
+				},
+			},
+		},
+	}
+}
+
+// modelSchema returns the schema object of the given model
+func modelSchema(model *models.Model) map[string]interface{} {
+	fInfos := model.FieldsGet()
+	properties := make(map[string]interface{})
+	var required []string
+	for jsonName, fi := range fInfos {
+		fSchema := fieldSchema(fi)
+		properties[jsonName] = fSchema
+		if fi.Required && fi.DefaultFunc == nil && fSchema["readOnly"] == nil {
+			required = append(required, jsonName)
+		}
+	}
+	res := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		res["required"] = required
+	}
+	return res
+}
+
+// fieldSchema returns the schema object of the field with the given info
+func fieldSchema(fi *models.FieldInfo) map[string]interface{} {
+	res := make(map[string]interface{})
+	switch fi.Type {
+	case fieldtype.Boolean:
+		res["type"] = "boolean"
+	case fieldtype.Integer:
+		res["type"] = "integer"
+		res["format"] = "int64"
+	case fieldtype.Float:
+		res["type"] = "number"
+	case fieldtype.Date:
+		res["type"] = "string"
+		res["format"] = "date"
+	case fieldtype.DateTime:
+		res["type"] = "string"
+		res["format"] = "date-time"
+	case fieldtype.Binary:
+		res["type"] = "string"
+		res["format"] = "byte"
+	case fieldtype.Selection:
+		res["type"] = "string"
+		var values []string
+		for value := range fi.Selection {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		res["enum"] = values
+	case fieldtype.Many2One, fieldtype.One2One, fieldtype.Rev2One:
+		res["type"] = "integer"
+		res["format"] = "int64"
+		res["nullable"] = true
+	case fieldtype.One2Many, fieldtype.Many2Many:
+		res["type"] = "array"
+		res["items"] = map[string]interface{}{"type": "integer", "format": "int64"}
+	default:
+		res["type"] = "string"
+	}
+	if fi.String != "" {
+		res["title"] = fi.String
+	}
+	if fi.Help != "" {
+		res["description"] = fi.Help
+	}
+	if fi.Relation != "" {
+		res["x-relation"] = fi.Relation
+	}
+	if fi.ReadOnly || !fi.Store || fi.Name == "ID" {
+		res["readOnly"] = true
+	}
+	return res
+}
+
+// collectionPathItem returns the path item object of the records list of the given model
+func collectionPathItem(model *models.Model) map[string]interface{} {
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     fmt.Sprintf("List %s records", model.Name()),
+			"operationId": fmt.Sprintf("list%s", model.Name()),
+			"tags":        []string{model.Name()},
+			"parameters": []interface{}{
+				queryParameter("domain", "Domain to filter the records, as a JSON list", "string"),
+				queryParameter("fields", "Comma separated list of fields to return", "string"),
+				queryParameter("limit", fmt.Sprintf("Maximum number of records to return, at most %d (default %d)", MaxLimit, DefaultLimit), "integer"),
+				queryParameter("offset", "Number of records to skip", "integer"),
+				queryParameter("order", "Comma separated list of fields to order by, with an optional 'desc' suffix", "string"),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of records",
+					"content": jsonContent(map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"count":   map[string]interface{}{"type": "integer"},
+							"limit":   map[string]interface{}{"type": "integer"},
+							"offset":  map[string]interface{}{"type": "integer"},
+							"records": map[string]interface{}{"type": "array", "items": schemaRef(model.Name())},
+						},
+					}),
+				},
+				"default": responseRef("Error"),
+			},
+		},
+		"post": map[string]interface{}{
+			"summary":     fmt.Sprintf("Create a %s record", model.Name()),
+			"operationId": fmt.Sprintf("create%s", model.Name()),
+			"tags":        []string{model.Name()},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaRef(model.Name())),
+			},
+			"responses": map[string]interface{}{
+				"201": map[string]interface{}{
+					"description": "Created record",
+					"content":     jsonContent(schemaRef(model.Name())),
+				},
+				"default": responseRef("Error"),
+			},
+		},
+	}
+}
+
+// recordPathItem returns the path item object of a single record of the given model
+func recordPathItem(model *models.Model) map[string]interface{} {
+	return map[string]interface{}{
+		"parameters": []interface{}{
+			map[string]interface{}{
+				"name":     "id",
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "integer", "format": "int64"},
+			},
+		},
+		"get": map[string]interface{}{
+			"summary":     fmt.Sprintf("Read a %s record", model.Name()),
+			"operationId": fmt.Sprintf("read%s", model.Name()),
+			"tags":        []string{model.Name()},
+			"parameters": []interface{}{
+				queryParameter("fields", "Comma separated list of fields to return", "string"),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Record",
+					"content":     jsonContent(schemaRef(model.Name())),
+				},
+				"default": responseRef("Error"),
+			},
+		},
+		"patch": map[string]interface{}{
+			"summary":     fmt.Sprintf("Update a %s record", model.Name()),
+			"operationId": fmt.Sprintf("update%s", model.Name()),
+			"tags":        []string{model.Name()},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaRef(model.Name())),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Updated record",
+					"content":     jsonContent(schemaRef(model.Name())),
+				},
+				"default": responseRef("Error"),
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     fmt.Sprintf("Delete a %s record", model.Name()),
+			"operationId": fmt.Sprintf("delete%s", model.Name()),
+			"tags":        []string{model.Name()},
+			"responses": map[string]interface{}{
+				"204":     map[string]interface{}{"description": "Record deleted"},
+				"default": responseRef("Error"),
+			},
+		},
+	}
+}
+
+// queryParameter returns a query parameter object
+func queryParameter(name, description, typ string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"schema":      map[string]interface{}{"type": typ},
+	}
+}
+
+// jsonContent returns a content object of JSON media type with the given schema
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaRef returns a reference to the given schema component
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": fmt.Sprintf("#/components/schemas/%s", name)}
+}
+
+// responseRef returns a reference to the given response component
+func responseRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": fmt.Sprintf("#/components/responses/%s", name)}
+}
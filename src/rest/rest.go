@@ -0,0 +1,366 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/server"
+)
+
+const (
+	// DefaultLimit is the maximum number of records returned
+	// by a list request when no limit is given.
+	DefaultLimit = 100
+	// MaxLimit is the greatest limit that can be given to a list request
+	MaxLimit = 1000
+)
+
+// errNotFound is returned inside handlers when the requested record does not exist
+var errNotFound = errors.New("record not found")
+
+// A listParams holds the query parameters of a list request
+type listParams struct {
+	domain []interface{}
+	fields []string
+	limit  int
+	offset int
+	order  []string
+}
+
+// authenticate is the middleware that authenticates the user of the request
+// with an API key, with HTTP basic authentication, or with the session of
+// the web client.
+//
+// The uid of the authenticated user is stored in the context.
+func authenticate(c *server.Context) {
+	uid, err := c.AuthenticatedUID()
+	if err != nil {
+		c.AbortWithJSONError(c.AuthenticationFailureStatus(err), err)
+		return
+	}
+	if uid == 0 {
+		c.Header("WWW-Authenticate", `Basic realm="Hexya"`)
+		c.AbortWithJSONError(http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	c.Set("uid", uid)
+}
+
+// exposedModel returns the model with the given name or JSON name if it
+// is exposed by the REST API.
+func exposedModel(name string) (*models.Model, bool) {
+	model, ok := models.Registry.Get(name)
+	if !ok || model.IsMixin() || model.IsM2MLink() {
+		return nil, false
+	}
+	return model, true
+}
+
+// execute runs fnct in the Environment of the authenticated user for the
+// model of the request and writes the response. fnct returns the HTTP status
+// and the data to write, or errNotFound if the requested record does not exist.
+func execute(c *server.Context, fnct func(rc *models.RecordCollection) (int, interface{}, error)) {
+	model, ok := exposedModel(c.Param("model"))
+	if !ok {
+		c.AbortWithJSONError(http.StatusNotFound, fmt.Errorf("unknown model %s", c.Param("model")))
+		return
+	}
+	var (
+		code   int
+		data   interface{}
+		errRes error
+	)
+	err := models.ExecuteInNewEnvironmentContext(c.Request.Context(), c.GetInt64("uid"), func(env models.Environment) {
+		code, data, errRes = fnct(env.WithAPIScope(c.APIScope()).Pool(model.Name()))
+	})
+	switch {
+	case errRes == errNotFound:
+		c.AbortWithJSONError(http.StatusNotFound, errRes)
+	case errRes != nil:
+		c.AbortWithJSONError(http.StatusBadRequest, errRes)
+	case err != nil:
+		c.AbortWithJSONError(0, err)
+	case data == nil:
+		c.Status(code)
+	default:
+		c.JSON(code, data)
+	}
+}
+
+// searchRecords is the controller that lists the records of a model
+func searchRecords(c *server.Context) {
+	params, err := parseListParams(c)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	execute(c, func(rc *models.RecordCollection) (int, interface{}, error) {
+		rc = rc.SearchAll()
+		if len(params.domain) > 0 {
+			cond := models.ParseDomain(params.domain, rc.Model())
+			rc.CheckFieldsAccess(cond.FieldNames()...)
+			rc = rc.Search(cond)
+		}
+		count := rc.SearchCount()
+		if len(params.order) > 0 {
+			rc.CheckFieldsAccess(rc.Model().OrderFieldNames(params.order...)...)
+			rc = rc.OrderBy(params.order...)
+		}
+		rc = rc.Limit(params.limit).Offset(params.offset)
+		records, err := readRecords(rc, params.fields)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, map[string]interface{}{
+			"count":   count,
+			"limit":   params.limit,
+			"offset":  params.offset,
+			"records": records,
+		}, nil
+	})
+}
+
+// readRecord is the controller that returns a single record
+func readRecord(c *server.Context) {
+	fields := parseFields(c.Query("fields"))
+	execute(c, func(rc *models.RecordCollection) (int, interface{}, error) {
+		rec, err := browseRecord(rc, c.Param("id"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return firstRecord(http.StatusOK, rec, fields)
+	})
+}
+
+// createRecord is the controller that creates a new record
+func createRecord(c *server.Context) {
+	values, err := bindValues(c)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	execute(c, func(rc *models.RecordCollection) (int, interface{}, error) {
+		fMap, err := convertValues(rc.Model(), values)
+		if err != nil {
+			return 0, nil, err
+		}
+		rec := rc.CallRPC("Create", models.NewModelDataFromRS(rc, fMap)).(models.RecordSet).Collection()
+		return firstRecord(http.StatusCreated, rec, nil)
+	})
+}
+
+// updateRecord is the controller that updates a record
+func updateRecord(c *server.Context) {
+	values, err := bindValues(c)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	execute(c, func(rc *models.RecordCollection) (int, interface{}, error) {
+		rec, err := browseRecord(rc, c.Param("id"))
+		if err != nil {
+			return 0, nil, err
+		}
+		fMap, err := convertValues(rec.Model(), values)
+		if err != nil {
+			return 0, nil, err
+		}
+		rec.CallRPC("Write", models.NewModelDataFromRS(rec, fMap))
+		return firstRecord(http.StatusOK, rec, nil)
+	})
+}
+
+// deleteRecord is the controller that deletes a record
+func deleteRecord(c *server.Context) {
+	execute(c, func(rc *models.RecordCollection) (int, interface{}, error) {
+		rec, err := browseRecord(rc, c.Param("id"))
+		if err != nil {
+			return 0, nil, err
+		}
+		rec.CallRPC("Unlink")
+		return http.StatusNoContent, nil, nil
+	})
+}
+
+// browseRecord returns the record of rc's model with the given id.
+// It returns errNotFound if the record does not exist or is not
+// visible to the current user.
+func browseRecord(rc *models.RecordCollection, idStr string) (*models.RecordCollection, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id %s", idStr)
+	}
+	rec := rc.Search(rc.Model().Field(models.ID).Equals(id)).Fetch()
+	if rec.IsEmpty() {
+		return nil, errNotFound
+	}
+	return rec, nil
+}
+
+// readRecords returns the values of the given fields of the records of rc
+// as a slice of maps, indexed by the fields JSON names. If fields is empty,
+// all the stored fields are returned. It returns an error if one of the
+// given fields does not exist.
+//
+// Relation fields are returned as the id of the related record or as a
+// list of ids for x2many fields.
+func readRecords(rc *models.RecordCollection, fields []string) ([]map[string]interface{}, error) {
+	fInfos := rc.Model().FieldsGet()
+	if len(fields) == 0 {
+		for jsonName, fi := range fInfos {
+			if fi.Store {
+				fields = append(fields, jsonName)
+			}
+		}
+	}
+	fieldNames := make(models.FieldNames, len(fields))
+	for i, f := range fields {
+		fi, err := fieldInfo(rc.Model(), fInfos, f)
+		if err != nil {
+			return nil, err
+		}
+		fieldNames[i] = rc.Model().FieldName(fi.JSON)
+	}
+	recsData := rc.CallRPC("Read", fieldNames).([]models.RecordData)
+	res := make([]map[string]interface{}, len(recsData))
+	for i, recData := range recsData {
+		res[i] = make(map[string]interface{})
+		for jsonName, value := range recData.Underlying().FieldMap {
+			res[i][jsonName] = serializeValue(fInfos[jsonName], value)
+		}
+	}
+	return res, nil
+}
+
+// firstRecord returns the given status and the values of the given
+// fields of the first record of rc, as returned by readRecords.
+func firstRecord(status int, rc *models.RecordCollection, fields []string) (int, interface{}, error) {
+	records, err := readRecords(rc, fields)
+	if err != nil {
+		return 0, nil, err
+	}
+	return status, records[0], nil
+}
+
+// fieldInfo returns the info of the field of the given model with the given
+// name or JSON name from fInfos, or an error if there is no such field.
+func fieldInfo(model *models.Model, fInfos map[string]*models.FieldInfo, name string) (*models.FieldInfo, error) {
+	f, ok := model.Fields().Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s", name)
+	}
+	fi, ok := fInfos[f.JSON()]
+	if !ok || fi == nil {
+		return nil, fmt.Errorf("unknown field %s", name)
+	}
+	return fi, nil
+}
+
+// serializeValue returns the given value of a field with the given info
+// as it should be output by the REST API.
+func serializeValue(fi *models.FieldInfo, value interface{}) interface{} {
+	rs, ok := value.(models.RecordSet)
+	if !ok {
+		return value
+	}
+	ids := rs.Collection().Ids()
+	if fi != nil && fi.Type.Is2ManyRelationType() {
+		return ids
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return ids[0]
+}
+
+// convertValues returns a FieldMap from the given values decoded from a
+// JSON request body. Date and DateTime values are parsed from strings
+// and the id is removed. It returns an error if one of the keys of values
+// is not a field of the model.
+func convertValues(model *models.Model, values map[string]interface{}) (models.FieldMap, error) {
+	res := make(models.FieldMap)
+	fInfos := model.FieldsGet()
+	for key, value := range values {
+		fi, err := fieldInfo(model, fInfos, key)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Name == "ID" {
+			continue
+		}
+		if str, ok := value.(string); ok {
+			switch fi.Type {
+			case fieldtype.Date:
+				value = dates.ParseDate(str)
+			case fieldtype.DateTime:
+				value = dates.ParseDateTime(str)
+			}
+		}
+		res[fi.JSON] = value
+	}
+	return res, nil
+}
+
+// bindValues decodes the JSON object of the request body
+func bindValues(c *server.Context) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	if err := json.NewDecoder(c.Request.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %s", err)
+	}
+	return values, nil
+}
+
+// parseListParams returns the listParams from the query parameters of the request
+func parseListParams(c *server.Context) (listParams, error) {
+	params := listParams{
+		fields: parseFields(c.Query("fields")),
+		limit:  DefaultLimit,
+	}
+	if domStr := c.Query("domain"); domStr != "" {
+		if err := json.Unmarshal([]byte(domStr), &params.domain); err != nil {
+			return params, fmt.Errorf("invalid domain: %s", err)
+		}
+	}
+	for param, target := range map[string]*int{"limit": &params.limit, "offset": &params.offset} {
+		valStr := c.Query(param)
+		if valStr == "" {
+			continue
+		}
+		val, err := strconv.Atoi(valStr)
+		if err != nil || val < 0 {
+			return params, fmt.Errorf("invalid %s: %s", param, valStr)
+		}
+		*target = val
+	}
+	if params.limit < 1 || params.limit > MaxLimit {
+		return params, fmt.Errorf("invalid limit: %d, it must be between 1 and %d", params.limit, MaxLimit)
+	}
+	if orderStr := c.Query("order"); orderStr != "" {
+		for _, expr := range strings.Split(orderStr, ",") {
+			params.order = append(params.order, strings.TrimSpace(expr))
+		}
+	}
+	return params, nil
+}
+
+// parseFields returns the field names of the given comma separated list
+func parseFields(fieldsStr string) []string {
+	if fieldsStr == "" {
+		return nil
+	}
+	var res []string
+	for _, f := range strings.Split(fieldsStr, ",") {
+		res = append(res, strings.TrimSpace(f))
+	}
+	return res
+}
@@ -0,0 +1,300 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testAuthBackend struct{}
+
+// testUsers are the uids of the users of testAuthBackend
+var testUsers = map[string]int64{
+	"admin": 1,
+	"john":  2,
+}
+
+func (tab testAuthBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	uid, ok := testUsers[login]
+	if !ok {
+		return 0, security.UserNotFoundError(login)
+	}
+	if secret != "secret" {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	return uid, nil
+}
+
+func performRequest(method, path string, auth bool) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if auth {
+		req.SetBasicAuth("admin", "secret")
+	}
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+// performRequestAs performs a request with the given JSON body
+// authenticated as the user with the given login.
+func performRequestAs(login, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.SetBasicAuth(login, "secret")
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+// createTestDatabase creates and connects the test database of this
+// package, as the models tests do. It returns a function that drops
+// the database, or an error if the database server cannot be reached.
+func createTestDatabase() (func(), error) {
+	driver, user, password, prefix := os.Getenv("HEXYA_DB_DRIVER"), os.Getenv("HEXYA_DB_USER"),
+		os.Getenv("HEXYA_DB_PASSWORD"), os.Getenv("HEXYA_DB_PREFIX")
+	if driver == "" {
+		driver = "postgres"
+	}
+	if user == "" {
+		user = "hexya"
+	}
+	if password == "" {
+		password = "hexya"
+	}
+	if prefix == "" {
+		prefix = "hexya"
+	}
+	dbName := fmt.Sprintf("%s_rest_tests", prefix)
+	adminConnStr := fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s", user, password)
+	admDB, err := sqlx.Connect(driver, adminConnStr)
+	if err != nil {
+		return nil, err
+	}
+	admDB.MustExec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", dbName))
+	admDB.MustExec(fmt.Sprintf("CREATE DATABASE %s", dbName))
+	admDB.Close()
+	models.DBConnect(models.ConnectionParams{
+		Driver:   driver,
+		DBName:   dbName,
+		User:     user,
+		Password: password,
+		SSLMode:  "disable",
+	})
+	return func() {
+		models.DBClose()
+		admDB := sqlx.MustConnect(driver, adminConnStr)
+		admDB.MustExec(fmt.Sprintf("DROP DATABASE %s", dbName))
+		admDB.Close()
+	}, nil
+}
+
+func newTestContext(query string) *server.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/User?"+query, nil)
+	return &server.Context{Context: ctx}
+}
+
+func TestREST(t *testing.T) {
+	Convey("Bootstrapping REST API before models should panic", t, func() {
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Creating models and bootstrapping", t, func() {
+		user := models.NewModel("User")
+		tag := models.NewModel("Tag")
+		models.NewMixinModel("Activable")
+		user.AddFields(map[string]models.FieldDefinition{
+			"Name":     fields.Char{Required: true, Help: "The user's name"},
+			"Age":      fields.Integer{},
+			"Birthday": fields.Date{},
+			"Status": fields.Selection{Selection: types.Selection{
+				"active": "Active", "inactive": "Inactive"}},
+			"Tags": fields.Many2Many{RelationModel: models.Registry.MustGet("Tag")},
+		})
+		tag.AddFields(map[string]models.FieldDefinition{
+			"Name": fields.Char{},
+		})
+		models.BootStrap()
+		security.AuthenticationRegistry.RegisterBackend(testAuthBackend{})
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Testing the OpenAPI document", t, func() {
+		doc := OpenAPIDocument()
+		So(doc["openapi"], ShouldEqual, OpenAPIVersion)
+		paths := doc["paths"].(map[string]interface{})
+		So(paths, ShouldContainKey, "/User")
+		So(paths, ShouldContainKey, "/User/{id}")
+		So(paths, ShouldContainKey, "/Tag")
+		So(paths, ShouldNotContainKey, "/Activable")
+		userSchema := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})["User"].(map[string]interface{})
+		So(userSchema["required"], ShouldResemble, []string{"name"})
+		props := userSchema["properties"].(map[string]interface{})
+		So(props["name"], ShouldResemble, map[string]interface{}{
+			"type": "string", "title": "Name", "description": "The user's name"})
+		So(props["age"].(map[string]interface{})["type"], ShouldEqual, "integer")
+		So(props["birthday"].(map[string]interface{})["format"], ShouldEqual, "date")
+		So(props["status"].(map[string]interface{})["enum"], ShouldResemble, []string{"active", "inactive"})
+		So(props["tags_ids"].(map[string]interface{})["type"], ShouldEqual, "array")
+		So(props["tags_ids"].(map[string]interface{})["x-relation"], ShouldEqual, "Tag")
+		So(props["id"].(map[string]interface{})["readOnly"], ShouldBeTrue)
+	})
+	Convey("Testing query parameters", t, func() {
+		params, err := parseListParams(newTestContext(`domain=[["name","=","John"]]&fields=name,%20age&limit=10&offset=20&order=name%20desc,id`))
+		So(err, ShouldBeNil)
+		So(params.domain, ShouldResemble, []interface{}{[]interface{}{"name", "=", "John"}})
+		So(params.fields, ShouldResemble, []string{"name", "age"})
+		So(params.limit, ShouldEqual, 10)
+		So(params.offset, ShouldEqual, 20)
+		So(params.order, ShouldResemble, []string{"name desc", "id"})
+		params, err = parseListParams(newTestContext(""))
+		So(err, ShouldBeNil)
+		So(params.limit, ShouldEqual, DefaultLimit)
+		_, err = parseListParams(newTestContext("limit=-1"))
+		So(err, ShouldNotBeNil)
+		_, err = parseListParams(newTestContext("limit=0"))
+		So(err, ShouldNotBeNil)
+		_, err = parseListParams(newTestContext(fmt.Sprintf("limit=%d", MaxLimit+1)))
+		So(err, ShouldNotBeNil)
+		params, err = parseListParams(newTestContext(fmt.Sprintf("limit=%d", MaxLimit)))
+		So(err, ShouldBeNil)
+		So(params.limit, ShouldEqual, MaxLimit)
+		_, err = parseListParams(newTestContext("domain=[foo"))
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Testing body values", t, func() {
+		userModel := models.Registry.MustGet("User")
+		Convey("Values should be indexed by JSON name and dates parsed", func() {
+			fMap, err := convertValues(userModel, map[string]interface{}{"id": 3, "Name": "John", "birthday": "2000-01-02"})
+			So(err, ShouldBeNil)
+			So(fMap, ShouldNotContainKey, "id")
+			So(fMap["name"], ShouldEqual, "John")
+			So(fMap["birthday"], ShouldHaveSameTypeAs, dates.Date{})
+		})
+		Convey("Unknown fields should return an error", func() {
+			_, err := convertValues(userModel, map[string]interface{}{"nmae": "John"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown field nmae")
+		})
+	})
+	Convey("Testing routes", t, func() {
+		Convey("Requests without authentication should fail", func() {
+			w := performRequest(http.MethodGet, "/api/openapi.json", false)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Header().Get("WWW-Authenticate"), ShouldNotBeEmpty)
+		})
+		Convey("Requests with wrong credentials should fail", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/api/openapi.json", nil)
+			req.SetBasicAuth("admin", "wrong")
+			w := httptest.NewRecorder()
+			server.GetServer().ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Requests with an invalid API key should fail", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/api/openapi.json", nil)
+			req.Header.Set("Authorization", "Bearer invalid")
+			w := httptest.NewRecorder()
+			server.GetServer().ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Getting the OpenAPI document", func() {
+			w := performRequest(http.MethodGet, "/api/openapi.json", true)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			var doc map[string]interface{}
+			So(json.Unmarshal(w.Body.Bytes(), &doc), ShouldBeNil)
+			So(doc["paths"], ShouldContainKey, "/User")
+		})
+		Convey("Requesting an unknown model should return 404", func() {
+			w := performRequest(http.MethodGet, "/api/v1/Unknown", true)
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+			w = performRequest(http.MethodDelete, "/api/v1/Activable/1", true)
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestRESTPermissions(t *testing.T) {
+	dropDatabase, err := createTestDatabase()
+	if err != nil {
+		t.Skipf("Test database unavailable: %s", err)
+	}
+	defer dropDatabase()
+	models.SyncDatabase()
+	tagModel := models.Registry.MustGet("Tag")
+	group := security.Registry.NewGroup("rest_test_group", "REST Test Group")
+	security.Registry.AddMembership(testUsers["john"], group)
+	createTag := func(name string) int64 {
+		w := performRequestAs("admin", http.MethodPost, "/api/v1/Tag", fmt.Sprintf(`{"name": %q}`, name))
+		So(w.Code, ShouldEqual, http.StatusCreated)
+		var res map[string]interface{}
+		So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+		return int64(res["id"].(float64))
+	}
+	Convey("Testing permissions", t, func() {
+		visibleID := createTag("visible")
+		hiddenID := createTag("hidden")
+		visiblePath := fmt.Sprintf("/api/v1/Tag/%d", visibleID)
+		hiddenPath := fmt.Sprintf("/api/v1/Tag/%d", hiddenID)
+		Convey("Users without execution permissions should be denied", func() {
+			So(performRequestAs("john", http.MethodGet, "/api/v1/Tag", "").Code, ShouldEqual, http.StatusForbidden)
+			So(performRequestAs("john", http.MethodGet, visiblePath, "").Code, ShouldEqual, http.StatusForbidden)
+			So(performRequestAs("john", http.MethodPost, "/api/v1/Tag", `{"name": "new"}`).Code, ShouldEqual, http.StatusForbidden)
+			So(performRequestAs("john", http.MethodPatch, visiblePath, `{"name": "renamed"}`).Code, ShouldEqual, http.StatusForbidden)
+			So(performRequestAs("john", http.MethodDelete, visiblePath, "").Code, ShouldEqual, http.StatusForbidden)
+		})
+		Convey("Users should only access the records allowed by the record rules", func() {
+			tagModel.Methods().AllowAllToGroup(group)
+			tagModel.AddRecordRule(&models.RecordRule{
+				Name:      "rest_test_visible_tags",
+				Group:     group,
+				Condition: tagModel.Field(tagModel.FieldName("Name")).Equals("visible"),
+				Perms:     security.All,
+			})
+			w := performRequestAs("john", http.MethodGet, "/api/v1/Tag?fields=name", "")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			var res map[string]interface{}
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			So(res["count"], ShouldEqual, 1)
+			So(res["records"], ShouldResemble, []interface{}{map[string]interface{}{"id": float64(visibleID), "name": "visible"}})
+			So(performRequestAs("john", http.MethodGet, visiblePath, "").Code, ShouldEqual, http.StatusOK)
+			So(performRequestAs("john", http.MethodGet, hiddenPath, "").Code, ShouldEqual, http.StatusNotFound)
+			So(performRequestAs("john", http.MethodPatch, hiddenPath, `{"name": "renamed"}`).Code, ShouldEqual, http.StatusNotFound)
+			So(performRequestAs("john", http.MethodDelete, hiddenPath, "").Code, ShouldEqual, http.StatusNotFound)
+			So(performRequestAs("john", http.MethodPatch, visiblePath, `{"name": "visible"}`).Code, ShouldEqual, http.StatusOK)
+			So(performRequestAs("admin", http.MethodGet, hiddenPath, "").Code, ShouldEqual, http.StatusOK)
+			Reset(func() {
+				tagModel.RemoveRecordRule("rest_test_visible_tags")
+				tagModel.Methods().RevokeAllFromGroup(group)
+			})
+		})
+		Convey("Unknown fields should be rejected with 400", func() {
+			So(performRequestAs("admin", http.MethodGet, visiblePath+"?fields=nmae", "").Code, ShouldEqual, http.StatusBadRequest)
+			So(performRequestAs("admin", http.MethodGet, "/api/v1/Tag?fields=name,nmae", "").Code, ShouldEqual, http.StatusBadRequest)
+			So(performRequestAs("admin", http.MethodPost, "/api/v1/Tag", `{"nmae": "new"}`).Code, ShouldEqual, http.StatusBadRequest)
+			So(performRequestAs("admin", http.MethodPatch, visiblePath, `{"nmae": "new"}`).Code, ShouldEqual, http.StatusBadRequest)
+		})
+		Reset(func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				env.Pool("Tag").SearchAll().Call("Unlink")
+			}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,750 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/hexya-erp/hexya/src/i18n"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/xmlutils"
+	"github.com/hexya-erp/hexya/src/views"
+)
+
+// A methodAdapter calls a model method on rc from the positional
+// and keyword arguments given with Odoo's conventions.
+type methodAdapter func(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{}
+
+// methodAdapters are the adapters of the methods whose arguments
+// must be converted, indexed by the method name given by the client.
+var methodAdapters = map[string]methodAdapter{
+	"search_read":     searchRead,
+	"search":          search,
+	"search_count":    searchCount,
+	"read":            read,
+	"create":          create,
+	"write":           write,
+	"unlink":          unlink,
+	"name_get":        nameGet,
+	"name_search":     nameSearch,
+	"read_group":      readGroup,
+	"fields_get":      fieldsGet,
+	"fields_view_get": fieldsViewGet,
+}
+
+// executeKw calls the given method of the given model in the Environment
+// of the user with the given uid, restricted to the given scope if it is
+// not nil, and returns its result. The Environment is bound to ctx.
+func executeKw(ctx context.Context, uid int64, scope *models.APIScope, modelName, method string, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	model, ok := exposedModel(modelName)
+	if !ok {
+		return nil, fmt.Errorf("unknown model %s", modelName)
+	}
+	var (
+		res  interface{}
+		lang string
+	)
+	err := models.ExecuteInNewEnvironmentContext(ctx, uid, func(env models.Environment) {
+		rc := env.WithAPIScope(scope).Pool(model.Name())
+		if kwCtx, ok := kwargs["context"].(map[string]interface{}); ok {
+			newCtx := env.Context().Copy()
+			for key, value := range kwCtx {
+				if strings.HasPrefix(key, "hexya_") {
+					// Internal keys change the behaviour of the ORM
+					// and must not be set by clients
+					continue
+				}
+				newCtx = newCtx.WithKey(key, value)
+			}
+			rc = rc.WithNewContext(newCtx)
+		}
+		lang = rc.Env().Context().GetString("lang")
+		if adapter, ok := methodAdapters[method]; ok {
+			res = adapter(rc, args, kwargs)
+			return
+		}
+		res = callMethod(rc, method, args)
+	})
+	if err != nil && lang != "" {
+		// Formatted messages are translated when they are formatted,
+		// constant ones such as concurrency errors are translated here.
+		err = exceptions.WithMessage(err, i18n.TranslateCode(lang, "", exceptions.Message(err)))
+	}
+	return res, err
+}
+
+// exposedModel returns the model with the given name if it can be called
+// through RPC. Odoo style names such as 'res.partner' are searched as
+// table names.
+func exposedModel(name string) (*models.Model, bool) {
+	model, ok := models.Registry.Get(name)
+	if !ok {
+		model, ok = models.Registry.Get(strings.Replace(name, ".", "_", -1))
+	}
+	if !ok || model.IsMixin() || model.IsM2MLink() {
+		return nil, false
+	}
+	return model, true
+}
+
+// searchRead implements the search_read method
+func searchRead(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	recs := searchRecords(rc,
+		argument(args, kwargs, 0, "domain"),
+		argument(args, kwargs, 2, "offset"),
+		argument(args, kwargs, 3, "limit"),
+		argument(args, kwargs, 4, "order"))
+	return readRecords(recs, toStrings(argument(args, kwargs, 1, "fields")))
+}
+
+// search implements the search method
+func search(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	checkReadAccess(rc)
+	recs := searchRecords(rc,
+		argument(args, kwargs, 0, "args"),
+		argument(args, kwargs, 1, "offset"),
+		argument(args, kwargs, 2, "limit"),
+		argument(args, kwargs, 3, "order"))
+	if count, _ := argument(args, kwargs, 4, "count").(bool); count {
+		return recs.SearchCount()
+	}
+	return recs.Ids()
+}
+
+// searchCount implements the search_count method
+func searchCount(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	checkReadAccess(rc)
+	return searchRecords(rc, argument(args, kwargs, 0, "args"), nil, nil, nil).SearchCount()
+}
+
+// read implements the read method
+func read(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	recs := browse(rc, argument(args, kwargs, 0, "ids"))
+	return readRecords(recs, toStrings(argument(args, kwargs, 1, "fields")))
+}
+
+// create implements the create method.
+//
+// It returns the id of the created record, or a list of ids
+// if a list of values is given.
+func create(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	switch vals := argument(args, kwargs, 0, "vals").(type) {
+	case map[string]interface{}:
+		return rc.CallRPC("Create", convertValues(rc, vals)).(models.RecordSet).Collection().Ids()[0]
+	case []interface{}:
+		res := make([]int64, len(vals))
+		for i, v := range vals {
+			vMap, ok := v.(map[string]interface{})
+			if !ok {
+				log.Panic("create values must be a dictionary", "model", rc.ModelName(), "values", v)
+			}
+			res[i] = rc.CallRPC("Create", convertValues(rc, vMap)).(models.RecordSet).Collection().Ids()[0]
+		}
+		return res
+	default:
+		log.Panic("create values must be a dictionary", "model", rc.ModelName(), "values", vals)
+	}
+	return nil
+}
+
+// write implements the write method.
+//
+// If the values contain x2many fields, the records are written one by one
+// so that relative commands are applied to the value of each record.
+func write(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	recs := browse(rc, argument(args, kwargs, 0, "ids"))
+	vals, ok := argument(args, kwargs, 1, "vals").(map[string]interface{})
+	if !ok {
+		log.Panic("write values must be a dictionary", "model", rc.ModelName())
+	}
+	fInfos := rc.Model().FieldsGet()
+	for key := range vals {
+		if fInfos[rc.Model().JSONizeFieldName(key)].Type.Is2ManyRelationType() {
+			for _, rec := range recs.Records() {
+				rec.CallRPC("Write", convertValues(rec, vals))
+			}
+			return true
+		}
+	}
+	recs.CallRPC("Write", convertValues(recs, vals))
+	return true
+}
+
+// unlink implements the unlink method
+func unlink(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	browse(rc, argument(args, kwargs, 0, "ids")).CallRPC("Unlink")
+	return true
+}
+
+// nameGet implements the name_get method
+func nameGet(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	return nameGetRecords(browse(rc, argument(args, kwargs, 0, "ids")))
+}
+
+// nameSearch implements the name_search method
+func nameSearch(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	name, _ := argument(args, kwargs, 0, "name").(string)
+	domain := toDomain(argument(args, kwargs, 1, "args"))
+	op := operator.IContains
+	if opStr, ok := argument(args, kwargs, 2, "operator").(string); ok && opStr != "" {
+		op = operator.Operator(opStr)
+		if !op.IsValid() {
+			log.Panic("Unknown operator", "operator", opStr)
+		}
+	}
+	limit := toInt(argument(args, kwargs, 3, "limit"), 100)
+	recs := rc.CallRPC("SearchByName", name, op, models.ParseDomain(domain, rc.Model()), limit).(models.RecordSet).Collection()
+	return nameGetRecords(recs)
+}
+
+// readGroup implements the read_group method
+func readGroup(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	checkReadAccess(rc)
+	lazy := true
+	if l, ok := argument(args, kwargs, 6, "lazy").(bool); ok {
+		lazy = l
+	}
+	var groupBy models.FieldNames
+	for _, gb := range toStrings(argument(args, kwargs, 2, "groupby")) {
+		groupBy = append(groupBy, rc.Model().FieldName(strings.Split(gb, ":")[0]))
+	}
+	if len(groupBy) == 0 {
+		log.Panic("read_group needs at least one groupby field", "model", rc.ModelName())
+	}
+	if lazy {
+		groupBy = groupBy[:1]
+	}
+	aggFields := append(models.FieldNames{}, groupBy...)
+	for _, f := range toStrings(argument(args, kwargs, 1, "fields")) {
+		aggFields = append(aggFields, rc.Model().FieldName(strings.Split(f, ":")[0]))
+	}
+	rc.CheckFieldsAccess(aggFields...)
+	recs := searchRecords(rc,
+		argument(args, kwargs, 0, "domain"),
+		argument(args, kwargs, 3, "offset"),
+		argument(args, kwargs, 4, "limit"),
+		argument(args, kwargs, 5, "orderby"))
+	fInfos := rc.Model().FieldsGet()
+	countKey := "__count"
+	if lazy {
+		countKey = fmt.Sprintf("%s_count", groupBy[0].JSON())
+	}
+	var res []map[string]interface{}
+	for _, row := range recs.GroupBy(groupBy...).Aggregates(aggFields...) {
+		group := map[string]interface{}{
+			countKey:   row.Count,
+			"__domain": row.Condition.Serialize(),
+		}
+		for jsonName, value := range row.Values.FieldMap {
+			group[jsonName] = serializeValue(fInfos[jsonName], value)
+		}
+		res = append(res, group)
+	}
+	return res
+}
+
+// fieldsGet implements the fields_get method
+func fieldsGet(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	var fields models.FieldNames
+	for _, f := range toStrings(argument(args, kwargs, 0, "allfields")) {
+		fields = append(fields, rc.Model().FieldName(f))
+	}
+	return normalizeResult(rc.CallRPC("FieldsGet", models.FieldsGetArgs{Fields: fields}))
+}
+
+// fieldsViewGet implements the fields_view_get method.
+//
+// The view is given by its id or else by its type, in which case the
+// first view of this type for rc's model is returned.
+func fieldsViewGet(rc *models.RecordCollection, args []interface{}, kwargs map[string]interface{}) interface{} {
+	viewID, _ := argument(args, kwargs, 0, "view_id").(string)
+	viewType, _ := argument(args, kwargs, 1, "view_type").(string)
+	if viewType == "" {
+		viewType = string(views.ViewTypeForm)
+	}
+	var view *views.View
+	if viewID != "" {
+		view = views.Registry.GetByID(viewID)
+	} else {
+		view = views.Registry.GetFirstViewForModel(rc.ModelName(), views.ViewType(viewType))
+	}
+	if view == nil || view.Model != rc.ModelName() {
+		log.Panic("Unknown view", "model", rc.ModelName(), "viewID", viewID, "viewType", viewType)
+	}
+	return normalizeResult(viewDescription(rc, view))
+}
+
+// viewDescription returns the arch of the given view of rc's model in the
+// language of rc's context together with the definition of its fields.
+//
+// The fields that the current user is not allowed to access are removed
+// from the arch and from the fields, as well as their embedded views.
+func viewDescription(rc *models.RecordCollection, view *views.View) map[string]interface{} {
+	arch, err := xmlutils.DocumentToXMLNoIndent(view.ArchForUser(rc.Env().Context().GetString("lang"), rc.Env().Uid()))
+	if err != nil {
+		log.Panic("Unable to serialize view arch", "view", view.ID, "error", err)
+	}
+	fInfos := make(map[string]*models.FieldInfo)
+	if len(view.Fields) > 0 {
+		fieldNames := make(models.FieldNames, len(view.Fields))
+		for i, f := range view.Fields {
+			fieldNames[i] = rc.Model().FieldName(f)
+		}
+		fInfos = rc.CallRPC("FieldsGet", models.FieldsGetArgs{Fields: fieldNames}).(map[string]*models.FieldInfo)
+	}
+	for fieldName, subViews := range view.SubViews {
+		fInfo, ok := fInfos[rc.Model().JSONizeFieldName(fieldName)]
+		if !ok {
+			continue
+		}
+		relRC := rc.Env().Pool(fInfo.Relation)
+		fInfo.Views = make(map[string]interface{})
+		for subViewType, subView := range subViews {
+			fInfo.Views[string(subViewType)] = viewDescription(relRC, subView)
+		}
+	}
+	return map[string]interface{}{
+		"arch":         string(arch),
+		"fields":       fInfos,
+		"model":        view.Model,
+		"name":         view.Name,
+		"type":         string(view.Type),
+		"view_id":      view.ID,
+		"field_parent": view.FieldParent,
+	}
+}
+
+// callMethod calls the method given by its snake case name on rc.
+//
+// If the first argument is a list of ids, the method is called on these
+// records. Other arguments are converted to the types of the method parameters.
+func callMethod(rc *models.RecordCollection, method string, args []interface{}) interface{} {
+	methName := goMethodName(method)
+	if _, ok := rc.Model().Methods().Get(methName); !ok {
+		methName = method
+	}
+	if len(args) > 0 {
+		if ids, ok := toIDs(args[0]); ok {
+			rc = browse(rc, ids)
+			args = args[1:]
+		}
+	}
+	rc.CheckRPCAccess(methName)
+	methType := rc.MethodType(methName)
+	if methType.IsVariadic() {
+		log.Panic("Methods with variadic parameters cannot be called through RPC", "model", rc.ModelName(), "method", methName)
+	}
+	if methType.NumIn()-1 != len(args) {
+		log.Panic("Wrong number of arguments", "model", rc.ModelName(), "method", methName, "expected", methType.NumIn()-1, "received", len(args))
+	}
+	callArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		target := reflect.New(methType.In(i + 1))
+		data, err := json.Marshal(arg)
+		if err == nil {
+			err = json.Unmarshal(data, target.Interface())
+		}
+		if err != nil {
+			log.Panic("Unable to convert argument", "model", rc.ModelName(), "method", methName, "argument", i, "error", err)
+		}
+		callArgs[i] = target.Elem().Interface()
+	}
+	return normalizeResult(rc.CallRPC(methName, callArgs...))
+}
+
+// searchRecords returns the records of rc's model matching the given domain
+// with the given offset, limit and order arguments.
+func searchRecords(rc *models.RecordCollection, domain, offset, limit, order interface{}) *models.RecordCollection {
+	rc = rc.SearchAll()
+	if dom := toDomain(domain); len(dom) > 0 {
+		cond := models.ParseDomain(dom, rc.Model())
+		rc.CheckFieldsAccess(cond.FieldNames()...)
+		rc = rc.Search(cond)
+	}
+	if o := toInt(offset, 0); o > 0 {
+		rc = rc.Offset(o)
+	}
+	if l := toInt(limit, 0); l > 0 {
+		rc = rc.Limit(l)
+	}
+	if orderStr, ok := order.(string); ok && orderStr != "" {
+		var exprs []string
+		for _, expr := range strings.Split(orderStr, ",") {
+			exprs = append(exprs, strings.TrimSpace(expr))
+		}
+		rc.CheckFieldsAccess(rc.Model().OrderFieldNames(exprs...)...)
+		rc = rc.OrderBy(exprs...)
+	}
+	return rc
+}
+
+// browse returns the records of rc's model with the given ids.
+// It panics if some of the records do not exist or are not visible
+// to the current user.
+func browse(rc *models.RecordCollection, ids interface{}) *models.RecordCollection {
+	idList, ok := toIDs(ids)
+	if !ok {
+		log.Panic("Invalid ids", "model", rc.ModelName(), "ids", ids)
+	}
+	recs := rc.Search(rc.Model().Field(models.ID).In(idList)).Fetch()
+	if recs.Len() != len(uniqueIDs(idList)) {
+		log.Panic("Some records do not exist or you are not allowed to access them", "model", rc.ModelName(), "ids", idList)
+	}
+	return recs
+}
+
+// checkReadAccess panics if the current user is not allowed to read the records of rc's model
+func checkReadAccess(rc *models.RecordCollection) {
+	rc.CheckRPCAccess("Load")
+	rc.CheckExecutionPermission(rc.Model().Methods().MustGet("Load"))
+}
+
+// readRecords returns the values of the given fields of the records of rc
+// as a slice of maps, indexed by the fields JSON names. If fields is empty,
+// all the fields are returned.
+func readRecords(rc *models.RecordCollection, fields []string) []map[string]interface{} {
+	fInfos := rc.Model().FieldsGet()
+	if len(fields) == 0 {
+		for jsonName := range fInfos {
+			fields = append(fields, jsonName)
+		}
+	}
+	fieldNames := make(models.FieldNames, len(fields))
+	for i, f := range fields {
+		fieldNames[i] = rc.Model().FieldName(f)
+	}
+	recsData := rc.CallRPC("Read", fieldNames).([]models.RecordData)
+	res := make([]map[string]interface{}, len(recsData))
+	for i, recData := range recsData {
+		res[i] = make(map[string]interface{})
+		for jsonName, value := range recData.Underlying().FieldMap {
+			res[i][jsonName] = serializeValue(fInfos[jsonName], value)
+		}
+	}
+	return res
+}
+
+// nameGetRecords returns the list of [id, display name] pairs of the records of rc
+func nameGetRecords(rc *models.RecordCollection) [][]interface{} {
+	res := make([][]interface{}, 0, rc.Len())
+	for _, rec := range rc.Records() {
+		res = append(res, []interface{}{rec.Ids()[0], rec.CallRPC("NameGet")})
+	}
+	return res
+}
+
+// serializeValue returns the given value of a field with the given info
+// as expected by Odoo clients:
+//
+// - Empty values are returned as false,
+// - Many2one values are returned as [id, display name] pairs,
+// - x2many values are returned as lists of ids,
+// - Dates and datetimes are returned as strings.
+func serializeValue(fi *models.FieldInfo, value interface{}) interface{} {
+	switch val := value.(type) {
+	case nil:
+		return false
+	case models.RecordSet:
+		rc := val.Collection()
+		if fi != nil && fi.Type.Is2ManyRelationType() {
+			return rc.Ids()
+		}
+		if rc.IsEmpty() {
+			return false
+		}
+		return []interface{}{rc.Ids()[0], rc.Sudo().Call("NameGet")}
+	case dates.Date:
+		if val.IsZero() {
+			return false
+		}
+		return val.String()
+	case dates.DateTime:
+		if val.IsZero() {
+			return false
+		}
+		return val.String()
+	}
+	return value
+}
+
+// convertValues returns a ModelData for rc's model from the given values
+// sent by an Odoo client.
+//
+// Dates and datetimes are parsed from strings, integers given for float fields
+// are converted and x2many commands are applied to the current value of the
+// field if rc is a single record.
+func convertValues(rc *models.RecordCollection, vals map[string]interface{}) *models.ModelData {
+	model := rc.Model()
+	fInfos := model.FieldsGet()
+	fMap := make(models.FieldMap)
+	toCreate := make(map[string][]*models.ModelData)
+	for key, value := range vals {
+		fi := fInfos[model.JSONizeFieldName(key)]
+		if fi.Name == "ID" {
+			continue
+		}
+		switch {
+		case fi.Type.Is2ManyRelationType():
+			value, toCreate[fi.JSON] = x2ManyValue(rc, fi, value)
+		case fi.Type == fieldtype.Date:
+			if str, ok := value.(string); ok {
+				value = dates.ParseDate(str)
+			}
+		case fi.Type == fieldtype.DateTime:
+			if str, ok := value.(string); ok {
+				value = dates.ParseDateTime(str)
+			}
+		case fi.Type == fieldtype.Float:
+			if i, ok := value.(int64); ok {
+				value = float64(i)
+			}
+		}
+		fMap[fi.JSON] = value
+	}
+	res := models.NewModelDataFromRS(rc, fMap)
+	for field, data := range toCreate {
+		for _, d := range data {
+			res.Create(model.FieldName(field), d)
+		}
+	}
+	return res
+}
+
+// x2ManyValue returns the ids of the records to set in the x2many field
+// with the given info after applying the given value, which may be a list
+// of ids or a list of Odoo's x2many commands.
+//
+// It also returns the data of the records to create and link to the field.
+func x2ManyValue(rc *models.RecordCollection, fi *models.FieldInfo, value interface{}) ([]int64, []*models.ModelData) {
+	if ids, ok := toIDs(value); ok {
+		return ids, nil
+	}
+	cmds, ok := value.([]interface{})
+	if !ok {
+		log.Panic("Invalid value for x2many field", "model", rc.ModelName(), "field", fi.Name, "value", value)
+	}
+	var (
+		res      []int64
+		toCreate []*models.ModelData
+	)
+	if rc.Len() == 1 {
+		res = rc.Get(rc.Model().FieldName(fi.JSON)).(models.RecordSet).Collection().Ids()
+	}
+	relRC := rc.Env().Pool(fi.Relation)
+	for _, c := range cmds {
+		cmd, _ := c.([]interface{})
+		code, ok := int64(-1), false
+		if len(cmd) > 0 {
+			code, ok = toInt64(cmd[0])
+		}
+		var id int64
+		if len(cmd) > 1 {
+			id, _ = toInt64(cmd[1])
+		}
+		switch {
+		case !ok:
+			log.Panic("Invalid x2many command", "model", rc.ModelName(), "field", fi.Name, "command", c)
+		case code == 0 && len(cmd) > 2:
+			vals, _ := cmd[2].(map[string]interface{})
+			if fi.Type == fieldtype.Many2Many {
+				res = append(res, relRC.CallRPC("Create", convertValues(relRC, vals)).(models.RecordSet).Collection().Ids()[0])
+				continue
+			}
+			toCreate = append(toCreate, convertValues(relRC, vals))
+		case code == 1 && len(cmd) > 2:
+			vals, _ := cmd[2].(map[string]interface{})
+			rec := browse(relRC, id)
+			rec.CallRPC("Write", convertValues(rec, vals))
+		case code == 2:
+			browse(relRC, id).CallRPC("Unlink")
+			res = removeID(res, id)
+		case code == 3:
+			res = removeID(res, id)
+		case code == 4:
+			res = append(removeID(res, id), id)
+		case code == 5:
+			res = nil
+		case code == 6 && len(cmd) > 2:
+			res, ok = toIDs(cmd[2])
+			if !ok {
+				log.Panic("Invalid ids in x2many command", "model", rc.ModelName(), "field", fi.Name, "command", c)
+			}
+		default:
+			log.Panic("Invalid x2many command", "model", rc.ModelName(), "field", fi.Name, "command", c)
+		}
+	}
+	if res == nil {
+		res = []int64{}
+	}
+	return res, toCreate
+}
+
+// normalizeResult converts the given result of a method call into
+// values that can be encoded by XML-RPC and JSON-RPC.
+//
+// RecordSets are returned as lists of ids and other values are
+// converted through their JSON representation.
+func normalizeResult(value interface{}) interface{} {
+	if rs, ok := value.(models.RecordSet); ok {
+		return rs.Collection().Ids()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Panic("Unable to serialize method result", "error", err)
+	}
+	var res interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&res); err != nil {
+		log.Panic("Unable to serialize method result", "error", err)
+	}
+	return convertJSONNumbers(res)
+}
+
+// convertJSONNumbers returns the given decoded JSON value with
+// its json.Number values converted to int64 or float64.
+func convertJSONNumbers(value interface{}) interface{} {
+	switch val := value.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		for i, v := range val {
+			val[i] = convertJSONNumbers(v)
+		}
+	case map[string]interface{}:
+		for k, v := range val {
+			val[k] = convertJSONNumbers(v)
+		}
+	}
+	return value
+}
+
+// goMethodName returns the Go method name of the given snake case method name.
+// eg. action_confirm => ActionConfirm
+func goMethodName(method string) string {
+	var res strings.Builder
+	for _, part := range strings.Split(method, "_") {
+		runes := []rune(part)
+		if len(runes) == 0 {
+			continue
+		}
+		runes[0] = unicode.ToUpper(runes[0])
+		res.WriteString(string(runes))
+	}
+	return res.String()
+}
+
+// argument returns the argument with the given name in kwargs
+// or else the positional argument at index pos in args.
+// It returns nil if the argument is not given.
+func argument(args []interface{}, kwargs map[string]interface{}, pos int, name string) interface{} {
+	if val, ok := kwargs[name]; ok {
+		return val
+	}
+	if pos < len(args) {
+		return args[pos]
+	}
+	return nil
+}
+
+// toInt64 converts the given integer or float value to int64
+func toInt64(value interface{}) (int64, bool) {
+	switch val := value.(type) {
+	case int64:
+		return val, true
+	case int:
+		return int64(val), true
+	case float64:
+		return int64(val), true
+	}
+	return 0, false
+}
+
+// toInt returns the given value as an int or def if it is not a number
+func toInt(value interface{}, def int) int {
+	if val, ok := toInt64(value); ok {
+		return int(val)
+	}
+	return def
+}
+
+// toIDs converts the given id or list of ids to a slice of int64.
+// It returns false if value is neither.
+func toIDs(value interface{}) ([]int64, bool) {
+	if id, ok := toInt64(value); ok {
+		return []int64{id}, true
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	res := make([]int64, len(list))
+	for i, v := range list {
+		if res[i], ok = toInt64(v); !ok {
+			return nil, false
+		}
+	}
+	return res, true
+}
+
+// toStrings converts the given string or list of strings to a slice of strings
+func toStrings(value interface{}) []string {
+	switch val := value.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		var res []string
+		for _, v := range val {
+			if s, ok := v.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// toDomain returns the given value as a domain, or nil if it is not a list
+func toDomain(value interface{}) []interface{} {
+	res, _ := value.([]interface{})
+	return res
+}
+
+// removeID returns the given ids without id
+func removeID(ids []int64, id int64) []int64 {
+	var res []int64
+	for _, i := range ids {
+		if i != id {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// uniqueIDs returns the given ids without duplicates
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool)
+	var res []int64
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package rpc implements an external API compatible with Odoo's XML-RPC and
+JSON-RPC protocols, so that existing connectors and scripts can be used with
+Hexya without custom code.
+
+The API is mounted in the controllers registry by BootStrap with the
+following routes:
+
+	POST /xmlrpc/2/common  XML-RPC common service
+	POST /xmlrpc/2/object  XML-RPC object service
+	POST /jsonrpc          JSON-RPC access to both services
+
+The common service provides the 'version', 'login' and 'authenticate' methods.
+Credentials are checked by the security.AuthenticationRegistry.
+
+The object service provides the 'execute_kw' and 'execute' methods, which
+call a method of a model on behalf of an authenticated user. The following
+methods have their arguments converted from Odoo's conventions:
+
+	search_read, search, search_count, read, create, write, unlink,
+	name_search, name_get, read_group, fields_get
+
+Other public methods of the model can be called by their snake case name.
+In this case, the first argument is the list of ids of the records to call
+the method on and the other arguments are converted to the parameter types
+of the method.
+
+All calls are executed in the Environment of the authenticated user, so that
+method execution permissions and record rules apply.
+*/
+package rpc
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+const (
+	// XMLRPCPath is the path of the controllers group of the XML-RPC API
+	XMLRPCPath = "/xmlrpc/2"
+	// JSONRPCPath is the path of the JSON-RPC API controller
+	JSONRPCPath = "/jsonrpc"
+	// ProtocolVersion is the version of the external API protocol
+	ProtocolVersion = 1
+)
+
+var (
+	// ServerVersion is the version returned by the 'version' method of the
+	// common service. Some clients expect it to be an Odoo version number.
+	ServerVersion = "12.0"
+	// ServerVersionInfo is the detailed version returned by the 'version'
+	// method of the common service.
+	ServerVersionInfo = []interface{}{12, 0, 0, "final", 0, ""}
+)
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+)
+
+// BootStrap adds the XML-RPC and JSON-RPC controllers to the controllers registry.
+//
+// It must be called after the models have been bootstrapped and
+// before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("RPC API is already bootstrapped")
+	}
+	if !models.BootStrapped() {
+		log.Panic("Models must be bootstrapped before the RPC API")
+	}
+	grp := controllers.Registry.AddGroup(XMLRPCPath)
+	grp.AddController(http.MethodPost, "/common", xmlRPCHandler("common"))
+	grp.AddController(http.MethodPost, "/object", xmlRPCHandler("object"))
+	controllers.Registry.AddController(http.MethodPost, JSONRPCPath, jsonRPCHandler)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("rpc")
+}
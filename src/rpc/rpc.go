@@ -0,0 +1,301 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/apikeys"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/xmlrpc"
+)
+
+const (
+	// faultAccessDenied is the XML-RPC fault code of authentication errors
+	faultAccessDenied = 3
+	// faultServerError is the XML-RPC fault code of all other errors
+	faultServerError = 1
+)
+
+// errAccessDenied is returned when the credentials of a call are not valid
+var errAccessDenied = errors.New("access denied")
+
+// A service executes the given method of an RPC service with the given arguments.
+// ctx is the context of the request and authCtx is the context to pass to
+// the authentication registry.
+type service func(ctx context.Context, authCtx *types.Context, method string, args []interface{}) (interface{}, error)
+
+// services are the RPC services indexed by name
+var services = map[string]service{
+	"common": commonService,
+	"object": objectService,
+}
+
+// loginKey is the key of the logins cache. The same uid designates
+// different users in different databases.
+type loginKey struct {
+	database string
+	uid      int64
+}
+
+// logins caches the login of users that have been authenticated through
+// the common service, so that the object service can check the password
+// of the user with the security.AuthenticationRegistry.
+var logins = struct {
+	sync.RWMutex
+	byUID map[loginKey]string
+}{
+	byUID: make(map[loginKey]string),
+}
+
+// newLoginKey returns the key of the logins cache for
+// the given uid in the database of ctx.
+func newLoginKey(ctx context.Context, uid int64) loginKey {
+	database, _ := security.DatabaseNameFromContext(ctx)
+	return loginKey{database: database, uid: uid}
+}
+
+// jsonRPCParams are the params of a JSON-RPC call request
+type jsonRPCParams struct {
+	Service string        `json:"service"`
+	Method  string        `json:"method"`
+	Args    []interface{} `json:"args"`
+}
+
+// xmlRPCHandler returns the controller of the XML-RPC endpoint of the service with the given name
+func xmlRPCHandler(serviceName string) server.HandlerFunc {
+	return func(c *server.Context) {
+		method, args, err := xmlrpc.DecodeMethodCall(c.Request.Body)
+		if err != nil {
+			writeFault(c, err)
+			return
+		}
+		res, err := dispatch(c.Request.Context(), c.AuthenticationContext(), serviceName, method, args)
+		if err != nil {
+			writeFault(c, err)
+			return
+		}
+		var buf bytes.Buffer
+		if err = xmlrpc.EncodeResponse(&buf, res); err != nil {
+			writeFault(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/xml; charset=utf-8", buf.Bytes())
+	}
+}
+
+// writeFault writes the given error as an XML-RPC fault response
+func writeFault(c *server.Context, err error) {
+	fault := xmlrpc.Fault{
+		Code:   faultServerError,
+		String: errorMessage(err),
+	}
+	if err == errAccessDenied {
+		fault = xmlrpc.Fault{
+			Code:   faultAccessDenied,
+			String: "Access Denied",
+		}
+	}
+	var buf bytes.Buffer
+	xmlrpc.EncodeFault(&buf, fault)
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", buf.Bytes())
+}
+
+// jsonRPCHandler is the controller of the JSON-RPC endpoint
+func jsonRPCHandler(c *server.Context) {
+	var req server.RequestRPC
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	var params jsonRPCParams
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil {
+		err = fmt.Errorf("invalid params: %s", err)
+	}
+	if req.Method != "call" {
+		err = fmt.Errorf("unknown JSON-RPC method %s", req.Method)
+	}
+	var res interface{}
+	if err == nil {
+		res, err = dispatch(c.Request.Context(), c.AuthenticationContext(), params.Service, params.Method, params.Args)
+	}
+	switch {
+	case err == errAccessDenied:
+		c.JSON(http.StatusOK, server.ResponseError{
+			JsonRPC: "2.0",
+			ID:      req.ID,
+			Error: server.JSONRPCError{
+				Code:    http.StatusOK,
+				Message: "Hexya Server Error",
+				Data: server.JSONRPCErrorData{
+					Arguments:     []string{errorMessage(err)},
+					ExceptionType: "access_denied",
+				},
+			},
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusOK, server.NewResponseError(req.ID, err))
+		return
+	}
+	c.JSON(http.StatusOK, server.ResponseRPC{
+		JsonRPC: "2.0",
+		ID:      req.ID,
+		Result:  res,
+	})
+}
+
+// errorMessage returns the message of the given error to send to the client
+func errorMessage(err error) string {
+	return exceptions.Message(err)
+}
+
+// dispatch calls the given method of the service with the given name
+func dispatch(ctx context.Context, authCtx *types.Context, serviceName, method string, args []interface{}) (interface{}, error) {
+	srv, ok := services[serviceName]
+	if !ok {
+		return nil, fmt.Errorf("unknown service %s", serviceName)
+	}
+	return srv(ctx, authCtx, method, args)
+}
+
+// commonService implements the methods of the common service
+func commonService(ctx context.Context, authCtx *types.Context, method string, args []interface{}) (interface{}, error) {
+	switch method {
+	case "version":
+		return map[string]interface{}{
+			"server_version":      ServerVersion,
+			"server_version_info": ServerVersionInfo,
+			"server_serie":        ServerVersion,
+			"protocol_version":    ProtocolVersion,
+		}, nil
+	case "login", "authenticate":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("%s expects at least 3 arguments, got %d", method, len(args))
+		}
+		login, _ := args[1].(string)
+		password, _ := args[2].(string)
+		if apikeys.IsAPIKey(password) {
+			uid, _, err := apikeys.Authenticate(ctx, password)
+			if err != nil {
+				log.Info("RPC authentication failed", "login", login, "error", err)
+				return false, nil
+			}
+			return uid, nil
+		}
+		uid, err := security.AuthenticationRegistry.Authenticate(login, password, authCtx)
+		if err != nil {
+			log.Info("RPC authentication failed", "login", login, "error", err)
+			return false, nil
+		}
+		logins.Lock()
+		logins.byUID[newLoginKey(ctx, uid)] = login
+		logins.Unlock()
+		return uid, nil
+	}
+	return nil, fmt.Errorf("unknown method %s of service common", method)
+}
+
+// objectService implements the methods of the object service
+func objectService(ctx context.Context, authCtx *types.Context, method string, args []interface{}) (interface{}, error) {
+	switch method {
+	case "execute_kw":
+		if len(args) < 6 {
+			return nil, fmt.Errorf("execute_kw expects at least 6 arguments, got %d", len(args))
+		}
+	case "execute":
+		if len(args) < 5 {
+			return nil, fmt.Errorf("execute expects at least 5 arguments, got %d", len(args))
+		}
+	default:
+		return nil, fmt.Errorf("unknown method %s of service object", method)
+	}
+	uid, ok := toInt64(args[1])
+	if !ok {
+		return nil, errAccessDenied
+	}
+	password, _ := args[2].(string)
+	scope, err := checkCredentials(ctx, authCtx, uid, password)
+	if err != nil {
+		return nil, err
+	}
+	modelName, _ := args[3].(string)
+	methodName, _ := args[4].(string)
+	mArgs := args[5:]
+	var kwargs map[string]interface{}
+	if method == "execute_kw" {
+		mArgs, _ = args[5].([]interface{})
+		if len(args) > 6 {
+			kwargs, _ = args[6].(map[string]interface{})
+		}
+	}
+	return executeKw(ctx, uid, scope, modelName, methodName, mArgs, kwargs)
+}
+
+// checkCredentials returns errAccessDenied if the given password is
+// not valid for the user with the given uid.
+//
+// The password can also be an API key of the user, in which case
+// the scope of the key is returned.
+func checkCredentials(ctx context.Context, authCtx *types.Context, uid int64, password string) (*models.APIScope, error) {
+	if apikeys.IsAPIKey(password) {
+		keyUID, scope, err := apikeys.Authenticate(ctx, password)
+		if err != nil || keyUID != uid {
+			return nil, errAccessDenied
+		}
+		return scope, nil
+	}
+	login := userLogin(ctx, uid)
+	if login == "" {
+		return nil, errAccessDenied
+	}
+	authUID, err := security.AuthenticationRegistry.Authenticate(login, password, authCtx)
+	if err != nil || authUID != uid {
+		return nil, errAccessDenied
+	}
+	return nil, nil
+}
+
+// userLogin returns the login of the user with the given uid in the database of ctx.
+//
+// The login is taken from the users authenticated by the common service
+// or else from the Login field of the User model if it exists. It returns
+// an empty string if the login cannot be found.
+func userLogin(ctx context.Context, uid int64) string {
+	logins.RLock()
+	login, ok := logins.byUID[newLoginKey(ctx, uid)]
+	logins.RUnlock()
+	if ok {
+		return login
+	}
+	userModel, ok := models.Registry.Get("User")
+	if !ok {
+		return ""
+	}
+	if _, ok = userModel.Fields().Get("Login"); !ok {
+		return ""
+	}
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		user := env.Pool(userModel.Name()).Search(userModel.Field(models.ID).Equals(uid))
+		if user.IsEmpty() {
+			return
+		}
+		login, _ = user.Get(userModel.FieldName("Login")).(string)
+	})
+	if err != nil {
+		log.Warn("Unable to read the login of the user", "uid", uid, "error", err)
+		return ""
+	}
+	return login
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/xmlrpc"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+type testAuthBackend struct{}
+
+func (tab testAuthBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	if login != "admin" {
+		return 0, security.UserNotFoundError(login)
+	}
+	if secret != "secret" {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	return 2, nil
+}
+
+func xmlRPCCall(path, method string, params ...interface{}) (interface{}, error) {
+	var buf bytes.Buffer
+	xmlrpc.EncodeMethodCall(&buf, method, params...)
+	req, _ := http.NewRequest(http.MethodPost, path, &buf)
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return xmlrpc.DecodeMethodResponse(w.Body)
+}
+
+func jsonRPCCall(service, method string, args ...interface{}) map[string]interface{} {
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "call",
+		"id":      7,
+		"params": map[string]interface{}{
+			"service": service,
+			"method":  method,
+			"args":    args,
+		},
+	})
+	req, _ := http.NewRequest(http.MethodPost, JSONRPCPath, bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	var res map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return res
+}
+
+func TestRPC(t *testing.T) {
+	Convey("Bootstrapping RPC API before models should panic", t, func() {
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Creating models and bootstrapping", t, func() {
+		partner := models.NewModel("Partner")
+		tag := models.NewModel("Tag")
+		models.NewMixinModel("Activable")
+		partner.AddFields(map[string]models.FieldDefinition{
+			"Name":     fields.Char{},
+			"Birthday": fields.Date{},
+			"Rate":     fields.Float{},
+			"Tags":     fields.Many2Many{RelationModel: models.Registry.MustGet("Tag")},
+		})
+		tag.AddFields(map[string]models.FieldDefinition{
+			"Name": fields.Char{},
+		})
+		models.BootStrap()
+		security.AuthenticationRegistry.RegisterBackend(testAuthBackend{})
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Testing the common service", t, func() {
+		Convey("Getting the server version", func() {
+			res, err := xmlRPCCall("/xmlrpc/2/common", "version")
+			So(err, ShouldBeNil)
+			So(res.(map[string]interface{})["server_version"], ShouldEqual, ServerVersion)
+			So(res.(map[string]interface{})["protocol_version"], ShouldEqual, ProtocolVersion)
+		})
+		Convey("Authenticating with valid credentials", func() {
+			res, err := xmlRPCCall("/xmlrpc/2/common", "authenticate", "db", "admin", "secret", map[string]interface{}{})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, 2)
+			So(userLogin(context.Background(), 2), ShouldEqual, "admin")
+		})
+		Convey("Authenticating with invalid credentials", func() {
+			res, err := xmlRPCCall("/xmlrpc/2/common", "authenticate", "db", "admin", "wrong", map[string]interface{}{})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, false)
+			res, err = xmlRPCCall("/xmlrpc/2/common", "login", "db", "john", "secret")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, false)
+		})
+		Convey("Calling an unknown method", func() {
+			_, err := xmlRPCCall("/xmlrpc/2/common", "foo")
+			So(err, ShouldHaveSameTypeAs, xmlrpc.Fault{})
+			So(err.(xmlrpc.Fault).Code, ShouldEqual, faultServerError)
+		})
+	})
+	Convey("Testing the object service", t, func() {
+		Convey("Calling with a wrong password should be denied", func() {
+			_, err := xmlRPCCall("/xmlrpc/2/object", "execute_kw", "db", 2, "wrong", "Partner", "search", []interface{}{[]interface{}{}})
+			So(err, ShouldResemble, xmlrpc.Fault{Code: faultAccessDenied, String: "Access Denied"})
+		})
+		Convey("Calling with an unknown user should be denied", func() {
+			_, err := xmlRPCCall("/xmlrpc/2/object", "execute_kw", "db", 5, "secret", "Partner", "search", []interface{}{[]interface{}{}})
+			So(err, ShouldResemble, xmlrpc.Fault{Code: faultAccessDenied, String: "Access Denied"})
+		})
+		Convey("Calling with missing arguments should fail", func() {
+			_, err := xmlRPCCall("/xmlrpc/2/object", "execute_kw", "db", 2, "secret")
+			So(err.(xmlrpc.Fault).Code, ShouldEqual, faultServerError)
+		})
+		Convey("Calling an unknown model or a mixin should fail", func() {
+			_, err := xmlRPCCall("/xmlrpc/2/object", "execute_kw", "db", 2, "secret", "Unknown", "search", []interface{}{})
+			So(err.(xmlrpc.Fault).String, ShouldEqual, "unknown model Unknown")
+			_, err = xmlRPCCall("/xmlrpc/2/object", "execute_kw", "db", 2, "secret", "Activable", "search", []interface{}{})
+			So(err.(xmlrpc.Fault).String, ShouldEqual, "unknown model Activable")
+		})
+		Convey("Sending an invalid XML-RPC request should fail", func() {
+			req, _ := http.NewRequest(http.MethodPost, "/xmlrpc/2/object", strings.NewReader("<foo/>"))
+			w := httptest.NewRecorder()
+			server.GetServer().ServeHTTP(w, req)
+			_, err := xmlrpc.DecodeMethodResponse(w.Body)
+			So(err.(xmlrpc.Fault).Code, ShouldEqual, faultServerError)
+		})
+	})
+	Convey("Testing the JSON-RPC endpoint", t, func() {
+		Convey("Getting the server version", func() {
+			res := jsonRPCCall("common", "version")
+			So(res["id"], ShouldEqual, 7)
+			So(res["result"].(map[string]interface{})["server_version"], ShouldEqual, ServerVersion)
+		})
+		Convey("Authenticating", func() {
+			res := jsonRPCCall("common", "login", "db", "admin", "secret")
+			So(res["result"], ShouldEqual, 2)
+		})
+		Convey("Calling with a wrong password should be denied", func() {
+			res := jsonRPCCall("object", "execute_kw", "db", 2, "wrong", "Partner", "search", []interface{}{})
+			So(res, ShouldNotContainKey, "result")
+			data := res["error"].(map[string]interface{})["data"].(map[string]interface{})
+			So(data["exception_type"], ShouldEqual, "access_denied")
+		})
+		Convey("Calling an unknown service should fail", func() {
+			res := jsonRPCCall("foo", "bar")
+			data := res["error"].(map[string]interface{})["data"].(map[string]interface{})
+			So(data["exception_type"], ShouldEqual, "user_error")
+			So(data["arguments"], ShouldResemble, []interface{}{"unknown service foo"})
+		})
+		Convey("Typed errors should be mapped to their exception type", func() {
+			services["failing"] = func(_ context.Context, _ *types.Context, _ string, _ []interface{}) (interface{}, error) {
+				return nil, exceptions.ValidationError{Message: "Invalid value", Debug: "stack trace"}
+			}
+			defer delete(services, "failing")
+			res := jsonRPCCall("failing", "call")
+			rpcErr := res["error"].(map[string]interface{})
+			So(rpcErr["code"], ShouldEqual, http.StatusUnprocessableEntity)
+			data := rpcErr["data"].(map[string]interface{})
+			So(data["exception_type"], ShouldEqual, "validation_error")
+			So(data["arguments"], ShouldResemble, []interface{}{"Invalid value"})
+			So(data["debug"], ShouldBeEmpty)
+			viper.Set("Debug", true)
+			defer viper.Set("Debug", false)
+			res = jsonRPCCall("failing", "call")
+			data = res["error"].(map[string]interface{})["data"].(map[string]interface{})
+			So(data["debug"], ShouldEqual, "stack trace")
+		})
+	})
+	Convey("Testing argument conversion helpers", t, func() {
+		Convey("Method names", func() {
+			So(goMethodName("action_confirm"), ShouldEqual, "ActionConfirm")
+			So(goMethodName("name_get"), ShouldEqual, "NameGet")
+			So(goMethodName("Compute"), ShouldEqual, "Compute")
+		})
+		Convey("Arguments", func() {
+			args := []interface{}{"a", int64(3)}
+			So(argument(args, nil, 1, "limit"), ShouldEqual, 3)
+			So(argument(args, map[string]interface{}{"limit": 5}, 1, "limit"), ShouldEqual, 5)
+			So(argument(args, nil, 2, "order"), ShouldBeNil)
+			So(toInt(nil, 100), ShouldEqual, 100)
+			So(toInt(false, 100), ShouldEqual, 100)
+			So(toInt(float64(12), 100), ShouldEqual, 12)
+		})
+		Convey("Ids", func() {
+			ids, ok := toIDs([]interface{}{int64(1), float64(2)})
+			So(ok, ShouldBeTrue)
+			So(ids, ShouldResemble, []int64{1, 2})
+			ids, ok = toIDs(int64(4))
+			So(ok, ShouldBeTrue)
+			So(ids, ShouldResemble, []int64{4})
+			_, ok = toIDs([]interface{}{"name"})
+			So(ok, ShouldBeFalse)
+			So(removeID([]int64{1, 2, 3}, 2), ShouldResemble, []int64{1, 3})
+			So(uniqueIDs([]int64{1, 2, 1}), ShouldResemble, []int64{1, 2})
+			So(toStrings("name"), ShouldResemble, []string{"name"})
+			So(toStrings([]interface{}{"name", "rate"}), ShouldResemble, []string{"name", "rate"})
+		})
+		Convey("Models", func() {
+			_, ok := exposedModel("Partner")
+			So(ok, ShouldBeTrue)
+			_, ok = exposedModel("partner")
+			So(ok, ShouldBeTrue)
+			_, ok = exposedModel("Activable")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Results", func() {
+			So(serializeValue(nil, nil), ShouldEqual, false)
+			So(normalizeResult(map[string]interface{}{"a": 1, "b": []float64{1.5}}), ShouldResemble,
+				map[string]interface{}{"a": int64(1), "b": []interface{}{1.5}})
+		})
+	})
+}
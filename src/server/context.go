@@ -7,11 +7,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/apikeys"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/hweb"
 )
@@ -23,6 +32,9 @@ type Context struct {
 }
 
 // RPC serializes the given struct as JSON-RPC into the response body.
+//
+// If an error is given, it is sent instead as a JSON-RPC error,
+// as defined by its mapping in the ErrorRegistry.
 func (c *Context) RPC(code int, obj interface{}, err ...error) {
 	id, ok := c.Get("id")
 	if !ok {
@@ -34,25 +46,7 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 		id = req.ID
 	}
 	if len(err) > 0 && err[0] != nil {
-		userError, ok2 := err[0].(exceptions.UserError)
-		if !ok2 {
-			c.AbortWithError(http.StatusInternalServerError, errors.New("error is of unknown type"))
-			return
-		}
-		respErr := ResponseError{
-			JsonRPC: "2.0",
-			ID:      id.(int64),
-			Error: JSONRPCError{
-				Code:    code,
-				Message: "Hexya Server Error",
-				Data: JSONRPCErrorData{
-					Arguments:     []string{userError.Message},
-					ExceptionType: "user_error",
-					Debug:         userError.Debug,
-				},
-			},
-		}
-		c.JSON(code, respErr)
+		c.JSON(code, NewResponseError(id.(int64), err[0]))
 		return
 	}
 	resp := ResponseRPC{
@@ -63,6 +57,26 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 	c.JSON(code, resp)
 }
 
+// AbortWithJSONError aborts the request and writes the given error as a
+// JSON object with its code and message, with the given HTTP status code.
+//
+// If code is 0, the status code is the one of the mapping of err in the
+// ErrorRegistry, or http.StatusBadRequest if err has no specific mapping.
+func (c *Context) AbortWithJSONError(code int, err error) {
+	if code == 0 {
+		code = ErrorRegistry.Mapping(err).Code
+		if code == defaultErrorMapping.Code {
+			code = http.StatusBadRequest
+		}
+	}
+	c.AbortWithStatusJSON(code, gin.H{
+		"error": gin.H{
+			"code":    code,
+			"message": exceptions.Message(err),
+		},
+	})
+}
+
 // BindRPCParams binds the RPC parameters to the given data object.
 func (c *Context) BindRPCParams(data interface{}) {
 	var req RequestRPC
@@ -82,6 +96,190 @@ func (c *Context) Session() sessions.Session {
 	return sessions.Default(c.Context)
 }
 
+// AuthenticatedUID returns the uid of the user authenticated by this request,
+// either with an API key given in an 'Authorization: Bearer' header, with HTTP
+// basic authentication against the authentication backends or with the
+// session of the web client. API keys are also accepted as basic
+// authentication passwords, in which case the login is ignored.
+//
+// When the request is authenticated by an API key, the scope of the key
+// is stored in the context and can be retrieved with APIScope.
+//
+// It returns 0 and no error if the request has no credentials and an error
+// if the given credentials are not valid.
+func (c *Context) AuthenticatedUID() (int64, error) {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return c.authenticateAPIKey(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if login, secret, ok := c.Request.BasicAuth(); ok {
+		if apikeys.IsAPIKey(secret) {
+			return c.authenticateAPIKey(secret)
+		}
+		return security.AuthenticationRegistry.Authenticate(login, secret, c.AuthenticationContext())
+	}
+	if uid, ok := c.Session().Get("uid").(int64); ok && uid != 0 {
+		return uid, nil
+	}
+	return 0, nil
+}
+
+// RemoteIP returns the address of the client of this request.
+//
+// The X-Forwarded-For header is only taken into account if the request
+// comes from one of the TrustedProxies. The address of the client is then
+// the last one of the header that is not a trusted proxy, since the first
+// ones may be given by the client itself.
+func (c *Context) RemoteIP() string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(c.Request.RemoteAddr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrustedProxy(ip) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(c.Request.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fwdIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fwdIP == nil {
+			break
+		}
+		ip = fwdIP
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// AuthenticationContext returns the context to pass to the authentication
+// registry for this request. It holds the address of the client, so that
+// failed attempts can be throttled by address, and the name of the database
+// of the request, so that backends can search their users in it.
+func (c *Context) AuthenticationContext() *types.Context {
+	res := types.NewContext().WithKey(security.RemoteAddrKey, c.RemoteIP())
+	if database := models.DatabaseFromContext(c.Request.Context()); database != nil {
+		res = res.WithKey(security.DatabaseKey, database.Name())
+	}
+	return res
+}
+
+// AuthenticationFailureStatus returns the HTTP status of the response to a
+// request whose authentication failed with the given error. When the attempt
+// is throttled, it returns http.StatusTooManyRequests and sets the
+// Retry-After header.
+func (c *Context) AuthenticationFailureStatus(err error) int {
+	tErr, ok := err.(security.LoginThrottledError)
+	if !ok {
+		return http.StatusUnauthorized
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(tErr.RetryAfter).Seconds()))))
+	return http.StatusTooManyRequests
+}
+
+// authenticateAPIKey returns the uid of the user authenticated by the
+// given API key and stores the scope of the key in the context.
+func (c *Context) authenticateAPIKey(token string) (int64, error) {
+	uid, scope, err := apikeys.Authenticate(c.Request.Context(), token)
+	if err != nil {
+		return 0, err
+	}
+	c.Set("apiScope", scope)
+	return uid, nil
+}
+
+// APIScope returns the scope of the API key that authenticated this
+// request, or nil if the request was not authenticated by an API key.
+//
+// The returned scope should be applied to the Environment in which
+// the request is executed with Environment.WithAPIScope.
+func (c *Context) APIScope() *models.APIScope {
+	scope, _ := c.Get("apiScope")
+	res, _ := scope.(*models.APIScope)
+	return res
+}
+
+// LogIn authenticates the user with the given login and secret against the
+// authentication backends and establishes the session of the web client.
+//
+// If the user must also give a second factor, the session is left pending and
+// a security.SecondFactorRequiredError is returned. The session is then
+// established by VerifySecondFactor, or by CompletePendingLogIn once the user
+// has enrolled a second factor.
+func (c *Context) LogIn(login, secret string) (int64, error) {
+	uid, err := security.AuthenticationRegistry.Authenticate(login, secret, c.AuthenticationContext())
+	return c.setSessionUser(uid, login, err)
+}
+
+// LogInUser establishes the session of the web client for the user with the
+// given uid and login, who has been authenticated by other means than the
+// authentication backends, such as a single sign-on provider.
+//
+// As with LogIn, the session is left pending and a
+// security.SecondFactorRequiredError is returned if the user must also give
+// a second factor.
+func (c *Context) LogInUser(uid int64, login string) error {
+	_, err := c.setSessionUser(uid, login, security.AuthenticationRegistry.CheckSecondFactor(c.Request.Context(), uid, login))
+	return err
+}
+
+// setSessionUser sets the user of the session from the result of its
+// authentication and returns them.
+func (c *Context) setSessionUser(uid int64, login string, err error) (int64, error) {
+	sess := c.Session()
+	sess.Delete("uid")
+	sess.Delete("pending_uid")
+	switch err.(type) {
+	case nil:
+		sess.Set("uid", uid)
+		sess.Set("login", login)
+	case security.SecondFactorRequiredError:
+		sess.Set("pending_uid", uid)
+		sess.Set("login", login)
+	default:
+		uid = 0
+	}
+	if sErr := sess.Save(); sErr != nil {
+		return 0, sErr
+	}
+	return uid, err
+}
+
+// PendingUID returns the uid of the user whose session is pending the
+// verification of a second factor, or 0 if there is no such session.
+func (c *Context) PendingUID() int64 {
+	uid, _ := c.Session().Get("pending_uid").(int64)
+	return uid
+}
+
+// VerifySecondFactor checks the given second factor code of the user whose
+// session is pending and establishes the session if the code is valid.
+func (c *Context) VerifySecondFactor(code string) (int64, error) {
+	uid := c.PendingUID()
+	if uid == 0 {
+		return 0, errors.New("no pending authentication")
+	}
+	login, _ := c.Session().Get("login").(string)
+	if err := security.AuthenticationRegistry.VerifySecondFactor(c.Request.Context(), uid, login, code); err != nil {
+		return 0, err
+	}
+	return uid, c.CompletePendingLogIn()
+}
+
+// CompletePendingLogIn establishes the pending session without verifying a
+// second factor. It must only be called when the user has otherwise proven
+// the possession of a second factor, for instance when enrolling one.
+func (c *Context) CompletePendingLogIn() error {
+	sess := c.Session()
+	uid := c.PendingUID()
+	if uid == 0 {
+		return errors.New("no pending authentication")
+	}
+	sess.Delete("pending_uid")
+	sess.Set("uid", uid)
+	return sess.Save()
+}
+
 // Super calls the next middleware / handler layer
 // It is an alias for Next
 func (c *Context) Super() {
@@ -0,0 +1,101 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/spf13/viper"
+)
+
+// An ErrorMapping defines how the errors of a type
+// are sent to the clients in JSON-RPC responses.
+type ErrorMapping struct {
+	// Code is the code of the JSON-RPC error
+	Code int
+	// ExceptionType is the exception_type of the JSON-RPC error data,
+	// from which the client decides how to display the error.
+	ExceptionType string
+}
+
+// defaultErrorMapping is the mapping of errors of unregistered types
+var defaultErrorMapping = ErrorMapping{
+	Code:          http.StatusOK,
+	ExceptionType: "user_error",
+}
+
+// An ErrorMappingCollection maps error types to ErrorMapping instances
+type ErrorMappingCollection struct {
+	sync.RWMutex
+	mappings map[reflect.Type]ErrorMapping
+}
+
+// Register maps the type of the given err to the given mapping.
+// err is only used for its type and is typically a zero value,
+// such as exceptions.AccessError{}.
+func (er *ErrorMappingCollection) Register(err error, mapping ErrorMapping) {
+	er.Lock()
+	defer er.Unlock()
+	er.mappings[reflect.TypeOf(err)] = mapping
+}
+
+// Mapping returns the mapping of the given err. It is the mapping of the
+// first error of err's chain whose type is registered, or the mapping of
+// exceptions.UserError if there is none.
+func (er *ErrorMappingCollection) Mapping(err error) ErrorMapping {
+	er.RLock()
+	defer er.RUnlock()
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if mapping, ok := er.mappings[reflect.TypeOf(e)]; ok {
+			return mapping
+		}
+	}
+	return defaultErrorMapping
+}
+
+// ErrorRegistry is the registry of the mappings of errors sent
+// to the clients in JSON-RPC responses. Modules can register the
+// mappings of their own error types.
+var ErrorRegistry = &ErrorMappingCollection{
+	mappings: map[reflect.Type]ErrorMapping{
+		reflect.TypeOf(exceptions.UserError{}):        defaultErrorMapping,
+		reflect.TypeOf(exceptions.AccessError{}):      {Code: http.StatusForbidden, ExceptionType: "access_error"},
+		reflect.TypeOf(exceptions.MissingError{}):     {Code: http.StatusNotFound, ExceptionType: "missing_error"},
+		reflect.TypeOf(exceptions.ValidationError{}):  {Code: http.StatusUnprocessableEntity, ExceptionType: "validation_error"},
+		reflect.TypeOf(exceptions.ConstraintError{}):  {Code: http.StatusUnprocessableEntity, ExceptionType: "validation_error"},
+		reflect.TypeOf(exceptions.ConcurrencyError{}): {Code: http.StatusConflict, ExceptionType: "warning"},
+	},
+}
+
+// NewResponseError returns the JSON-RPC response to send for the given
+// request id and error. The debug information is only sent if the server
+// runs in debug mode.
+//
+// The message of the error is sent as is, since it must be translated
+// in the language of the user when it is formatted, e.g. with
+// RecordCollection.T.
+func NewResponseError(id int64, err error) ResponseError {
+	mapping := ErrorRegistry.Mapping(err)
+	var debug string
+	if viper.GetBool("Debug") {
+		debug = exceptions.Debug(err)
+	}
+	return ResponseError{
+		JsonRPC: "2.0",
+		ID:      id,
+		Error: JSONRPCError{
+			Code:    mapping.Code,
+			Message: "Hexya Server Error",
+			Data: JSONRPCErrorData{
+				Arguments:     []string{exceptions.Message(err)},
+				ExceptionType: mapping.ExceptionType,
+				Debug:         debug,
+			},
+		},
+	}
+}
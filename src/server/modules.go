@@ -15,6 +15,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -37,9 +38,10 @@ var ResourceDir string
 // A Module is a go package that implements business features.
 // This struct is used to register modules.
 type Module struct {
-	Name     string
-	PreInit  func() // Function to be run before bootstrap but after all calls to init
-	PostInit func() // Function to be run after initialisation is complete and before server starts
+	Name        string
+	PreInit     func() // Function to be run before bootstrap but after all calls to init
+	PostInit    func() // Function to be run after initialisation is complete and before server starts
+	PreShutdown func() // Function to be run when the server is about to shut down
 }
 
 // A ModulesList is a list of Module objects
@@ -70,19 +72,43 @@ func RegisterModule(mod *Module) {
 // - menu items
 // Internal resources are defined in XML files.
 func LoadInternalResources(resourceDir string) {
-	loadData(resourceDir, "resources", "xml", loadXMLResourceFile)
+	loadData(resourceDir, "resources", map[string]func(string){"xml": loadXMLResourceFile})
 }
 
-// LoadDataRecords loads all the data records in the 'data' directory into the database.
-// Data records are defined in CSV files.
+// dataRecordsLoaders returns the functions that load data records files
+// into the database of ctx by file extension (without .)
+func dataRecordsLoaders(ctx context.Context) map[string]func(string) {
+	return map[string]func(string){
+		"csv":  func(fileName string) { models.LoadCSVDataFileContext(ctx, fileName) },
+		"xml":  func(fileName string) { models.LoadXMLDataFileContext(ctx, fileName) },
+		"json": func(fileName string) { models.LoadJSONDataFileContext(ctx, fileName) },
+		"yaml": func(fileName string) { models.LoadYAMLDataFileContext(ctx, fileName) },
+		"yml":  func(fileName string) { models.LoadYAMLDataFileContext(ctx, fileName) },
+	}
+}
+
+// LoadDataRecords loads all the data records in the 'data' directory into the default database.
+// Data records are defined in CSV, XML, JSON or YAML files.
 func LoadDataRecords(resourceDir string) {
-	loadData(resourceDir, "data", "csv", models.LoadCSVDataFile)
+	LoadDataRecordsContext(context.Background(), resourceDir)
+}
+
+// LoadDataRecordsContext loads all the data records in the 'data' directory
+// into the database of ctx.
+func LoadDataRecordsContext(ctx context.Context, resourceDir string) {
+	loadData(resourceDir, "data", dataRecordsLoaders(ctx))
 }
 
-// LoadDemoRecords loads all the data records in the 'demo' directory into the database.
-// Demo records are defined in CSV files.
+// LoadDemoRecords loads all the data records in the 'demo' directory into the default database.
+// Demo records are defined in CSV, XML, JSON or YAML files.
 func LoadDemoRecords(resourceDir string) {
-	loadData(resourceDir, "demo", "csv", models.LoadCSVDataFile)
+	LoadDemoRecordsContext(context.Background(), resourceDir)
+}
+
+// LoadDemoRecordsContext loads all the data records in the 'demo' directory
+// into the database of ctx.
+func LoadDemoRecordsContext(ctx context.Context, resourceDir string) {
+	loadData(resourceDir, "demo", dataRecordsLoaders(ctx))
 }
 
 // LoadTranslations loads all translation data from the PO files in the 'i18n' directory
@@ -121,23 +147,28 @@ func LoadModuleTranslations(i18nDir string, langs []string) {
 	}
 }
 
-// loadData loads the files in the given dir with the given extension (without .)
-// using the loader function.
-func loadData(resourceDir, dir, ext string, loader func(string)) {
+// loadData loads the files in the given dir using the loader function of
+// their extension (without .) in loaders. Files of each module are loaded
+// by alphabetical order whatever their extension.
+func loadData(resourceDir, dir string, loaders map[string]func(string)) {
 	for _, mod := range Modules {
 		dataDir := filepath.Join(resourceDir, dir, mod.Name)
 		if _, err := os.Stat(dataDir); err != nil {
 			// No resources dir in this module
 			continue
 		}
-		dataFiles, err := filepath.Glob(fmt.Sprintf("%s/*.%s", dataDir, ext))
-		if err != nil {
-			log.Panic("Unable to scan directory for data files", "dir", dataDir, "type", ext, "error", err)
+		var dataFiles []string
+		for ext := range loaders {
+			extFiles, err := filepath.Glob(fmt.Sprintf("%s/*.%s", dataDir, ext))
+			if err != nil {
+				log.Panic("Unable to scan directory for data files", "dir", dataDir, "type", ext, "error", err)
+			}
+			dataFiles = append(dataFiles, extFiles...)
 		}
 		dataFilesSorted := sort.StringSlice(dataFiles)
 		dataFilesSorted.Sort()
 		for _, dataFile := range dataFilesSorted {
-			loader(dataFile)
+			loaders[strings.TrimPrefix(filepath.Ext(dataFile), ".")](dataFile)
 		}
 	}
 }
@@ -159,6 +190,8 @@ func loadXMLResourceFile(fileName string) {
 				menus.LoadFromEtree(object)
 			case "template":
 				templates.LoadFromEtree(object)
+			case "record":
+				log.Panic("Records must be defined in XML files of the data or demo directories", "filename", fileName, "id", object.SelectAttrValue("id", ""))
 			default:
 				log.Panic("Unknown XML tag", "filename", fileName, "tag", object.Tag)
 			}
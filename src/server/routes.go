@@ -3,7 +3,13 @@
 
 package server
 
-import "github.com/gin-gonic/gin"
+import (
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
+)
 
 // A HandlerFunc is a function that can be used for handling a given request or as a middleware
 type HandlerFunc func(*Context)
@@ -28,6 +34,20 @@ func wrapContextFuncs(handlers ...HandlerFunc) []gin.HandlerFunc {
 	return wrappedHandlers
 }
 
+// routeHandlers returns the gin handlers of a route of this group at
+// relativePath with the given handlers. They are preceded by a handler
+// that stores the route in the context for the metrics.
+func (rg *RouterGroup) routeHandlers(relativePath string, handlers []HandlerFunc) []gin.HandlerFunc {
+	route := path.Join(rg.BasePath(), relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(route, "/") {
+		route += "/"
+	}
+	tagRoute := func(c *gin.Context) {
+		c.Set(metrics.RouteKey, route)
+	}
+	return append([]gin.HandlerFunc{tagRoute}, wrapContextFuncs(handlers...)...)
+}
+
 // Group creates a new router group. You should add all the routes that have common middlwares or the same path prefix.
 // For example, all the routes that use a common middlware for authorization could be grouped.
 func (rg *RouterGroup) Group(relativePath string, handlers ...HandlerFunc) *RouterGroup {
@@ -52,46 +72,46 @@ func (rg *RouterGroup) Use(middleware ...HandlerFunc) gin.IRoutes {
 // frequently used, non-standardized or custom methods (e.g. for internal
 // communication with a proxy).
 func (rg *RouterGroup) Handle(httpMethod, relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.Handle(httpMethod, relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.Handle(httpMethod, relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // POST is a shortcut for router.Handle("POST", path, handle)
 func (rg *RouterGroup) POST(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.POST(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.POST(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // GET is a shortcut for router.Handle("GET", path, handle)
 func (rg *RouterGroup) GET(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.GET(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.GET(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // DELETE is a shortcut for router.Handle("DELETE", path, handle)
 func (rg *RouterGroup) DELETE(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.DELETE(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.DELETE(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // PATCH is a shortcut for router.Handle("PATCH", path, handle)
 func (rg *RouterGroup) PATCH(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.PATCH(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.PATCH(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // PUT is a shortcut for router.Handle("PUT", path, handle)
 func (rg *RouterGroup) PUT(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.PUT(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.PUT(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // OPTIONS is a shortcut for router.Handle("OPTIONS", path, handle)
 func (rg *RouterGroup) OPTIONS(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.OPTIONS(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.OPTIONS(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // HEAD is a shortcut for router.Handle("HEAD", path, handle)
 func (rg *RouterGroup) HEAD(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.HEAD(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.HEAD(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
 
 // Any registers a route that matches all the HTTP methods.
 // GET, POST, PUT, PATCH, HEAD, OPTIONS, DELETE, CONNECT, TRACE
 func (rg *RouterGroup) Any(relativePath string, handlers ...HandlerFunc) gin.IRoutes {
-	return rg.RouterGroup.Any(relativePath, wrapContextFuncs(handlers...)...)
+	return rg.RouterGroup.Any(relativePath, rg.routeHandlers(relativePath, handlers)...)
 }
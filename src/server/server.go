@@ -15,16 +15,24 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/hexya-erp/hexya/src/templates"
 	"github.com/hexya-erp/hexya/src/tools/logging"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
+	"github.com/hexya-erp/hexya/src/tools/tracing"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -33,6 +41,9 @@ import (
 // It is internally a wrapper around a gin.Engine
 type Server struct {
 	*gin.Engine
+	mu          sync.Mutex
+	httpServers []*http.Server
+	shutDown    bool
 }
 
 // Group creates a new router group. You should add all the routes that have common middlwares or the same path prefix.
@@ -43,34 +54,49 @@ func (s *Server) Group(relativePath string, handlers ...HandlerFunc) *RouterGrou
 	}
 }
 
+// newHTTPServer returns a new http.Server listening on addr with the given
+// handler and registers it so that it is stopped by Shutdown.
+func (s *Server) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutDown {
+		// Make ListenAndServe return http.ErrServerClosed
+		srv.Close()
+	}
+	s.httpServers = append(s.httpServers, srv)
+	return srv
+}
+
 // Run attaches the router to a http.Server and starts listening and serving HTTP requests.
-// It is a shortcut for http.ListenAndServe(addr, router)
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine until Shutdown is called or an error happens.
 func (s *Server) Run(addr string) (err error) {
-	defer func() { log.Error("HTTP server stopped", "error", err) }()
+	defer func() { err = logServerStop("HTTP server stopped", err) }()
 
 	log.Info("Hexya is up and running HTTP", "address", addr)
-	err = http.ListenAndServe(addr, s)
+	err = s.newHTTPServer(addr, s).ListenAndServe()
 	return
 }
 
 // RunTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests.
-// It is a shortcut for http.ListenAndServeTLS(addr, certFile, keyFile, router)
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine until Shutdown is called or an error happens.
 func (s *Server) RunTLS(addr string, certFile string, keyFile string) (err error) {
-	defer func() { log.Error("HTTPS server stopped", err) }()
+	defer func() { err = logServerStop("HTTPS server stopped", err) }()
 
 	log.Info("Hexya is up and running HTTPS", "address", addr, "cert", certFile, "key", keyFile)
-	err = http.ListenAndServeTLS(addr, certFile, keyFile, s)
+	err = s.newHTTPServer(addr, s).ListenAndServeTLS(certFile, keyFile)
 	return
 }
 
 // RunAutoTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests on port 443
 // for all interfaces.
 // It automatically gets certificate for the given domain from Letsencrypt.
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine until Shutdown is called or an error happens.
 func (s *Server) RunAutoTLS(domain string) (err error) {
-	defer func() { log.Error("HTTPS server stopped", err) }()
+	defer func() { err = logServerStop("HTTPS server stopped", err) }()
 
 	log.Info("Hexya is up and running HTTPS auto", "domain", domain)
 
@@ -80,16 +106,42 @@ func (s *Server) RunAutoTLS(domain string) (err error) {
 		Prompt:     autocert.AcceptTOS,
 		HostPolicy: autocert.HostWhitelist(domain),
 	}
-	go http.ListenAndServe(":http", m.HTTPHandler(nil))
-	srv := &http.Server{
-		Addr:      ":https",
-		TLSConfig: &tls.Config{GetCertificate: m.GetCertificate},
-		Handler:   s,
-	}
+	go s.newHTTPServer(":http", m.HTTPHandler(nil)).ListenAndServe()
+	srv := s.newHTTPServer(":https", s)
+	srv.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate}
 	err = srv.ListenAndServeTLS("", "")
 	return
 }
 
+// Shutdown gracefully stops the servers started with Run, RunTLS or RunAutoTLS.
+// They stop accepting connections and Shutdown waits for the requests
+// being served to complete, or until the given context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	httpServers := s.httpServers
+	s.httpServers = nil
+	s.shutDown = true
+	s.mu.Unlock()
+	var res error
+	for _, srv := range httpServers {
+		if err := srv.Shutdown(ctx); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// logServerStop logs that a server stopped with the given error
+// and returns the error, or nil if the server was shut down.
+func logServerStop(msg string, err error) error {
+	if err == http.ErrServerClosed {
+		log.Info(msg)
+		return nil
+	}
+	log.Error(msg, "error", err)
+	return err
+}
+
 // A RequestRPC is the message format expected from a client
 type RequestRPC struct {
 	JsonRPC string          `json:"jsonrpc"`
@@ -131,6 +183,61 @@ type JSONRPCError struct {
 var hexyaServer *Server
 var log logging.Logger
 
+// RequestTimeout is the maximum duration of the requests. When it is
+// reached, the context of the request is done, so that its database
+// queries are cancelled. There is no limit if it is zero.
+var RequestTimeout time.Duration
+
+// TrustedProxies are the networks of the reverse proxies whose
+// X-Forwarded-For header is trusted to get the address of the clients.
+// It is set by SetTrustedProxies.
+var TrustedProxies []*net.IPNet
+
+// SetTrustedProxies sets TrustedProxies from the given IP addresses
+// or CIDR networks (ex: 10.0.0.1 or 10.0.0.0/8).
+func SetTrustedProxies(proxies []string) error {
+	res := make([]*net.IPNet, len(proxies))
+	for i, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %s: %s", proxies[i], err)
+		}
+		res[i] = ipNet
+	}
+	TrustedProxies = res
+	return nil
+}
+
+// isTrustedProxy returns true if ip belongs to the TrustedProxies
+func isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range TrustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestTimeout is a middleware that sets the deadline of the
+// context of the requests according to RequestTimeout.
+func requestTimeout(c *gin.Context) {
+	if RequestTimeout <= 0 {
+		c.Next()
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), RequestTimeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
 // GetServer return the http server instance
 func GetServer() *Server {
 	return hexyaServer
@@ -140,9 +247,12 @@ func init() {
 	log = logging.GetLogger("server")
 	// Set to ReleaseMode now for tests and is overridden later (hexya/cmd/server.go)
 	gin.SetMode(gin.ReleaseMode)
-	hexyaServer = &Server{gin.New()}
+	hexyaServer = &Server{Engine: gin.New()}
 	store := cookie.NewStore([]byte(">r&5#5T/sG-jnf=EW8$(WQX'-m2R6Gk*^qqr`CxEtG'wQ[/'G@`NYn^on?b!4G`9"),
 		[]byte("!WY9Q|}09!4Ke=@w0HS|]$u,p1f^k(5T"))
+	hexyaServer.Use(metrics.ForGin())
+	hexyaServer.Use(tracing.ForGin())
+	hexyaServer.Use(requestTimeout)
 	hexyaServer.Use(gin.Recovery())
 	hexyaServer.Use(sessions.Sessions("hexya-session", store))
 	hexyaServer.Use(logging.LogForGin(log))
@@ -182,3 +292,35 @@ func PostInitModules() {
 		}
 	}
 }
+
+// PreShutdown runs all actions that need to be done when the server is
+// about to shut down, before requests are drained, workers are stopped
+// and the database is closed.
+//
+// This function runs successively all PreShutdown() func of modules
+func PreShutdown() {
+	PreShutdownModules()
+}
+
+// PreShutdownModules calls successively all PreShutdown functions of all installed modules.
+//
+// A panic in a PreShutdown function is logged and does not prevent the
+// other modules' functions to run.
+func PreShutdownModules() {
+	for _, module := range Modules {
+		if module.PreShutdown != nil {
+			runPreShutdown(module)
+		}
+	}
+}
+
+// runPreShutdown runs the PreShutdown function of the given module
+// and logs the panic if any.
+func runPreShutdown(module *Module) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("PreShutdown function of module failed", "module", module.Name, "error", r)
+		}
+	}()
+	module.PreShutdown()
+}
@@ -2,6 +2,14 @@
 // See LICENSE file for full licensing details.
 
 // Package exceptions provides error types used throughout Hexya
+//
+// The ORM panics with these types and ExecuteInNewEnvironment returns them
+// as is, so that callers can tell them apart with errors.As:
+//
+//	var accessErr exceptions.AccessError
+//	if errors.As(err, &accessErr) {
+//	    // ...
+//	}
 package exceptions
 
 import "fmt"
@@ -18,3 +26,180 @@ type UserError struct {
 func (u UserError) Error() string {
 	return fmt.Sprintf("%s\n----------------------------------\n%s", u.Message, u.Debug)
 }
+
+// AccessError is an error raised when the current user is not allowed
+// to access a model, a field, a method or a record.
+type AccessError struct {
+	Message string
+	Debug   string
+}
+
+// Error returns the message of the AccessError
+func (e AccessError) Error() string {
+	return e.Message
+}
+
+// ValidationError is an error raised when data do not satisfy
+// the constraints of a model.
+type ValidationError struct {
+	Message string
+	Debug   string
+}
+
+// Error returns the message of the ValidationError
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+// MissingError is an error raised when a record that is
+// accessed does not exist, e.g. because it has been deleted.
+type MissingError struct {
+	Message string
+	Debug   string
+}
+
+// Error returns the message of the MissingError
+func (e MissingError) Error() string {
+	return e.Message
+}
+
+// ConstraintError is an error raised when a statement violates
+// a constraint of the database, such as a unique constraint.
+type ConstraintError struct {
+	// Constraint is the name of the violated SQL constraint
+	Constraint string
+	Message    string
+	Debug      string
+	// Err is the error returned by the database
+	Err error
+}
+
+// Error returns the message of the ConstraintError
+func (e ConstraintError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error returned by the database
+func (e ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// ConcurrencyError is an error raised when a transaction cannot be
+// completed because of concurrent transactions, even after retries.
+type ConcurrencyError struct {
+	Message string
+	Debug   string
+	// Err is the error returned by the database
+	Err error
+}
+
+// Error returns the message of the ConcurrencyError
+func (e ConcurrencyError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error returned by the database
+func (e ConcurrencyError) Unwrap() error {
+	return e.Err
+}
+
+// IsHexyaError returns true if err is one of the error types of this package
+func IsHexyaError(err error) bool {
+	switch err.(type) {
+	case UserError, AccessError, ValidationError, MissingError, ConstraintError, ConcurrencyError:
+		return true
+	}
+	return false
+}
+
+// WithDebug returns a copy of err with the given debug information
+// if it is one of the error types of this package, and err otherwise.
+func WithDebug(err error, debug string) error {
+	switch e := err.(type) {
+	case UserError:
+		e.Debug = debug
+		return e
+	case AccessError:
+		e.Debug = debug
+		return e
+	case ValidationError:
+		e.Debug = debug
+		return e
+	case MissingError:
+		e.Debug = debug
+		return e
+	case ConstraintError:
+		e.Debug = debug
+		return e
+	case ConcurrencyError:
+		e.Debug = debug
+		return e
+	}
+	return err
+}
+
+// WithMessage returns a copy of err with the given message if it
+// is one of the error types of this package, and err otherwise.
+func WithMessage(err error, message string) error {
+	switch e := err.(type) {
+	case UserError:
+		e.Message = message
+		return e
+	case AccessError:
+		e.Message = message
+		return e
+	case ValidationError:
+		e.Message = message
+		return e
+	case MissingError:
+		e.Message = message
+		return e
+	case ConstraintError:
+		e.Message = message
+		return e
+	case ConcurrencyError:
+		e.Message = message
+		return e
+	}
+	return err
+}
+
+// Message returns the message to display to the user for err, that is
+// the Message of the error types of this package, or err.Error() otherwise.
+func Message(err error) string {
+	switch e := err.(type) {
+	case UserError:
+		return e.Message
+	case AccessError:
+		return e.Message
+	case ValidationError:
+		return e.Message
+	case MissingError:
+		return e.Message
+	case ConstraintError:
+		return e.Message
+	case ConcurrencyError:
+		return e.Message
+	}
+	return err.Error()
+}
+
+// Debug returns the debug information of err if it is one of
+// the error types of this package, or an empty string otherwise.
+func Debug(err error) string {
+	switch e := err.(type) {
+	case UserError:
+		return e.Debug
+	case AccessError:
+		return e.Debug
+	case ValidationError:
+		return e.Debug
+	case MissingError:
+		return e.Debug
+	case ConstraintError:
+		return e.Debug
+	case ConcurrencyError:
+		return e.Debug
+	}
+	return ""
+}
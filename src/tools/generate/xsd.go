@@ -0,0 +1,291 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/menus"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/templates"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/hexya/src/tools/xsdutils"
+	"github.com/hexya-erp/hexya/src/views"
+)
+
+// XSDFileName is the name of the generated XSD file for resource files
+const XSDFileName = "hexya.xsd"
+
+// xsdViewTypes are the view types listed in the XSD
+var xsdViewTypes = []views.ViewType{
+	views.ViewTypeTree, views.ViewTypeList, views.ViewTypeForm, views.ViewTypeGraph, views.ViewTypeCalendar,
+	views.ViewTypeDiagram, views.ViewTypeGantt, views.ViewTypeKanban, views.ViewTypeSearch, views.ViewTypeQWeb,
+}
+
+// xsdActionTypes are the action types listed in the XSD
+var xsdActionTypes = []actions.ActionType{
+	actions.ActionActWindow, actions.ActionURL, actions.ActionServer, actions.ActionReport,
+	actions.ActionClient, actions.ActionCloseWindow,
+}
+
+// xsdActionViewTypes are the action view types listed in the XSD
+var xsdActionViewTypes = []actions.ActionViewType{
+	actions.ActionViewTypeForm, actions.ActionViewTypeTree,
+}
+
+// xsdSimpleTypes maps Go types of resource structs to the XSD simple types
+var xsdSimpleTypes = map[reflect.Type]string{
+	reflect.TypeOf(views.ViewType("")):         "ViewType",
+	reflect.TypeOf(actions.ActionType("")):     "ActionType",
+	reflect.TypeOf(actions.ActionViewType("")): "ActionViewType",
+}
+
+// CreateXSD generates an XSD file for the resource XML files of the given modules.
+//
+// The XSD is built from the XML struct tags of the resources and has enumerations
+// of the model names, the field names of each model, the view types and the action types.
+func CreateXSD(modules []*ModuleInfo, fileName string) {
+	modelsASTData := GetModelsASTData(modules)
+	schema := BuildXSD(modelsASTData)
+	data, err := schema.Bytes()
+	if err != nil {
+		log.Panic("Error while generating XSD", "error", err)
+	}
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		log.Panic("Error while saving XSD file", "error", err, "fileName", fileName)
+	}
+}
+
+// BuildXSD returns the XSD Schema for resource XML files with the given models.
+//
+// Records are given the type of their model with XSD 1.1 type alternatives, so
+// that their fields are checked against the fields of this model only. Fields of
+// view archs may belong to related models and are checked against all models.
+//
+// Attributes are required only if the loader of the resource requires them,
+// that is the model of a record and the name of a field.
+func BuildXSD(modelsASTData map[string]ModelASTData) *xsdutils.Schema {
+	var modelNames []string
+	for modelName := range modelsASTData {
+		modelNames = append(modelNames, modelName)
+	}
+	sort.Strings(modelNames)
+	schema := xsdutils.Schema{
+		Elements: []xsdutils.Element{
+			{Name: "hexya", Type: "Hexya"},
+			{Name: "field", Type: "Field"},
+		},
+		ComplexTypes: []xsdutils.ComplexType{
+			{Name: "Hexya", Elements: []xsdutils.Element{{Name: "data", Type: "Data"}}},
+			{Name: "Data", Elements: []xsdutils.Element{
+				{Name: "view", Type: "View"},
+				{Name: "action", Type: "Action"},
+				{Name: "menuitem", Type: "MenuItem"},
+				{Name: "template", Type: "Template"},
+				{Name: "record", Type: "Record", Alternatives: xsdAlternatives("model", modelNames, func(modelName string) string {
+					return fmt.Sprintf("Record.%s", modelName)
+				})},
+			}},
+			{Name: "Record", Elements: []xsdutils.Element{{Ref: "field"}}, Attributes: []xsdutils.Attribute{
+				{Name: "id", Type: "xs:string"},
+				{Name: "model", Type: "ModelName", Required: true},
+			}},
+			{Name: "Field", Mixed: true, AnyElement: true, AnyAttribute: true, Attributes: []xsdutils.Attribute{
+				{Name: "name", Type: "FieldName", Required: true},
+			}},
+		},
+	}
+	addXSDComplexType(&schema, "View", reflect.TypeOf(views.ViewXML{}))
+	addXSDComplexType(&schema, "Action", reflect.TypeOf(actions.Action{}))
+	addXSDComplexType(&schema, "MenuItem", reflect.TypeOf(menus.Menu{}))
+	addXSDComplexType(&schema, "Template", reflect.TypeOf(templates.TemplateXML{}))
+
+	fieldNamesTypes := make([]string, len(modelNames))
+	for i, modelName := range modelNames {
+		fieldNamesTypes[i] = fmt.Sprintf("FieldName.%s", modelName)
+		addXSDModelTypes(&schema, modelsASTData, modelName)
+	}
+	schema.SimpleTypes = append(schema.SimpleTypes,
+		xsdutils.SimpleType{Name: "ModelName", Values: modelNames},
+		xsdutils.SimpleType{Name: "FieldName", MemberTypes: fieldNamesTypes},
+		xsdutils.SimpleType{Name: "ViewType", Values: stringValues(xsdViewTypes)},
+		xsdutils.SimpleType{Name: "ActionType", Values: stringValues(xsdActionTypes)},
+		xsdutils.SimpleType{Name: "ActionViewType", Values: stringValues(xsdActionViewTypes)},
+	)
+	return &schema
+}
+
+// addXSDModelTypes adds to the schema the types of the records of the given model:
+//
+// - FieldName.<Model> enumerates the field names of the model,
+//
+// - Record.<Model> is the type of the records of the model,
+//
+// - Field.<Model> is the type of the fields of these records,
+//
+// - Field.<Model>.<Field> is the type of each one2many field, which holds
+// nested records of the related model.
+//
+// The model of nested records is not required, since it is given by the field.
+func addXSDModelTypes(schema *xsdutils.Schema, modelsASTData map[string]ModelASTData, modelName string) {
+	fieldType := fmt.Sprintf("Field.%s", modelName)
+	fieldNamesType := fmt.Sprintf("FieldName.%s", modelName)
+	fieldNames := make(map[string]bool)
+	var o2mTypes []xsdutils.Alternative
+	for _, fieldName := range sortedFieldNames(modelsASTData[modelName].Fields) {
+		field := modelsASTData[modelName].Fields[fieldName]
+		jsonName := strutils.GetDefaultString(field.JSON, models.SnakeCaseFieldName(fieldName, field.FType))
+		fieldNames[fieldName] = true
+		fieldNames[jsonName] = true
+		if _, ok := modelsASTData[field.RelModel]; !ok || field.FType != fieldtype.One2Many {
+			continue
+		}
+		o2mType := fmt.Sprintf("%s.%s", fieldType, fieldName)
+		o2mTypes = append(o2mTypes, xsdAlternatives("name", []string{fieldName, jsonName}, func(string) string {
+			return o2mType
+		})...)
+		schema.ComplexTypes = append(schema.ComplexTypes, xsdutils.ComplexType{
+			Name:         o2mType,
+			AnyAttribute: true,
+			Elements:     []xsdutils.Element{{Name: "record", Type: fmt.Sprintf("Record.%s", field.RelModel)}},
+			Attributes:   []xsdutils.Attribute{{Name: "name", Type: fieldNamesType, Required: true}},
+		})
+	}
+	schema.ComplexTypes = append(schema.ComplexTypes,
+		xsdutils.ComplexType{
+			Name:     fmt.Sprintf("Record.%s", modelName),
+			Elements: []xsdutils.Element{{Name: "field", Type: fieldType, Alternatives: o2mTypes}},
+			Attributes: []xsdutils.Attribute{
+				{Name: "id", Type: "xs:string"},
+				{Name: "model", Type: "ModelName"},
+			},
+		},
+		xsdutils.ComplexType{
+			Name:         fieldType,
+			Mixed:        true,
+			AnyElement:   true,
+			AnyAttribute: true,
+			Attributes:   []xsdutils.Attribute{{Name: "name", Type: fieldNamesType, Required: true}},
+		},
+	)
+	schema.SimpleTypes = append(schema.SimpleTypes, xsdutils.SimpleType{
+		Name:   fieldNamesType,
+		Values: sortedKeys(fieldNames),
+	})
+}
+
+// addXSDComplexType adds to the schema the complex type with the given name
+// from the XML struct tags of the given struct type. Complex types of child
+// elements are added recursively.
+func addXSDComplexType(schema *xsdutils.Schema, name string, typ reflect.Type) {
+	ct := xsdutils.ComplexType{Name: name}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup("xml")
+		if !ok || tag == "-" {
+			continue
+		}
+		toks := strings.Split(tag, ",")
+		xmlName, flags := toks[0], toks[1:]
+		switch {
+		case strutils.IsIn("innerxml", flags...):
+			ct.Mixed = true
+			ct.AnyElement = true
+		case strutils.IsIn("attr", flags...):
+			// The loaders of views, actions, menus and templates do not
+			// require any attribute, e.g. extension views have no id.
+			ct.Attributes = append(ct.Attributes, xsdutils.Attribute{
+				Name: xmlName,
+				Type: xsdAttributeType(xmlName, field.Type),
+			})
+		default:
+			elemType := field.Type
+			if elemType.Kind() == reflect.Slice {
+				elemType = elemType.Elem()
+			}
+			if elemType.Kind() != reflect.Struct {
+				ct.Elements = append(ct.Elements, xsdutils.Element{Name: xmlName, Type: xsdAttributeType(xmlName, elemType)})
+				continue
+			}
+			typeName := name + strings.Title(xmlName)
+			ct.Elements = append(ct.Elements, xsdutils.Element{Name: xmlName, Type: typeName})
+			addXSDComplexType(schema, typeName, elemType)
+		}
+	}
+	schema.ComplexTypes = append(schema.ComplexTypes, ct)
+}
+
+// xsdAttributeType returns the XSD type of the attribute with the given name
+// and Go type.
+func xsdAttributeType(name string, typ reflect.Type) string {
+	if st, ok := xsdSimpleTypes[typ]; ok {
+		return st
+	}
+	if name == "model" || name == "src_model" {
+		return "ModelName"
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "xs:boolean"
+	case reflect.Uint8:
+		return "xs:unsignedByte"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "xs:integer"
+	}
+	return "xs:string"
+}
+
+// xsdAlternatives returns the type alternatives that give the type returned by
+// typeName to the elements whose attr attribute has one of the given values.
+func xsdAlternatives(attr string, values []string, typeName func(string) string) []xsdutils.Alternative {
+	var res []xsdutils.Alternative
+	seen := make(map[string]bool)
+	for _, value := range values {
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		res = append(res, xsdutils.Alternative{
+			Test: fmt.Sprintf("@%s='%s'", attr, value),
+			Type: typeName(value),
+		})
+	}
+	return res
+}
+
+// sortedFieldNames returns the names of the given fields sorted alphabetically
+func sortedFieldNames(fields map[string]FieldASTData) []string {
+	res := make([]string, 0, len(fields))
+	for fieldName := range fields {
+		res = append(res, fieldName)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// stringValues returns the given slice of string based values as a []string
+func stringValues(values interface{}) []string {
+	val := reflect.ValueOf(values)
+	res := make([]string, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		res[i] = val.Index(i).String()
+	}
+	return res
+}
+
+// sortedKeys returns the keys of the given map sorted alphabetically
+func sortedKeys(m map[string]bool) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package generate
+
+import (
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/xsdutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var xsdTestModels = map[string]ModelASTData{
+	"User": {
+		Name: "User",
+		Fields: map[string]FieldASTData{
+			"ID":    {Name: "ID", FType: fieldtype.Integer},
+			"Name":  {Name: "Name", FType: fieldtype.Char},
+			"Posts": {Name: "Posts", FType: fieldtype.One2Many, RelModel: "Post"},
+		},
+	},
+	"Post": {
+		Name: "Post",
+		Fields: map[string]FieldASTData{
+			"ID":    {Name: "ID", FType: fieldtype.Integer},
+			"Title": {Name: "Title", FType: fieldtype.Char, JSON: "post_title"},
+			"User":  {Name: "User", FType: fieldtype.Many2One, RelModel: "User"},
+		},
+	},
+}
+
+func validateXSD(schema *xsdutils.Schema, xml string) []string {
+	doc := etree.NewDocument()
+	So(doc.ReadFromString(xml), ShouldBeNil)
+	var res []string
+	for _, err := range schema.Validate(doc) {
+		res = append(res, err.Error())
+	}
+	return res
+}
+
+func TestBuildXSD(t *testing.T) {
+	Convey("Testing XSD generation", t, func() {
+		data, err := BuildXSD(xsdTestModels).Bytes()
+		So(err, ShouldBeNil)
+		doc := etree.NewDocument()
+		So(doc.ReadFromBytes(data), ShouldBeNil)
+		schema, err := xsdutils.ReadSchema(doc)
+		So(err, ShouldBeNil)
+		Convey("Field names should be enumerated per model", func() {
+			xsd := string(data)
+			So(xsd, ShouldContainSubstring, `<xs:simpleType name="FieldName.User">`)
+			So(xsd, ShouldContainSubstring, `<xs:simpleType name="FieldName.Post">`)
+			So(xsd, ShouldContainSubstring, `<xs:union memberTypes="FieldName.Post FieldName.User"/>`)
+			So(xsd, ShouldContainSubstring, `<xs:enumeration value="post_title"/>`)
+			So(xsd, ShouldContainSubstring, `<xs:enumeration value="posts_ids"/>`)
+		})
+		Convey("Valid resources should validate", func() {
+			So(validateXSD(schema, `
+<hexya>
+	<data>
+		<view id="user_form" model="User" priority="12">
+			<form>
+				<field name="Name"/>
+				<field name="Posts">
+					<tree>
+						<field name="Title"/>
+					</tree>
+				</field>
+			</form>
+		</view>
+		<view inherit_id="user_form">
+			<field name="Name" position="after"/>
+		</view>
+		<action id="user_action" type="ir.actions.act_window" model="User" view_mode="tree,form"/>
+		<menuitem name="Users" action="user_action" sequence="3"/>
+		<template id="user_template">
+			<div>Users</div>
+		</template>
+		<record id="user_john" model="User">
+			<field name="Name">John</field>
+			<field name="posts_ids">
+				<record>
+					<field name="post_title">Hello</field>
+				</record>
+			</field>
+		</record>
+		<record id="post_hello" model="Post">
+			<field name="Title">Hello</field>
+			<field name="User" ref="user_john"/>
+		</record>
+	</data>
+</hexya>`), ShouldBeEmpty)
+		})
+		Convey("Fields should be checked against the model of their record", func() {
+			So(validateXSD(schema, `
+<hexya>
+	<data>
+		<record id="user_john" model="User">
+			<field name="Title">John</field>
+			<field name="Posts">
+				<record>
+					<field name="Name">Hello</field>
+				</record>
+			</field>
+		</record>
+		<record id="post_hello" model="Post">
+			<field name="Posts"/>
+		</record>
+	</data>
+</hexya>`), ShouldResemble, []string{
+				"/hexya/data/record/field: attribute 'name': 'Title' is not a valid FieldName.User",
+				"/hexya/data/record/field/record/field: attribute 'name': 'Name' is not a valid FieldName.Post",
+				"/hexya/data/record/field: attribute 'name': 'Posts' is not a valid FieldName.Post",
+			})
+		})
+		Convey("Attributes required by the loaders should be required", func() {
+			So(validateXSD(schema, `
+<hexya>
+	<data>
+		<view id="user_form" model="User">
+			<form>
+				<field string="Name"/>
+			</form>
+		</view>
+		<record id="user_john">
+			<field name="Name">John</field>
+		</record>
+		<record model="User">
+			<field>John</field>
+		</record>
+		<record model="Partner"/>
+	</data>
+</hexya>`), ShouldResemble, []string{
+				"/hexya/data/view/form/field: missing attribute 'name'",
+				"/hexya/data/record: missing attribute 'model'",
+				"/hexya/data/record/field: missing attribute 'name'",
+				"/hexya/data/record: attribute 'model': 'Partner' is not a valid ModelName",
+			})
+		})
+	})
+}
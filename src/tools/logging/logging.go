@@ -180,12 +180,22 @@ func GetLogger(moduleName string) Logger {
 // error with the panic message. This function is separated from
 // LogAndPanic so that unwanted panics can still be logged with
 // this function.
+//
+// If panicData is one of the error types of the exceptions package, it
+// is returned as is, with the stacktrace as debug information if it has
+// none. Otherwise, an exceptions.UserError is returned.
 func LogPanicData(panicData interface{}) error {
 	msg := fmt.Sprintf("%v", panicData)
 	log.Error("Hexya panicked", "msg", msg)
 
 	stackTrace := stack(1)
 	fullMsg := fmt.Sprintf("%s\n\n%s", msg, stackTrace)
+	if err, ok := panicData.(error); ok && exceptions.IsHexyaError(err) {
+		if exceptions.Debug(err) != "" {
+			return err
+		}
+		return exceptions.WithDebug(err, fullMsg)
+	}
 	return exceptions.UserError{
 		Message: msg,
 		Debug:   fullMsg,
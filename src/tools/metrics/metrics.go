@@ -0,0 +1,170 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package metrics holds the Prometheus metrics of the application.
+//
+// The metrics are collected by the HTTP server, the database layer and the
+// worker loop and are registered in Registry, which is exposed by the health
+// package at /metrics. Modules can register their own collectors in Registry.
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RouteKey is the key of the gin context under which the route of the
+// request is stored, so that it can be used as label of the HTTP metrics.
+const RouteKey = "hexya_route"
+
+// unknownRoute is the route label of requests that did not match a route
+const unknownRoute = "other"
+
+// Registry is the Prometheus registry of all the metrics of the application
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestDuration is the histogram of the HTTP requests
+	// durations by method, route and status.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "hexya",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	// SQLQueryDuration is the histogram of the SQL queries durations
+	// by status, which is either 'ok' or 'error'.
+	SQLQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "hexya",
+		Subsystem: "sql",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of the SQL queries by status.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"status"})
+	// SerializationRetries counts the transactions that have been
+	// retried because of a serialization failure.
+	SerializationRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "hexya",
+		Subsystem: "db",
+		Name:      "serialization_retries_total",
+		Help:      "Number of transactions retried because of a serialization failure.",
+	})
+	// WorkerRunDuration is the histogram of the worker functions runs
+	// durations by worker and status, which is either 'ok' or 'panic'.
+	WorkerRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "hexya",
+		Subsystem: "worker",
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the worker functions runs by worker and status.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"worker", "status"})
+)
+
+// ForGin returns a gin middleware that observes the
+// HTTP requests in HTTPRequestDuration.
+func ForGin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.GetString(RouteKey)
+		if route == "" {
+			route = unknownRoute
+		}
+		HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveSQLQuery records in SQLQueryDuration a query
+// started at start that returned the given error.
+func ObserveSQLQuery(start time.Time, err error) {
+	SQLQueryDuration.WithLabelValues(status(err != nil, "error")).Observe(time.Since(start).Seconds())
+}
+
+// ObserveWorkerRun records in WorkerRunDuration a run of the
+// given worker started at start, that panicked or not.
+func ObserveWorkerRun(worker string, start time.Time, panicked bool) {
+	WorkerRunDuration.WithLabelValues(worker, status(panicked, "panic")).Observe(time.Since(start).Seconds())
+}
+
+// status returns the status label value, which is failure if failed is true and 'ok' otherwise.
+func status(failed bool, failure string) string {
+	if failed {
+		return failure
+	}
+	return "ok"
+}
+
+// A dbStatsCollector collects the statistics of a database connection pool
+type dbStatsCollector struct {
+	stats             func() sql.DBStats
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+// NewDBStatsCollector returns a collector of the database connection
+// pool statistics returned by the given function.
+func NewDBStatsCollector(stats func() sql.DBStats) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("hexya", "db_pool", name), help, nil, nil)
+	}
+	return &dbStatsCollector{
+		stats:             stats,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:              desc("open_connections", "Number of established connections, both in use and idle."),
+		inUse:             desc("in_use_connections", "Number of connections currently in use."),
+		idle:              desc("idle_connections", "Number of idle connections."),
+		waitCount:         desc("wait_count_total", "Total number of connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "Total time blocked waiting for a new connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "Total number of connections closed due to the maximum of idle connections."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Total number of connections closed due to the maximum connection lifetime."),
+	}
+}
+
+// Describe sends the descriptors of the metrics of the collector to ch
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxLifetimeClosed
+}
+
+// Collect sends the current database pool statistics to ch
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		SQLQueryDuration,
+		SerializationRetries,
+		WorkerRunDuration,
+	)
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package totp implements time-based one-time passwords as defined by
+// RFC 6238, compatible with usual authenticator applications.
+//
+// Secrets are base32 encoded without padding and codes are 6 digits long,
+// computed with HMAC-SHA1 over 30 seconds periods.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// Period is the validity period of a code in seconds
+	Period = 30
+	// Digits is the number of digits of a code
+	Digits = 6
+	// secretLen is the number of random bytes of a secret
+	secretLen = 20
+)
+
+// encoding is the base32 encoding of secrets
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded secret
+func GenerateSecret() (string, error) {
+	buf := make([]byte, secretLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// decodeSecret returns the key of the given base32 encoded secret.
+// Spaces and padding are ignored and lower case letters are accepted.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// Counter returns the time step counter of the given time
+func Counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// CodeAt returns the code of the given secret for the given time step counter
+func CodeAt(secret string, counter int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Code returns the code of the given secret at the given time
+func Code(secret string, t time.Time) (string, error) {
+	return CodeAt(secret, Counter(t))
+}
+
+// Verify checks the given code against the given secret at the given time.
+// Codes of the window time steps before and after t are also accepted to
+// allow for clock drift.
+//
+// It returns the time step counter of the matching code, so that callers
+// can reject codes that have already been used, and true if the code is valid.
+func Verify(secret, code string, t time.Time, window int) (int64, bool) {
+	counter := Counter(t)
+	for i := -int64(window); i <= int64(window); i++ {
+		expected, err := CodeAt(secret, counter+i)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter + i, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth URI of the given secret to be given to
+// authenticator applications, usually as a QR code.
+func URI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", Digits))
+	params.Set("period", fmt.Sprintf("%d", Period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// QRCode returns the PNG image of the QR code of the given
+// otpauth URI with the given size in pixels.
+func QRCode(uri string, size int) ([]byte, error) {
+	return qrcode.Encode(uri, qrcode.Medium, size)
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package totp
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rfcSecret is the base32 encoding of the SHA1 secret of RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTP(t *testing.T) {
+	Convey("Testing TOTP", t, func() {
+		Convey("Codes should match RFC 6238 test vectors", func() {
+			for ts, code := range map[int64]string{
+				59:         "287082",
+				1111111109: "081804",
+				1111111111: "050471",
+				1234567890: "005924",
+				2000000000: "279037",
+			} {
+				res, err := Code(rfcSecret, time.Unix(ts, 0))
+				So(err, ShouldBeNil)
+				So(res, ShouldEqual, code)
+			}
+		})
+		Convey("Secrets should be accepted in lower case and with spaces", func() {
+			res, err := Code("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "287082")
+		})
+		Convey("Invalid secrets should return an error", func() {
+			_, err := Code("1234", time.Now())
+			So(err, ShouldNotBeNil)
+			_, ok := Verify("1234", "123456", time.Now(), 1)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Generated secrets should be random and usable", func() {
+			secret1, err := GenerateSecret()
+			So(err, ShouldBeNil)
+			secret2, err := GenerateSecret()
+			So(err, ShouldBeNil)
+			So(secret1, ShouldHaveLength, 32)
+			So(secret1, ShouldNotEqual, secret2)
+			_, err = Code(secret1, time.Now())
+			So(err, ShouldBeNil)
+		})
+		Convey("Verifying codes within the drift window", func() {
+			now := time.Unix(1111111111, 0)
+			counter, ok := Verify(rfcSecret, "050471", now, 1)
+			So(ok, ShouldBeTrue)
+			So(counter, ShouldEqual, Counter(now))
+			previous, _ := Code(rfcSecret, now.Add(-Period*time.Second))
+			counter, ok = Verify(rfcSecret, previous, now, 1)
+			So(ok, ShouldBeTrue)
+			So(counter, ShouldEqual, Counter(now)-1)
+			old, _ := Code(rfcSecret, now.Add(-2*Period*time.Second))
+			_, ok = Verify(rfcSecret, old, now, 1)
+			So(ok, ShouldBeFalse)
+			_, ok = Verify(rfcSecret, old, now, 2)
+			So(ok, ShouldBeTrue)
+			_, ok = Verify(rfcSecret, "000000", now, 1)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Getting the otpauth URI and QR code", func() {
+			uri := URI("Hexya", "john@example.com", rfcSecret)
+			u, err := url.Parse(uri)
+			So(err, ShouldBeNil)
+			So(u.Scheme, ShouldEqual, "otpauth")
+			So(u.Host, ShouldEqual, "totp")
+			So(u.Path, ShouldEqual, "/Hexya:john@example.com")
+			So(u.Query().Get("secret"), ShouldEqual, rfcSecret)
+			So(u.Query().Get("issuer"), ShouldEqual, "Hexya")
+			png, err := QRCode(uri, 256)
+			So(err, ShouldBeNil)
+			So(bytes.HasPrefix(png, []byte("\x89PNG")), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,440 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// QueueSize is the maximum number of ended spans waiting to be
+	// exported. Spans ended when the queue is full are dropped.
+	QueueSize = 4096
+	// BatchSize is the maximum number of spans sent at once to the exporter
+	BatchSize = 512
+	// BatchTimeout is the maximum time an ended span waits before being exported
+	BatchTimeout = 5 * time.Second
+)
+
+// An Exporter sends spans to a tracing backend
+type Exporter interface {
+	// ExportSpans exports the given ended spans
+	ExportSpans(spans []*SpanData) error
+	// Shutdown releases the resources of the exporter.
+	// ExportSpans is not called after Shutdown.
+	Shutdown() error
+}
+
+var (
+	// processor is the batchProcessor of the current exporter
+	processor atomic.Value
+	// processorMu serializes the changes of processor
+	processorMu sync.Mutex
+)
+
+// Enabled returns true if an exporter is set
+func Enabled() bool {
+	return currentProcessor() != nil
+}
+
+// currentProcessor returns the batchProcessor of the current exporter, or nil
+func currentProcessor() *batchProcessor {
+	bp, _ := processor.Load().(*batchProcessor)
+	return bp
+}
+
+// SetExporter enables tracing and sets the exporter to which spans are sent.
+// If exporter is nil, tracing is disabled.
+//
+// The previous exporter, if any, is flushed and shut down.
+func SetExporter(exporter Exporter) {
+	processorMu.Lock()
+	defer processorMu.Unlock()
+	var bp *batchProcessor
+	if exporter != nil {
+		bp = newBatchProcessor(exporter)
+	}
+	previous := currentProcessor()
+	processor.Store(bp)
+	if previous != nil {
+		if err := previous.shutdown(context.Background()); err != nil {
+			log.Warn("Unable to shut down span exporter", "error", err)
+		}
+	}
+}
+
+// Shutdown disables tracing, exports the pending spans and shuts down
+// the exporter. It returns ctx.Err() if ctx is done before the end of
+// the export.
+func Shutdown(ctx context.Context) error {
+	processorMu.Lock()
+	defer processorMu.Unlock()
+	previous := currentProcessor()
+	processor.Store((*batchProcessor)(nil))
+	if previous == nil {
+		return nil
+	}
+	return previous.shutdown(ctx)
+}
+
+// A batchProcessor queues ended spans and sends them in batches to an exporter
+type batchProcessor struct {
+	exporter Exporter
+	queue    chan *SpanData
+	stop     chan struct{}
+	done     chan struct{}
+	dropped  uint64
+}
+
+// newBatchProcessor returns a new batchProcessor for the given exporter
+// and starts its export loop.
+func newBatchProcessor(exporter Exporter) *batchProcessor {
+	bp := &batchProcessor{
+		exporter: exporter,
+		queue:    make(chan *SpanData, QueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go bp.loop()
+	return bp
+}
+
+// enqueue adds the given span to the queue, or drops it if the queue is full
+func (bp *batchProcessor) enqueue(span *SpanData) {
+	select {
+	case bp.queue <- span:
+	default:
+		if atomic.AddUint64(&bp.dropped, 1) == 1 {
+			log.Warn("Span queue is full, dropping spans", "size", QueueSize)
+		}
+	}
+}
+
+// loop exports the queued spans by batches until the processor is stopped
+func (bp *batchProcessor) loop() {
+	defer close(bp.done)
+	ticker := time.NewTicker(BatchTimeout)
+	defer ticker.Stop()
+	batch := make([]*SpanData, 0, BatchSize)
+	for {
+		select {
+		case span := <-bp.queue:
+			batch = append(batch, span)
+			if len(batch) >= BatchSize {
+				batch = bp.export(batch)
+			}
+		case <-ticker.C:
+			batch = bp.export(batch)
+		case <-bp.stop:
+			for {
+				select {
+				case span := <-bp.queue:
+					batch = append(batch, span)
+					if len(batch) >= BatchSize {
+						batch = bp.export(batch)
+					}
+				default:
+					bp.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends the given batch to the exporter and returns an empty batch
+func (bp *batchProcessor) export(batch []*SpanData) []*SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := bp.exporter.ExportSpans(batch); err != nil {
+		log.Warn("Unable to export spans", "spans", len(batch), "error", err)
+	}
+	return make([]*SpanData, 0, BatchSize)
+}
+
+// shutdown exports the pending spans and shuts down the exporter
+func (bp *batchProcessor) shutdown(ctx context.Context) error {
+	close(bp.stop)
+	select {
+	case <-bp.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if dropped := atomic.LoadUint64(&bp.dropped); dropped > 0 {
+		log.Warn("Spans have been dropped because the queue was full", "dropped", dropped)
+	}
+	return bp.exporter.Shutdown()
+}
+
+// A jsonSpan is the JSON representation of a span written by a writerExporter
+type jsonSpan struct {
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	DurationMS   float64                `json:"duration_ms"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+// A writerExporter writes spans as JSON lines to a writer
+type writerExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewWriterExporter returns an Exporter that writes each span
+// as a JSON object on a single line to w.
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+// NewFileExporter returns an Exporter that appends each span as a JSON
+// object on a single line to the file with the given name, creating it
+// if it does not exist.
+func NewFileExporter(fileName string) (Exporter, error) {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &writerExporter{w: f, closer: f}, nil
+}
+
+// ExportSpans writes the given spans
+func (we *writerExporter) ExportSpans(spans []*SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, span := range spans {
+		js := jsonSpan{
+			Name:       span.Name,
+			Kind:       span.Kind.String(),
+			TraceID:    span.TraceID.String(),
+			SpanID:     span.SpanID.String(),
+			Start:      span.Start,
+			End:        span.End,
+			DurationMS: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+			Attributes: span.Attributes,
+		}
+		if span.ParentSpanID.IsValid() {
+			js.ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Failed {
+			js.Error = span.Message
+		}
+		if err := enc.Encode(js); err != nil {
+			return err
+		}
+	}
+	we.mu.Lock()
+	defer we.mu.Unlock()
+	_, err := we.w.Write(buf.Bytes())
+	return err
+}
+
+// Shutdown closes the file of the exporter, if any
+func (we *writerExporter) Shutdown() error {
+	if we.closer == nil {
+		return nil
+	}
+	return we.closer.Close()
+}
+
+// An OTLPExporter sends spans to an OpenTelemetry collector
+// with the OTLP/HTTP protocol in JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the base URL of the collector, e.g. http://localhost:4318.
+	// Spans are posted to its /v1/traces path.
+	Endpoint string
+	// Headers are added to each export request, e.g. for authentication
+	Headers map[string]string
+	// ServiceName is the service.name resource attribute of the spans.
+	// Defaults to hexya.
+	ServiceName string
+	// HTTPClient is the client used to send spans.
+	// Defaults to a client with a 10 seconds timeout.
+	HTTPClient *http.Client
+}
+
+var _ Exporter = new(OTLPExporter)
+
+// defaultOTLPClient is the HTTP client of OTLPExporter without HTTPClient
+var defaultOTLPClient = &http.Client{Timeout: 10 * time.Second}
+
+// tracesURL returns the URL to which spans are posted
+func (oe *OTLPExporter) tracesURL() string {
+	if strings.HasSuffix(oe.Endpoint, "/v1/traces") {
+		return oe.Endpoint
+	}
+	return strings.TrimSuffix(oe.Endpoint, "/") + "/v1/traces"
+}
+
+// ExportSpans posts the given spans to the collector
+func (oe *OTLPExporter) ExportSpans(spans []*SpanData) error {
+	body, err := json.Marshal(oe.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, oe.tracesURL(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range oe.Headers {
+		req.Header.Set(k, v)
+	}
+	client := oe.HTTPClient
+	if client == nil {
+		client = defaultOTLPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, oe.tracesURL())
+	}
+	return nil
+}
+
+// Shutdown does nothing for OTLPExporter
+func (oe *OTLPExporter) Shutdown() error {
+	return nil
+}
+
+// OTLP JSON encoding of an export request
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+// otlpStatusError is the OTLP status code of failed spans
+const otlpStatusError = 2
+
+// request returns the OTLP export request of the given spans
+func (oe *OTLPExporter) request(spans []*SpanData) otlpRequest {
+	serviceName := oe.ServiceName
+	if serviceName == "" {
+		serviceName = "hexya"
+	}
+	otlpSpans := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		ots := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.ParentSpanID.IsValid() {
+			ots.ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Failed {
+			ots.Status = otlpStatus{Code: otlpStatusError, Message: span.Message}
+		}
+		otlpSpans[i] = ots
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes(map[string]interface{}{"service.name": serviceName}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/hexya-erp/hexya"},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes returns the given attributes in OTLP encoding, sorted by key
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	res := make([]otlpKeyValue, 0, len(attributes))
+	for k, v := range attributes {
+		var value otlpAnyValue
+		switch val := v.(type) {
+		case string:
+			value.StringValue = &val
+		case bool:
+			value.BoolValue = &val
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			s := fmt.Sprintf("%d", val)
+			value.IntValue = &s
+		case float32:
+			f := float64(val)
+			value.DoubleValue = &f
+		case float64:
+			value.DoubleValue = &val
+		default:
+			s := fmt.Sprintf("%v", val)
+			value.StringValue = &s
+		}
+		res = append(res, otlpKeyValue{Key: k, Value: value})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
@@ -0,0 +1,12 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tracing
+
+import "github.com/hexya-erp/hexya/src/tools/logging"
+
+var log logging.Logger
+
+func init() {
+	log = logging.GetLogger("tracing")
+}
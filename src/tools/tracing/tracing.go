@@ -0,0 +1,335 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package tracing records the spans of the application and exports them
+// in batches to an OpenTelemetry collector or to a file.
+//
+// Spans are recorded for each HTTP request, each method layer called on a
+// RecordCollection and each SQL statement. Tracing is disabled until an
+// exporter is set with SetExporter. When it is disabled, StartSpan returns
+// nil spans on which all methods are no-ops, so that callers do not need
+// to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
+)
+
+// TraceParentHeader is the W3C Trace Context header used to
+// propagate the trace of a request between services.
+const TraceParentHeader = "traceparent"
+
+// A TraceID identifies a trace, that is a tree of spans
+type TraceID [16]byte
+
+// String returns the hexadecimal representation of the trace ID
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid returns true if the trace ID is not zero
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// A SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the hexadecimal representation of the span ID
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid returns true if the span ID is not zero
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// A SpanKind is the role of a span in a trace, with OpenTelemetry values
+type SpanKind int
+
+// Kinds of spans
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// String returns the name of the span kind
+func (k SpanKind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// SpanData is the data of an ended span, as sent to exporters
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	// Failed is true if the operation of the span failed,
+	// in which case Message describes the error.
+	Failed  bool
+	Message string
+}
+
+// A Span is a timed operation of a trace.
+//
+// All methods of Span can be called on a nil Span,
+// which is what StartSpan returns when tracing is disabled.
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// StartSpan starts a new span of the given kind with the given name.
+// If parent is nil, the span is the root span of a new trace.
+//
+// It returns nil if tracing is disabled.
+func StartSpan(name string, kind SpanKind, parent *Span) *Span {
+	if !Enabled() {
+		return nil
+	}
+	if parent == nil {
+		return newSpan(name, kind, newTraceID(), SpanID{})
+	}
+	return newSpan(name, kind, parent.data.TraceID, parent.data.SpanID)
+}
+
+// StartRemoteSpan starts a new span of the given kind with the given name,
+// child of the remote span described by the given W3C traceparent header.
+// If traceParent is empty or invalid, the span is the root span of a new trace.
+//
+// It returns nil if tracing is disabled.
+func StartRemoteSpan(name string, kind SpanKind, traceParent string) *Span {
+	if !Enabled() {
+		return nil
+	}
+	traceID, parentID, ok := ParseTraceParent(traceParent)
+	if !ok {
+		return newSpan(name, kind, newTraceID(), SpanID{})
+	}
+	return newSpan(name, kind, traceID, parentID)
+}
+
+// newSpan returns a new started span
+func newSpan(name string, kind SpanKind, traceID TraceID, parentID SpanID) *Span {
+	return &Span{
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			TraceID:      traceID,
+			SpanID:       newSpanID(),
+			ParentSpanID: parentID,
+			Start:        time.Now(),
+			Attributes:   make(map[string]interface{}),
+		},
+	}
+}
+
+// SetName changes the name of the span
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttribute sets the attribute with the given key to the given value.
+// Values should be strings, numbers or booleans. Attributes set after the
+// end of the span are ignored.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the operation of the span as failed with the given message
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Failed = true
+	s.data.Message = message
+}
+
+// End ends the span and sends it to the exporter.
+// Calling End more than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	if bp := currentProcessor(); bp != nil {
+		bp.enqueue(&data)
+	}
+}
+
+// TraceID returns the ID of the trace of this span
+func (s *Span) TraceID() TraceID {
+	if s == nil {
+		return TraceID{}
+	}
+	return s.data.TraceID
+}
+
+// SpanID returns the ID of this span
+func (s *Span) SpanID() SpanID {
+	if s == nil {
+		return SpanID{}
+	}
+	return s.data.SpanID
+}
+
+// TraceParent returns the W3C traceparent header value
+// to propagate this span to other services.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", s.data.TraceID, s.data.SpanID)
+}
+
+// ParseTraceParent parses the given W3C traceparent header value and returns
+// the trace ID and parent span ID it holds. ok is false if it is invalid.
+func ParseTraceParent(header string) (traceID TraceID, parentID SpanID, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return
+	}
+	ok = traceID.IsValid() && parentID.IsValid()
+	return
+}
+
+// spanKey is the key of the span in a context.Context
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx which holds the given span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span held by ctx, or nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ForGin returns a gin middleware that records a span for each HTTP request.
+//
+// The span continues the trace of the traceparent header of the request if
+// any, and is stored in the context of the request.
+func ForGin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Enabled() {
+			c.Next()
+			return
+		}
+		span := StartRemoteSpan("HTTP "+c.Request.Method, KindServer, c.GetHeader(TraceParentHeader))
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.target", c.Request.URL.Path)
+		span.SetAttribute("http.client_ip", c.ClientIP())
+		c.Request = c.Request.WithContext(ContextWithSpan(c.Request.Context(), span))
+		defer span.End()
+		c.Next()
+		if route := c.GetString(metrics.RouteKey); route != "" {
+			span.SetName(c.Request.Method + " " + route)
+			span.SetAttribute("http.route", route)
+		}
+		span.SetAttribute("http.status_code", c.Writer.Status())
+		if c.Writer.Status() >= 500 {
+			span.SetError(fmt.Sprintf("HTTP status %d", c.Writer.Status()))
+		}
+	}
+}
+
+// idGenerator generates random trace and span IDs. It is seeded
+// by crypto/rand but is much faster for the frequent span creations.
+var idGenerator = struct {
+	sync.Mutex
+	*rand.Rand
+}{
+	Rand: rand.New(rand.NewSource(randomSeed())),
+}
+
+// randomSeed returns a random seed for idGenerator
+func randomSeed() int64 {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+// newTraceID returns a new random trace ID
+func newTraceID() TraceID {
+	var res TraceID
+	idGenerator.Lock()
+	defer idGenerator.Unlock()
+	for !res.IsValid() {
+		idGenerator.Read(res[:])
+	}
+	return res
+}
+
+// newSpanID returns a new random span ID
+func newSpanID() SpanID {
+	var res SpanID
+	idGenerator.Lock()
+	defer idGenerator.Unlock()
+	for !res.IsValid() {
+		idGenerator.Read(res[:])
+	}
+	return res
+}
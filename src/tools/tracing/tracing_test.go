@@ -0,0 +1,231 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/tools/metrics"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A memoryExporter keeps the exported spans in memory
+type memoryExporter struct {
+	sync.Mutex
+	spans    []*SpanData
+	shutDown bool
+}
+
+func (me *memoryExporter) ExportSpans(spans []*SpanData) error {
+	me.Lock()
+	defer me.Unlock()
+	me.spans = append(me.spans, spans...)
+	return nil
+}
+
+func (me *memoryExporter) Shutdown() error {
+	me.shutDown = true
+	return nil
+}
+
+// byName returns the exported span with the given name
+func (me *memoryExporter) byName(name string) *SpanData {
+	for _, span := range me.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	Convey("Testing tracing", t, func() {
+		Convey("Spans should be nil and no-ops when tracing is disabled", func() {
+			So(Enabled(), ShouldBeFalse)
+			span := StartSpan("test", KindInternal, nil)
+			So(span, ShouldBeNil)
+			So(func() {
+				span.SetName("other")
+				span.SetAttribute("key", "value")
+				span.SetError("failed")
+				span.End()
+			}, ShouldNotPanic)
+			So(span.TraceParent(), ShouldBeEmpty)
+		})
+		Convey("Traceparent headers should be parsed", func() {
+			traceID, parentID, ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			So(ok, ShouldBeTrue)
+			So(traceID.String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(parentID.String(), ShouldEqual, "00f067aa0ba902b7")
+			for _, header := range []string{
+				"",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+				"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+				"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+			} {
+				_, _, ok = ParseTraceParent(header)
+				So(ok, ShouldBeFalse)
+			}
+		})
+		Convey("Ended spans should be exported to the exporter", func() {
+			exporter := new(memoryExporter)
+			SetExporter(exporter)
+			So(Enabled(), ShouldBeTrue)
+			root := StartSpan("root", KindServer, nil)
+			child := StartSpan("child", KindInternal, root)
+			child.SetAttribute("hexya.model", "User")
+			child.SetError("method panicked")
+			child.End()
+			child.SetAttribute("ignored", true)
+			child.End()
+			root.End()
+			remote := StartRemoteSpan("remote", KindServer, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			remote.End()
+			So(Shutdown(context.Background()), ShouldBeNil)
+			So(Enabled(), ShouldBeFalse)
+			So(exporter.shutDown, ShouldBeTrue)
+			So(exporter.spans, ShouldHaveLength, 3)
+			rootData, childData := exporter.byName("root"), exporter.byName("child")
+			So(rootData.TraceID.IsValid(), ShouldBeTrue)
+			So(rootData.ParentSpanID.IsValid(), ShouldBeFalse)
+			So(childData.TraceID, ShouldEqual, rootData.TraceID)
+			So(childData.ParentSpanID, ShouldEqual, rootData.SpanID)
+			So(childData.Attributes, ShouldResemble, map[string]interface{}{"hexya.model": "User"})
+			So(childData.Failed, ShouldBeTrue)
+			So(childData.Message, ShouldEqual, "method panicked")
+			So(childData.End.Before(childData.Start), ShouldBeFalse)
+			remoteData := exporter.byName("remote")
+			So(remoteData.TraceID.String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(remoteData.ParentSpanID.String(), ShouldEqual, "00f067aa0ba902b7")
+			So(remote.TraceParent(), ShouldEqual, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+remoteData.SpanID.String()+"-01")
+		})
+		Convey("Spans should be stored in contexts", func() {
+			SetExporter(new(memoryExporter))
+			span := StartSpan("test", KindInternal, nil)
+			ctx := ContextWithSpan(context.Background(), span)
+			So(SpanFromContext(ctx), ShouldEqual, span)
+			So(SpanFromContext(context.Background()), ShouldBeNil)
+			So(Shutdown(context.Background()), ShouldBeNil)
+		})
+		Convey("The writer exporter should write JSON lines", func() {
+			var buf bytes.Buffer
+			SetExporter(NewWriterExporter(&buf))
+			span := StartSpan("SELECT", KindClient, nil)
+			span.SetAttribute("db.statement", "SELECT 1")
+			span.SetError("connection refused")
+			span.End()
+			StartSpan("second", KindInternal, span).End()
+			So(Shutdown(context.Background()), ShouldBeNil)
+			var lines []map[string]interface{}
+			scanner := bufio.NewScanner(&buf)
+			for scanner.Scan() {
+				var line map[string]interface{}
+				So(json.Unmarshal(scanner.Bytes(), &line), ShouldBeNil)
+				lines = append(lines, line)
+			}
+			So(lines, ShouldHaveLength, 2)
+			So(lines[0]["name"], ShouldEqual, "SELECT")
+			So(lines[0]["kind"], ShouldEqual, "client")
+			So(lines[0]["trace_id"], ShouldEqual, span.TraceID().String())
+			So(lines[0]["parent_span_id"], ShouldBeNil)
+			So(lines[0]["attributes"], ShouldResemble, map[string]interface{}{"db.statement": "SELECT 1"})
+			So(lines[0]["error"], ShouldEqual, "connection refused")
+			So(lines[1]["parent_span_id"], ShouldEqual, span.SpanID().String())
+		})
+		Convey("The OTLP exporter should post spans to the collector", func() {
+			var (
+				body    map[string]interface{}
+				path    string
+				headers http.Header
+			)
+			collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				headers = r.Header
+				data, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(data, &body)
+			}))
+			defer collector.Close()
+			exporter := &OTLPExporter{
+				Endpoint:    collector.URL,
+				Headers:     map[string]string{"Authorization": "Bearer token"},
+				ServiceName: "erp",
+			}
+			span := newSpan("User.Write", KindInternal, newTraceID(), newSpanID())
+			span.SetAttribute("hexya.uid", int64(2))
+			span.SetAttribute("hexya.module", "github.com/hexya-addons/base")
+			span.SetError("method panicked")
+			span.ended = true
+			So(exporter.ExportSpans([]*SpanData{&span.data}), ShouldBeNil)
+			So(path, ShouldEqual, "/v1/traces")
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+			So(headers.Get("Authorization"), ShouldEqual, "Bearer token")
+			resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+			So(resourceSpans["resource"], ShouldResemble, map[string]interface{}{
+				"attributes": []interface{}{
+					map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "erp"}},
+				},
+			})
+			otlpSpan := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+			So(otlpSpan["name"], ShouldEqual, "User.Write")
+			So(otlpSpan["traceId"], ShouldEqual, span.data.TraceID.String())
+			So(otlpSpan["spanId"], ShouldEqual, span.data.SpanID.String())
+			So(otlpSpan["parentSpanId"], ShouldEqual, span.data.ParentSpanID.String())
+			So(otlpSpan["kind"], ShouldEqual, 1)
+			So(otlpSpan["status"], ShouldResemble, map[string]interface{}{"code": float64(2), "message": "method panicked"})
+			So(otlpSpan["attributes"], ShouldResemble, []interface{}{
+				map[string]interface{}{"key": "hexya.module", "value": map[string]interface{}{"stringValue": "github.com/hexya-addons/base"}},
+				map[string]interface{}{"key": "hexya.uid", "value": map[string]interface{}{"intValue": "2"}},
+			})
+		})
+		Convey("The OTLP exporter should return an error on failed requests", func() {
+			collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer collector.Close()
+			exporter := &OTLPExporter{Endpoint: collector.URL + "/v1/traces"}
+			span := newSpan("test", KindInternal, newTraceID(), SpanID{})
+			So(exporter.ExportSpans([]*SpanData{&span.data}), ShouldNotBeNil)
+		})
+		Convey("The gin middleware should record a span per request", func() {
+			gin.SetMode(gin.TestMode)
+			exporter := new(memoryExporter)
+			SetExporter(exporter)
+			var requestSpan *Span
+			router := gin.New()
+			router.Use(ForGin())
+			router.GET("/web/:id", func(c *gin.Context) {
+				c.Set(metrics.RouteKey, "/web/:id")
+				requestSpan = SpanFromContext(c.Request.Context())
+				c.AbortWithError(http.StatusInternalServerError, errors.New("failure"))
+			})
+			req, _ := http.NewRequest(http.MethodGet, "/web/12?password=secret", nil)
+			req.Header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			So(Shutdown(context.Background()), ShouldBeNil)
+			So(exporter.spans, ShouldHaveLength, 1)
+			span := exporter.spans[0]
+			So(requestSpan, ShouldNotBeNil)
+			So(span.SpanID, ShouldEqual, requestSpan.SpanID())
+			So(span.Name, ShouldEqual, "GET /web/:id")
+			So(span.Kind, ShouldEqual, KindServer)
+			So(span.TraceID.String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(span.Attributes["http.target"], ShouldEqual, "/web/12")
+			So(span.Attributes["http.route"], ShouldEqual, "/web/:id")
+			So(span.Attributes["http.status_code"], ShouldEqual, http.StatusInternalServerError)
+			So(span.Failed, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package xlsxutils
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+	relsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+	workbookRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+	workbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+	sheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	sheetFooter = `</sheetData></worksheet>`
+)
+
+// WriteSheet writes to w an XLSX workbook with a single sheet named sheetName
+// and holding the given rows.
+//
+// Cell values can be strings, booleans, integers or floats. Other values are
+// written with their default string representation. A nil value gives an empty cell.
+func WriteSheet(w io.Writer, sheetName string, rows [][]interface{}) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name    string
+		content string
+	}{
+		{name: "[Content_Types].xml", content: contentTypesXML},
+		{name: "_rels/.rels", content: relsXML},
+		{name: "xl/_rels/workbook.xml.rels", content: workbookRelsXML},
+		{name: "xl/workbook.xml", content: fmt.Sprintf(workbookXML, escape(sheetName))},
+		{name: "xl/worksheets/sheet1.xml", content: sheetXML(rows)},
+	}
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(fw, file.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// sheetXML returns the XML content of a worksheet with the given rows
+func sheetXML(rows [][]interface{}) string {
+	var buf strings.Builder
+	buf.WriteString(sheetHeader)
+	for i, row := range rows {
+		fmt.Fprintf(&buf, `<row r="%d">`, i+1)
+		for j, value := range row {
+			if value == nil {
+				continue
+			}
+			ref := fmt.Sprintf("%s%d", ColumnName(j), i+1)
+			switch v := value.(type) {
+			case bool:
+				b := 0
+				if v {
+					b = 1
+				}
+				fmt.Fprintf(&buf, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+				fmt.Fprintf(&buf, `<c r="%s"><v>%d</v></c>`, ref, v)
+			case float32:
+				fmt.Fprintf(&buf, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(float64(v), 'f', -1, 32))
+			case float64:
+				fmt.Fprintf(&buf, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				fmt.Fprintf(&buf, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escape(fmt.Sprint(v)))
+			}
+		}
+		buf.WriteString(`</row>`)
+	}
+	buf.WriteString(sheetFooter)
+	return buf.String()
+}
+
+// ColumnName returns the spreadsheet name of the column at the given
+// zero based index (i.e. A, B, ..., Z, AA, AB, etc.)
+func ColumnName(index int) string {
+	var res string
+	for index >= 0 {
+		res = string(rune('A'+index%26)) + res
+		index = index/26 - 1
+	}
+	return res
+}
+
+// escape returns s escaped for use in XML text and attributes
+func escape(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
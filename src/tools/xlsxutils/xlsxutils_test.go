@@ -0,0 +1,55 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package xlsxutils
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestXLSX(t *testing.T) {
+	Convey("Testing XLSX utilities", t, func() {
+		Convey("Column names", func() {
+			So(ColumnName(0), ShouldEqual, "A")
+			So(ColumnName(25), ShouldEqual, "Z")
+			So(ColumnName(26), ShouldEqual, "AA")
+			So(ColumnName(27), ShouldEqual, "AB")
+			So(ColumnName(701), ShouldEqual, "ZZ")
+			So(ColumnName(702), ShouldEqual, "AAA")
+		})
+		Convey("Writing a sheet", func() {
+			var buf bytes.Buffer
+			err := WriteSheet(&buf, "User", [][]interface{}{
+				{"ID", "Name", "Nums", "IsStaff", "Size"},
+				{"external_id_1", "Peter & <Co>", int64(1), true, 1.78},
+				{"external_id_2", "Mary", nil, false, 1.59},
+			})
+			So(err, ShouldBeNil)
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			So(err, ShouldBeNil)
+			files := make(map[string]string)
+			for _, f := range zr.File {
+				r, err := f.Open()
+				So(err, ShouldBeNil)
+				content, err := ioutil.ReadAll(r)
+				So(err, ShouldBeNil)
+				files[f.Name] = string(content)
+			}
+			So(files, ShouldContainKey, "[Content_Types].xml")
+			So(files, ShouldContainKey, "xl/workbook.xml")
+			So(files["xl/workbook.xml"], ShouldContainSubstring, `<sheet name="User"`)
+			sheet := files["xl/worksheets/sheet1.xml"]
+			So(sheet, ShouldContainSubstring, `<c r="A1" t="inlineStr"><is><t xml:space="preserve">ID</t></is></c>`)
+			So(sheet, ShouldContainSubstring, `<t xml:space="preserve">Peter &amp; &lt;Co&gt;</t>`)
+			So(sheet, ShouldContainSubstring, `<c r="C2"><v>1</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="D2" t="b"><v>1</v></c>`)
+			So(sheet, ShouldContainSubstring, `<c r="E3"><v>1.59</v></c>`)
+			So(sheet, ShouldNotContainSubstring, `r="C3"`)
+		})
+	})
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package xmlrpc implements the encoding and decoding of XML-RPC messages.
+//
+// Values are decoded into Go values as follows:
+//
+//	int, i4, i8         int64
+//	boolean             bool
+//	string              string
+//	double              float64
+//	dateTime.iso8601    string
+//	base64              string (still base64 encoded)
+//	array               []interface{}
+//	struct              map[string]interface{}
+//	nil                 nil
+//
+// Encoding accepts the same types, as well as all integer and float types,
+// slices, arrays, maps with string keys and fmt.Stringer values. Nil values
+// are encoded with the <nil/> extension.
+package xmlrpc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// A Fault is an XML-RPC fault response
+type Fault struct {
+	Code   int
+	String string
+}
+
+// Error returns the fault string of this Fault
+func (f Fault) Error() string {
+	return f.String
+}
+
+var _ error = Fault{}
+
+// DecodeMethodCall decodes the XML-RPC methodCall in r and
+// returns the method name and the parameters values.
+func DecodeMethodCall(r io.Reader) (string, []interface{}, error) {
+	doc := etree.NewDocument()
+	if _, err := doc.ReadFrom(r); err != nil {
+		return "", nil, fmt.Errorf("invalid XML: %s", err)
+	}
+	root := doc.SelectElement("methodCall")
+	if root == nil {
+		return "", nil, fmt.Errorf("missing methodCall element")
+	}
+	methodName := root.SelectElement("methodName")
+	if methodName == nil {
+		return "", nil, fmt.Errorf("missing methodName element")
+	}
+	params, err := decodeParams(root)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(methodName.Text()), params, nil
+}
+
+// DecodeMethodResponse decodes the XML-RPC methodResponse in r and
+// returns its value. If the response is a fault, a Fault error is returned.
+func DecodeMethodResponse(r io.Reader) (interface{}, error) {
+	doc := etree.NewDocument()
+	if _, err := doc.ReadFrom(r); err != nil {
+		return nil, fmt.Errorf("invalid XML: %s", err)
+	}
+	root := doc.SelectElement("methodResponse")
+	if root == nil {
+		return nil, fmt.Errorf("missing methodResponse element")
+	}
+	if fault := root.FindElement("fault/value"); fault != nil {
+		val, err := decodeValue(fault)
+		if err != nil {
+			return nil, err
+		}
+		faultStruct, _ := val.(map[string]interface{})
+		code, _ := faultStruct["faultCode"].(int64)
+		str, _ := faultStruct["faultString"].(string)
+		return nil, Fault{Code: int(code), String: str}
+	}
+	params, err := decodeParams(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) != 1 {
+		return nil, fmt.Errorf("method response should have exactly one parameter, got %d", len(params))
+	}
+	return params[0], nil
+}
+
+// decodeParams decodes the values of the params element of the given element
+func decodeParams(element *etree.Element) ([]interface{}, error) {
+	var res []interface{}
+	for _, param := range element.FindElements("params/param") {
+		value := param.SelectElement("value")
+		if value == nil {
+			return nil, fmt.Errorf("missing value element in param")
+		}
+		val, err := decodeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, val)
+	}
+	return res, nil
+}
+
+// decodeValue decodes the given value element
+func decodeValue(value *etree.Element) (interface{}, error) {
+	children := value.ChildElements()
+	if len(children) == 0 {
+		// No type means string
+		return value.Text(), nil
+	}
+	typed := children[0]
+	text := strings.TrimSpace(typed.Text())
+	switch typed.Tag {
+	case "int", "i4", "i8":
+		res, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer value '%s'", text)
+		}
+		return res, nil
+	case "boolean":
+		switch text {
+		case "1":
+			return true, nil
+		case "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean value '%s'", text)
+	case "string":
+		return typed.Text(), nil
+	case "double":
+		res, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double value '%s'", text)
+		}
+		return res, nil
+	case "dateTime.iso8601", "base64":
+		return text, nil
+	case "nil":
+		return nil, nil
+	case "array":
+		res := make([]interface{}, 0)
+		for _, item := range typed.FindElements("data/value") {
+			val, err := decodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, val)
+		}
+		return res, nil
+	case "struct":
+		res := make(map[string]interface{})
+		for _, member := range typed.SelectElements("member") {
+			name := member.SelectElement("name")
+			value := member.SelectElement("value")
+			if name == nil || value == nil {
+				return nil, fmt.Errorf("struct member must have a name and a value")
+			}
+			val, err := decodeValue(value)
+			if err != nil {
+				return nil, err
+			}
+			res[name.Text()] = val
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unknown value type '%s'", typed.Tag)
+}
+
+// EncodeMethodCall writes to w the XML-RPC methodCall of the
+// given method with the given parameters.
+func EncodeMethodCall(w io.Writer, method string, params ...interface{}) error {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0"`)
+	call := doc.CreateElement("methodCall")
+	call.CreateElement("methodName").SetText(method)
+	paramsElt := call.CreateElement("params")
+	for _, param := range params {
+		if err := encodeValue(paramsElt.CreateElement("param").CreateElement("value"), param); err != nil {
+			return err
+		}
+	}
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// EncodeResponse writes to w the XML-RPC methodResponse with the given value.
+func EncodeResponse(w io.Writer, value interface{}) error {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0"`)
+	param := doc.CreateElement("methodResponse").CreateElement("params").CreateElement("param")
+	if err := encodeValue(param.CreateElement("value"), value); err != nil {
+		return err
+	}
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// EncodeFault writes to w the XML-RPC methodResponse of the given fault.
+func EncodeFault(w io.Writer, fault Fault) error {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0"`)
+	value := doc.CreateElement("methodResponse").CreateElement("fault").CreateElement("value")
+	if err := encodeValue(value, map[string]interface{}{
+		"faultCode":   fault.Code,
+		"faultString": fault.String,
+	}); err != nil {
+		return err
+	}
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// encodeValue encodes the given value as children of the given value element
+func encodeValue(elt *etree.Element, value interface{}) error {
+	if value == nil {
+		elt.CreateElement("nil")
+		return nil
+	}
+	switch val := value.(type) {
+	case string:
+		elt.CreateElement("string").SetText(val)
+		return nil
+	case []byte:
+		elt.CreateElement("base64").SetText(base64.StdEncoding.EncodeToString(val))
+		return nil
+	case fmt.Stringer:
+		elt.CreateElement("string").SetText(val.String())
+		return nil
+	}
+	rVal := reflect.ValueOf(value)
+	switch rVal.Kind() {
+	case reflect.Bool:
+		if rVal.Bool() {
+			elt.CreateElement("boolean").SetText("1")
+		} else {
+			elt.CreateElement("boolean").SetText("0")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		elt.CreateElement("int").SetText(strconv.FormatInt(rVal.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		elt.CreateElement("int").SetText(strconv.FormatUint(rVal.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		elt.CreateElement("double").SetText(strconv.FormatFloat(rVal.Float(), 'f', -1, 64))
+	case reflect.String:
+		elt.CreateElement("string").SetText(rVal.String())
+	case reflect.Slice, reflect.Array:
+		data := elt.CreateElement("array").CreateElement("data")
+		for i := 0; i < rVal.Len(); i++ {
+			if err := encodeValue(data.CreateElement("value"), rVal.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if rVal.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unable to encode map with %s keys", rVal.Type().Key())
+		}
+		strct := elt.CreateElement("struct")
+		keys := rVal.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, key := range keys {
+			member := strct.CreateElement("member")
+			member.CreateElement("name").SetText(key.String())
+			if err := encodeValue(member.CreateElement("value"), rVal.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr, reflect.Interface:
+		if rVal.IsNil() {
+			elt.CreateElement("nil")
+			return nil
+		}
+		return encodeValue(elt, rVal.Elem().Interface())
+	default:
+		return fmt.Errorf("unable to encode value of type %T", value)
+	}
+	return nil
+}
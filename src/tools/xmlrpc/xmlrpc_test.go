@@ -0,0 +1,95 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package xmlrpc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type stringer struct{}
+
+func (s stringer) String() string {
+	return "2020-01-02"
+}
+
+func TestXMLRPC(t *testing.T) {
+	Convey("Testing XML-RPC messages", t, func() {
+		Convey("Decoding a method call", func() {
+			method, params, err := DecodeMethodCall(strings.NewReader(`<?xml version="1.0"?>
+<methodCall>
+	<methodName>execute_kw</methodName>
+	<params>
+		<param><value><string>db</string></value></param>
+		<param><value><int>2</int></value></param>
+		<param><value>secret</value></param>
+		<param><value><array><data>
+			<value><array><data>
+				<value><array><data>
+					<value><string>is_company</string></value>
+					<value><string>=</string></value>
+					<value><boolean>1</boolean></value>
+				</data></array></value>
+			</data></array></value>
+		</data></array></value></param>
+		<param><value><struct>
+			<member><name>limit</name><value><i4>5</i4></value></member>
+			<member><name>ratio</name><value><double>1.5</double></value></member>
+			<member><name>context</name><value><nil/></value></member>
+		</struct></value></param>
+	</params>
+</methodCall>`))
+			So(err, ShouldBeNil)
+			So(method, ShouldEqual, "execute_kw")
+			So(params, ShouldHaveLength, 5)
+			So(params[0], ShouldEqual, "db")
+			So(params[1], ShouldEqual, int64(2))
+			So(params[2], ShouldEqual, "secret")
+			So(params[3], ShouldResemble, []interface{}{[]interface{}{[]interface{}{"is_company", "=", true}}})
+			So(params[4], ShouldResemble, map[string]interface{}{"limit": int64(5), "ratio": 1.5, "context": nil})
+		})
+		Convey("Decoding invalid method calls", func() {
+			_, _, err := DecodeMethodCall(strings.NewReader(`<methodCall><params/></methodCall>`))
+			So(err, ShouldNotBeNil)
+			_, _, err = DecodeMethodCall(strings.NewReader(`<methodCall><methodName>foo</methodName><params>
+<param><value><int>abc</int></value></param></params></methodCall>`))
+			So(err, ShouldNotBeNil)
+			_, _, err = DecodeMethodCall(strings.NewReader(`<methodCall><methodName>foo</methodName><params>
+<param><value><float>1.2</float></value></param></params></methodCall>`))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Encoding and decoding a response", func() {
+			var buf bytes.Buffer
+			So(EncodeResponse(&buf, []map[string]interface{}{
+				{"id": 1, "name": "John & Co", "active": true, "date": stringer{}, "rate": float32(0.5), "partner_id": false},
+				{"id": int64(2), "tags": []int64{1, 2}, "parent": nil},
+			}), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "<string>John &amp; Co</string>")
+			res, err := DecodeMethodResponse(&buf)
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, []interface{}{
+				map[string]interface{}{"id": int64(1), "name": "John & Co", "active": true, "date": "2020-01-02", "rate": 0.5, "partner_id": false},
+				map[string]interface{}{"id": int64(2), "tags": []interface{}{int64(1), int64(2)}, "parent": nil},
+			})
+			So(EncodeResponse(&buf, map[int]string{1: "foo"}), ShouldNotBeNil)
+		})
+		Convey("Encoding and decoding a method call", func() {
+			var buf bytes.Buffer
+			So(EncodeMethodCall(&buf, "authenticate", "db", "admin", "admin", map[string]interface{}{}), ShouldBeNil)
+			method, params, err := DecodeMethodCall(&buf)
+			So(err, ShouldBeNil)
+			So(method, ShouldEqual, "authenticate")
+			So(params, ShouldResemble, []interface{}{"db", "admin", "admin", map[string]interface{}{}})
+		})
+		Convey("Encoding and decoding a fault", func() {
+			var buf bytes.Buffer
+			So(EncodeFault(&buf, Fault{Code: 3, String: "Access Denied"}), ShouldBeNil)
+			_, err := DecodeMethodResponse(&buf)
+			So(err, ShouldResemble, Fault{Code: 3, String: "Access Denied"})
+		})
+	})
+}
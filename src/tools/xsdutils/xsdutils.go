@@ -0,0 +1,457 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package xsdutils writes, reads and validates documents against simple XML
+// Schema Definitions. Only the subset of XSD used by Hexya is supported:
+//
+// - global elements with a named type, or referring to a global element,
+//
+// - XSD 1.1 type alternatives of elements with tests of the form @attr='value',
+//
+// - named complex types with attributes and a choice of child elements
+// or any lax child elements,
+//
+// - named simple types with enumerations or unions of other simple types.
+//
+// Child elements are checked by name, but their order and number are not checked.
+package xsdutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/beevik/etree"
+)
+
+// A Schema is a simple XML Schema Definition
+type Schema struct {
+	Elements     []Element
+	ComplexTypes []ComplexType
+	SimpleTypes  []SimpleType
+}
+
+// An Element is an element declaration. Either Name and Type
+// or Ref must be set.
+type Element struct {
+	Name string
+	Type string
+	Ref  string
+	// Alternatives are the types to use instead of Type when their
+	// test matches the element. The first matching one is used.
+	Alternatives []Alternative
+}
+
+// An Alternative is an XSD 1.1 conditional type assignment. Test must be
+// of the form @attr='value'. Alternatives are ignored by XSD 1.0 processors.
+type Alternative struct {
+	Test string
+	Type string
+}
+
+// alternativeTestRegexp matches the supported alternative tests
+var alternativeTestRegexp = regexp.MustCompile(`^@([\w:.-]+)\s*=\s*'([^']*)'$`)
+
+// An Attribute is an attribute declaration
+type Attribute struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+// A ComplexType is a named type for elements with attributes and
+// child elements.
+type ComplexType struct {
+	Name string
+	// Mixed is true if the element can have text content
+	Mixed bool
+	// Elements are the allowed child elements
+	Elements []Element
+	// AnyElement is true if any child element is allowed. Child
+	// elements are validated only if they are declared globally.
+	AnyElement bool
+	// Attributes are the declared attributes
+	Attributes []Attribute
+	// AnyAttribute is true if undeclared attributes are allowed.
+	AnyAttribute bool
+}
+
+// A SimpleType is a named type for attribute values or text-only
+// elements. It is either an enumeration of Values or the union of
+// the MemberTypes.
+type SimpleType struct {
+	Name        string
+	Values      []string
+	MemberTypes []string
+}
+
+// Write writes this Schema as an XSD document to w.
+func (s *Schema) Write(w io.Writer) error {
+	return schemaTemplate.Execute(w, s)
+}
+
+// Bytes returns this Schema as an XSD document.
+func (s *Schema) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	err := s.Write(&buf)
+	return buf.Bytes(), err
+}
+
+// ReadSchema reads a Schema from the given XSD document
+func ReadSchema(doc *etree.Document) (*Schema, error) {
+	root := doc.Root()
+	if root == nil || root.Tag != "schema" {
+		return nil, fmt.Errorf("document is not an XML schema")
+	}
+	var s Schema
+	for _, child := range root.ChildElements() {
+		switch child.Tag {
+		case "element":
+			s.Elements = append(s.Elements, readElement(child))
+		case "complexType":
+			s.ComplexTypes = append(s.ComplexTypes, readComplexType(child))
+		case "simpleType":
+			s.SimpleTypes = append(s.SimpleTypes, readSimpleType(child))
+		default:
+			return nil, fmt.Errorf("unsupported XSD tag '%s'", child.Tag)
+		}
+	}
+	return &s, nil
+}
+
+// ReadSchemaFile reads a Schema from the given XSD file
+func ReadSchemaFile(fileName string) (*Schema, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(fileName); err != nil {
+		return nil, err
+	}
+	return ReadSchema(doc)
+}
+
+// readElement returns the Element declared by the given xs:element tag
+func readElement(elt *etree.Element) Element {
+	res := Element{
+		Name: elt.SelectAttrValue("name", ""),
+		Type: elt.SelectAttrValue("type", ""),
+		Ref:  elt.SelectAttrValue("ref", ""),
+	}
+	for _, alt := range elt.SelectElements("alternative") {
+		res.Alternatives = append(res.Alternatives, Alternative{
+			Test: alt.SelectAttrValue("test", ""),
+			Type: alt.SelectAttrValue("type", ""),
+		})
+	}
+	return res
+}
+
+// readComplexType returns the ComplexType declared by the given xs:complexType tag
+func readComplexType(elt *etree.Element) ComplexType {
+	ct := ComplexType{
+		Name:  elt.SelectAttrValue("name", ""),
+		Mixed: elt.SelectAttrValue("mixed", "false") == "true",
+	}
+	for _, child := range elt.ChildElements() {
+		switch child.Tag {
+		case "attribute":
+			ct.Attributes = append(ct.Attributes, Attribute{
+				Name:     child.SelectAttrValue("name", ""),
+				Type:     child.SelectAttrValue("type", ""),
+				Required: child.SelectAttrValue("use", "") == "required",
+			})
+		case "anyAttribute":
+			ct.AnyAttribute = true
+		case "choice", "sequence":
+			for _, decl := range child.ChildElements() {
+				switch decl.Tag {
+				case "element":
+					ct.Elements = append(ct.Elements, readElement(decl))
+				case "any":
+					ct.AnyElement = true
+				}
+			}
+		}
+	}
+	return ct
+}
+
+// readSimpleType returns the SimpleType declared by the given xs:simpleType tag
+func readSimpleType(elt *etree.Element) SimpleType {
+	st := SimpleType{
+		Name: elt.SelectAttrValue("name", ""),
+	}
+	if union := elt.SelectElement("union"); union != nil {
+		st.MemberTypes = strings.Fields(union.SelectAttrValue("memberTypes", ""))
+	}
+	if restriction := elt.SelectElement("restriction"); restriction != nil {
+		for _, enum := range restriction.SelectElements("enumeration") {
+			st.Values = append(st.Values, enum.SelectAttrValue("value", ""))
+		}
+	}
+	return st
+}
+
+// Validate checks the given document against this Schema and
+// returns all the errors found.
+func (s *Schema) Validate(doc *etree.Document) []error {
+	root := doc.Root()
+	if root == nil {
+		return []error{fmt.Errorf("empty document")}
+	}
+	decl, ok := s.globalElement(root.Tag)
+	if !ok {
+		return []error{fmt.Errorf("%s: unknown root element", root.GetPath())}
+	}
+	var errs []error
+	s.validateElement(root, decl, &errs)
+	return errs
+}
+
+// globalElement returns the global declaration of the element with the given name
+func (s *Schema) globalElement(name string) (Element, bool) {
+	for _, elt := range s.Elements {
+		if elt.Name == name {
+			return elt, true
+		}
+	}
+	return Element{}, false
+}
+
+// complexType returns the complex type with the given name
+func (s *Schema) complexType(name string) (ComplexType, bool) {
+	for _, ct := range s.ComplexTypes {
+		if ct.Name == name {
+			return ct, true
+		}
+	}
+	return ComplexType{}, false
+}
+
+// simpleType returns the simple type with the given name
+func (s *Schema) simpleType(name string) (SimpleType, bool) {
+	for _, st := range s.SimpleTypes {
+		if st.Name == name {
+			return st, true
+		}
+	}
+	return SimpleType{}, false
+}
+
+// validateElement validates elt against the given declaration
+// and appends errors to errs.
+func (s *Schema) validateElement(elt *etree.Element, decl Element, errs *[]error) {
+	if ref := decl.Ref; ref != "" {
+		var ok bool
+		decl, ok = s.globalElement(ref)
+		if !ok {
+			*errs = append(*errs, fmt.Errorf("%s: undeclared referenced element '%s'", elt.GetPath(), ref))
+			return
+		}
+	}
+	typeName := decl.Type
+	for _, alt := range decl.Alternatives {
+		if alt.matches(elt) {
+			typeName = alt.Type
+			break
+		}
+	}
+	ct, ok := s.complexType(typeName)
+	if !ok {
+		// Simple content
+		if len(elt.ChildElements()) > 0 {
+			*errs = append(*errs, fmt.Errorf("%s: unexpected child elements", elt.GetPath()))
+		}
+		if err := s.checkValue(typeName, strings.TrimSpace(elt.Text())); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %s", elt.GetPath(), err))
+		}
+		return
+	}
+	s.validateAttributes(elt, ct, errs)
+	if !ct.Mixed {
+		for _, token := range elt.Child {
+			if cd, ok := token.(*etree.CharData); ok && strings.TrimSpace(cd.Data) != "" {
+				*errs = append(*errs, fmt.Errorf("%s: unexpected text content", elt.GetPath()))
+				break
+			}
+		}
+	}
+	for _, child := range elt.ChildElements() {
+		childDecl, ok := ct.childElement(child.Tag)
+		switch {
+		case ok:
+			s.validateElement(child, childDecl, errs)
+		case ct.AnyElement:
+			s.validateLax(child, errs)
+		default:
+			*errs = append(*errs, fmt.Errorf("%s: unexpected element '%s'", elt.GetPath(), child.Tag))
+		}
+	}
+}
+
+// validateLax validates elt if it is declared globally, or its descendants otherwise.
+func (s *Schema) validateLax(elt *etree.Element, errs *[]error) {
+	if decl, ok := s.globalElement(elt.Tag); ok {
+		s.validateElement(elt, decl, errs)
+		return
+	}
+	for _, child := range elt.ChildElements() {
+		s.validateLax(child, errs)
+	}
+}
+
+// validateAttributes checks the attributes of elt against the given ComplexType
+func (s *Schema) validateAttributes(elt *etree.Element, ct ComplexType, errs *[]error) {
+	for _, attrDecl := range ct.Attributes {
+		if attrDecl.Required && elt.SelectAttr(attrDecl.Name) == nil {
+			*errs = append(*errs, fmt.Errorf("%s: missing attribute '%s'", elt.GetPath(), attrDecl.Name))
+		}
+	}
+	for _, attr := range elt.Attr {
+		if attr.Space == "xmlns" || attr.Key == "xmlns" {
+			continue
+		}
+		attrDecl, ok := ct.attribute(attr.Key)
+		if !ok {
+			if !ct.AnyAttribute {
+				*errs = append(*errs, fmt.Errorf("%s: unexpected attribute '%s'", elt.GetPath(), attr.Key))
+			}
+			continue
+		}
+		if err := s.checkValue(attrDecl.Type, attr.Value); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: attribute '%s': %s", elt.GetPath(), attr.Key, err))
+		}
+	}
+}
+
+// checkValue returns an error if value is not valid for the given simple type
+func (s *Schema) checkValue(typeName, value string) error {
+	var err error
+	switch typeName {
+	case "", "xs:string", "xs:anySimpleType":
+		return nil
+	case "xs:boolean":
+		switch value {
+		case "true", "false", "1", "0":
+			return nil
+		}
+		return fmt.Errorf("'%s' is not a valid boolean", value)
+	case "xs:integer":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "xs:unsignedByte":
+		_, err = strconv.ParseUint(value, 10, 8)
+	default:
+		st, ok := s.simpleType(typeName)
+		if !ok {
+			return fmt.Errorf("unknown type '%s'", typeName)
+		}
+		return s.checkSimpleTypeValue(st, value)
+	}
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid %s", value, strings.TrimPrefix(typeName, "xs:"))
+	}
+	return nil
+}
+
+// checkSimpleTypeValue returns an error if value is not valid for st
+func (s *Schema) checkSimpleTypeValue(st SimpleType, value string) error {
+	for _, member := range st.MemberTypes {
+		if s.checkValue(member, value) == nil {
+			return nil
+		}
+	}
+	for _, v := range st.Values {
+		if v == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is not a valid %s", value, st.Name)
+}
+
+// matches returns true if the test of this Alternative matches elt.
+// Unsupported tests never match.
+func (a Alternative) matches(elt *etree.Element) bool {
+	toks := alternativeTestRegexp.FindStringSubmatch(a.Test)
+	if toks == nil {
+		return false
+	}
+	attr := elt.SelectAttr(toks[1])
+	return attr != nil && attr.Value == toks[2]
+}
+
+// childElement returns the declaration of the child element with the given name
+func (ct ComplexType) childElement(name string) (Element, bool) {
+	for _, elt := range ct.Elements {
+		if elt.Name == name || elt.Ref == name {
+			return elt, true
+		}
+	}
+	return Element{}, false
+}
+
+// attribute returns the declaration of the attribute with the given name
+func (ct ComplexType) attribute(name string) (Attribute, bool) {
+	for _, attr := range ct.Attributes {
+		if attr.Name == name {
+			return attr, true
+		}
+	}
+	return Attribute{}, false
+}
+
+var schemaTemplate = template.Must(template.New("").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!-- This file is autogenerated by hexya-generate -->
+<!-- DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN -->
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:vc="http://www.w3.org/2007/XMLSchema-versioning" elementFormDefault="qualified">
+{{- range .Elements }}
+	<xs:element {{ if .Ref }}ref="{{ .Ref }}"{{ else }}name="{{ .Name }}" type="{{ .Type }}"{{ end }}{{ if .Alternatives }}>
+{{- range .Alternatives }}
+		<xs:alternative test="{{ html .Test }}" type="{{ .Type }}" vc:minVersion="1.1"/>
+{{- end }}
+	</xs:element>
+{{- else }}/>
+{{- end }}
+{{- end }}
+{{- range .ComplexTypes }}
+	<xs:complexType name="{{ .Name }}"{{ if .Mixed }} mixed="true"{{ end }}>
+{{- if or .Elements .AnyElement }}
+		<xs:choice minOccurs="0" maxOccurs="unbounded">
+{{- range .Elements }}
+			<xs:element {{ if .Ref }}ref="{{ .Ref }}"{{ else }}name="{{ .Name }}" type="{{ .Type }}"{{ end }}{{ if .Alternatives }}>
+{{- range .Alternatives }}
+				<xs:alternative test="{{ html .Test }}" type="{{ .Type }}" vc:minVersion="1.1"/>
+{{- end }}
+			</xs:element>
+{{- else }}/>
+{{- end }}
+{{- end }}
+{{- if .AnyElement }}
+			<xs:any processContents="lax"/>
+{{- end }}
+		</xs:choice>
+{{- end }}
+{{- range .Attributes }}
+		<xs:attribute name="{{ .Name }}" type="{{ .Type }}"{{ if .Required }} use="required"{{ end }}/>
+{{- end }}
+{{- if .AnyAttribute }}
+		<xs:anyAttribute processContents="skip"/>
+{{- end }}
+	</xs:complexType>
+{{- end }}
+{{- range .SimpleTypes }}
+	<xs:simpleType name="{{ .Name }}">
+{{- if .MemberTypes }}
+		<xs:union memberTypes="{{ range $i, $m := .MemberTypes }}{{ if $i }} {{ end }}{{ $m }}{{ end }}"/>
+{{- else }}
+		<xs:restriction base="xs:string">
+{{- range .Values }}
+			<xs:enumeration value="{{ html . }}"/>
+{{- end }}
+		</xs:restriction>
+{{- end }}
+	</xs:simpleType>
+{{- end }}
+</xs:schema>
+`))
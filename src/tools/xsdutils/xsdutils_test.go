@@ -0,0 +1,138 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package xsdutils
+
+import (
+	"testing"
+
+	"github.com/beevik/etree"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testSchema = Schema{
+	Elements: []Element{
+		{Name: "hexya", Type: "Hexya"},
+		{Name: "field", Type: "Field"},
+	},
+	ComplexTypes: []ComplexType{
+		{Name: "Hexya", Elements: []Element{
+			{Name: "view", Type: "View"},
+			{Name: "priority", Type: "xs:unsignedByte"},
+			{Name: "record", Type: "Record", Alternatives: []Alternative{{Test: "@model='User'", Type: "UserRecord"}}},
+		}},
+		{Name: "View", Mixed: true, AnyElement: true, Attributes: []Attribute{
+			{Name: "id", Type: "xs:string", Required: true},
+			{Name: "model", Type: "ModelName"},
+			{Name: "active", Type: "xs:boolean"},
+		}},
+		{Name: "Field", AnyAttribute: true, Attributes: []Attribute{
+			{Name: "name", Type: "FieldName", Required: true},
+		}},
+		{Name: "Record", Elements: []Element{{Ref: "field"}}, Attributes: []Attribute{
+			{Name: "model", Type: "ModelName", Required: true},
+		}},
+		{Name: "UserRecord", Elements: []Element{{Name: "field", Type: "UserField"}}, Attributes: []Attribute{
+			{Name: "model", Type: "ModelName"},
+		}},
+		{Name: "UserField", AnyAttribute: true, Attributes: []Attribute{
+			{Name: "name", Type: "UserFieldName", Required: true},
+		}},
+	},
+	SimpleTypes: []SimpleType{
+		{Name: "ModelName", Values: []string{"User", "Post"}},
+		{Name: "UserFieldName", Values: []string{"Name", "Email"}},
+		{Name: "PostFieldName", Values: []string{"Title"}},
+		{Name: "FieldName", MemberTypes: []string{"UserFieldName", "PostFieldName"}},
+	},
+}
+
+func validate(s *Schema, xml string) []error {
+	doc := etree.NewDocument()
+	So(doc.ReadFromString(xml), ShouldBeNil)
+	return s.Validate(doc)
+}
+
+func TestXSD(t *testing.T) {
+	Convey("Testing XSD schemas", t, func() {
+		data, err := testSchema.Bytes()
+		So(err, ShouldBeNil)
+		Convey("Writing schema", func() {
+			xsd := string(data)
+			So(xsd, ShouldContainSubstring, `<xs:element name="hexya" type="Hexya"/>`)
+			So(xsd, ShouldContainSubstring, `<xs:complexType name="View" mixed="true">`)
+			So(xsd, ShouldContainSubstring, `<xs:any processContents="lax"/>`)
+			So(xsd, ShouldContainSubstring, `<xs:attribute name="id" type="xs:string" use="required"/>`)
+			So(xsd, ShouldContainSubstring, `<xs:enumeration value="Post"/>`)
+			So(xsd, ShouldContainSubstring, `<xs:union memberTypes="UserFieldName PostFieldName"/>`)
+			So(xsd, ShouldContainSubstring, `<xs:element name="record" type="Record">
+				<xs:alternative test="@model=&#39;User&#39;" type="UserRecord" vc:minVersion="1.1"/>
+			</xs:element>`)
+		})
+		Convey("Reading back schema", func() {
+			doc := etree.NewDocument()
+			So(doc.ReadFromBytes(data), ShouldBeNil)
+			schema, err := ReadSchema(doc)
+			So(err, ShouldBeNil)
+			So(*schema, ShouldResemble, testSchema)
+		})
+		Convey("Validating documents", func() {
+			So(validate(&testSchema, `
+<hexya>
+	<view id="my_view" model="User" active="true">
+		<form>
+			Some text
+			<field name="Name" string="Name"/>
+		</form>
+	</view>
+	<priority>12</priority>
+</hexya>`), ShouldBeEmpty)
+			errs := validate(&testSchema, `
+<hexya>
+	Text
+	<view model="Partner" active="yes" priority="3">
+		<form>
+			<field name="Title"/>
+			<field name="Age"/>
+		</form>
+	</view>
+	<priority>300</priority>
+	<action/>
+</hexya>`)
+			So(errs, ShouldHaveLength, 8)
+			So(errs[0].Error(), ShouldEqual, "/hexya: unexpected text content")
+			So(errs[1].Error(), ShouldEqual, "/hexya/view: missing attribute 'id'")
+			So(errs[2].Error(), ShouldEqual, "/hexya/view: attribute 'model': 'Partner' is not a valid ModelName")
+			So(errs[3].Error(), ShouldEqual, "/hexya/view: attribute 'active': 'yes' is not a valid boolean")
+			So(errs[4].Error(), ShouldEqual, "/hexya/view: unexpected attribute 'priority'")
+			So(errs[5].Error(), ShouldEqual, "/hexya/view/form/field: attribute 'name': 'Age' is not a valid FieldName")
+			So(errs[6].Error(), ShouldEqual, "/hexya/priority: '300' is not a valid unsignedByte")
+			So(errs[7].Error(), ShouldEqual, "/hexya: unexpected element 'action'")
+			So(validate(&testSchema, `<view id="foo"/>`)[0].Error(), ShouldEqual, "/view: unknown root element")
+		})
+		Convey("Validating documents with type alternatives", func() {
+			So(validate(&testSchema, `
+<hexya>
+	<record model="User">
+		<field name="Email"/>
+	</record>
+	<record model="Post">
+		<field name="Email"/>
+		<field name="Title"/>
+	</record>
+</hexya>`), ShouldBeEmpty)
+			errs := validate(&testSchema, `
+<hexya>
+	<record model="User">
+		<field name="Title"/>
+	</record>
+	<record>
+		<field name="Title"/>
+	</record>
+</hexya>`)
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Error(), ShouldEqual, "/hexya/record/field: attribute 'name': 'Title' is not a valid UserFieldName")
+			So(errs[1].Error(), ShouldEqual, "/hexya/record: missing attribute 'model'")
+		})
+	})
+}
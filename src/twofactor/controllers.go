@@ -0,0 +1,164 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package twofactor
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/totp"
+)
+
+// qrCodeSize is the size in pixels of the QR codes returned by enroll
+const qrCodeSize = 256
+
+var errNotAuthenticated = errors.New("authentication required")
+
+// A codeRequest is the body of requests which give a TOTP or recovery code
+type codeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// verify is the controller that verifies the second factor of a pending
+// session and establishes the session if it is valid.
+func verify(c *server.Context) {
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	uid, err := c.VerifySecondFactor(req.Code)
+	if err != nil {
+		c.AbortWithJSONError(c.AuthenticationFailureStatus(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"uid": uid})
+}
+
+// enroll is the controller that generates a new TOTP secret for the user
+// and returns it with its otpauth URI and QR code as a PNG data URI.
+func enroll(c *server.Context) {
+	uid := enrollmentUID(c)
+	if uid == 0 {
+		c.AbortWithJSONError(http.StatusUnauthorized, errNotAuthenticated)
+		return
+	}
+	account, _ := c.Session().Get("login").(string)
+	if account == "" {
+		account = fmt.Sprintf("%d", uid)
+	}
+	secret, uri, err := Enroll(c.Request.Context(), uid, account)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	png, err := totp.QRCode(uri, qrCodeSize)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":  secret,
+		"uri":     uri,
+		"qr_code": "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// confirm is the controller that confirms the enrollment of the user with
+// a first code and returns the recovery codes. If the session of the user
+// is pending, it is established.
+func confirm(c *server.Context) {
+	uid := enrollmentUID(c)
+	if uid == 0 {
+		c.AbortWithJSONError(http.StatusUnauthorized, errNotAuthenticated)
+		return
+	}
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	codes, err := Confirm(c.Request.Context(), uid, req.Code)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	if c.PendingUID() != 0 {
+		if err = c.CompletePendingLogIn(); err != nil {
+			c.AbortWithJSONError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// recoveryCodes is the controller that replaces the recovery
+// codes of the user of the session and returns them.
+func recoveryCodes(c *server.Context) {
+	uid := sessionUID(c)
+	if uid == 0 {
+		c.AbortWithJSONError(http.StatusUnauthorized, errNotAuthenticated)
+		return
+	}
+	codes, err := RegenerateRecoveryCodes(c.Request.Context(), uid)
+	if err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// disable is the controller that removes the second factor of the user of
+// the session, after checking a last code. The second factor cannot be
+// removed if it is required by the groups of the user.
+func disable(c *server.Context) {
+	uid := sessionUID(c)
+	if uid == 0 {
+		c.AbortWithJSONError(http.StatusUnauthorized, errNotAuthenticated)
+		return
+	}
+	var req codeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithJSONError(http.StatusBadRequest, err)
+		return
+	}
+	if security.Registry.SecondFactorRequiredContext(c.Request.Context(), uid) {
+		c.AbortWithJSONError(http.StatusForbidden, errors.New("a second factor is required for your groups"))
+		return
+	}
+	if !Verify(c.Request.Context(), uid, req.Code) {
+		c.AbortWithJSONError(http.StatusUnauthorized, ErrInvalidCode)
+		return
+	}
+	if err := Disable(c.Request.Context(), uid); err != nil {
+		c.AbortWithJSONError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// sessionUID returns the uid of the established session, or 0 if there is none
+func sessionUID(c *server.Context) int64 {
+	uid, _ := c.Session().Get("uid").(int64)
+	return uid
+}
+
+// enrollmentUID returns the uid of the user who can enroll a second factor
+// with this request, that is the user of the established session, or the
+// user of the pending session who has not enrolled a second factor yet.
+// It returns 0 if there is no such user.
+func enrollmentUID(c *server.Context) int64 {
+	if uid := sessionUID(c); uid != 0 {
+		return uid
+	}
+	if uid := c.PendingUID(); uid != 0 && !Enrolled(c.Request.Context(), uid) {
+		return uid
+	}
+	return 0
+}
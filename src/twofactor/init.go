@@ -0,0 +1,76 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package twofactor implements two-factor authentication with time-based one-time
+passwords (TOTP) as defined by RFC 6238.
+
+Users enroll a secret in their authenticator application, usually by scanning a
+QR code, and confirm the enrollment with a first code. They are then given
+recovery codes that can each be used once instead of a TOTP code.
+
+Once enrolled, users are authenticated by the authentication registry only after
+their second factor is verified. Groups can require their members to enroll a
+second factor with security.Group.RequireSecondFactor.
+
+BootStrap adds the following endpoints for the web client, under /auth/2fa:
+
+	POST /verify          Verify the code of a pending session
+	POST /enroll          Get a new secret, its otpauth URI and its QR code
+	POST /confirm         Confirm the enrollment and get recovery codes
+	POST /recovery_codes  Regenerate the recovery codes
+	POST /disable         Remove the second factor of the user
+*/
+package twofactor
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// Path is the path of the two-factor authentication endpoints
+const Path = "/auth/2fa"
+
+var (
+	log          logging.Logger
+	bootstrapped bool
+)
+
+// BootStrap adds the two-factor authentication controllers to the
+// controllers registry.
+//
+// It must be called after the models have been bootstrapped and
+// before controllers.BootStrap.
+func BootStrap() {
+	if bootstrapped {
+		log.Panic("Two-factor authentication is already bootstrapped")
+	}
+	if !models.BootStrapped() {
+		log.Panic("Models must be bootstrapped before two-factor authentication")
+	}
+	grp := controllers.Registry.AddGroup(Path)
+	grp.AddController(http.MethodPost, "/verify", verify)
+	grp.AddController(http.MethodPost, "/enroll", enroll)
+	grp.AddController(http.MethodPost, "/confirm", confirm)
+	grp.AddController(http.MethodPost, "/recovery_codes", recoveryCodes)
+	grp.AddController(http.MethodPost, "/disable", disable)
+	bootstrapped = true
+}
+
+func init() {
+	log = logging.GetLogger("twofactor")
+	totpSecret := models.NewModel(ModelName)
+	totpSecret.AddFields(map[string]models.FieldDefinition{
+		"UserID":        fields.Integer{String: "User ID", Required: true, Unique: true, Index: true},
+		"Secret":        fields.Char{Required: true, NoCopy: true},
+		"Confirmed":     fields.Boolean{Help: "Set when the user has verified a first code of the secret"},
+		"LastCounter":   fields.Integer{NoCopy: true, Help: "Time step of the last accepted code, so that codes cannot be used twice"},
+		"RecoveryCodes": fields.Text{NoCopy: true, Help: "Hashes of the unused recovery codes, one per line"},
+	})
+	security.AuthenticationRegistry.SetSecondFactorBackend(backend{})
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package twofactor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/password"
+	"github.com/hexya-erp/hexya/src/tools/totp"
+)
+
+const (
+	// ModelName is the name of the model in which TOTP secrets are stored
+	ModelName = "TOTPSecret"
+	// recoveryCodeLen is the number of random bytes of a recovery code
+	recoveryCodeLen = 5
+)
+
+var (
+	// Issuer is the name of the application shown in authenticator applications
+	Issuer = "Hexya"
+	// Window is the number of time steps before and after the current one
+	// during which a code is accepted, to allow for clock drift.
+	Window = 1
+	// RecoveryCodesNumber is the number of recovery codes given to users
+	RecoveryCodesNumber = 10
+)
+
+var (
+	// ErrAlreadyEnrolled is returned when enrolling a user who already has a second factor
+	ErrAlreadyEnrolled = errors.New("a second factor is already enrolled for this user")
+	// ErrNotEnrolled is returned when the user has no second factor
+	ErrNotEnrolled = errors.New("no second factor is enrolled for this user")
+	// ErrInvalidCode is returned when a code is not valid
+	ErrInvalidCode = errors.New("invalid code")
+)
+
+// backend is the security.SecondFactorBackend of this package
+type backend struct{}
+
+// Enrolled returns true if the user with the given uid has enrolled a second factor
+func (b backend) Enrolled(ctx context.Context, uid int64) bool {
+	return Enrolled(ctx, uid)
+}
+
+// Verify returns true if code is a valid TOTP or recovery code for the user with the given uid
+func (b backend) Verify(ctx context.Context, uid int64, code string) bool {
+	return Verify(ctx, uid, code)
+}
+
+var _ security.SecondFactorBackend = backend{}
+
+// Enroll generates a new TOTP secret for the user with the given uid and
+// returns it with its otpauth URI for the given account name. The secret
+// must then be confirmed with Confirm before it is used for authentication.
+//
+// Enrolling a user which has an unconfirmed secret replaces this secret.
+// It returns ErrAlreadyEnrolled if the user has a confirmed secret.
+func Enroll(ctx context.Context, uid int64, account string) (string, string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	var enrolled bool
+	err = models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		rec := findSecret(env, uid)
+		if rec.IsNotEmpty() && rec.Get(rec.Model().FieldName("Confirmed")).(bool) {
+			enrolled = true
+			return
+		}
+		rec.Call("Unlink")
+		rc := env.Pool(ModelName)
+		rc.Call("Create", models.NewModelData(rc.Model()).
+			Set(rc.Model().FieldName("UserID"), uid).
+			Set(rc.Model().FieldName("Secret"), secret))
+	})
+	switch {
+	case err != nil:
+		return "", "", err
+	case enrolled:
+		return "", "", ErrAlreadyEnrolled
+	}
+	return secret, totp.URI(Issuer, account, secret), nil
+}
+
+// Confirm checks the given code against the unconfirmed secret of the user
+// with the given uid. If it is valid, the secret is used from now on to
+// authenticate the user and the recovery codes of the user are returned.
+func Confirm(ctx context.Context, uid int64, code string) ([]string, error) {
+	var (
+		codes []string
+		cErr  error
+	)
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		rec := findSecret(env, uid)
+		switch {
+		case rec.IsEmpty():
+			cErr = ErrNotEnrolled
+			return
+		case rec.Get(rec.Model().FieldName("Confirmed")).(bool):
+			cErr = ErrAlreadyEnrolled
+			return
+		}
+		counter, ok := totp.Verify(rec.Get(rec.Model().FieldName("Secret")).(string), code, time.Now(), Window)
+		if !ok {
+			cErr = ErrInvalidCode
+			return
+		}
+		rec.Set(rec.Model().FieldName("Confirmed"), true)
+		rec.Set(rec.Model().FieldName("LastCounter"), counter)
+		codes = setRecoveryCodes(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, cErr
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user
+// with the given uid by new ones and returns them.
+func RegenerateRecoveryCodes(ctx context.Context, uid int64) ([]string, error) {
+	var codes []string
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		rec := findConfirmedSecret(env, uid)
+		if rec.IsEmpty() {
+			return
+		}
+		codes = setRecoveryCodes(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if codes == nil {
+		return nil, ErrNotEnrolled
+	}
+	return codes, nil
+}
+
+// Disable removes the second factor of the user with the given uid
+func Disable(ctx context.Context, uid int64) error {
+	return models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		findSecret(env, uid).Call("Unlink")
+	})
+}
+
+// Enrolled returns true if the user with the given uid has a confirmed TOTP secret.
+//
+// It also returns true if the secrets cannot be read, so that users
+// are never authenticated without their second factor.
+func Enrolled(ctx context.Context, uid int64) bool {
+	var res bool
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		res = findConfirmedSecret(env, uid).IsNotEmpty()
+	})
+	if err != nil {
+		log.Warn("Unable to read TOTP secret", "uid", uid, "error", err)
+		return true
+	}
+	return res
+}
+
+// Verify returns true if the given code is a valid TOTP code or an unused
+// recovery code of the user with the given uid. A TOTP code cannot be used
+// twice and a recovery code is removed once used.
+func Verify(ctx context.Context, uid int64, code string) bool {
+	var res bool
+	err := models.ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env models.Environment) {
+		// The transaction may be retried after a serialization error,
+		// in which case the result of the previous attempt is void.
+		res = false
+		rec := findConfirmedSecret(env, uid)
+		if rec.IsEmpty() {
+			return
+		}
+		if len(code) == totp.Digits {
+			counter, ok := totp.Verify(rec.Get(rec.Model().FieldName("Secret")).(string), code, time.Now(), Window)
+			if ok && counter > rec.Get(rec.Model().FieldName("LastCounter")).(int64) {
+				rec.Set(rec.Model().FieldName("LastCounter"), counter)
+				res = true
+			}
+			return
+		}
+		res = useRecoveryCode(rec, code)
+	})
+	if err != nil {
+		log.Warn("Unable to verify second factor", "uid", uid, "error", err)
+		return false
+	}
+	return res
+}
+
+// findSecret returns the TOTP secret record of the user with the given uid
+func findSecret(env models.Environment, uid int64) *models.RecordCollection {
+	model := models.Registry.MustGet(ModelName)
+	return env.Pool(ModelName).Search(model.Field(model.FieldName("UserID")).Equals(uid)).Fetch()
+}
+
+// findConfirmedSecret returns the TOTP secret record of the user with
+// the given uid if it is confirmed and an empty RecordCollection otherwise.
+func findConfirmedSecret(env models.Environment, uid int64) *models.RecordCollection {
+	model := models.Registry.MustGet(ModelName)
+	return env.Pool(ModelName).Search(model.Field(model.FieldName("UserID")).Equals(uid).
+		And().Field(model.FieldName("Confirmed")).Equals(true)).Fetch()
+}
+
+// setRecoveryCodes generates new recovery codes, stores
+// their hashes in rec and returns them.
+func setRecoveryCodes(rec *models.RecordCollection) []string {
+	codes := make([]string, RecoveryCodesNumber)
+	hashes := make([]string, RecoveryCodesNumber)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			log.Panic("Unable to generate recovery code", "error", err)
+		}
+		codes[i] = code
+		hashes[i], err = password.Hash(normalizeRecoveryCode(code))
+		if err != nil {
+			log.Panic("Unable to hash recovery code", "error", err)
+		}
+	}
+	rec.Set(rec.Model().FieldName("RecoveryCodes"), strings.Join(hashes, "\n"))
+	return codes
+}
+
+// useRecoveryCode returns true if the given code is one of the
+// recovery codes of rec, in which case it is removed from rec.
+func useRecoveryCode(rec *models.RecordCollection, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if len(code) != 2*recoveryCodeLen {
+		return false
+	}
+	hashes := strings.Fields(rec.Get(rec.Model().FieldName("RecoveryCodes")).(string))
+	for i, hash := range hashes {
+		if password.Verify(code, hash) {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			rec.Set(rec.Model().FieldName("RecoveryCodes"), strings.Join(hashes, "\n"))
+			return true
+		}
+	}
+	return false
+}
+
+// newRecoveryCode returns a new random recovery code of the form 'xxxxx-xxxxx'
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, recoveryCodeLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(buf)
+	return code[:recoveryCodeLen] + "-" + code[recoveryCodeLen:], nil
+}
+
+// normalizeRecoveryCode returns the given recovery code in lower case,
+// without dashes and spaces, so that users can type them freely.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package twofactor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/controllers"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/password"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func performRequest(path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.GetServer().ServeHTTP(w, req)
+	return w
+}
+
+func TestTwoFactor(t *testing.T) {
+	Convey("Bootstrapping two-factor authentication before models should panic", t, func() {
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Bootstrapping", t, func() {
+		models.BootStrap()
+		BootStrap()
+		controllers.BootStrap()
+		So(BootStrap, ShouldPanic)
+		fInfos := models.Registry.MustGet(ModelName).FieldsGet()
+		So(fInfos, ShouldContainKey, "user_id")
+		So(fInfos, ShouldContainKey, "secret")
+		So(fInfos, ShouldContainKey, "recovery_codes")
+	})
+	Convey("Testing recovery codes", t, func() {
+		code, err := newRecoveryCode()
+		So(err, ShouldBeNil)
+		So(code, ShouldHaveLength, 2*recoveryCodeLen+1)
+		So(code[recoveryCodeLen], ShouldEqual, '-')
+		code2, _ := newRecoveryCode()
+		So(code, ShouldNotEqual, code2)
+		So(normalizeRecoveryCode(code), ShouldEqual, strings.Replace(code, "-", "", 1))
+		So(normalizeRecoveryCode("AB12C - 3DE45"), ShouldEqual, "ab12c3de45")
+		hash, _ := password.Hash(normalizeRecoveryCode(code))
+		So(password.Verify(normalizeRecoveryCode(strings.ToUpper(code)), hash), ShouldBeTrue)
+	})
+	Convey("Testing the endpoints", t, func() {
+		Convey("Verifying a code without pending session should fail", func() {
+			w := performRequest(Path+"/verify", `{"code": "123456"}`)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Body.String(), ShouldContainSubstring, "no pending authentication")
+		})
+		Convey("Verifying without code should fail", func() {
+			w := performRequest(Path+"/verify", `{}`)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Enrolling without session should fail", func() {
+			w := performRequest(Path+"/enroll", ``)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			w = performRequest(Path+"/confirm", `{"code": "123456"}`)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Managing the second factor without session should fail", func() {
+			w := performRequest(Path+"/recovery_codes", ``)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			w = performRequest(Path+"/disable", `{"code": "123456"}`)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package users provisions the users authenticated by external identity
+providers such as LDAP directories or OpenID Connect providers.
+
+Users are looked up and created in the User model, which must have a Login
+field. Their security groups are synchronized from the groups given by the
+provider.
+*/
+package users
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// ModelName is the name of the model in which users are looked up and created
+const ModelName = "User"
+
+// A Profile holds the data of a user given by an identity provider
+type Profile struct {
+	Login string
+	Name  string
+	Email string
+}
+
+// model returns the User model and true if it exists and has a Login field
+func model() (*models.Model, bool) {
+	userModel, ok := models.Registry.Get(ModelName)
+	if !ok {
+		return nil, false
+	}
+	if _, ok = userModel.Fields().Get("Login"); !ok {
+		return nil, false
+	}
+	return userModel, true
+}
+
+// Available returns true if users can be looked up and created,
+// that is if there is a User model with a Login field.
+func Available() bool {
+	_, ok := model()
+	return ok
+}
+
+// FindByLogin returns the uid of the user with the given login,
+// or 0 if there is none.
+func FindByLogin(env models.Environment, login string) int64 {
+	userModel, ok := model()
+	if !ok {
+		return 0
+	}
+	user := env.Pool(userModel.Name()).Search(userModel.Field(userModel.FieldName("Login")).Equals(login)).Limit(1).Fetch()
+	if user.IsEmpty() {
+		return 0
+	}
+	return user.Ids()[0]
+}
+
+// Exists returns true if the user with the given uid exists
+func Exists(env models.Environment, uid int64) bool {
+	userModel, ok := model()
+	if !ok {
+		return false
+	}
+	return env.Pool(userModel.Name()).Search(userModel.Field(models.ID).Equals(uid)).IsNotEmpty()
+}
+
+// Create creates a user with the given profile and returns its uid.
+// The name of the user defaults to its login. It returns 0 if there
+// is no User model with a Login field.
+func Create(env models.Environment, profile Profile) int64 {
+	userModel, ok := model()
+	if !ok {
+		return 0
+	}
+	data := models.NewModelData(userModel).Set(userModel.FieldName("Login"), profile.Login)
+	if _, exists := userModel.Fields().Get("Name"); exists {
+		name := profile.Name
+		if name == "" {
+			name = profile.Login
+		}
+		data.Set(userModel.FieldName("Name"), name)
+	}
+	if _, exists := userModel.Fields().Get("Email"); exists && profile.Email != "" {
+		data.Set(userModel.FieldName("Email"), profile.Email)
+	}
+	return env.Pool(userModel.Name()).Call("Create", data).(models.RecordSet).Ids()[0]
+}
+
+// SyncGroups adds the user with the given uid to the security groups
+// of the given map whose value is true and removes it from those whose
+// value is false. The keys of the map are group IDs. Memberships are
+// those of the database of ctx.
+//
+// It returns the sorted IDs of the groups of the map that are not registered.
+func SyncGroups(ctx context.Context, uid int64, groups map[string]bool) []string {
+	var unknown []string
+	for groupID, member := range groups {
+		group := security.Registry.GetGroup(groupID)
+		if group == nil {
+			unknown = append(unknown, groupID)
+			continue
+		}
+		switch {
+		case member && !security.Registry.HasMembershipContext(ctx, uid, group):
+			security.Registry.AddMembershipContext(ctx, uid, group)
+		case !member && security.Registry.HasMembershipContext(ctx, uid, group):
+			security.Registry.RemoveMembershipContext(ctx, uid, group)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
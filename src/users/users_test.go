@@ -0,0 +1,34 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package users
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSyncGroups(t *testing.T) {
+	accounting := security.Registry.NewGroup("users_accounting", "Accounting")
+	sales := security.Registry.NewGroup("users_sales", "Sales")
+	Convey("Synchronizing groups", t, func() {
+		security.Registry.AddMembership(7, sales)
+		unknown := SyncGroups(context.Background(), 7, map[string]bool{
+			"users_accounting": true,
+			"users_sales":      false,
+			"users_unknown":    true,
+		})
+		So(unknown, ShouldResemble, []string{"users_unknown"})
+		So(security.Registry.HasMembership(7, accounting), ShouldBeTrue)
+		So(security.Registry.HasMembership(7, sales), ShouldBeFalse)
+		Convey("Synchronizing again should not change memberships", func() {
+			So(SyncGroups(context.Background(), 7, map[string]bool{"users_accounting": true, "users_sales": false}), ShouldBeEmpty)
+			So(security.Registry.HasMembership(7, accounting), ShouldBeTrue)
+			So(security.Registry.HasMembership(7, sales), ShouldBeFalse)
+		})
+		security.Registry.RemoveAllMembershipsForUser(7)
+	})
+}
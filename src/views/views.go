@@ -365,6 +365,37 @@ func (v *View) Arch(lang string) *etree.Document {
 	return res
 }
 
+// ArchForUser returns the arch of this view for the given language, without
+// the fields that the user with the given uid is not allowed to access.
+//
+// The labels of the removed fields are removed too.
+func (v *View) ArchForUser(lang string, uid int64) *etree.Document {
+	arch := v.Arch(lang)
+	model, ok := models.Registry.Get(v.Model)
+	if !ok {
+		return arch
+	}
+	var denied []string
+	for _, fieldName := range v.Fields {
+		if !model.FieldAccessibleBy(model.FieldName(fieldName), uid) {
+			denied = append(denied, fieldName)
+		}
+	}
+	if len(denied) == 0 {
+		return arch
+	}
+	res := arch.Copy()
+	for _, fieldName := range denied {
+		for _, elt := range res.FindElements(fmt.Sprintf("//field[@name='%s']", fieldName)) {
+			elt.Parent().RemoveChild(elt)
+		}
+		for _, elt := range res.FindElements(fmt.Sprintf("//label[@for='%s']", fieldName)) {
+			elt.Parent().RemoveChild(elt)
+		}
+	}
+	return res
+}
+
 // setViewType sets the Type field with the view type
 // scanned from arch
 func (v *View) setViewType() {
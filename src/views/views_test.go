@@ -24,6 +24,7 @@ import (
 	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/tools/xmlutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -159,6 +160,16 @@ var viewDef10 = `
 </view>
 `
 
+var viewDef11 = `
+<view id="restricted_form" model="User">
+	<form>
+		<field name="UserName"/>
+		<label for="Salary"/>
+		<field name="Salary"/>
+	</form>
+</view>
+`
+
 func documentToXMLString(elt *etree.Document) string {
 	xmlData, err := xmlutils.DocumentToXML(elt)
 	if err != nil {
@@ -240,7 +251,9 @@ func TestViews(t *testing.T) {
 			"Color":    fields.Integer{},
 			"Sequence": fields.Integer{},
 		})
+		managers := security.Registry.NewGroup("views_test_managers", "Managers")
 		user.AddFields(map[string]models.FieldDefinition{
+			"Salary":   fields.Float{Groups: []*security.Group{managers}},
 			"UserName": fields.Char{},
 			"Age":      fields.Integer{OnChange: models.Registry.MustGet("User").Methods().MustGet("OnChangeAge")},
 			"Groups":   fields.Many2Many{RelationModel: models.Registry.MustGet("Group")},
@@ -361,6 +374,27 @@ func TestViews(t *testing.T) {
 </form>
 `)
 	})
+	Convey("Removing restricted fields from views", t, func() {
+		Registry = NewCollection()
+		loadView(viewDef11)
+		BootStrap()
+		view := Registry.GetByID("restricted_form")
+		fullArch := `<form>
+	<field name="user_name"/>
+	<label for="salary"/>
+	<field name="salary"/>
+</form>
+`
+		So(documentToXMLString(view.ArchForUser("", security.SuperUserID)), ShouldEqual, fullArch)
+		So(documentToXMLString(view.ArchForUser("", 2)), ShouldEqual, `<form>
+	<field name="user_name"/>
+</form>
+`)
+		So(documentToXMLString(view.Arch("")), ShouldEqual, fullArch)
+		security.Registry.AddMembership(2, security.Registry.GetGroup("views_test_managers"))
+		So(documentToXMLString(view.ArchForUser("", 2)), ShouldEqual, fullArch)
+		security.Registry.RemoveMembership(2, security.Registry.GetGroup("views_test_managers"))
+	})
 	Convey("Bootstrapping views", t, func() {
 		Registry = NewCollection()
 		loadView(viewDef1)